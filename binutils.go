@@ -2,17 +2,50 @@ package main
 
 import (
 	"debug/elf"
+	"encoding/json"
 	"sort"
 	"strings"
 )
 
 // Statistics about code size in a program.
 type ProgramSize struct {
-	Packages map[string]*PackageSize
-	Sum      *PackageSize
-	Code     uint64
-	Data     uint64
-	BSS      uint64
+	Packages map[string]*PackageSize `json:"packages"`
+	Symbols  []*SymbolSize           `json:"symbols"`
+	Sum      *PackageSize            `json:"sum"`
+	Code     uint64                  `json:"code"`
+	Data     uint64                  `json:"data"`
+	BSS      uint64                  `json:"bss"`
+}
+
+// Flash usage of the whole program, including parts not attributed to a
+// package.
+func (ps *ProgramSize) Flash() uint64 {
+	return ps.Code + ps.Data
+}
+
+// Static RAM usage of the whole program, including the stack.
+func (ps *ProgramSize) RAM() uint64 {
+	return ps.Data + ps.BSS
+}
+
+// Encode the program size as JSON, including the flash and RAM usage.
+func (ps *ProgramSize) MarshalJSON() ([]byte, error) {
+	type programSize ProgramSize // without the MarshalJSON method
+	return json.Marshal(struct {
+		*programSize
+		Flash uint64 `json:"flash"`
+		RAM   uint64 `json:"ram"`
+	}{(*programSize)(ps), ps.Flash(), ps.RAM()})
+}
+
+// Return the size of every symbol by name (ProgramSize.Symbols). Symbols with
+// the same name, like static functions in different C files, are added up.
+func (ps *ProgramSize) SymbolSizes() map[string]uint64 {
+	sizes := make(map[string]uint64, len(ps.Symbols))
+	for _, symbol := range ps.Symbols {
+		sizes[symbol.Name] += symbol.Size
+	}
+	return sizes
 }
 
 // Return the list of package names (ProgramSize.Packages) sorted
@@ -28,10 +61,10 @@ func (ps *ProgramSize) SortedPackageNames() []string {
 
 // The size of a package, calculated from the linked object file.
 type PackageSize struct {
-	Code   uint64
-	ROData uint64
-	Data   uint64
-	BSS    uint64
+	Code   uint64 `json:"code"`
+	ROData uint64 `json:"rodata"`
+	Data   uint64 `json:"data"`
+	BSS    uint64 `json:"bss"`
 }
 
 // Flash usage in regular microcontrollers.
@@ -44,6 +77,25 @@ func (ps *PackageSize) RAM() uint64 {
 	return ps.Data + ps.BSS
 }
 
+// Encode the package size as JSON, including the flash and RAM usage.
+func (ps *PackageSize) MarshalJSON() ([]byte, error) {
+	type packageSize PackageSize // without the MarshalJSON method
+	return json.Marshal(struct {
+		*packageSize
+		Flash uint64 `json:"flash"`
+		RAM   uint64 `json:"ram"`
+	}{(*packageSize)(ps), ps.Flash(), ps.RAM()})
+}
+
+// The size of a single symbol, and the package it is attributed to. Kind is
+// one of "code", "rodata", "data" or "bss", like the columns of PackageSize.
+type SymbolSize struct {
+	Name    string `json:"name"`
+	Package string `json:"package"`
+	Kind    string `json:"kind"`
+	Size    uint64 `json:"size"`
+}
+
 type symbolList []elf.Symbol
 
 func (l symbolList) Len() int {
@@ -76,18 +128,18 @@ func Sizes(path string) (*ProgramSize, error) {
 	var sumData uint64
 	var sumBSS uint64
 	for _, section := range file.Sections {
+		// Count every section that is loaded into memory, not just code and
+		// data but also sections like .ARM.exidx and .init_array.
 		if section.Flags&elf.SHF_ALLOC == 0 {
 			continue
 		}
-		if section.Type != elf.SHT_PROGBITS && section.Type != elf.SHT_NOBITS {
-			continue
-		}
 		if section.Type == elf.SHT_NOBITS {
 			sumBSS += section.Size
-		} else if section.Flags&elf.SHF_EXECINSTR != 0 {
-			sumCode += section.Size
 		} else if section.Flags&elf.SHF_WRITE != 0 {
 			sumData += section.Size
+		} else {
+			// Code and read-only data, like the text column of size.
+			sumCode += section.Size
 		}
 	}
 
@@ -116,6 +168,7 @@ func Sizes(path string) (*ProgramSize, error) {
 	sort.Sort(symbolList(symbols))
 
 	sizes := map[string]*PackageSize{}
+	var symbolSizes []*SymbolSize
 	var lastSymbolValue uint64
 	for _, symbol := range symbols {
 		symType := elf.ST_TYPE(symbol.Info)
@@ -133,17 +186,28 @@ func Sizes(path string) (*ProgramSize, error) {
 			sizes[pkgName] = pkgSize
 		}
 		if lastSymbolValue != symbol.Value || lastSymbolValue == 0 {
+			var kind string
 			if symType == elf.STT_FUNC {
 				pkgSize.Code += symbol.Size
+				kind = "code"
 			} else if section.Flags&elf.SHF_WRITE != 0 {
 				if section.Type == elf.SHT_NOBITS {
 					pkgSize.BSS += symbol.Size
+					kind = "bss"
 				} else {
 					pkgSize.Data += symbol.Size
+					kind = "data"
 				}
 			} else {
 				pkgSize.ROData += symbol.Size
+				kind = "rodata"
 			}
+			symbolSizes = append(symbolSizes, &SymbolSize{
+				Name:    symbol.Name,
+				Package: pkgName,
+				Kind:    kind,
+				Size:    symbol.Size,
+			})
 		}
 		lastSymbolValue = symbol.Value
 	}
@@ -156,5 +220,5 @@ func Sizes(path string) (*ProgramSize, error) {
 		sum.BSS += pkg.BSS
 	}
 
-	return &ProgramSize{Packages: sizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
+	return &ProgramSize{Packages: sizes, Symbols: symbolSizes, Code: sumCode, Data: sumData, BSS: sumBSS, Sum: sum}, nil
 }
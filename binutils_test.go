@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// The sizes of testdata/sizes.elf, see testdata/sizes.s.
+var testSizesELF = &ProgramSize{
+	Packages: map[string]*PackageSize{
+		"(bootstrap)": {Code: 7},
+		"main":        {Code: 17, ROData: 12, BSS: 32},
+		"runtime":     {Code: 8, Data: 8},
+	},
+	Symbols: []*SymbolSize{
+		{Name: "_start", Package: "(bootstrap)", Kind: "code", Size: 7},
+		{Name: "main.main", Package: "main", Kind: "code", Size: 17},
+		{Name: "runtime.alloc", Package: "runtime", Kind: "code", Size: 8},
+		{Name: "main.table", Package: "main", Kind: "rodata", Size: 12},
+		{Name: "runtime.heapStart", Package: "runtime", Kind: "data", Size: 8},
+		{Name: "main.buf", Package: "main", Kind: "bss", Size: 32},
+	},
+	Sum:  &PackageSize{Code: 32, ROData: 12, Data: 8, BSS: 32},
+	Code: 24 + 32 + 12, // .note.tinygo, .text and .rodata
+	Data: 8,
+	BSS:  36,
+}
+
+func TestSizes(t *testing.T) {
+	sizes, err := Sizes(TESTDATA + "/sizes.elf")
+	if err != nil {
+		t.Fatal("could not read sizes:", err)
+	}
+	if !reflect.DeepEqual(sizes, testSizesELF) {
+		gotJSON, _ := sizes.MarshalJSON()
+		wantJSON, _ := testSizesELF.MarshalJSON()
+		t.Errorf("unexpected sizes\ngot:  %s\nwant: %s", gotJSON, wantJSON)
+	}
+	if sizes.Flash() != 76 || sizes.RAM() != 44 {
+		t.Errorf("got %d bytes of flash and %d bytes of RAM, want 76 and 44", sizes.Flash(), sizes.RAM())
+	}
+	if pkg := sizes.Packages["main"]; pkg.Flash() != 29 || pkg.RAM() != 32 {
+		t.Errorf("package main: got %d bytes of flash and %d bytes of RAM, want 29 and 32", pkg.Flash(), pkg.RAM())
+	}
+}
+
+func TestSymbolSizes(t *testing.T) {
+	sizes := &ProgramSize{
+		Symbols: []*SymbolSize{
+			{Name: "main.main", Size: 10},
+			{Name: "memset", Size: 4},
+			{Name: "memset", Size: 6}, // a static function in another file
+		},
+	}
+	want := map[string]uint64{
+		"main.main": 10,
+		"memset":    10,
+	}
+	if got := sizes.SymbolSizes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSizeDiff(t *testing.T) {
+	oldSizes := &ProgramSize{
+		Packages: map[string]*PackageSize{
+			"main":    {Code: 100, ROData: 10, BSS: 8},
+			"runtime": {Code: 200, Data: 4},
+			"removed": {Code: 30},
+		},
+		Symbols: []*SymbolSize{
+			{Name: "main.main", Size: 100},
+			{Name: "main.buf", Size: 8},
+			{Name: "runtime.alloc", Size: 200},
+			{Name: "removed.f", Size: 30},
+		},
+		Sum:  &PackageSize{Code: 330, ROData: 10, Data: 4, BSS: 8},
+		Code: 400,
+		Data: 4,
+		BSS:  1032,
+	}
+	newSizes := &ProgramSize{
+		Packages: map[string]*PackageSize{
+			"main":    {Code: 150, ROData: 10, BSS: 8},
+			"runtime": {Code: 200, Data: 4},
+			"added":   {Code: 20, Data: 4},
+		},
+		Symbols: []*SymbolSize{
+			{Name: "main.main", Size: 120},
+			{Name: "main.helper", Size: 30},
+			{Name: "main.buf", Size: 8},
+			{Name: "runtime.alloc", Size: 200},
+			{Name: "added.f", Size: 20},
+		},
+		Sum:  &PackageSize{Code: 370, ROData: 10, Data: 8, BSS: 8},
+		Code: 440,
+		Data: 8,
+		BSS:  1032,
+	}
+	want := "" +
+		"   flash   delta |     ram   delta | package\n" +
+		"     24     +24 |       4      +4 | added\n" +
+		"    160     +50 |       8      +0 | main\n" +
+		"      0     -30 |       0      +0 | removed\n" +
+		"    388     +44 |      16      +4 | (sum)\n" +
+		"    448     +44 |    1040      +4 | (all)\n" +
+		"\n" +
+		"    old     new   delta | symbol\n" +
+		"      0      30     +30 | main.helper\n" +
+		"      0      20     +20 | added.f\n" +
+		"    100     120     +20 | main.main\n" +
+		"     30       0     -30 | removed.f\n"
+	buf := &bytes.Buffer{}
+	writeSizeDiff(buf, oldSizes, newSizes)
+	if buf.String() != want {
+		t.Errorf("unexpected size diff\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestCheckSizeBudget(t *testing.T) {
+	tests := []struct {
+		flashSize uint64
+		ramSize   uint64
+		err       string
+	}{
+		{0, 0, ""},
+		{76, 44, ""},
+		{75, 0, "program too large: uses 76 bytes of flash, but only 75 bytes are available"},
+		{0, 43, "program too large: uses 44 bytes of RAM, but only 43 bytes are available"},
+	}
+	for _, tc := range tests {
+		spec := &TargetSpec{FlashSize: tc.flashSize, RAMSize: tc.ramSize}
+		err := checkSizeBudget(spec, testSizesELF)
+		if tc.err == "" && err != nil {
+			t.Errorf("flash %d, RAM %d: unexpected error: %v", tc.flashSize, tc.ramSize, err)
+		} else if tc.err != "" && (err == nil || err.Error() != tc.err) {
+			t.Errorf("flash %d, RAM %d: expected error %q, got: %v", tc.flashSize, tc.ramSize, tc.err, err)
+		}
+	}
+}
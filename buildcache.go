@@ -1,108 +1,297 @@
 package main
 
+// This file implements the build cache. Cached files are content addressed:
+// the name of a cache entry includes a hash of the contents of all source
+// files and of a config key, which describes everything else that affects the
+// output (compiler version, target, flags). Changing any of them results in a
+// different entry, so that stale files are never used. Old entries are removed
+// when the cache grows too big.
+
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Get the cache directory, usually ~/.cache/tinygo
+// Version of the cache format. Increase it to invalidate all cache entries,
+// for example when the way files are compiled changes.
+const cacheVersion = "1"
+
+// The maximum size of the cache directory in bytes. The least recently used
+// entries are removed when the cache grows bigger than this.
+const cacheMaxSize = 512 * 1024 * 1024
+
+// Get the cache directory: the TINYGOCACHE environment variable if set,
+// otherwise ~/.cache/tinygo
 func cacheDir() string {
+	if dir := os.Getenv("TINYGOCACHE"); dir != "" {
+		return dir
+	}
 	home := getHomeDir()
 	dir := filepath.Join(home, ".cache", "tinygo")
 	return dir
 }
 
-// Return the newest timestamp of all the file paths passed in. Used to check
-// for stale caches.
-func cacheTimestamp(paths []string) (time.Time, error) {
-	var timestamp time.Time
-	for _, path := range paths {
-		st, err := os.Stat(path)
+// Return the path of the cache entry with the given name, source files and
+// config key. The hash of the inputs is inserted into the name before the
+// extension, like librt-armv7m-none-eabi-<hash>.a.
+func cachePath(name, configKey string, sourceFiles []string) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, "tinygo cache "+cacheVersion+"\x00")
+	io.WriteString(h, configKey+"\x00")
+	for _, path := range sourceFiles {
+		f, err := os.Open(path)
 		if err != nil {
-			return time.Time{}, err
+			return "", err
 		}
-		if timestamp.IsZero() {
-			timestamp = st.ModTime()
-		} else if timestamp.Before(st.ModTime()) {
-			timestamp = st.ModTime()
+		io.WriteString(h, filepath.Base(path)+"\x00")
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
 		}
+		h.Write([]byte{0})
+	}
+	ext := filepath.Ext(name)
+	name = strings.TrimSuffix(name, ext) + "-" + hex.EncodeToString(h.Sum(nil)) + ext
+	return filepath.Join(cacheDir(), name), nil
+}
+
+// Return the version of a tool like clang, as printed by `tool --version`, to
+// include in a config key.
+func toolVersion(command string) (string, error) {
+	out, err := exec.Command(command, "--version").Output()
+	if err != nil {
+		return "", errors.New("cannot get version of " + command + ": " + err.Error())
 	}
-	return timestamp, nil
+	return string(out), nil
 }
 
 // Try to load a given file from the cache. Return "", nil if no cached file can
-// be found (or the file is stale), return the absolute path if there is a cache
-// and return an error on I/O errors.
+// be found, return the absolute path if there is a cache and return an error
+// on I/O errors.
 //
-// TODO: the configKey is currently ignored. It is supposed to be used as extra
-// data for the cache key, like the compiler version and arguments.
+// The configKey contains all information besides the source files that affects
+// the cached file, like the compiler version and arguments.
 func cacheLoad(name, configKey string, sourceFiles []string) (string, error) {
-	dir := cacheDir()
-	cachepath := filepath.Join(dir, name)
-	cacheStat, err := os.Stat(cachepath)
+	cachepath, err := cachePath(name, configKey, sourceFiles)
+	if err != nil {
+		return "", err // cannot read source files
+	}
+	_, err = os.Stat(cachepath)
 	if os.IsNotExist(err) {
 		return "", nil // does not exist
 	} else if err != nil {
 		return "", err // cannot stat cache file
 	}
 
-	sourceTimestamp, err := cacheTimestamp(sourceFiles)
-	if err != nil {
-		return "", err // cannot stat source files
-	}
-
-	if cacheStat.ModTime().After(sourceTimestamp) {
-		return cachepath, nil
-	} else {
-		os.Remove(cachepath)
-		// stale cache
-		return "", nil
-	}
+	// Mark the file as recently used, so that it is not evicted.
+	now := time.Now()
+	os.Chtimes(cachepath, now, now)
+	return cachepath, nil
 }
 
 // Store the file located at tmppath in the cache with the given name. The
-// tmppath may or may not be gone afterwards.
-//
-// Note: the configKey is ignored, see cacheLoad.
+// tmppath may or may not be gone afterwards. The configKey and sourceFiles
+// must be the same as passed to cacheLoad.
 func cacheStore(tmppath, name, configKey string, sourceFiles []string) (string, error) {
-	// get the last modified time
-	if len(sourceFiles) == 0 {
-		panic("cache: no source files")
-	}
-
-	// TODO: check the config key
-
 	dir := cacheDir()
 	err := os.MkdirAll(dir, 0777)
 	if err != nil {
 		return "", err
 	}
-	cachepath := filepath.Join(dir, name)
+	cachepath, err := cachePath(name, configKey, sourceFiles)
+	if err != nil {
+		return "", err
+	}
 	err = os.Rename(tmppath, cachepath)
 	if err != nil {
+		// Probably on a different filesystem, so copy the file. Write it to
+		// a unique temporary file first so that other processes never see a
+		// partially written cache entry, even when they store the same entry
+		// at the same time.
 		inf, err := os.Open(tmppath)
 		if err != nil {
 			return "", err
 		}
 		defer inf.Close()
-		outf, err := os.Create(cachepath + ".tmp")
+		outf, err := ioutil.TempFile(dir, cacheTempPrefix)
 		if err != nil {
 			return "", err
 		}
+		defer os.Remove(outf.Name())
 
 		_, err = io.Copy(outf, inf)
+		if err != nil {
+			outf.Close()
+			return "", err
+		}
+		err = outf.Close()
 		if err != nil {
 			return "", err
 		}
 
-		err = os.Rename(cachepath+".tmp", cachepath)
+		err = os.Rename(outf.Name(), cachepath)
 		if err != nil {
 			return "", err
 		}
+	}
+
+	return cachepath, cacheEvict(cachepath, cacheMaxSize)
+}
 
-		return cachepath, outf.Close()
+// Return the cache name of a file of a compiled package, like
+// pkg-github.com_aykevl_tinygo_foo.bc for github.com/aykevl/tinygo/foo.bc.
+func cachePackageName(name string) string {
+	return "pkg-" + strings.Replace(name, "/", "_", -1)
+}
+
+// packageCache stores the compiled packages of the compiler in the build
+// cache. It implements compiler.PackageCache. The package files are not
+// compiled from source files by themselves, so the key passed by the compiler
+// describes their inputs instead.
+type packageCache struct {
+	configKey string // the version of the compiler
+}
+
+// Create a packageCache for the running compiler.
+func newPackageCache() (*packageCache, error) {
+	version, err := compilerVersion()
+	if err != nil {
+		return nil, err
 	}
-	return cachepath, nil
+	return &packageCache{configKey: version}, nil
+}
+
+func (pc *packageCache) Load(name, key string) (string, error) {
+	return cacheLoad(cachePackageName(name), pc.configKey+"\x00"+key, nil)
+}
+
+func (pc *packageCache) Store(tmppath, name, key string) (string, error) {
+	return cacheStore(tmppath, cachePackageName(name), pc.configKey+"\x00"+key, nil)
+}
+
+// The result of compilerVersion, which is only computed once per process.
+var (
+	compilerVersionOnce  sync.Once
+	compilerVersionValue string
+	compilerVersionErr   error
+)
+
+// Return a hash of the running tinygo executable. Compiled packages can only be
+// reused by the exact same compiler. Hashing the executable takes a while, so
+// it is only done once.
+func compilerVersion() (string, error) {
+	compilerVersionOnce.Do(func() {
+		compilerVersionValue, compilerVersionErr = hashExecutable()
+	})
+	return compilerVersionValue, compilerVersionErr
+}
+
+// Return a hash of the contents of the running executable.
+func hashExecutable() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return "tinygo " + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Prefix of the temporary files that cacheStore writes to the cache directory
+// before renaming them to their final name.
+const cacheTempPrefix = "tmp-"
+
+// A file in the cache directory.
+type cacheEntry struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// Return all files in the cache directory. It returns no entries when the
+// cache directory does not exist. Temporary files are skipped: they don't
+// count toward the size of the cache and must not be evicted, as another
+// process may still be writing them.
+func cacheEntries() ([]cacheEntry, error) {
+	dir := cacheDir()
+	f, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	var entries []cacheEntry
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), cacheTempPrefix) {
+			continue
+		}
+		entries = append(entries, cacheEntry{filepath.Join(dir, info.Name()), info.Size(), info.ModTime()})
+	}
+	return entries, nil
+}
+
+// Remove the least recently used entries until the cache is no bigger than
+// maxSize bytes. The entry at keep is never removed, as it is about to be used.
+func cacheEvict(keep string, maxSize int64) error {
+	entries, err := cacheEntries()
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, entry := range entries {
+		size += entry.size
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, entry := range entries {
+		if size <= maxSize {
+			break
+		}
+		if entry.path == keep {
+			continue
+		}
+		err := os.Remove(entry.path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= entry.size
+	}
+	return nil
+}
+
+// Return the number of entries in the cache and their total size in bytes.
+func cacheStats() (count int, size int64, err error) {
+	entries, err := cacheEntries()
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, entry := range entries {
+		size += entry.size
+	}
+	return len(entries), size, nil
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Set an environment variable for a test. The returned function restores the
+// old value.
+func setenv(key, value string) func() {
+	oldValue, hadValue := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if hadValue {
+			os.Setenv(key, oldValue)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// Use a new temporary cache directory for a test. The returned function
+// removes it again.
+func tempCacheDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "tinygo-cache")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	restore := setenv("TINYGOCACHE", dir)
+	return dir, func() {
+		restore()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCachePath(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	source := filepath.Join(dir, "source.c")
+	err := ioutil.WriteFile(source, []byte("int x;\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := func(key string) string {
+		path, err := cachePath("librt.a", key, []string{source})
+		if err != nil {
+			t.Fatal("could not get cache path:", err)
+		}
+		return path
+	}
+
+	pathA := path("key A")
+	if filepath.Dir(pathA) != dir {
+		t.Errorf("cache entry %s is not in the cache directory %s", pathA, dir)
+	}
+	if filepath.Ext(pathA) != ".a" {
+		t.Errorf("cache entry %s lost its extension", pathA)
+	}
+	if path("key A") != pathA {
+		t.Error("the same inputs result in a different path")
+	}
+	if path("key B") == pathA {
+		t.Error("a different config key results in the same path")
+	}
+	err = ioutil.WriteFile(source, []byte("int y;\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path("key A") == pathA {
+		t.Error("a different source file results in the same path")
+	}
+}
+
+func TestCacheStoreLoad(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	tmppath := filepath.Join(dir, "output.bc")
+	err := ioutil.WriteFile(tmppath, []byte("contents"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := cacheStore(tmppath, "pkg.bc", "key", nil)
+	if err != nil {
+		t.Fatal("could not store file:", err)
+	}
+
+	loaded, err := cacheLoad("pkg.bc", "key", nil)
+	if err != nil {
+		t.Fatal("could not load file:", err)
+	}
+	if loaded != stored {
+		t.Errorf("loaded %s, want %s", loaded, stored)
+	}
+	data, err := ioutil.ReadFile(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "contents" {
+		t.Errorf("cache entry contains %q, want %q", data, "contents")
+	}
+
+	loaded, err = cacheLoad("pkg.bc", "other key", nil)
+	if err != nil {
+		t.Fatal("could not load file:", err)
+	}
+	if loaded != "" {
+		t.Errorf("loaded %s with a different config key", loaded)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	dir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	// Create five entries of 100 bytes, from least to most recently used.
+	now := time.Now()
+	var paths []string
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, make([]byte, 100), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modTime := now.Add(time.Duration(i-5) * time.Hour)
+		err = os.Chtimes(path, modTime, modTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	// A temporary file of cacheStore that is still being written by another
+	// process. It must not be counted or removed, even though it is the least
+	// recently used file.
+	tmppath := filepath.Join(dir, cacheTempPrefix+"123")
+	err := ioutil.WriteFile(tmppath, make([]byte, 100), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modTime := now.Add(-10 * time.Hour)
+	err = os.Chtimes(tmppath, modTime, modTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keep the least recently used entry: the next ones must be removed
+	// instead.
+	err = cacheEvict(paths[0], 300)
+	if err != nil {
+		t.Fatal("could not evict cache entries:", err)
+	}
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		exists := err == nil
+		if wantExists := i != 1 && i != 2; exists != wantExists {
+			t.Errorf("entry %d exists: %v, want %v", i, exists, wantExists)
+		}
+	}
+	if _, err := os.Stat(tmppath); err != nil {
+		t.Error("temporary file was evicted:", err)
+	}
+	count, size, err := cacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || size != 300 {
+		t.Errorf("cache stats: got %d entries of %d bytes, want 3 entries of 300 bytes", count, size)
+	}
+
+	// Nothing is removed when the cache is small enough.
+	err = cacheEvict("", 300)
+	if err != nil {
+		t.Fatal("could not evict cache entries:", err)
+	}
+	count, _, err = cacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("cache has %d entries after evicting nothing, want 3", count)
+	}
+}
+
+func TestPackageCache(t *testing.T) {
+	cacheDir, cleanup := tempCacheDir(t)
+	defer cleanup()
+
+	// Create a program in a temporary GOPATH. The mid package uses a constant
+	// of the dep package, so its code changes when only dep changes.
+	gopath, err := ioutil.TempDir("", "tinygo-gopath")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(gopath)
+	defer setenv("GOPATH", gopath)()
+	writeFile := func(path, contents string) {
+		path = filepath.Join(gopath, "src", "cachetest", path)
+		err := os.MkdirAll(filepath.Dir(path), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(contents), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("main.go", "package main\n\nimport \"cachetest/mid\"\n\nfunc main() {\n\tprintln(mid.Value())\n}\n")
+	writeFile("mid/mid.go", "package mid\n\nimport \"cachetest/dep\"\n\nfunc Value() int {\n\treturn dep.Value\n}\n")
+	writeFile("dep/dep.go", "package dep\n\nconst Value = 1\n")
+	mainPath := filepath.Join(gopath, "src", "cachetest", "main.go")
+
+	config := &BuildConfig{
+		opt:       "z",
+		nilChecks: true,
+	}
+	build := func(name, want string) []byte {
+		binary := filepath.Join(gopath, name)
+		err := Build(mainPath, binary, "", config)
+		if err != nil {
+			t.Fatal("failed to build:", err)
+		}
+		output, err := exec.Command(binary).Output()
+		if err != nil {
+			t.Fatal("failed to run:", err)
+		}
+		// putchar() prints CRLF, convert it to LF.
+		output = bytes.Replace(output, []byte{'\r', '\n'}, []byte{'\n'}, -1)
+		if string(output) != want {
+			t.Errorf("%s build printed %q, want %q", name, output, want)
+		}
+		data, err := ioutil.ReadFile(binary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	midEntries := func() int {
+		matches, err := filepath.Glob(filepath.Join(cacheDir, "pkg-cachetest_mid-*.bc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(matches)
+	}
+
+	// A build with a warm cache must be the same as a cold build.
+	cold := build("cold", "1\n")
+	count, _, err := cacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 || midEntries() != 1 {
+		t.Fatalf("cold build stored %d cache entries, %d of package mid", count, midEntries())
+	}
+	warm := build("warm", "1\n")
+	if !bytes.Equal(cold, warm) {
+		t.Error("warm build differs from cold build")
+	}
+	warmCount, _, err := cacheStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmCount != count {
+		t.Errorf("warm build changed the number of cache entries from %d to %d", count, warmCount)
+	}
+
+	// Changing dep must rebuild the packages that import it.
+	writeFile("dep/dep.go", "package dep\n\nconst Value = 2\n")
+	build("changed", "2\n")
+	if midEntries() != 2 {
+		t.Errorf("package mid has %d cache entries after changing dep, want 2", midEntries())
+	}
+}
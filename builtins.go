@@ -174,7 +174,15 @@ func loadBuiltins(target string) (path string, err error) {
 		srcs[i] = filepath.Join(builtinsDir, name)
 	}
 
-	if path, err := cacheLoad(outfile, commands["clang"], srcs); path != "" || err != nil {
+	// The archive depends on the clang version and the flags it is compiled
+	// with, not just on the source files.
+	flags := []string{"-c", "-Oz", "-g", "-Werror", "-Wall", "-std=c11", "-fshort-enums", "-nostdlibinc", "--target=" + target}
+	version, err := toolVersion(commands["clang"])
+	if err != nil {
+		return "", err
+	}
+	configKey := version + "\x00" + strings.Join(flags, " ")
+	if path, err := cacheLoad(outfile, configKey, srcs); path != "" || err != nil {
 		return path, err
 	}
 
@@ -195,7 +203,7 @@ func loadBuiltins(target string) (path string, err error) {
 		objpath := filepath.Join(dir, objname+".o")
 		objs = append(objs, objpath)
 		srcpath := filepath.Join(builtinsDir, name)
-		cmd := exec.Command(commands["clang"], "-c", "-Oz", "-g", "-Werror", "-Wall", "-std=c11", "-fshort-enums", "-nostdlibinc", "--target="+target, "-o", objpath, srcpath)
+		cmd := exec.Command(commands["clang"], append(flags, "-o", objpath, srcpath)...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		cmd.Dir = dir
@@ -216,5 +224,5 @@ func loadBuiltins(target string) (path string, err error) {
 		return "", err
 	}
 
-	return cacheStore(arpath, outfile, commands["clang"], srcs)
+	return cacheStore(arpath, outfile, configKey, srcs)
 }
@@ -31,6 +31,17 @@ func (c *Compiler) createCall(fn llvm.Value, args []llvm.Value, name string) llv
 		fragments := c.expandFormalParam(arg)
 		expanded = append(expanded, fragments...)
 	}
+	if !fn.IsAFunction().IsNil() {
+		// A function that is declared in one package and defined in another
+		// using //go:linkname may have different (but compatible) pointer
+		// types as parameters, like *time.runtimeTimer and *runtime.timer.
+		paramTypes := fn.Type().ElementType().ParamTypes()
+		for i, arg := range expanded {
+			if i < len(paramTypes) && arg.Type() != paramTypes[i] && arg.Type().TypeKind() == llvm.PointerTypeKind && paramTypes[i].TypeKind() == llvm.PointerTypeKind {
+				expanded[i] = c.builder.CreateBitCast(arg, paramTypes[i], "")
+			}
+		}
+	}
 	return c.builder.CreateCall(fn, expanded, name)
 }
 
@@ -0,0 +1,188 @@
+package compiler
+
+// This file lowers channel operations (make, send, receive, select) to runtime
+// calls.
+// Blocking operations suspend the current coroutine after the runtime call, so
+// that the scheduler can run other goroutines until the operation completes.
+
+import (
+	"go/types"
+	"strconv"
+
+	"github.com/aykevl/go-llvm"
+	"golang.org/x/tools/go/ssa"
+)
+
+// emitMakeChan returns a new channel of the given type with the given buffer
+// size (which is 0 for unbuffered channels).
+func (c *Compiler) emitMakeChan(frame *Frame, expr *ssa.MakeChan) (llvm.Value, error) {
+	valueType, err := c.getLLVMType(expr.Type().Underlying().(*types.Chan).Elem())
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	size, err := c.parseExpr(frame, expr.Size)
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	sizeCast, err := c.parseConvert(expr.Size.Type(), types.Typ[types.Uintptr], size)
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	elementSize := llvm.ConstInt(c.uintptrType, c.targetData.TypeAllocSize(valueType), false)
+	return c.createRuntimeCall("chanMake", []llvm.Value{elementSize, sizeCast}, ""), nil
+}
+
+// emitChanSend emits a send of a value over a channel. The value is passed to
+// the runtime via an alloca, which lives in the coroutine frame while this
+// goroutine is blocked.
+func (c *Compiler) emitChanSend(frame *Frame, instr *ssa.Send) error {
+	ch, err := c.parseExpr(frame, instr.Chan)
+	if err != nil {
+		return err
+	}
+	value, err := c.parseExpr(frame, instr.X)
+	if err != nil {
+		return err
+	}
+	valueAlloca := c.createEntryBlockAlloca(frame, value.Type(), "chan.value")
+	c.builder.CreateStore(value, valueAlloca)
+	valuePtr := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "chan.valuePtr")
+	c.createRuntimeCall("chanSend", []llvm.Value{c.getCoroutine(frame), ch, valuePtr}, "")
+	if frame.blocking {
+		c.emitYield(frame, "chan.sendComplete")
+		// The channel may have been closed while this goroutine was blocked,
+		// in which case the send panics now.
+		c.createRuntimeCall("chanSendResume", []llvm.Value{frame.taskHandle}, "")
+	}
+	c.emitUnwindCheck(frame)
+	return nil
+}
+
+// emitChanRecv emits a receive from a channel. It returns the received value,
+// or a {value, ok} tuple for a comma-ok receive.
+func (c *Compiler) emitChanRecv(frame *Frame, unop *ssa.UnOp, ch llvm.Value) (llvm.Value, error) {
+	valueType, err := c.getLLVMType(unop.X.Type().Underlying().(*types.Chan).Elem())
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	valueAlloca := c.createEntryBlockAlloca(frame, valueType, "chan.value")
+	valuePtr := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "chan.valuePtr")
+	commaOk := c.createRuntimeCall("chanRecv", []llvm.Value{c.getCoroutine(frame), ch, valuePtr}, "chan.ok")
+	if frame.blocking {
+		c.emitYield(frame, "chan.recvComplete")
+		// The sender (or chanClose) stored the commaOk value in the task
+		// state, as the return value of chanRecv is not valid when blocked.
+		data := c.createRuntimeCall("getTaskPromiseData", []llvm.Value{frame.taskHandle}, "")
+		commaOk = c.builder.CreateICmp(llvm.IntNE, data, llvm.ConstInt(data.Type(), 0, false), "chan.ok")
+	}
+	c.emitUnwindCheck(frame)
+	received := c.builder.CreateLoad(valueAlloca, "chan.received")
+	if unop.CommaOk {
+		tuple := llvm.Undef(c.ctx.StructType([]llvm.Type{valueType, c.ctx.Int1Type()}, false))
+		tuple = c.builder.CreateInsertValue(tuple, received, 0, "")
+		tuple = c.builder.CreateInsertValue(tuple, commaOk, 1, "")
+		return tuple, nil
+	} else {
+		return received, nil
+	}
+}
+
+// emitSelect emits a select statement. All cases are passed to the runtime at
+// once, which picks a case that is ready or blocks on all of them. The result
+// is a tuple of the chosen case index (-1 for the default case), the commaOk
+// value of a receive, and the received value of every receive case.
+func (c *Compiler) emitSelect(frame *Frame, expr *ssa.Select) (llvm.Value, error) {
+	stateType := c.mod.GetTypeByName("runtime.chanSelectState")
+	states := c.createEntryBlockAlloca(frame, llvm.ArrayType(stateType, len(expr.States)), "select.states")
+	resultTypes := []llvm.Type{c.intType, c.ctx.Int1Type()}
+	var recvAllocas []llvm.Value
+	hasSend := false
+	for i, state := range expr.States {
+		ch, err := c.parseExpr(frame, state.Chan)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		var valueAlloca llvm.Value
+		isSend := llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+		if state.Dir == types.SendOnly {
+			isSend = llvm.ConstInt(c.ctx.Int1Type(), 1, false)
+			hasSend = true
+			value, err := c.parseExpr(frame, state.Send)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			valueAlloca = c.createEntryBlockAlloca(frame, value.Type(), "select.send.value")
+			c.builder.CreateStore(value, valueAlloca)
+		} else {
+			valueType, err := c.getLLVMType(state.Chan.Type().Underlying().(*types.Chan).Elem())
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			valueAlloca = c.createEntryBlockAlloca(frame, valueType, "select.recv.value")
+			recvAllocas = append(recvAllocas, valueAlloca)
+			resultTypes = append(resultTypes, valueType)
+		}
+		stateValue := llvm.Undef(stateType)
+		stateValue = c.builder.CreateInsertValue(stateValue, ch, 0, "")
+		stateValue = c.builder.CreateInsertValue(stateValue, c.builder.CreateBitCast(valueAlloca, c.i8ptrType, ""), 1, "")
+		stateValue = c.builder.CreateInsertValue(stateValue, isSend, 2, "")
+		statePtr := c.builder.CreateGEP(states, []llvm.Value{
+			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
+			llvm.ConstInt(c.ctx.Int32Type(), uint64(i), false),
+		}, "select.state"+strconv.Itoa(i))
+		c.builder.CreateStore(stateValue, statePtr)
+	}
+
+	// Pass the states to the runtime as a slice.
+	statesPtr := c.builder.CreateGEP(states, []llvm.Value{
+		llvm.ConstInt(c.ctx.Int32Type(), 0, false),
+		llvm.ConstInt(c.ctx.Int32Type(), 0, false),
+	}, "select.states.ptr")
+	statesLen := llvm.ConstInt(c.lenType, uint64(len(expr.States)), false)
+	statesSlice := llvm.Undef(c.ctx.StructType([]llvm.Type{statesPtr.Type(), c.lenType, c.lenType}, false))
+	statesSlice = c.builder.CreateInsertValue(statesSlice, statesPtr, 0, "")
+	statesSlice = c.builder.CreateInsertValue(statesSlice, statesLen, 1, "")
+	statesSlice = c.builder.CreateInsertValue(statesSlice, statesLen, 2, "")
+	hasDefault := llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+	if !expr.Blocking {
+		hasDefault = llvm.ConstInt(c.ctx.Int1Type(), 1, false)
+	}
+	result := c.createRuntimeCall("chanSelect", []llvm.Value{c.getCoroutine(frame), statesSlice, hasDefault}, "select.result")
+	index := c.builder.CreateExtractValue(result, 0, "select.index")
+	commaOk := c.builder.CreateExtractValue(result, 1, "select.ok")
+	if frame.blocking && expr.Blocking {
+		c.emitYield(frame, "select.complete")
+		if hasSend {
+			// A send case panics when its channel was closed while blocked.
+			c.createRuntimeCall("chanSendResume", []llvm.Value{frame.taskHandle}, "")
+		}
+		// The result is stored in the task state, as the return value of
+		// chanSelect is not valid when blocked. The lowest bit is the commaOk
+		// value, the other bits are the index of the chosen case.
+		data := c.createRuntimeCall("getTaskPromiseData", []llvm.Value{frame.taskHandle}, "")
+		one := llvm.ConstInt(data.Type(), 1, false)
+		index = c.builder.CreateIntCast(c.builder.CreateLShr(data, one, ""), c.intType, "select.index")
+		commaOk = c.builder.CreateICmp(llvm.IntNE, c.builder.CreateAnd(data, one, ""), llvm.ConstInt(data.Type(), 0, false), "select.ok")
+	}
+
+	c.emitUnwindCheck(frame)
+
+	tuple := llvm.Undef(c.ctx.StructType(resultTypes, false))
+	tuple = c.builder.CreateInsertValue(tuple, index, 0, "")
+	tuple = c.builder.CreateInsertValue(tuple, commaOk, 1, "")
+	for i, valueAlloca := range recvAllocas {
+		received := c.builder.CreateLoad(valueAlloca, "select.received")
+		tuple = c.builder.CreateInsertValue(tuple, received, i+2, "")
+	}
+	return tuple, nil
+}
+
+// getCoroutine returns the coroutine handle of this function to pass to
+// runtime functions that may block, or nil if this function is not a coroutine
+// (when there is no scheduler).
+func (c *Compiler) getCoroutine(frame *Frame) llvm.Value {
+	if frame.blocking {
+		return frame.taskHandle
+	}
+	return llvm.ConstPointerNull(c.i8ptrType)
+}
@@ -32,55 +32,82 @@ func init() {
 // Configure the compiler.
 type Config struct {
 	Triple     string   // LLVM target triple, e.g. x86_64-unknown-linux-gnu (empty string means default)
+	GOOS       string   // GOOS used to select files of the standard library (empty means taken from the triple)
+	GOARCH     string   // GOARCH used to select files of the standard library (empty means taken from the triple)
 	DumpSSA    bool     // dump Go SSA, for compiler debugging
 	Debug      bool     // add debug symbols for gdb
 	RootDir    string   // GOROOT for TinyGo
 	GOPATH     string   // GOPATH, like `go env GOPATH`
 	BuildTags  []string // build tags for TinyGo (empty means {runtime.GOOS/runtime.GOARCH})
 	InitInterp bool     // use new init interpretation, meaning the old one is disabled
+	NilChecks  bool     // panic on nil pointer dereferences instead of crashing
+	Tracebacks bool     // emit a pc table for stack traces and runtime.Caller
+	TypeNames  bool     // always include type names, for printing interface values
+	TestConfig bool     // compile the tests of the package, with a generated main package that runs them
+
+	PackageCache PackageCache // cache for compiled packages (nil means no cache)
 }
 
 type Compiler struct {
 	Config
+	moduleState
+	ctx          llvm.Context
+	builder      llvm.Builder
+	machine      llvm.TargetMachine
+	targetData   llvm.TargetData
+	intType      llvm.Type
+	i8ptrType    llvm.Type // for convenience
+	uintptrType  llvm.Type
+	lenType      llvm.Type
+	typecodeType llvm.Type // also defined as runtime.typecodeID
+	initFuncs    []llvm.Value
+	functions    map[string]*ir.Function // functions by link name
+	sourceHashes map[*types.Package]string
+	pcEntries    []pcEntry // source location of each pc
+	ir           *ir.Program
+}
+
+// The state of the LLVM module that is being built: either the module of a
+// single package, or the final module into which all packages are linked.
+type moduleState struct {
 	mod              llvm.Module
-	ctx              llvm.Context
-	builder          llvm.Builder
 	dibuilder        *llvm.DIBuilder
 	cu               llvm.Metadata
 	difiles          map[string]llvm.Metadata
 	ditypes          map[string]llvm.Metadata
-	machine          llvm.TargetMachine
-	targetData       llvm.TargetData
-	intType          llvm.Type
-	i8ptrType        llvm.Type // for convenience
-	uintptrType      llvm.Type
-	lenType          llvm.Type
 	coroIdFunc       llvm.Value
 	coroSizeFunc     llvm.Value
 	coroBeginFunc    llvm.Value
 	coroSuspendFunc  llvm.Value
 	coroEndFunc      llvm.Value
 	coroFreeFunc     llvm.Value
-	initFuncs        []llvm.Value
 	deferFuncs       []*ir.Function
 	deferInvokeFuncs []InvokeDeferFunction
 	ctxDeferFuncs    []ContextDeferFunction
-	ir               *ir.Program
+	arrayEqualFuncs  []*types.Array                // array types that are compared with ==
+	sliceArrays      map[*ir.ArrayValue]llvm.Value // backing arrays of interpreted slices
 }
 
 type Frame struct {
-	fn           *ir.Function
-	locals       map[ssa.Value]llvm.Value            // local variables
-	blockEntries map[*ssa.BasicBlock]llvm.BasicBlock // a *ssa.BasicBlock may be split up
-	blockExits   map[*ssa.BasicBlock]llvm.BasicBlock // these are the exit blocks
-	currentBlock *ssa.BasicBlock
-	phis         []Phi
-	blocking     bool
-	taskHandle   llvm.Value
-	cleanupBlock llvm.BasicBlock
-	suspendBlock llvm.BasicBlock
-	deferPtr     llvm.Value
-	difunc       llvm.Metadata
+	fn                *ir.Function
+	locals            map[ssa.Value]llvm.Value            // local variables
+	blockEntries      map[*ssa.BasicBlock]llvm.BasicBlock // a *ssa.BasicBlock may be split up
+	blockExits        map[*ssa.BasicBlock]llvm.BasicBlock // these are the exit blocks
+	currentBlock      *ssa.BasicBlock
+	phis              []Phi
+	blocking          bool
+	taskHandle        llvm.Value
+	resultPtr         llvm.Value // where a blocking function stores its results
+	cleanupBlock      llvm.BasicBlock
+	suspendBlock      llvm.BasicBlock
+	finalSuspendBlock llvm.BasicBlock
+	deferPtr          llvm.Value
+	deferCall         llvm.Value // whether recover() may stop a panic, see runtime.deferCallEnter
+	unwindBlock       llvm.BasicBlock
+	difunc            llvm.Metadata
+	callFrame         llvm.Value      // runtime.callFrame of this function, if any
+	pcBlock           *ssa.BasicBlock // block of the last pc stored in callFrame
+	pcPos             token.Position  // source location of the last pc
 }
 
 type Phi struct {
@@ -92,7 +119,8 @@ type Phi struct {
 type ContextDeferFunction struct {
 	fn          llvm.Value
 	deferStruct []llvm.Type
-	signature   *types.Signature
+	fnType      llvm.Type // type of the closure function pointer
+	recovers    bool      // whether the closure function calls recover()
 }
 
 // A thunk for a defer that defers calling an interface method.
@@ -109,9 +137,7 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 		config.BuildTags = []string{runtime.GOOS, runtime.GOARCH}
 	}
 	c := &Compiler{
-		Config:  config,
-		difiles: make(map[string]llvm.Metadata),
-		ditypes: make(map[string]llvm.Metadata),
+		Config: config,
 	}
 
 	target, err := llvm.GetTargetFromTriple(config.Triple)
@@ -122,11 +148,7 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 	c.targetData = c.machine.CreateTargetData()
 
 	c.ctx = llvm.NewContext()
-	c.mod = c.ctx.NewModule(pkgName)
-	c.mod.SetTarget(config.Triple)
-	c.mod.SetDataLayout(c.targetData.String())
 	c.builder = c.ctx.NewBuilder()
-	c.dibuilder = llvm.NewDIBuilder(c.mod)
 
 	// Depends on platform (32bit or 64bit), but fix it here for now.
 	c.intType = c.ctx.Int32Type()
@@ -139,25 +161,43 @@ func NewCompiler(pkgName string, config Config) (*Compiler, error) {
 	}
 	c.i8ptrType = llvm.PointerType(c.ctx.Int8Type(), 0)
 
+	c.moduleState = c.newModuleState(pkgName)
+
+	return c, nil
+}
+
+// newModuleState creates a new LLVM module with the given name, with the
+// intrinsics that the compiler uses declared in it.
+func (c *Compiler) newModuleState(name string) moduleState {
+	m := moduleState{
+		mod:         c.ctx.NewModule(name),
+		difiles:     make(map[string]llvm.Metadata),
+		ditypes:     make(map[string]llvm.Metadata),
+		sliceArrays: make(map[*ir.ArrayValue]llvm.Value),
+	}
+	m.mod.SetTarget(c.Triple)
+	m.mod.SetDataLayout(c.targetData.String())
+	m.dibuilder = llvm.NewDIBuilder(m.mod)
+
 	coroIdType := llvm.FunctionType(c.ctx.TokenType(), []llvm.Type{c.ctx.Int32Type(), c.i8ptrType, c.i8ptrType, c.i8ptrType}, false)
-	c.coroIdFunc = llvm.AddFunction(c.mod, "llvm.coro.id", coroIdType)
+	m.coroIdFunc = llvm.AddFunction(m.mod, "llvm.coro.id", coroIdType)
 
 	coroSizeType := llvm.FunctionType(c.ctx.Int32Type(), nil, false)
-	c.coroSizeFunc = llvm.AddFunction(c.mod, "llvm.coro.size.i32", coroSizeType)
+	m.coroSizeFunc = llvm.AddFunction(m.mod, "llvm.coro.size.i32", coroSizeType)
 
 	coroBeginType := llvm.FunctionType(c.i8ptrType, []llvm.Type{c.ctx.TokenType(), c.i8ptrType}, false)
-	c.coroBeginFunc = llvm.AddFunction(c.mod, "llvm.coro.begin", coroBeginType)
+	m.coroBeginFunc = llvm.AddFunction(m.mod, "llvm.coro.begin", coroBeginType)
 
 	coroSuspendType := llvm.FunctionType(c.ctx.Int8Type(), []llvm.Type{c.ctx.TokenType(), c.ctx.Int1Type()}, false)
-	c.coroSuspendFunc = llvm.AddFunction(c.mod, "llvm.coro.suspend", coroSuspendType)
+	m.coroSuspendFunc = llvm.AddFunction(m.mod, "llvm.coro.suspend", coroSuspendType)
 
 	coroEndType := llvm.FunctionType(c.ctx.Int1Type(), []llvm.Type{c.i8ptrType, c.ctx.Int1Type()}, false)
-	c.coroEndFunc = llvm.AddFunction(c.mod, "llvm.coro.end", coroEndType)
+	m.coroEndFunc = llvm.AddFunction(m.mod, "llvm.coro.end", coroEndType)
 
 	coroFreeType := llvm.FunctionType(c.i8ptrType, []llvm.Type{c.ctx.TokenType(), c.i8ptrType}, false)
-	c.coroFreeFunc = llvm.AddFunction(c.mod, "llvm.coro.free", coroFreeType)
+	m.coroFreeFunc = llvm.AddFunction(m.mod, "llvm.coro.free", coroFreeType)
 
-	return c, nil
+	return m
 }
 
 // Return the LLVM module. Only valid after a successful compile.
@@ -174,6 +214,14 @@ func (c *Compiler) TargetData() llvm.TargetData {
 // fails (in any stage).
 func (c *Compiler) Compile(mainPath string) error {
 	tripleSplit := strings.Split(c.Triple, "-")
+	goos := c.GOOS
+	if goos == "" {
+		goos = tripleSplit[2]
+	}
+	goarch := c.GOARCH
+	if goarch == "" {
+		goarch = tripleSplit[0]
+	}
 
 	// Prefix the GOPATH with the system GOROOT, as GOROOT is already set to
 	// the TinyGo root.
@@ -193,8 +241,8 @@ func (c *Compiler) Compile(mainPath string) error {
 			},
 		},
 		Build: &build.Context{
-			GOARCH:      tripleSplit[0],
-			GOOS:        tripleSplit[2],
+			GOARCH:      goarch,
+			GOOS:        goos,
 			GOROOT:      c.RootDir,
 			GOPATH:      gopath,
 			CgoEnabled:  true,
@@ -205,7 +253,13 @@ func (c *Compiler) Compile(mainPath string) error {
 		ParserMode: parser.ParseComments,
 	}
 	config.Import("runtime")
-	if strings.HasSuffix(mainPath, ".go") {
+	if c.TestConfig {
+		err := c.loadTestPackage(&config, mainPath)
+		if err != nil {
+			return err
+		}
+		mainPath = "main" // the generated main package
+	} else if strings.HasSuffix(mainPath, ".go") {
 		config.CreateFromFilenames("main", mainPath)
 	} else {
 		config.Import(mainPath)
@@ -226,15 +280,6 @@ func (c *Compiler) Compile(mainPath string) error {
 	c.ir.AnalyseBlockingRecursive()    // make all parents of blocking calls blocking (transitively)
 	c.ir.AnalyseGoCalls()              // check whether we need a scheduler
 
-	// Initialize debug information.
-	c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
-		Language:  llvm.DW_LANG_Go,
-		File:      mainPath,
-		Dir:       "",
-		Producer:  "TinyGo",
-		Optimized: true,
-	})
-
 	var frames []*Frame
 
 	// Declare all named struct types.
@@ -259,54 +304,79 @@ func (c *Compiler) Compile(mainPath string) error {
 		}
 	}
 
-	// Declare all globals. These will get an initializer when parsing "package
-	// initializer" functions.
-	for _, g := range c.ir.Globals {
-		typ := g.Type().(*types.Pointer).Elem()
-		llvmType, err := c.getLLVMType(typ)
-		if err != nil {
-			return err
-		}
-		global := c.mod.NamedGlobal(g.LinkName())
-		if global.IsNil() {
-			global = llvm.AddGlobal(c.mod, llvmType, g.LinkName())
+	// The width of typecodes depends on the target and is set by the runtime
+	// with build tags.
+	c.typecodeType = c.mod.GetTypeByName("runtime._interface").StructElementTypes()[0]
+
+	// Find the function with each link name. A function with a body is
+	// preferred over a body-less //go:linkname declaration.
+	c.functions = make(map[string]*ir.Function)
+	for _, f := range c.ir.Functions {
+		if other := c.functions[f.LinkName()]; other == nil || len(other.Blocks) == 0 {
+			c.functions[f.LinkName()] = f
 		}
-		g.LLVMGlobal = global
-		if !g.IsExtern() {
-			global.SetLinkage(llvm.InternalLinkage)
-			initializer, err := c.getZeroValue(llvmType)
-			if err != nil {
-				return err
+	}
+
+	// Try to interpret as much as possible of the package initializers.
+	// Whenever it hits an instruction that it doesn't understand, it bails out
+	// and leaves the rest to the compiler (so initialization continues at
+	// runtime).
+	// This should only happen when it hits a function call or the end of the
+	// block, ideally.
+	if !c.InitInterp {
+		for _, f := range c.ir.Functions {
+			if f.Synthetic == "package initializer" {
+				err := c.ir.Interpret(f.Blocks[0], c.DumpSSA)
+				if err != nil {
+					return err
+				}
 			}
-			global.SetInitializer(initializer)
 		}
 	}
 
-	// Declare all functions.
-	for _, f := range c.ir.Functions {
-		frame, err := c.parseFuncDecl(f)
-		if err != nil {
-			return err
-		}
-		frames = append(frames, frame)
+	// Initialize debug information.
+	c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
+		Language:  llvm.DW_LANG_Go,
+		File:      mainPath,
+		Dir:       "",
+		Producer:  "TinyGo",
+		Optimized: true,
+	})
+
+	// Declare all globals and functions before linking packages into the
+	// module, so that the definitions in the packages use the same types.
+	err = c.declareGlobals(false)
+	if err != nil {
+		return err
+	}
+	_, err = c.declareFunctions(nil)
+	if err != nil {
+		return err
+	}
+
+	// Compile all packages to separate modules (or load them from the cache)
+	// and link them into this module.
+	err = c.linkPackages(lprogram)
+	if err != nil {
+		return err
+	}
+
+	// Everything that depends on the whole program is done at this link
+	// stage: globals are defined and package initializers, wrappers and
+	// runtime type information are created.
+	err = c.declareGlobals(true)
+	if err != nil {
+		return err
+	}
+	frames, err = c.declareFunctions(isLinkStageFunction)
+	if err != nil {
+		return err
 	}
 
-	// Find and interpret package initializers.
+	// Compile package initializers.
 	for _, frame := range frames {
 		if frame.fn.Synthetic == "package initializer" {
 			c.initFuncs = append(c.initFuncs, frame.fn.LLVMFn)
-			// Try to interpret as much as possible of the init() function.
-			// Whenever it hits an instruction that it doesn't understand, it
-			// bails out and leaves the rest to the compiler (so initialization
-			// continues at runtime).
-			// This should only happen when it hits a function call or the end
-			// of the block, ideally.
-			if !c.InitInterp {
-				err := c.ir.Interpret(frame.fn.Blocks[0], c.DumpSSA)
-				if err != nil {
-					return err
-				}
-			}
 			err = c.parseFunc(frame)
 			if err != nil {
 				return err
@@ -325,7 +395,8 @@ func (c *Compiler) Compile(mainPath string) error {
 		}
 	}
 
-	// Add definitions to declarations.
+	// Add definitions to the remaining declarations that are not part of a
+	// package, like wrappers for methods.
 	for _, frame := range frames {
 		if frame.fn.CName() != "" {
 			continue
@@ -333,17 +404,179 @@ func (c *Compiler) Compile(mainPath string) error {
 		if frame.fn.Blocks == nil {
 			continue // external function
 		}
-		var err error
-		if frame.fn.Synthetic == "package initializer" {
-			continue // already done
-		} else {
-			err = c.parseFunc(frame)
+		if !isLinkStageFunction(frame.fn) || frame.fn.Synthetic == "package initializer" {
+			continue // in a package or already done
+		}
+		err = c.parseFunc(frame)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.createWrappers()
+	if err != nil {
+		return err
+	}
+
+	// After all packages are imported, add a synthetic initializer function
+	// that calls the initializer of each package.
+	initFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["initAll"].(*ssa.Function))
+	initFn.LLVMFn.SetLinkage(llvm.InternalLinkage)
+	initFn.LLVMFn.SetUnnamedAddr(true)
+	if c.Debug {
+		difunc, err := c.attachDebugInfo(initFn)
+		if err != nil {
+			return err
+		}
+		pos := c.ir.Program.Fset.Position(initFn.Pos())
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
+	}
+	block := c.ctx.AddBasicBlock(initFn.LLVMFn, "entry")
+	c.builder.SetInsertPointAtEnd(block)
+	for _, fn := range c.initFuncs {
+		c.builder.CreateCall(fn, nil, "")
+		if c.ir.NeedsUnwinding() {
+			// Abort on a panic that was not recovered.
+			c.createRuntimeCall("panicTaskDone", []llvm.Value{llvm.ConstPointerNull(c.i8ptrType)}, "")
 		}
+	}
+	c.builder.CreateRetVoid()
+
+	// Tell the runtime to unwind the stack on a panic, to run deferred calls
+	// and to allow recovering it.
+	if c.ir.NeedsUnwinding() {
+		c.mod.NamedGlobal("runtime.unwindEnabled").SetInitializer(llvm.ConstInt(c.ctx.Int1Type(), 1, false))
+	}
+
+	// Print interface values with their type name when the program can read
+	// type names anyway, or when the target asks for it. Otherwise the type
+	// names and the reflect package are left out of the program.
+	if typeNamesEnabled := c.mod.NamedGlobal("runtime.typeNamesEnabled"); !typeNamesEnabled.IsNil() && (c.TypeNames || c.ir.UsesReflect()) {
+		typeNamesEnabled.SetInitializer(llvm.ConstInt(c.ctx.Int1Type(), 1, false))
+	}
+
+	// Add a wrapper for the main.main function, either calling it directly or
+	// setting up the scheduler with it.
+	mainWrapper := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["mainWrapper"].(*ssa.Function))
+	mainWrapper.LLVMFn.SetLinkage(llvm.InternalLinkage)
+	mainWrapper.LLVMFn.SetUnnamedAddr(true)
+	if c.Debug {
+		difunc, err := c.attachDebugInfo(mainWrapper)
 		if err != nil {
 			return err
 		}
+		pos := c.ir.Program.Fset.Position(mainWrapper.Pos())
+		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
 	}
+	block = c.ctx.AddBasicBlock(mainWrapper.LLVMFn, "entry")
+	c.builder.SetInsertPointAtEnd(block)
+	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
+	if c.ir.NeedsScheduler() {
+		coroutine := c.builder.CreateCall(realMain, []llvm.Value{llvm.ConstPointerNull(c.i8ptrType)}, "")
+		scheduler := c.mod.NamedFunction("runtime.scheduler")
+		c.builder.CreateCall(scheduler, []llvm.Value{coroutine}, "")
+	} else {
+		c.builder.CreateCall(realMain, nil, "")
+		if c.ir.NeedsUnwinding() {
+			// Abort on a panic that was not recovered.
+			c.createRuntimeCall("panicTaskDone", []llvm.Value{llvm.ConstPointerNull(c.i8ptrType)}, "")
+		}
+	}
+	c.builder.CreateRetVoid()
 
+	// Functions in packages are defined with external linkage, so that they
+	// can be linked together. Only exported functions need that.
+	for _, f := range c.ir.Functions {
+		if f.LLVMFn.IsDeclaration() || f.IsExported() {
+			continue
+		}
+		f.LLVMFn.SetLinkage(llvm.InternalLinkage)
+		f.LLVMFn.SetUnnamedAddr(true)
+	}
+
+	// Now that all types, interfaces and methods are known, replace the
+	// placeholders of typecodes and such with real numbers.
+	err = c.resolvePlaceholders()
+	if err != nil {
+		return err
+	}
+
+	// Add runtime type information for interfaces: interface calls and type
+	// asserts.
+	err = c.createInterfaceRTTI()
+	if err != nil {
+		return err
+	}
+
+	// Add type descriptors for the reflect package.
+	err = c.createTypeDescriptors()
+	if err != nil {
+		return err
+	}
+
+	// Add the pc table for stack traces.
+	c.createPCTable()
+
+	c.finalizeModule()
+
+	return nil
+}
+
+// Declare all globals in the current module. When define is set, the globals
+// are given a zero initializer, which is replaced with the real initializer
+// after the package initializers have been interpreted. Otherwise they are
+// left as external declarations, to be defined when linking.
+func (c *Compiler) declareGlobals(define bool) error {
+	for _, g := range c.ir.Globals {
+		typ := g.Type().(*types.Pointer).Elem()
+		llvmType, err := c.getLLVMType(typ)
+		if err != nil {
+			return err
+		}
+		global := c.mod.NamedGlobal(g.LinkName())
+		if global.IsNil() {
+			global = llvm.AddGlobal(c.mod, llvmType, g.LinkName())
+		}
+		g.LLVMGlobal = global
+		if define && !g.IsExtern() {
+			global.SetLinkage(llvm.InternalLinkage)
+			initializer, err := c.getZeroValue(llvmType)
+			if err != nil {
+				return err
+			}
+			global.SetInitializer(initializer)
+		}
+	}
+	return nil
+}
+
+// Declare all functions in the current module. Debug information is attached
+// to the functions for which define returns true, as they will be defined in
+// this module. It returns a frame for every function.
+func (c *Compiler) declareFunctions(define func(*ir.Function) bool) ([]*Frame, error) {
+	// Functions with a body are declared first, so that a body-less
+	// //go:linkname declaration (for example in the reflect package, which is
+	// compiled before the runtime that imports it) doesn't determine the
+	// parameter types of the function it refers to.
+	var frames []*Frame
+	for _, withBody := range []bool{true, false} {
+		for _, f := range c.ir.Functions {
+			if (len(f.Blocks) != 0) != withBody {
+				continue
+			}
+			frame, err := c.parseFuncDecl(f, define != nil && define(f))
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, frame)
+		}
+	}
+	return frames, nil
+}
+
+// Create the bodies of the wrappers that were declared while compiling
+// functions in the current module, like the wrappers of deferred calls.
+func (c *Compiler) createWrappers() error {
 	// Create deferred function wrappers.
 	for _, fn := range c.deferFuncs {
 		// This function gets a single parameter which is a pointer to a struct
@@ -379,8 +612,16 @@ func (c *Compiler) Compile(mainPath string) error {
 			forwardParam := c.builder.CreateLoad(gep, "param")
 			forwardParams = append(forwardParams, forwardParam)
 		}
+		if c.ir.FunctionNeedsContext(fn) {
+			// The function is not a closure here, so pass a nil context, like
+			// a regular call to it does.
+			forwardParams = append(forwardParams, llvm.ConstPointerNull(c.i8ptrType))
+		}
 
 		// Call real function (of which this is a wrapper).
+		if !fn.CallsRecover() {
+			c.emitDeferCallClear()
+		}
 		c.createCall(fn.LLVMFn, forwardParams, "")
 		c.builder.CreateRetVoid()
 	}
@@ -413,7 +654,10 @@ func (c *Compiler) Compile(mainPath string) error {
 			forwardParams = append(forwardParams, forwardParam)
 		}
 
-		// Call real function (of which this is a wrapper).
+		// Call real function (of which this is a wrapper). The method is only
+		// known at run time, so it is up to the method whether it calls
+		// recover(). Only functions called by a method that doesn't call
+		// recover() itself could wrongly recover the panic.
 		fnGEP := c.builder.CreateGEP(deferFramePtr, []llvm.Value{zero, llvm.ConstInt(c.ctx.Int32Type(), 2, false)}, "fn.gep")
 		fn := c.builder.CreateLoad(fnGEP, "fn")
 		c.createCall(fn, forwardParams, "")
@@ -467,70 +711,31 @@ func (c *Compiler) Compile(mainPath string) error {
 
 		// Cast the function pointer in the closure to the correct function
 		// pointer type.
-		closureType, err := c.getLLVMType(thunk.signature)
-		if err != nil {
-			return err
-		}
-		fpType := closureType.StructElementTypes()[1]
-		fpCast := c.builder.CreateBitCast(fp, fpType, "closure.fp.cast")
+		fpCast := c.builder.CreateBitCast(fp, thunk.fnType, "closure.fp.cast")
 
 		// Call real function (of which this is a wrapper).
+		if !thunk.recovers {
+			c.emitDeferCallClear()
+		}
 		c.createCall(fpCast, forwardParams, "")
 		c.builder.CreateRetVoid()
 	}
 
-	// After all packages are imported, add a synthetic initializer function
-	// that calls the initializer of each package.
-	initFn := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["initAll"].(*ssa.Function))
-	initFn.LLVMFn.SetLinkage(llvm.InternalLinkage)
-	initFn.LLVMFn.SetUnnamedAddr(true)
-	if c.Debug {
-		difunc, err := c.attachDebugInfo(initFn)
+	// Create the functions that compare arrays. Comparing nested arrays may
+	// declare more of them while doing this.
+	for i := 0; i < len(c.arrayEqualFuncs); i++ {
+		err := c.createArrayEqualFunc(c.arrayEqualFuncs[i])
 		if err != nil {
 			return err
 		}
-		pos := c.ir.Program.Fset.Position(initFn.Pos())
-		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
-	}
-	block := c.ctx.AddBasicBlock(initFn.LLVMFn, "entry")
-	c.builder.SetInsertPointAtEnd(block)
-	for _, fn := range c.initFuncs {
-		c.builder.CreateCall(fn, nil, "")
 	}
-	c.builder.CreateRetVoid()
 
-	// Add a wrapper for the main.main function, either calling it directly or
-	// setting up the scheduler with it.
-	mainWrapper := c.ir.GetFunction(c.ir.Program.ImportedPackage("runtime").Members["mainWrapper"].(*ssa.Function))
-	mainWrapper.LLVMFn.SetLinkage(llvm.InternalLinkage)
-	mainWrapper.LLVMFn.SetUnnamedAddr(true)
-	if c.Debug {
-		difunc, err := c.attachDebugInfo(mainWrapper)
-		if err != nil {
-			return err
-		}
-		pos := c.ir.Program.Fset.Position(mainWrapper.Pos())
-		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), difunc, llvm.Metadata{})
-	}
-	block = c.ctx.AddBasicBlock(mainWrapper.LLVMFn, "entry")
-	c.builder.SetInsertPointAtEnd(block)
-	realMain := c.mod.NamedFunction(c.ir.MainPkg().Pkg.Path() + ".main")
-	if c.ir.NeedsScheduler() {
-		coroutine := c.builder.CreateCall(realMain, []llvm.Value{llvm.ConstPointerNull(c.i8ptrType)}, "")
-		scheduler := c.mod.NamedFunction("runtime.scheduler")
-		c.builder.CreateCall(scheduler, []llvm.Value{coroutine}, "")
-	} else {
-		c.builder.CreateCall(realMain, nil, "")
-	}
-	c.builder.CreateRetVoid()
-
-	// Add runtime type information for interfaces: interface calls and type
-	// asserts.
-	err = c.createInterfaceRTTI()
-	if err != nil {
-		return err
-	}
+	return nil
+}
 
+// Add the module flags and finalize the debug information of the current
+// module.
+func (c *Compiler) finalizeModule() {
 	// see: https://reviews.llvm.org/D18355
 	c.mod.AddNamedMetadataOperand("llvm.module.flags",
 		c.ctx.MDNode([]llvm.Metadata{
@@ -540,8 +745,6 @@ func (c *Compiler) Compile(mainPath string) error {
 		}),
 	)
 	c.dibuilder.Finalize()
-
-	return nil
 }
 
 func (c *Compiler) getLLVMType(goType types.Type) (llvm.Type, error) {
@@ -590,10 +793,27 @@ func (c *Compiler) getLLVMType(goType types.Type) (llvm.Type, error) {
 	case *types.Map:
 		return llvm.PointerType(c.mod.GetTypeByName("runtime.hashmap"), 0), nil
 	case *types.Named:
-		if _, ok := typ.Underlying().(*types.Struct); ok {
-			llvmType := c.mod.GetTypeByName(typ.Obj().Pkg().Path() + "." + typ.Obj().Name())
+		if st, ok := typ.Underlying().(*types.Struct); ok {
+			name := typ.Obj().Pkg().Path() + "." + typ.Obj().Name()
+			if typ.Obj().Parent() != typ.Obj().Pkg().Scope() {
+				// Types declared inside a function are not package members, so
+				// they are created on first use. They are not unique by name
+				// so add the position to the name.
+				name += "$" + strconv.Itoa(int(typ.Obj().Pos()))
+				llvmType := c.mod.GetTypeByName(name)
+				if llvmType.IsNil() {
+					llvmType = c.ctx.StructCreateNamed(name)
+					underlying, err := c.getLLVMType(st)
+					if err != nil {
+						return llvm.Type{}, err
+					}
+					llvmType.StructSetBody(underlying.StructElementTypes(), false)
+				}
+				return llvmType, nil
+			}
+			llvmType := c.mod.GetTypeByName(name)
 			if llvmType.IsNil() {
-				return llvm.Type{}, errors.New("type not found: " + typ.Obj().Pkg().Path() + "." + typ.Obj().Name())
+				return llvm.Type{}, errors.New("type not found: " + name)
 			}
 			return llvmType, nil
 		}
@@ -649,16 +869,20 @@ func (c *Compiler) getLLVMType(goType types.Type) (llvm.Type, error) {
 			}
 			paramTypes = append(paramTypes, c.expandFormalParamType(subType)...)
 		}
-		var ptr llvm.Type
+		if c.ir.SignatureNeedsContext(typ) {
+			// closures have an extra context parameter
+			paramTypes = append(paramTypes, c.i8ptrType)
+		}
+		fnType := llvm.FunctionType(returnType, paramTypes, false)
+		if typ.Recv() == nil && c.ir.IsBlockingSignature(typ) {
+			// function pointers to blocking functions point to coroutines
+			fnType = c.getCoroutineFunctionType(fnType)
+		}
+		ptr := llvm.PointerType(fnType, 0)
 		if c.ir.SignatureNeedsContext(typ) {
 			// make a closure type (with a function pointer type inside):
 			// {context, funcptr}
-			paramTypes = append(paramTypes, c.i8ptrType)
-			ptr = llvm.PointerType(llvm.FunctionType(returnType, paramTypes, false), 0)
 			ptr = c.ctx.StructType([]llvm.Type{c.i8ptrType, ptr}, false)
-		} else {
-			// make a simple function pointer
-			ptr = llvm.PointerType(llvm.FunctionType(returnType, paramTypes, false), 0)
 		}
 		return ptr, nil
 	case *types.Slice:
@@ -687,6 +911,19 @@ func (c *Compiler) getLLVMType(goType types.Type) (llvm.Type, error) {
 	}
 }
 
+// getCoroutineFunctionType returns the type of a blocking function (a
+// coroutine) with the given regular function type. A coroutine gets its parent
+// coroutine as the first parameter and returns its own handle, so any results
+// are stored through a pointer passed as the second parameter.
+func (c *Compiler) getCoroutineFunctionType(fnType llvm.Type) llvm.Type {
+	paramTypes := []llvm.Type{c.i8ptrType} // parent coroutine
+	if returnType := fnType.ReturnType(); returnType.TypeKind() != llvm.VoidTypeKind {
+		paramTypes = append(paramTypes, llvm.PointerType(returnType, 0))
+	}
+	paramTypes = append(paramTypes, fnType.ParamTypes()...)
+	return llvm.FunctionType(c.i8ptrType, paramTypes, false)
+}
+
 // Return a zero LLVM value for any LLVM type. Setting this value as an
 // initializer has the same effect as setting 'zeroinitializer' on a value.
 // Sadly, I haven't found a way to do it directly with the Go API but this works
@@ -792,7 +1029,10 @@ func (c *Compiler) getDIType(typ types.Type) (llvm.Metadata, error) {
 	}
 }
 
-func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
+// parseFuncDecl declares the function in the current module and returns a
+// frame for it. Debug information is attached when the function is going to be
+// defined in this module.
+func (c *Compiler) parseFuncDecl(f *ir.Function, define bool) (*Frame, error) {
 	frame := &Frame{
 		fn:           f,
 		locals:       make(map[ssa.Value]llvm.Value),
@@ -802,12 +1042,7 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
 	}
 
 	var retType llvm.Type
-	if frame.blocking {
-		if f.Signature.Results() != nil {
-			return nil, errors.New("todo: return values in blocking function")
-		}
-		retType = c.i8ptrType
-	} else if f.Signature.Results() == nil {
+	if f.Signature.Results() == nil {
 		retType = c.ctx.VoidType()
 	} else if f.Signature.Results().Len() == 1 {
 		var err error
@@ -828,9 +1063,6 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
 	}
 
 	var paramTypes []llvm.Type
-	if frame.blocking {
-		paramTypes = append(paramTypes, c.i8ptrType) // parent coroutine
-	}
 	for _, param := range f.Params {
 		paramType, err := c.getLLVMType(param.Type())
 		if err != nil {
@@ -847,6 +1079,9 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
 	}
 
 	fnType := llvm.FunctionType(retType, paramTypes, false)
+	if frame.blocking {
+		fnType = c.getCoroutineFunctionType(fnType)
+	}
 
 	name := f.LinkName()
 	frame.fn.LLVMFn = c.mod.NamedFunction(name)
@@ -854,13 +1089,13 @@ func (c *Compiler) parseFuncDecl(f *ir.Function) (*Frame, error) {
 		frame.fn.LLVMFn = llvm.AddFunction(c.mod, name, fnType)
 	}
 
-	if c.Debug && f.Synthetic == "package initializer" {
+	if define && c.Debug && f.Synthetic == "package initializer" {
 		difunc, err := c.attachDebugInfoRaw(f, f.LLVMFn, "", "", 0)
 		if err != nil {
 			return nil, err
 		}
 		frame.difunc = difunc
-	} else if c.Debug && f.Syntax() != nil && len(f.Blocks) != 0 {
+	} else if define && c.Debug && f.Syntax() != nil && len(f.Blocks) != 0 {
 		// Create debug info file if needed.
 		difunc, err := c.attachDebugInfo(f)
 		if err != nil {
@@ -916,15 +1151,16 @@ func (c *Compiler) attachDebugInfoRaw(f *ir.Function, llvmFn llvm.Value, suffix,
 	return difunc, nil
 }
 
-// Create a new global hashmap bucket, for map initialization.
-func (c *Compiler) initMapNewBucket(prefix string, mapType *types.Map) (llvm.Value, uint64, uint64, error) {
-	llvmKeyType, err := c.getLLVMType(mapType.Key().Underlying())
+// Return the LLVM type of a hashmap bucket of the given map type, together
+// with the size of the key and the value.
+func (c *Compiler) getMapBucketType(mapType *types.Map) (llvm.Type, uint64, uint64, error) {
+	llvmKeyType, err := c.getLLVMType(mapType.Key())
 	if err != nil {
-		return llvm.Value{}, 0, 0, err
+		return llvm.Type{}, 0, 0, err
 	}
-	llvmValueType, err := c.getLLVMType(mapType.Elem().Underlying())
+	llvmValueType, err := c.getLLVMType(mapType.Elem())
 	if err != nil {
-		return llvm.Value{}, 0, 0, err
+		return llvm.Type{}, 0, 0, err
 	}
 	keySize := c.targetData.TypeAllocSize(llvmKeyType)
 	valueSize := c.targetData.TypeAllocSize(llvmValueType)
@@ -934,14 +1170,41 @@ func (c *Compiler) initMapNewBucket(prefix string, mapType *types.Map) (llvm.Val
 		llvm.ArrayType(llvmKeyType, 8),      // key type
 		llvm.ArrayType(llvmValueType, 8),    // value type
 	}, false)
-	bucketValue, err := c.getZeroValue(bucketType)
-	if err != nil {
-		return llvm.Value{}, 0, 0, err
+	return bucketType, keySize, valueSize, nil
+}
+
+// Create a chain of hashmap buckets with the given entries, for map
+// initialization. The first bucket is returned as a constant, all following
+// buckets in the chain are stored in new globals.
+func (c *Compiler) initMapBucketChain(prefix string, bucketType llvm.Type, tophashes []uint8, keys, values []llvm.Value) (llvm.Value, error) {
+	numBuckets := (len(keys) + 7) / 8
+	if numBuckets == 0 {
+		numBuckets = 1
 	}
-	bucket := llvm.AddGlobal(c.mod, bucketType, prefix+"$hashmap$bucket")
-	bucket.SetInitializer(bucketValue)
-	bucket.SetLinkage(llvm.InternalLinkage)
-	return bucket, keySize, valueSize, nil
+	next := llvm.ConstPointerNull(c.i8ptrType)
+	var bucket llvm.Value
+	for b := numBuckets - 1; b >= 0; b-- {
+		var err error
+		bucket, err = c.getZeroValue(bucketType)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		bucket = llvm.ConstInsertValue(bucket, next, []uint32{1})
+		for i := b * 8; i < len(keys) && i < b*8+8; i++ {
+			tophashValue := llvm.ConstInt(c.ctx.Int8Type(), uint64(tophashes[i]), false)
+			bucket = llvm.ConstInsertValue(bucket, tophashValue, []uint32{0, uint32(i % 8)})
+			bucket = llvm.ConstInsertValue(bucket, keys[i], []uint32{2, uint32(i % 8)})
+			bucket = llvm.ConstInsertValue(bucket, values[i], []uint32{3, uint32(i % 8)})
+		}
+		if b != 0 {
+			// This is an overflow bucket, put it in a global.
+			bucketGlobal := llvm.AddGlobal(c.mod, bucketType, prefix+"$hashmap$bucket")
+			bucketGlobal.SetInitializer(bucket)
+			bucketGlobal.SetLinkage(llvm.InternalLinkage)
+			next = llvm.ConstBitCast(bucketGlobal, c.i8ptrType)
+		}
+	}
+	return bucket, nil
 }
 
 func (c *Compiler) parseGlobalInitializer(g *ir.Global) error {
@@ -1012,83 +1275,73 @@ func (c *Compiler) getInterpretedValue(prefix string, value ir.Value) (llvm.Valu
 		return c.parseMakeInterface(underlying, value.Type, prefix)
 
 	case *ir.MapValue:
-		// Create initial bucket.
-		firstBucketGlobal, keySize, valueSize, err := c.initMapNewBucket(prefix, value.Type)
+		bucketType, keySize, valueSize, err := c.getMapBucketType(value.Type)
 		if err != nil {
 			return llvm.Value{}, err
 		}
 
-		// Insert each key/value pair in the hashmap.
-		bucketGlobal := firstBucketGlobal
+		// Use as many buckets as the runtime would have after inserting all
+		// keys, so that chains stay short.
+		bucketBits := uint(0)
+		for len(value.Keys) > 6<<bucketBits {
+			bucketBits++
+		}
+		numBuckets := 1 << bucketBits
+
+		// Sort each key/value pair in the bucket it belongs to.
+		tophashes := make([][]uint8, numBuckets)
+		keys := make([][]llvm.Value, numBuckets)
+		values := make([][]llvm.Value, numBuckets)
 		for i, key := range value.Keys {
 			llvmKey, err := c.getInterpretedValue(prefix, key)
 			if err != nil {
-				return llvm.Value{}, nil
+				return llvm.Value{}, err
 			}
 			llvmValue, err := c.getInterpretedValue(prefix, value.Values[i])
 			if err != nil {
-				return llvm.Value{}, nil
-			}
-
-			constVal := key.(*ir.ConstValue).Expr
-			var keyBuf []byte
-			switch constVal.Type().Underlying().(*types.Basic).Kind() {
-			case types.String, types.UntypedString:
-				keyBuf = []byte(constant.StringVal(constVal.Value))
-			case types.Int:
-				keyBuf = make([]byte, c.targetData.TypeAllocSize(c.intType))
-				n, _ := constant.Uint64Val(constVal.Value)
-				for i := range keyBuf {
-					keyBuf[i] = byte(n)
-					n >>= 8
-				}
-			default:
-				return llvm.Value{}, errors.New("todo: init: map key not implemented: " + constVal.Type().Underlying().String())
+				return llvm.Value{}, err
 			}
-			hash := hashmapHash(keyBuf)
+			hash, err := c.getMapKeyHash(value.Type.Key(), key)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			bucketNumber := hash & uint32(numBuckets-1)
+			tophashes[bucketNumber] = append(tophashes[bucketNumber], hashmapTopHash(hash))
+			keys[bucketNumber] = append(keys[bucketNumber], llvmKey)
+			values[bucketNumber] = append(values[bucketNumber], llvmValue)
+		}
 
-			if i%8 == 0 && i != 0 {
-				// Bucket is full, create a new one.
-				newBucketGlobal, _, _, err := c.initMapNewBucket(prefix, value.Type)
-				if err != nil {
-					return llvm.Value{}, err
-				}
-				zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
-				newBucketPtr := llvm.ConstInBoundsGEP(newBucketGlobal, []llvm.Value{zero})
-				newBucketPtrCast := llvm.ConstBitCast(newBucketPtr, c.i8ptrType)
-				// insert pointer into old bucket
-				bucket := bucketGlobal.Initializer()
-				bucket = llvm.ConstInsertValue(bucket, newBucketPtrCast, []uint32{1})
-				bucketGlobal.SetInitializer(bucket)
-				// switch to next bucket
-				bucketGlobal = newBucketGlobal
-			}
-
-			tophashValue := llvm.ConstInt(c.ctx.Int8Type(), uint64(hashmapTopHash(hash)), false)
-			bucket := bucketGlobal.Initializer()
-			bucket = llvm.ConstInsertValue(bucket, tophashValue, []uint32{0, uint32(i % 8)})
-			bucket = llvm.ConstInsertValue(bucket, llvmKey, []uint32{2, uint32(i % 8)})
-			bucket = llvm.ConstInsertValue(bucket, llvmValue, []uint32{3, uint32(i % 8)})
-			bucketGlobal.SetInitializer(bucket)
+		// Create the buckets.
+		buckets := make([]llvm.Value, numBuckets)
+		for i := range buckets {
+			buckets[i], err = c.initMapBucketChain(prefix, bucketType, tophashes[i], keys[i], values[i])
+			if err != nil {
+				return llvm.Value{}, err
+			}
 		}
+		bucketsGlobal := llvm.AddGlobal(c.mod, llvm.ArrayType(bucketType, numBuckets), prefix+"$hashmap$buckets")
+		bucketsGlobal.SetInitializer(llvm.ConstArray(bucketType, buckets))
+		bucketsGlobal.SetLinkage(llvm.InternalLinkage)
 
 		// Create the hashmap itself.
-		zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
-		bucketPtr := llvm.ConstInBoundsGEP(firstBucketGlobal, []llvm.Value{zero})
 		hashmapType := c.mod.GetTypeByName("runtime.hashmap")
+		keyKind := hashmapKeyKind(value.Type.Key())
+		keyTypecode := uint64(c.getTypeNum(value.Type.Key()))
 		hashmap := llvm.ConstNamedStruct(hashmapType, []llvm.Value{
-			llvm.ConstPointerNull(llvm.PointerType(hashmapType, 0)),  // next
-			llvm.ConstBitCast(bucketPtr, c.i8ptrType),                // buckets
-			llvm.ConstInt(c.lenType, uint64(len(value.Keys)), false), // count
-			llvm.ConstInt(c.ctx.Int8Type(), keySize, false),          // keySize
-			llvm.ConstInt(c.ctx.Int8Type(), valueSize, false),        // valueSize
-			llvm.ConstInt(c.ctx.Int8Type(), 0, false),                // bucketBits
+			llvm.ConstBitCast(bucketsGlobal, c.i8ptrType),              // buckets
+			llvm.ConstInt(c.lenType, uint64(len(value.Keys)), false),   // count
+			llvm.ConstInt(c.ctx.Int8Type(), keySize, false),            // keySize
+			llvm.ConstInt(c.ctx.Int8Type(), valueSize, false),          // valueSize
+			llvm.ConstInt(c.ctx.Int8Type(), uint64(bucketBits), false), // bucketBits
+			llvm.ConstInt(c.ctx.Int8Type(), keyKind, false),            // keyKind
+			llvm.ConstInt(c.typecodeType, keyTypecode, false),          // keyType
 		})
 
 		// Create a pointer to this hashmap.
 		hashmapPtr := llvm.AddGlobal(c.mod, hashmap.Type(), prefix+"$hashmap")
 		hashmapPtr.SetInitializer(hashmap)
 		hashmapPtr.SetLinkage(llvm.InternalLinkage)
+		zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
 		return llvm.ConstInBoundsGEP(hashmapPtr, []llvm.Value{zero}), nil
 
 	case *ir.PointerBitCastValue:
@@ -1133,7 +1386,6 @@ func (c *Compiler) getInterpretedValue(prefix string, value ir.Value) (llvm.Valu
 
 	case *ir.SliceValue:
 		var globalPtr llvm.Value
-		var arrayLength uint64
 		if value.Array == nil {
 			arrayType, err := c.getLLVMType(value.Type.Elem())
 			if err != nil {
@@ -1141,21 +1393,30 @@ func (c *Compiler) getInterpretedValue(prefix string, value ir.Value) (llvm.Valu
 			}
 			globalPtr = llvm.ConstPointerNull(llvm.PointerType(arrayType, 0))
 		} else {
-			// make array
-			array, err := c.getInterpretedValue(prefix, value.Array)
-			if err != nil {
-				return llvm.Value{}, err
+			var global llvm.Value
+			if value.Global != nil {
+				// The backing array is a global variable.
+				global = value.Global.LLVMGlobal
+			} else if g, ok := c.sliceArrays[value.Array]; ok {
+				// The backing array is shared with another slice.
+				global = g
+			} else {
+				// make array
+				array, err := c.getInterpretedValue(prefix, value.Array)
+				if err != nil {
+					return llvm.Value{}, err
+				}
+				// make global from array
+				global = llvm.AddGlobal(c.mod, array.Type(), prefix+"$array")
+				global.SetInitializer(array)
+				global.SetLinkage(llvm.InternalLinkage)
+				c.sliceArrays[value.Array] = global
 			}
-			// make global from array
-			global := llvm.AddGlobal(c.mod, array.Type(), prefix+"$array")
-			global.SetInitializer(array)
-			global.SetLinkage(llvm.InternalLinkage)
 
-			// get pointer to global
+			// get pointer to the first element in the global
 			zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
-			globalPtr = c.builder.CreateInBoundsGEP(global, []llvm.Value{zero, zero}, "")
-
-			arrayLength = uint64(len(value.Array.Elems))
+			offset := llvm.ConstInt(c.ctx.Int32Type(), value.Offset, false)
+			globalPtr = llvm.ConstInBoundsGEP(global, []llvm.Value{zero, offset})
 		}
 
 		// make slice
@@ -1163,11 +1424,10 @@ func (c *Compiler) getInterpretedValue(prefix string, value ir.Value) (llvm.Valu
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		llvmLen := llvm.ConstInt(c.lenType, arrayLength, false)
 		slice := llvm.ConstNamedStruct(sliceTyp, []llvm.Value{
 			globalPtr, // ptr
-			llvmLen,   // len
-			llvmLen,   // cap
+			llvm.ConstInt(c.lenType, value.Len, false), // len
+			llvm.ConstInt(c.lenType, value.Cap, false), // cap
 		})
 		return slice, nil
 
@@ -1209,10 +1469,6 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 	if c.DumpSSA {
 		fmt.Printf("\nfunc %s:\n", frame.fn.Function)
 	}
-	if !frame.fn.IsExported() {
-		frame.fn.LLVMFn.SetLinkage(llvm.InternalLinkage)
-		frame.fn.LLVMFn.SetUnnamedAddr(true)
-	}
 	if frame.fn.IsInterrupt() && strings.HasPrefix(c.Triple, "avr") {
 		frame.fn.LLVMFn.SetFunctionCallConv(85) // CallingConv::AVR_SIGNAL
 	}
@@ -1237,6 +1493,15 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 
 	// Load function parameters
 	llvmParamIndex := 0
+	if frame.blocking {
+		// Skip the parent coroutine parameter.
+		llvmParamIndex++
+		if frame.fn.Signature.Results() != nil {
+			frame.resultPtr = frame.fn.LLVMFn.Param(llvmParamIndex)
+			frame.resultPtr.SetName("task.result")
+			llvmParamIndex++
+		}
+	}
 	for i, param := range frame.fn.Params {
 		llvmType, err := c.getLLVMType(param.Type())
 		if err != nil {
@@ -1323,6 +1588,12 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 		c.builder.CreateStore(llvm.ConstPointerNull(deferType), frame.deferPtr)
 	}
 
+	if frame.fn.CallsRecover() && c.canUnwind(frame) {
+		// recover() only stops a panic when this function is called directly
+		// as a deferred call, which is only known right at the start.
+		frame.deferCall = c.createRuntimeCall("deferCallEnter", nil, "defercall")
+	}
+
 	if frame.blocking {
 		// Coroutine initialization.
 		taskState := c.builder.CreateAlloca(c.mod.GetTypeByName("runtime.taskState"), "task.state")
@@ -1344,10 +1615,14 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 
 		// Coroutine cleanup. Free resources associated with this coroutine.
 		c.builder.SetInsertPointAtEnd(frame.cleanupBlock)
+		// re-insert parent coroutine
+		if c.ir.NeedsUnwinding() {
+			// Pass a panic in progress on to the parent.
+			c.createRuntimeCall("panicTaskDone", []llvm.Value{frame.fn.LLVMFn.FirstParam()}, "")
+		}
+		c.createRuntimeCall("activateTask", []llvm.Value{frame.fn.LLVMFn.FirstParam()}, "")
 		mem := c.builder.CreateCall(c.coroFreeFunc, []llvm.Value{id, frame.taskHandle}, "task.data.free")
 		c.createRuntimeCall("free", []llvm.Value{mem}, "")
-		// re-insert parent coroutine
-		c.createRuntimeCall("yieldToScheduler", []llvm.Value{frame.fn.LLVMFn.FirstParam()}, "")
 		c.builder.CreateBr(frame.suspendBlock)
 
 		// Coroutine suspend. A call to llvm.coro.suspend() will branch here.
@@ -1356,6 +1631,11 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 		c.builder.CreateRet(frame.taskHandle)
 	}
 
+	if c.hasCallFrame(frame) {
+		c.builder.SetInsertPointAtEnd(entryBlock)
+		c.emitCallFramePush(frame)
+	}
+
 	// Fill blocks with instructions.
 	for _, block := range frame.fn.DomPreorder() {
 		if c.DumpSSA {
@@ -1380,6 +1660,7 @@ func (c *Compiler) parseFunc(frame *Frame) error {
 			}
 		}
 		if frame.fn.Name() == "init" && len(block.Instrs) == 0 {
+			c.emitCallFramePop(frame)
 			c.builder.CreateRetVoid()
 		}
 	}
@@ -1405,6 +1686,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 		pos := c.ir.Program.Fset.Position(instr.Pos())
 		c.builder.SetCurrentDebugLocation(uint(pos.Line), uint(pos.Column), frame.difunc, llvm.Metadata{})
 	}
+	c.emitCallFramePC(frame, instr)
 
 	switch instr := instr.(type) {
 	case ssa.Value:
@@ -1506,10 +1788,12 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 				valueTypes = append(valueTypes, llvmParam.Type())
 			}
 
+			closureFn := c.ir.GetFunction(makeClosure.Fn.(*ssa.Function))
 			thunk := ContextDeferFunction{
 				callback,
 				valueTypes,
-				makeClosure.Fn.(*ssa.Function).Signature,
+				closureFn.LLVMFn.Type(),
+				closureFn.CallsRecover(),
 			}
 			c.ctxDeferFuncs = append(c.ctxDeferFuncs, thunk)
 
@@ -1537,14 +1821,22 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 		return nil
 
 	case *ssa.Go:
-		if instr.Common().Method != nil {
-			return errors.New("todo: go on method receiver")
+		// The new goroutine starts with an empty call stack.
+		if !frame.callFrame.IsNil() {
+			callStack := c.mod.NamedGlobal("runtime.callStack")
+			c.builder.CreateStore(llvm.ConstNull(callStack.Type().ElementType()), callStack)
 		}
 
 		// Execute non-blocking calls (including builtins) directly.
 		// parentHandle param is ignored.
-		if !c.ir.IsBlocking(c.ir.GetFunction(instr.Common().Value.(*ssa.Function))) {
+		if !c.ir.IsBlockingCall(instr.Common()) {
 			_, err := c.parseCall(frame, instr.Common(), llvm.Value{})
+			if c.ir.NeedsUnwinding() {
+				// This is the root of the goroutine, so a panic that is still
+				// unwinding was not recovered.
+				c.createRuntimeCall("panicTaskDone", []llvm.Value{llvm.ConstPointerNull(c.i8ptrType)}, "")
+			}
+			c.emitCallFrameRestore(frame)
 			return err // probably nil
 		}
 
@@ -1555,6 +1847,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 		if err != nil {
 			return err
 		}
+		c.emitCallFrameRestore(frame)
 		c.createRuntimeCall("yieldToScheduler", []llvm.Value{handle}, "")
 		return nil
 	case *ssa.If:
@@ -1585,59 +1878,73 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 			return err
 		}
 		mapType := instr.Map.Type().Underlying().(*types.Map)
-		return c.emitMapUpdate(mapType.Key(), m, key, value)
+		return c.emitMapUpdate(frame, mapType.Key(), m, key, value)
 	case *ssa.Panic:
 		value, err := c.parseExpr(frame, instr.X)
 		if err != nil {
 			return err
 		}
+		if !c.canUnwind(frame) {
+			// Panics in the runtime are always fatal.
+			c.createRuntimeCall("panicAbort", []llvm.Value{value}, "")
+			c.builder.CreateUnreachable()
+			return nil
+		}
 		c.createRuntimeCall("_panic", []llvm.Value{value}, "")
-		c.builder.CreateUnreachable()
+		if c.ir.NeedsUnwinding() {
+			c.builder.CreateBr(c.getUnwindBlock(frame))
+		} else {
+			c.builder.CreateUnreachable()
+		}
 		return nil
 	case *ssa.Return:
-		if frame.blocking {
-			if len(instr.Results) != 0 {
-				return errors.New("todo: return values from blocking function")
-			}
-			// Final suspend.
-			continuePoint := c.builder.CreateCall(c.coroSuspendFunc, []llvm.Value{
-				llvm.ConstNull(c.ctx.TokenType()),
-				llvm.ConstInt(c.ctx.Int1Type(), 1, false), // final=true
-			}, "")
-			sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
-			sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
-			return nil
-		} else {
-			if len(instr.Results) == 0 {
-				c.builder.CreateRetVoid()
-				return nil
-			} else if len(instr.Results) == 1 {
-				val, err := c.parseExpr(frame, instr.Results[0])
-				if err != nil {
-					return err
-				}
-				c.builder.CreateRet(val)
-				return nil
+		var retVal llvm.Value
+		if len(instr.Results) == 1 {
+			val, err := c.parseExpr(frame, instr.Results[0])
+			if err != nil {
+				return err
+			}
+			retVal = val
+		} else if len(instr.Results) > 1 {
+			// Multiple return values. Put them all in a struct.
+			var retType llvm.Type
+			if frame.blocking {
+				retType = frame.resultPtr.Type().ElementType()
 			} else {
-				// Multiple return values. Put them all in a struct.
-				retVal, err := c.getZeroValue(frame.fn.LLVMFn.Type().ElementType().ReturnType())
+				retType = frame.fn.LLVMFn.Type().ElementType().ReturnType()
+			}
+			var err error
+			retVal, err = c.getZeroValue(retType)
+			if err != nil {
+				return err
+			}
+			for i, result := range instr.Results {
+				val, err := c.parseExpr(frame, result)
 				if err != nil {
 					return err
 				}
-				for i, result := range instr.Results {
-					val, err := c.parseExpr(frame, result)
-					if err != nil {
-						return err
-					}
-					retVal = c.builder.CreateInsertValue(retVal, val, i, "")
-				}
-				c.builder.CreateRet(retVal)
-				return nil
+				retVal = c.builder.CreateInsertValue(retVal, val, i, "")
+			}
+		}
+		if frame.blocking {
+			if !retVal.IsNil() {
+				c.emitStoreResult(frame, retVal)
 			}
+			c.emitFinalSuspend(frame)
+			return nil
+		}
+		c.emitCallFramePop(frame)
+		if retVal.IsNil() {
+			c.builder.CreateRetVoid()
+		} else {
+			c.builder.CreateRet(retVal)
 		}
+		return nil
+	case *ssa.Send:
+		return c.emitChanSend(frame, instr)
 	case *ssa.RunDefers:
-		deferData := c.builder.CreateLoad(frame.deferPtr, "")
-		c.createRuntimeCall("rundefers", []llvm.Value{deferData}, "")
+		c.createRuntimeCall("rundefers", []llvm.Value{frame.deferPtr}, "")
+		c.emitUnwindCheck(frame)
 		return nil
 	case *ssa.Store:
 		llvmAddr, err := c.parseExpr(frame, instr.Addr)
@@ -1652,6 +1959,7 @@ func (c *Compiler) parseInstr(frame *Frame, instr ssa.Instruction) error {
 		if err != nil {
 			return err
 		}
+		c.emitNilCheck(frame, llvmAddr, instr.Addr)
 		if c.targetData.TypeAllocSize(llvmVal.Type()) == 0 {
 			// nothing to store
 			return nil
@@ -1703,12 +2011,22 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string)
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		switch args[0].Type().(type) {
+		switch args[0].Type().Underlying().(type) {
 		case *types.Slice:
 			return c.builder.CreateExtractValue(value, 2, "cap"), nil
+		case *types.Chan:
+			return c.createRuntimeCall("chanCap", []llvm.Value{value}, "cap"), nil
 		default:
 			return llvm.Value{}, errors.New("todo: cap: unknown type")
 		}
+	case "close":
+		ch, err := c.parseExpr(frame, args[0])
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		c.createRuntimeCall("chanClose", []llvm.Value{ch}, "")
+		c.emitUnwindCheck(frame)
+		return llvm.Value{}, nil
 	case "complex":
 		r, err := c.parseExpr(frame, args[0])
 		if err != nil {
@@ -1718,19 +2036,13 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string)
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		t := args[0].Type().Underlying().(*types.Basic)
-		var cplx llvm.Value
-		switch t.Kind() {
-		case types.Float32:
-			cplx = llvm.Undef(llvm.VectorType(c.ctx.FloatType(), 2))
-		case types.Float64:
-			cplx = llvm.Undef(llvm.VectorType(c.ctx.DoubleType(), 2))
+		// Look at the LLVM type, as the Go type may be a named type.
+		switch r.Type().TypeKind() {
+		case llvm.FloatTypeKind, llvm.DoubleTypeKind:
+			return c.createComplex(r, i), nil
 		default:
-			return llvm.Value{}, errors.New("unsupported type in complex builtin: " + t.String())
+			return llvm.Value{}, errors.New("unsupported type in complex builtin: " + args[0].Type().String())
 		}
-		cplx = c.builder.CreateInsertElement(cplx, r, llvm.ConstInt(c.ctx.Int8Type(), 0, false), "")
-		cplx = c.builder.CreateInsertElement(cplx, i, llvm.ConstInt(c.ctx.Int8Type(), 1, false), "")
-		return cplx, nil
 	case "copy":
 		dst, err := c.parseExpr(frame, args[0])
 		if err != nil {
@@ -1758,7 +2070,7 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string)
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		return llvm.Value{}, c.emitMapDelete(args[1].Type(), m, key)
+		return llvm.Value{}, c.emitMapDelete(frame, args[1].Type(), m, key)
 	case "imag":
 		cplx, err := c.parseExpr(frame, args[0])
 		if err != nil {
@@ -1778,6 +2090,8 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string)
 			llvmLen = c.builder.CreateExtractValue(value, 1, "len")
 		case *types.Map:
 			llvmLen = c.createRuntimeCall("hashmapLen", []llvm.Value{value}, "len")
+		case *types.Chan:
+			llvmLen = c.createRuntimeCall("chanLen", []llvm.Value{value}, "len")
 		default:
 			return llvm.Value{}, errors.New("todo: len: unknown type")
 		}
@@ -1853,26 +2167,27 @@ func (c *Compiler) parseBuiltin(frame *Frame, args []ssa.Value, callName string)
 		index := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
 		return c.builder.CreateExtractElement(cplx, index, "real"), nil
 	case "recover":
-		return c.createRuntimeCall("_recover", nil, ""), nil
+		deferCall := frame.deferCall
+		if deferCall.IsNil() {
+			deferCall = llvm.ConstInt(c.ctx.Int1Type(), 0, false)
+		}
+		return c.createRuntimeCall("_recover", []llvm.Value{deferCall}, ""), nil
 	case "ssa:wrapnilchk":
-		// TODO: do an actual nil check?
-		return c.parseExpr(frame, args[0])
+		// Check for a nil receiver in a wrapper of a method with a value
+		// receiver.
+		ptr, err := c.parseExpr(frame, args[0])
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		c.emitNilCheck(frame, ptr, args[0])
+		return ptr, nil
 	default:
 		return llvm.Value{}, errors.New("todo: builtin: " + callName)
 	}
 }
 
 func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, context llvm.Value, blocking bool, parentHandle llvm.Value) (llvm.Value, error) {
-	var params []llvm.Value
-	if blocking {
-		if parentHandle.IsNil() {
-			// Started from 'go' statement.
-			params = append(params, llvm.ConstNull(c.i8ptrType))
-		} else {
-			// Blocking function calls another blocking function.
-			params = append(params, parentHandle)
-		}
-	}
+	var params []llvm.Value
 	for _, param := range args {
 		val, err := c.parseExpr(frame, param)
 		if err != nil {
@@ -1892,54 +2207,98 @@ func (c *Compiler) parseFunctionCall(frame *Frame, args []ssa.Value, llvmFn, con
 		c.createRuntimeCall("sleepTask", []llvm.Value{frame.taskHandle, params[0]}, "")
 
 		// Yield to scheduler.
-		continuePoint := c.builder.CreateCall(c.coroSuspendFunc, []llvm.Value{
-			llvm.ConstNull(c.ctx.TokenType()),
-			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
-		}, "")
-		wakeup := c.ctx.InsertBasicBlock(llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.wakeup")
-		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
-		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
-		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
-		c.builder.SetInsertPointAtEnd(wakeup)
+		c.emitYield(frame, "task.wakeup")
+
+		return llvm.Value{}, nil
+	}
 
+	if llvmFn.Name() == "sync.runtime_Semacquire" {
+		// Acquire the semaphore, or block until it is released when it isn't
+		// available.
+		c.createRuntimeCall("semacquire", []llvm.Value{c.getCoroutine(frame), params[0]}, "")
+		if frame.blocking {
+			c.emitYield(frame, "sema.acquired")
+		}
 		return llvm.Value{}, nil
 	}
 
+	return c.createFunctionCall(frame, llvmFn, params, blocking, parentHandle), nil
+}
+
+// createFunctionCall calls the given function (or function pointer) with the
+// given parameters. A blocking function is called as a coroutine: it is
+// started with a nil parent when parentHandle is nil (for a go statement), and
+// otherwise this frame waits until the coroutine has finished. The return
+// value is then the result of the call, or the handle of the new coroutine for
+// a go statement.
+func (c *Compiler) createFunctionCall(frame *Frame, llvmFn llvm.Value, params []llvm.Value, blocking bool, parentHandle llvm.Value) llvm.Value {
+	if !blocking {
+		return c.createCall(llvmFn, params, "")
+	}
+
+	var resultAlloca llvm.Value
+	if parentHandle.IsNil() {
+		// Started from 'go' statement.
+		params = append([]llvm.Value{llvm.ConstNull(c.i8ptrType)}, params...)
+	} else {
+		// Blocking function calls another blocking function.
+		params = append([]llvm.Value{parentHandle}, params...)
+	}
+	numParams := 0
+	for _, param := range params {
+		numParams += len(c.expandFormalParam(param))
+	}
+	if numParams < llvmFn.Type().ElementType().ParamTypesCount() {
+		// The blocking function has results, which are stored in a slot
+		// provided by the caller (the second parameter). A goroutine started
+		// with a go statement has no caller to read them, so it gets nil.
+		resultPtr := llvm.ConstNull(llvmFn.Type().ElementType().ParamTypes()[1])
+		if !parentHandle.IsNil() {
+			resultAlloca = c.createEntryBlockAlloca(frame, resultPtr.Type().ElementType(), "task.result")
+			resultPtr = resultAlloca
+		}
+		params = append(params[:1], append([]llvm.Value{resultPtr}, params[1:]...)...)
+	}
+
 	result := c.createCall(llvmFn, params, "")
-	if blocking && !parentHandle.IsNil() {
+	if !parentHandle.IsNil() {
 		// Calling a blocking function as a regular function call.
 		// This is done by passing the current coroutine as a parameter to the
 		// new coroutine and dropping the current coroutine from the scheduler
 		// (with the TASK_STATE_CALL state). When the subroutine is finished, it
 		// will reactivate the parent (this frame) in it's destroy function.
 
-		c.createRuntimeCall("yieldToScheduler", []llvm.Value{result}, "")
-
-		// Set task state to TASK_STATE_CALL.
+		// Set task state to TASK_STATE_CALL. This must happen before the
+		// subroutine is scheduled, as it may finish (and reactivate the
+		// parent) right away.
 		c.createRuntimeCall("waitForAsyncCall", []llvm.Value{frame.taskHandle}, "")
 
+		c.createRuntimeCall("yieldToScheduler", []llvm.Value{result}, "")
+
 		// Yield to the scheduler.
-		continuePoint := c.builder.CreateCall(c.coroSuspendFunc, []llvm.Value{
-			llvm.ConstNull(c.ctx.TokenType()),
-			llvm.ConstInt(c.ctx.Int1Type(), 0, false),
-		}, "")
-		resume := c.ctx.InsertBasicBlock(llvm.NextBasicBlock(c.builder.GetInsertBlock()), "task.callComplete")
-		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
-		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), resume)
-		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
-		c.builder.SetInsertPointAtEnd(resume)
+		c.emitYield(frame, "task.callComplete")
+
+		if c.ir.NeedsUnwinding() {
+			// Continue unwinding if the subroutine returned because of a
+			// panic.
+			c.createRuntimeCall("panicTaskResume", []llvm.Value{frame.taskHandle}, "")
+		}
+
+		if !resultAlloca.IsNil() {
+			// The subroutine has stored its results before finishing.
+			result = c.builder.CreateLoad(resultAlloca, "task.result")
+		}
 	}
-	return result, nil
+	return result
 }
 
 func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon, parentHandle llvm.Value) (llvm.Value, error) {
 	if instr.IsInvoke() {
-		// TODO: blocking methods (needs analysis)
 		fnCast, args, err := c.getInvokeCall(frame, instr)
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		return c.createCall(fnCast, args, ""), nil
+		return c.createFunctionCall(frame, fnCast, args, c.ir.IsBlockingCall(instr), parentHandle), nil
 	}
 
 	// Try to call the function directly for trivially static calls.
@@ -1952,6 +2311,10 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon, parentHandle l
 			return c.builder.CreateCall(target, nil, ""), nil
 		}
 
+		if fn.RelString(nil) == "runtime.getCurrentStackPointer" {
+			return c.emitGetCurrentStackPointer(), nil
+		}
+
 		if fn.RelString(nil) == "device/arm.AsmFull" || fn.RelString(nil) == "device/avr.AsmFull" {
 			asmString := constant.StringVal(instr.Args[0].(*ssa.Const).Value)
 			registers := map[string]llvm.Value{}
@@ -2043,7 +2406,7 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon, parentHandle l
 				}
 			}
 		}
-		return c.parseFunctionCall(frame, instr.Args, targetFunc.LLVMFn, context, c.ir.IsBlocking(targetFunc), parentHandle)
+		return c.parseFunctionCall(frame, instr.Args, targetFunc.LLVMFn, context, c.ir.IsBlockingCall(instr), parentHandle)
 	}
 
 	// Builtin or function pointer.
@@ -2055,7 +2418,6 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon, parentHandle l
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		// TODO: blocking function pointers (needs analysis)
 		var context llvm.Value
 		if c.ir.SignatureNeedsContext(instr.Signature()) {
 			// 'value' is a closure, not a raw function pointer.
@@ -2064,7 +2426,7 @@ func (c *Compiler) parseCall(frame *Frame, instr *ssa.CallCommon, parentHandle l
 			context = c.builder.CreateExtractValue(value, 0, "")
 			value = c.builder.CreateExtractValue(value, 1, "")
 		}
-		return c.parseFunctionCall(frame, instr.Args, value, context, false, parentHandle)
+		return c.parseFunctionCall(frame, instr.Args, value, context, c.ir.IsBlockingCall(instr), parentHandle)
 	}
 }
 
@@ -2102,26 +2464,323 @@ func (c *Compiler) emitBoundsCheck(frame *Frame, arrayLen, index llvm.Value, ind
 	} else {
 		c.createRuntimeCall("lookupBoundsCheck", []llvm.Value{arrayLen, index}, "")
 	}
+	c.emitUnwindCheck(frame)
+}
+
+// createComplex creates a complex number (a vector of two floats) from its
+// real and imaginary part.
+func (c *Compiler) createComplex(r, i llvm.Value) llvm.Value {
+	cplx := llvm.Undef(llvm.VectorType(r.Type(), 2))
+	cplx = c.builder.CreateInsertElement(cplx, r, llvm.ConstInt(c.ctx.Int32Type(), 0, false), "")
+	cplx = c.builder.CreateInsertElement(cplx, i, llvm.ConstInt(c.ctx.Int32Type(), 1, false), "")
+	return cplx
+}
+
+// splitComplex returns the real and imaginary part of a complex number (or
+// the two elements of any other vector of two elements).
+func (c *Compiler) splitComplex(cplx llvm.Value) (llvm.Value, llvm.Value) {
+	r := c.builder.CreateExtractElement(cplx, llvm.ConstInt(c.ctx.Int32Type(), 0, false), "")
+	i := c.builder.CreateExtractElement(cplx, llvm.ConstInt(c.ctx.Int32Type(), 1, false), "")
+	return r, i
+}
+
+// emitDivideCheck panics when the integer divisor of a division or remainder
+// is zero.
+func (c *Compiler) emitDivideCheck(frame *Frame, divisor llvm.Value, divisorType types.Type) {
+	if typ, ok := divisorType.Underlying().(*types.Basic); !ok || typ.Info()&types.IsInteger == 0 {
+		// Floating point division by zero results in an infinity or NaN.
+		return
+	}
+	if divisor.IsConstant() && !divisor.IsNull() {
+		// Dividing by a non-zero constant is always fine.
+		return
+	}
+
+	// The divisor is only compared against zero, so it can be zero-extended.
+	if divisor.Type().IntTypeWidth() > c.intType.IntTypeWidth() {
+		if divisor.Type().IntTypeWidth() < 64 {
+			divisor = c.builder.CreateZExt(divisor, c.ctx.Int64Type(), "")
+		}
+		c.createRuntimeCall("divideByZeroCheckLong", []llvm.Value{divisor}, "")
+	} else {
+		if divisor.Type().IntTypeWidth() < c.intType.IntTypeWidth() {
+			divisor = c.builder.CreateZExt(divisor, c.intType, "")
+		}
+		c.createRuntimeCall("divideByZeroCheck", []llvm.Value{divisor}, "")
+	}
+	c.emitUnwindCheck(frame)
+}
+
+// emitShiftCheck panics when a signed shift count is negative.
+func (c *Compiler) emitShiftCheck(frame *Frame, count ssa.Value) error {
+	// The SSA package converts signed shift counts to uint64 without a source
+	// position, unlike explicit conversions. Check the original value.
+	if conv, ok := count.(*ssa.Convert); ok && conv.Pos() == token.NoPos {
+		count = conv.X
+	}
+	if count.Type().Underlying().(*types.Basic).Info()&types.IsUnsigned != 0 {
+		// Unsigned shift counts can't be negative.
+		return nil
+	}
+	shift, err := c.parseExpr(frame, count)
+	if err != nil {
+		return err
+	}
+	if shift.IsConstant() && shift.SExtValue() >= 0 {
+		return nil
+	}
+
+	if shift.Type().IntTypeWidth() > c.intType.IntTypeWidth() {
+		if shift.Type().IntTypeWidth() < 64 {
+			shift = c.builder.CreateSExt(shift, c.ctx.Int64Type(), "")
+		}
+		c.createRuntimeCall("shiftNegativeCheckLong", []llvm.Value{shift}, "")
+	} else {
+		if shift.Type().IntTypeWidth() < c.intType.IntTypeWidth() {
+			shift = c.builder.CreateSExt(shift, c.intType, "")
+		}
+		c.createRuntimeCall("shiftNegativeCheck", []llvm.Value{shift}, "")
+	}
+	c.emitUnwindCheck(frame)
+	return nil
+}
+
+// emitNilCheck panics when the given pointer is nil, so that dereferencing it
+// results in a Go panic instead of a crash (or no crash at all, on
+// microcontrollers where address 0 is readable). Pointers that are known to be
+// non-nil, like the address of a variable, are not checked.
+func (c *Compiler) emitNilCheck(frame *Frame, ptr llvm.Value, value ssa.Value) {
+	if !c.NilChecks {
+		return
+	}
+	switch value.(type) {
+	case *ssa.Alloc, *ssa.Global, *ssa.FreeVar, *ssa.FieldAddr, *ssa.IndexAddr:
+		// The address of a variable or of a field or element of a variable.
+		// These addresses have been checked already, if necessary.
+		return
+	}
+	if ptr.IsConstant() && !ptr.IsNull() {
+		// For example a memory-mapped register.
+		return
+	}
+	c.createRuntimeCall("nilPointerCheck", []llvm.Value{ptr}, "")
+	c.emitUnwindCheck(frame)
 }
 
-func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high llvm.Value) {
+// emitSliceBoundsCheck checks the indices of a slice expression. The indices
+// must be of the same type, which is either int or int64. The max index is
+// only checked when it is set (for full slice expressions).
+func (c *Compiler) emitSliceBoundsCheck(frame *Frame, capacity, low, high, max llvm.Value) {
 	if frame.fn.IsNoBounds() {
 		// The //go:nobounds pragma was added to the function to avoid bounds
 		// checking.
 		return
 	}
 
-	if low.Type().IntTypeWidth() > 32 || high.Type().IntTypeWidth() > 32 {
-		if low.Type().IntTypeWidth() < 64 {
-			low = c.builder.CreateSExt(low, c.ctx.Int64Type(), "")
+	long := low.Type().IntTypeWidth() > c.intType.IntTypeWidth()
+	if max.IsNil() {
+		if long {
+			c.createRuntimeCall("sliceBoundsCheckLong", []llvm.Value{capacity, low, high}, "")
+		} else {
+			c.createRuntimeCall("sliceBoundsCheck", []llvm.Value{capacity, low, high}, "")
+		}
+	} else {
+		if long {
+			c.createRuntimeCall("sliceBoundsCheck3Long", []llvm.Value{capacity, low, high, max}, "")
+		} else {
+			c.createRuntimeCall("sliceBoundsCheck3", []llvm.Value{capacity, low, high, max}, "")
 		}
-		if high.Type().IntTypeWidth() < 64 {
-			high = c.builder.CreateSExt(high, c.ctx.Int64Type(), "")
+	}
+	c.emitUnwindCheck(frame)
+}
+
+// extendInteger converts an integer of the given Go type to a bigger (or
+// equally sized) LLVM integer type, taking the signedness into account.
+func (c *Compiler) extendInteger(value llvm.Value, typ types.Type, llvmType llvm.Type) llvm.Value {
+	if value.Type().IntTypeWidth() >= llvmType.IntTypeWidth() {
+		return value
+	}
+	if typ.Underlying().(*types.Basic).Info()&types.IsUnsigned != 0 {
+		return c.builder.CreateZExt(value, llvmType, "")
+	} else {
+		return c.builder.CreateSExt(value, llvmType, "")
+	}
+}
+
+// emitYield suspends the current coroutine and continues in a new basic block
+// with the given name once the scheduler resumes it. The task state must have
+// been set before calling this: the scheduler uses it to decide when to resume
+// this coroutine.
+func (c *Compiler) emitYield(frame *Frame, name string) {
+	continuePoint := c.builder.CreateCall(c.coroSuspendFunc, []llvm.Value{
+		llvm.ConstNull(c.ctx.TokenType()),
+		llvm.ConstInt(c.ctx.Int1Type(), 0, false),
+	}, "")
+	wakeup := c.insertBasicBlock(name)
+	sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+	sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 0, false), wakeup)
+	sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+	c.builder.SetInsertPointAtEnd(wakeup)
+	frame.blockExits[frame.currentBlock] = wakeup
+	c.emitCallFrameRestore(frame)
+}
+
+// insertBasicBlock creates a new basic block right after the current block,
+// for when the current block needs to be split.
+func (c *Compiler) insertBasicBlock(name string) llvm.BasicBlock {
+	currentBlock := c.builder.GetInsertBlock()
+	if next := llvm.NextBasicBlock(currentBlock); !next.IsNil() {
+		return c.ctx.InsertBasicBlock(next, name)
+	}
+	return c.ctx.AddBasicBlock(currentBlock.Parent(), name)
+}
+
+// emitFinalSuspend returns from a blocking function, by suspending the
+// coroutine for the last time. All returns share the same final suspend point,
+// as LLVM allows only one.
+func (c *Compiler) emitFinalSuspend(frame *Frame) {
+	if frame.finalSuspendBlock.IsNil() {
+		currentBlock := c.builder.GetInsertBlock()
+		frame.finalSuspendBlock = c.ctx.AddBasicBlock(frame.fn.LLVMFn, "task.finalSuspend")
+		c.builder.SetInsertPointAtEnd(frame.finalSuspendBlock)
+		c.emitCallFramePop(frame)
+		continuePoint := c.builder.CreateCall(c.coroSuspendFunc, []llvm.Value{
+			llvm.ConstNull(c.ctx.TokenType()),
+			llvm.ConstInt(c.ctx.Int1Type(), 1, false), // final=true
+		}, "")
+		sw := c.builder.CreateSwitch(continuePoint, frame.suspendBlock, 2)
+		sw.AddCase(llvm.ConstInt(c.ctx.Int8Type(), 1, false), frame.cleanupBlock)
+		c.builder.SetInsertPointAtEnd(currentBlock)
+	}
+	c.builder.CreateBr(frame.finalSuspendBlock)
+}
+
+// emitStoreResult stores the results of a blocking function in the slot
+// provided by the caller, if there is one. Goroutines started with a go
+// statement have nowhere to store their results.
+func (c *Compiler) emitStoreResult(frame *Frame, value llvm.Value) {
+	nextBlock := c.insertBasicBlock("task.return")
+	storeBlock := c.insertBasicBlock("task.storeResult")
+	hasResultPtr := c.builder.CreateICmp(llvm.IntNE, frame.resultPtr, llvm.ConstNull(frame.resultPtr.Type()), "")
+	c.builder.CreateCondBr(hasResultPtr, storeBlock, nextBlock)
+	c.builder.SetInsertPointAtEnd(storeBlock)
+	c.builder.CreateStore(value, frame.resultPtr)
+	c.builder.CreateBr(nextBlock)
+	c.builder.SetInsertPointAtEnd(nextBlock)
+	frame.blockExits[frame.currentBlock] = nextBlock
+}
+
+// canUnwind returns whether panics may unwind through this function. This is
+// only the case when the program defers a call or uses recover(). Functions in the runtime
+// handle panics explicitly, so they are never unwound.
+func (c *Compiler) canUnwind(frame *Frame) bool {
+	if !c.ir.NeedsUnwinding() {
+		return false
+	}
+	return frame.fn.Pkg == nil || frame.fn.Pkg.Pkg.Path() != "runtime"
+}
+
+// emitUnwindCheck checks whether the stack is being unwound because of a panic,
+// after a call that may have panicked. If so, it continues in the unwind block
+// of this function.
+func (c *Compiler) emitUnwindCheck(frame *Frame) {
+	if !c.canUnwind(frame) {
+		return
+	}
+	unwinding := c.builder.CreateLoad(c.mod.NamedGlobal("runtime.unwinding"), "unwinding")
+	continueBlock := c.insertBasicBlock("unwind.next")
+	c.builder.CreateCondBr(unwinding, c.getUnwindBlock(frame), continueBlock)
+	c.builder.SetInsertPointAtEnd(continueBlock)
+	frame.blockExits[frame.currentBlock] = continueBlock
+}
+
+// emitDeferCallClear tells the runtime that the deferred call that is about to
+// be made doesn't call recover(), so that functions called by it can't recover
+// the panic either. See runtime.deferCallEnter.
+func (c *Compiler) emitDeferCallClear() {
+	if !c.ir.NeedsUnwinding() {
+		return
+	}
+	directDeferCall := c.mod.NamedGlobal("runtime.directDeferCall")
+	c.builder.CreateStore(llvm.ConstInt(c.ctx.Int1Type(), 0, false), directDeferCall)
+}
+
+// getUnwindBlock returns the block to jump to when this function is unwinding
+// because of a panic, creating it when necessary. It runs the deferred calls of
+// this function and continues in the recover block when one of them recovered
+// the panic. Otherwise it returns, so the caller will continue unwinding.
+func (c *Compiler) getUnwindBlock(frame *Frame) llvm.BasicBlock {
+	if !frame.unwindBlock.IsNil() {
+		return frame.unwindBlock
+	}
+	currentBlock := c.builder.GetInsertBlock()
+	frame.unwindBlock = c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind")
+	c.builder.SetInsertPointAtEnd(frame.unwindBlock)
+	if frame.fn.Recover != nil {
+		recovered := c.createRuntimeCall("panicRunDefers", []llvm.Value{frame.deferPtr}, "unwind.recovered")
+		returnBlock := c.ctx.AddBasicBlock(frame.fn.LLVMFn, "unwind.return")
+		c.builder.CreateCondBr(recovered, frame.blockEntries[frame.fn.Recover], returnBlock)
+		c.builder.SetInsertPointAtEnd(returnBlock)
+	}
+	// The return value is ignored by the caller while unwinding.
+	if frame.blocking {
+		c.emitFinalSuspend(frame)
+	} else {
+		c.emitCallFramePop(frame)
+		if returnType := frame.fn.LLVMFn.Type().ElementType().ReturnType(); returnType.TypeKind() == llvm.VoidTypeKind {
+			c.builder.CreateRetVoid()
+		} else {
+			c.builder.CreateRet(llvm.Undef(returnType))
 		}
-		c.createRuntimeCall("sliceBoundsCheckLong", []llvm.Value{capacity, low, high}, "")
+	}
+	c.builder.SetInsertPointAtEnd(currentBlock)
+	return frame.unwindBlock
+}
+
+// createEntryBlockAlloca creates a new alloca at the start of the entry block
+// of this function. Allocas in the entry block are static allocations, which is
+// important inside loops and in coroutines (where they become part of the
+// coroutine frame).
+func (c *Compiler) createEntryBlockAlloca(frame *Frame, t llvm.Type, name string) llvm.Value {
+	currentBlock := c.builder.GetInsertBlock()
+	entryBlock := frame.fn.LLVMFn.EntryBasicBlock()
+	if first := entryBlock.FirstInstruction(); first.IsNil() {
+		c.builder.SetInsertPointAtEnd(entryBlock)
 	} else {
-		c.createRuntimeCall("sliceBoundsCheck", []llvm.Value{capacity, low, high}, "")
+		c.builder.SetInsertPointBefore(first)
+	}
+	alloca := c.builder.CreateAlloca(t, name)
+	c.builder.SetInsertPointAtEnd(currentBlock)
+	return alloca
+}
+
+// emitGetCurrentStackPointer returns the current stack pointer as an uintptr,
+// for the garbage collector. All callee-saved registers are clobbered first, so
+// that the calling function saves them on the stack where the garbage
+// collector will find pointers stored in them.
+func (c *Compiler) emitGetCurrentStackPointer() llvm.Value {
+	var clobbers []string
+	switch {
+	case strings.HasPrefix(c.Triple, "avr"):
+		for _, reg := range []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 28, 29} {
+			clobbers = append(clobbers, "~{r"+strconv.Itoa(reg)+"}")
+		}
+	case strings.HasPrefix(c.Triple, "arm") || strings.HasPrefix(c.Triple, "thumb"):
+		for reg := 4; reg <= 11; reg++ {
+			clobbers = append(clobbers, "~{r"+strconv.Itoa(reg)+"}")
+		}
+	}
+	fnType := llvm.FunctionType(c.ctx.VoidType(), nil, false)
+	asm := llvm.InlineAsm(fnType, "", strings.Join(clobbers, ","), true, false, 0)
+	c.builder.CreateCall(asm, nil, "")
+
+	stacksave := c.mod.NamedFunction("llvm.stacksave")
+	if stacksave.IsNil() {
+		stacksaveType := llvm.FunctionType(c.i8ptrType, nil, false)
+		stacksave = llvm.AddFunction(c.mod, "llvm.stacksave", stacksaveType)
 	}
+	sp := c.builder.CreateCall(stacksave, nil, "sp")
+	return c.builder.CreatePtrToInt(sp, c.uintptrType, "sp.uintptr")
 }
 
 func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
@@ -2165,11 +2824,37 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		return c.parseBinOp(expr.Op, expr.X.Type().Underlying(), x, y)
+		switch expr.Op {
+		case token.QUO, token.REM:
+			c.emitDivideCheck(frame, y, expr.Y.Type())
+		case token.SHL, token.SHR:
+			err := c.emitShiftCheck(frame, expr.Y)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+		}
+		result, err := c.parseBinOp(expr.Op, expr.X.Type().Underlying(), x, y)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		if (expr.Op == token.EQL || expr.Op == token.NEQ) && containsInterface(expr.X.Type()) {
+			// Comparing interfaces panics when their dynamic type is not
+			// comparable.
+			c.emitUnwindCheck(frame)
+		}
+		return result, nil
 	case *ssa.Call:
 		// Passing the current task here to the subroutine. It is only used when
 		// the subroutine is blocking.
-		return c.parseCall(frame, expr.Common(), frame.taskHandle)
+		result, err := c.parseCall(frame, expr.Common(), frame.taskHandle)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		if _, ok := expr.Common().Value.(*ssa.Builtin); !ok {
+			// The called function may have panicked.
+			c.emitUnwindCheck(frame)
+		}
+		return result, nil
 	case *ssa.ChangeInterface:
 		// Do not change between interface types: always use the underlying
 		// (concrete) type in the type number of the interface. Every method
@@ -2221,6 +2906,7 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		if err != nil {
 			return llvm.Value{}, err
 		}
+		c.emitNilCheck(frame, val, expr.X)
 		indices := []llvm.Value{
 			llvm.ConstInt(c.ctx.Int32Type(), 0, false),
 			llvm.ConstInt(c.ctx.Int32Type(), uint64(expr.Field), false),
@@ -2287,6 +2973,7 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			typ := expr.X.Type().(*types.Pointer).Elem().Underlying()
 			switch typ := typ.(type) {
 			case *types.Array:
+				c.emitNilCheck(frame, val, expr.X)
 				bufptr = val
 				buflen = llvm.ConstInt(c.lenType, uint64(typ.Len()), false)
 			default:
@@ -2348,11 +3035,13 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			if expr.CommaOk {
 				valueType = valueType.(*types.Tuple).At(0).Type()
 			}
-			return c.emitMapLookup(xType.Key(), valueType, value, index, expr.CommaOk)
+			return c.emitMapLookup(frame, xType.Key(), valueType, value, index, expr.CommaOk)
 		default:
 			panic("unknown lookup type: " + expr.String())
 		}
 
+	case *ssa.MakeChan:
+		return c.emitMakeChan(frame, expr)
 	case *ssa.MakeClosure:
 		// A closure returns a function pointer with context:
 		// {context, fp}
@@ -2378,7 +3067,9 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		valueSize := c.targetData.TypeAllocSize(llvmValueType)
 		llvmKeySize := llvm.ConstInt(c.ctx.Int8Type(), keySize, false)
 		llvmValueSize := llvm.ConstInt(c.ctx.Int8Type(), valueSize, false)
-		hashmap := c.createRuntimeCall("hashmapMake", []llvm.Value{llvmKeySize, llvmValueSize}, "")
+		llvmKeyKind := llvm.ConstInt(c.ctx.Int8Type(), hashmapKeyKind(mapType.Key()), false)
+		llvmKeyTypecode := c.getTypecode(mapType.Key())
+		hashmap := c.createRuntimeCall("hashmapMake", []llvm.Value{llvmKeySize, llvmValueSize, llvmKeyKind, llvmKeyTypecode}, "")
 		return hashmap, nil
 	case *ssa.MakeSlice:
 		sliceLen, err := c.parseExpr(frame, expr.Len)
@@ -2399,6 +3090,7 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		// Bounds checking.
 		if !frame.fn.IsNoBounds() {
 			c.createRuntimeCall("sliceBoundsCheckMake", []llvm.Value{sliceLen, sliceCap}, "")
+			c.emitUnwindCheck(frame)
 		}
 
 		// Allocate the backing array.
@@ -2486,110 +3178,84 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 		}
 		c.builder.CreateStore(zero, it)
 		return it, nil
+	case *ssa.Select:
+		return c.emitSelect(frame, expr)
 	case *ssa.Slice:
-		if expr.Max != nil {
-			return llvm.Value{}, errors.New("todo: full slice expressions (with max): " + expr.Type().String())
-		}
 		value, err := c.parseExpr(frame, expr.X)
 		if err != nil {
 			return llvm.Value{}, err
 		}
-		var low, high llvm.Value
-		if expr.Low == nil {
-			low = llvm.ConstInt(c.intType, 0, false)
-		} else {
-			low, err = c.parseExpr(frame, expr.Low)
-			if err != nil {
-				return llvm.Value{}, nil
-			}
-		}
-		if expr.High != nil {
-			high, err = c.parseExpr(frame, expr.High)
-			if err != nil {
-				return llvm.Value{}, nil
-			}
-		}
+
+		// Get the pointer, length and capacity of the value that is sliced.
+		var oldPtr, oldLen, oldCap llvm.Value
 		switch typ := expr.X.Type().Underlying().(type) {
 		case *types.Pointer: // pointer to array
-			// slice an array
-			length := typ.Elem().(*types.Array).Len()
-			llvmLen := llvm.ConstInt(c.lenType, uint64(length), false)
-			llvmLenInt := llvm.ConstInt(c.intType, uint64(length), false)
-			if high.IsNil() {
-				high = llvmLenInt
-			}
-			indices := []llvm.Value{
-				llvm.ConstInt(c.ctx.Int32Type(), 0, false),
-				low,
-			}
-			slicePtr := c.builder.CreateGEP(value, indices, "slice.ptr")
-			sliceLen := c.builder.CreateSub(high, low, "slice.len")
-			sliceCap := c.builder.CreateSub(llvmLenInt, low, "slice.cap")
-
-			// This check is optimized away in most cases.
-			c.emitSliceBoundsCheck(frame, llvmLen, low, high)
-
-			if c.targetData.TypeAllocSize(sliceLen.Type()) > c.targetData.TypeAllocSize(c.lenType) {
-				sliceLen = c.builder.CreateTrunc(sliceLen, c.lenType, "")
-				sliceCap = c.builder.CreateTrunc(sliceCap, c.lenType, "")
-			}
-
-			slice := c.ctx.ConstStruct([]llvm.Value{
-				llvm.Undef(slicePtr.Type()),
-				llvm.Undef(c.lenType),
-				llvm.Undef(c.lenType),
-			}, false)
-			slice = c.builder.CreateInsertValue(slice, slicePtr, 0, "")
-			slice = c.builder.CreateInsertValue(slice, sliceLen, 1, "")
-			slice = c.builder.CreateInsertValue(slice, sliceCap, 2, "")
-			return slice, nil
-
+			length := typ.Elem().Underlying().(*types.Array).Len()
+			zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+			oldPtr = c.builder.CreateGEP(value, []llvm.Value{zero, zero}, "slice.ptr")
+			oldLen = llvm.ConstInt(c.lenType, uint64(length), false)
+			oldCap = oldLen
 		case *types.Slice:
-			// slice a slice
-			oldPtr := c.builder.CreateExtractValue(value, 0, "")
-			oldLen := c.builder.CreateExtractValue(value, 1, "")
-			oldCap := c.builder.CreateExtractValue(value, 2, "")
-			if high.IsNil() {
-				high = oldLen
+			oldPtr = c.builder.CreateExtractValue(value, 0, "")
+			oldLen = c.builder.CreateExtractValue(value, 1, "")
+			oldCap = c.builder.CreateExtractValue(value, 2, "")
+		case *types.Basic:
+			if typ.Info()&types.IsString == 0 {
+				return llvm.Value{}, errors.New("unknown slice type: " + typ.String())
 			}
+			oldPtr = c.builder.CreateExtractValue(value, 0, "")
+			oldLen = c.builder.CreateExtractValue(value, 1, "")
+			oldCap = oldLen
+		default:
+			return llvm.Value{}, errors.New("unknown slice type: " + typ.String())
+		}
 
-			c.emitSliceBoundsCheck(frame, oldCap, low, high)
-
-			if c.targetData.TypeAllocSize(low.Type()) > c.targetData.TypeAllocSize(c.lenType) {
-				low = c.builder.CreateTrunc(low, c.lenType, "")
+		// Get the indices. They may be of any integer type, so convert them
+		// all to the same type: int, or int64 if one of them doesn't fit in
+		// an int.
+		indices := []ssa.Value{expr.Low, expr.High, expr.Max}
+		llvmIndices := make([]llvm.Value, len(indices))
+		indexType := c.intType
+		for i, index := range indices {
+			if index == nil {
+				continue
 			}
-			if c.targetData.TypeAllocSize(high.Type()) > c.targetData.TypeAllocSize(c.lenType) {
-				high = c.builder.CreateTrunc(high, c.lenType, "")
+			llvmIndices[i], err = c.parseExpr(frame, index)
+			if err != nil {
+				return llvm.Value{}, err
 			}
-
-			newPtr := c.builder.CreateGEP(oldPtr, []llvm.Value{low}, "")
-			newLen := c.builder.CreateSub(high, low, "")
-			newCap := c.builder.CreateSub(oldCap, low, "")
-			slice := c.ctx.ConstStruct([]llvm.Value{
-				llvm.Undef(newPtr.Type()),
-				llvm.Undef(c.lenType),
-				llvm.Undef(c.lenType),
-			}, false)
-			slice = c.builder.CreateInsertValue(slice, newPtr, 0, "")
-			slice = c.builder.CreateInsertValue(slice, newLen, 1, "")
-			slice = c.builder.CreateInsertValue(slice, newCap, 2, "")
-			return slice, nil
-
-		case *types.Basic:
-			if typ.Info()&types.IsString == 0 {
-				return llvm.Value{}, errors.New("unknown slice type: " + typ.String())
+			if llvmIndices[i].Type().IntTypeWidth() > indexType.IntTypeWidth() {
+				indexType = c.ctx.Int64Type()
 			}
-			// slice a string
-			oldPtr := c.builder.CreateExtractValue(value, 0, "")
-			oldLen := c.builder.CreateExtractValue(value, 1, "")
-			if high.IsNil() {
-				high = oldLen
+		}
+		low := llvm.ConstInt(indexType, 0, false)
+		high := c.builder.CreateZExt(oldLen, indexType, "")
+		max := c.builder.CreateZExt(oldCap, indexType, "")
+		for i, llvmIndex := range []*llvm.Value{&low, &high, &max} {
+			if indices[i] != nil {
+				*llvmIndex = c.extendInteger(llvmIndices[i], indices[i].Type(), indexType)
 			}
+		}
+
+		// This check is optimized away in most cases.
+		if expr.Max != nil {
+			c.emitSliceBoundsCheck(frame, oldCap, low, high, max)
+		} else {
+			c.emitSliceBoundsCheck(frame, oldCap, low, high, llvm.Value{})
+		}
 
-			c.emitSliceBoundsCheck(frame, oldLen, low, high)
+		// The indices are within the capacity, so they fit in the length type.
+		if indexType.IntTypeWidth() > c.lenType.IntTypeWidth() {
+			low = c.builder.CreateTrunc(low, c.lenType, "")
+			high = c.builder.CreateTrunc(high, c.lenType, "")
+			max = c.builder.CreateTrunc(max, c.lenType, "")
+		}
+		newPtr := c.builder.CreateGEP(oldPtr, []llvm.Value{low}, "")
+		newLen := c.builder.CreateSub(high, low, "slice.len")
+		newCap := c.builder.CreateSub(max, low, "slice.cap")
 
-			newPtr := c.builder.CreateGEP(oldPtr, []llvm.Value{low}, "")
-			newLen := c.builder.CreateSub(high, low, "")
+		if typ, ok := expr.Type().Underlying().(*types.Basic); ok && typ.Info()&types.IsString != 0 {
+			// The result is a string.
 			str, err := c.getZeroValue(c.mod.GetTypeByName("runtime._string"))
 			if err != nil {
 				return llvm.Value{}, err
@@ -2597,10 +3263,16 @@ func (c *Compiler) parseExpr(frame *Frame, expr ssa.Value) (llvm.Value, error) {
 			str = c.builder.CreateInsertValue(str, newPtr, 0, "")
 			str = c.builder.CreateInsertValue(str, newLen, 1, "")
 			return str, nil
-
-		default:
-			return llvm.Value{}, errors.New("unknown slice type: " + typ.String())
 		}
+		slice := c.ctx.ConstStruct([]llvm.Value{
+			llvm.Undef(newPtr.Type()),
+			llvm.Undef(c.lenType),
+			llvm.Undef(c.lenType),
+		}, false)
+		slice = c.builder.CreateInsertValue(slice, newPtr, 0, "")
+		slice = c.builder.CreateInsertValue(slice, newLen, 1, "")
+		slice = c.builder.CreateInsertValue(slice, newCap, 2, "")
+		return slice, nil
 	case *ssa.TypeAssert:
 		return c.parseTypeAssert(frame, expr)
 	case *ssa.UnOp:
@@ -2625,12 +3297,23 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 				return c.builder.CreateMul(x, y, ""), nil
 			case token.QUO: // /
 				if signed {
-					return c.builder.CreateSDiv(x, y, ""), nil
+					// Dividing the most negative number by -1 overflows, which
+					// is undefined in LLVM but wraps around in Go. Divide by 1
+					// instead and negate the result in that case.
+					minusOne := c.builder.CreateICmp(llvm.IntEQ, y, llvm.ConstAllOnes(y.Type()), "")
+					y = c.builder.CreateSelect(minusOne, llvm.ConstInt(y.Type(), 1, false), y, "")
+					quotient := c.builder.CreateSDiv(x, y, "")
+					negated := c.builder.CreateSub(llvm.ConstInt(x.Type(), 0, false), x, "")
+					return c.builder.CreateSelect(minusOne, negated, quotient, ""), nil
 				} else {
 					return c.builder.CreateUDiv(x, y, ""), nil
 				}
 			case token.REM: // %
 				if signed {
+					// x % -1 is always 0, just like x % 1. Avoid the overflow
+					// of the most negative number % -1 (see above).
+					minusOne := c.builder.CreateICmp(llvm.IntEQ, y, llvm.ConstAllOnes(y.Type()), "")
+					y = c.builder.CreateSelect(minusOne, llvm.ConstInt(y.Type(), 1, false), y, "")
 					return c.builder.CreateSRem(x, y, ""), nil
 				} else {
 					return c.builder.CreateURem(x, y, ""), nil
@@ -2642,28 +3325,34 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 			case token.XOR: // ^
 				return c.builder.CreateXor(x, y, ""), nil
 			case token.SHL, token.SHR:
+				// Shifting by at least the integer width is undefined in LLVM,
+				// but in Go it shifts out all bits. Compare the shift count to
+				// the width before it is truncated. The shift count is never
+				// negative here, that has been checked before.
+				width := uint64(x.Type().IntTypeWidth())
+				overflow := c.builder.CreateICmp(llvm.IntUGE, y, llvm.ConstInt(y.Type(), width, false), "shift.overflow")
 				sizeX := c.targetData.TypeAllocSize(x.Type())
 				sizeY := c.targetData.TypeAllocSize(y.Type())
 				if sizeX > sizeY {
 					// x and y must have equal sizes, make Y bigger in this case.
-					// y is unsigned, this has been checked by the Go type checker.
 					y = c.builder.CreateZExt(y, x.Type(), "")
 				} else if sizeX < sizeY {
-					// What about shifting more than the integer width?
-					// I'm not entirely sure what the Go spec is on that, but as
-					// Intel CPUs have undefined behavior when shifting more
-					// than the integer width I'm assuming it is also undefined
-					// in Go.
 					y = c.builder.CreateTrunc(y, x.Type(), "")
 				}
+				zero := llvm.ConstInt(x.Type(), 0, false)
 				switch op {
 				case token.SHL: // <<
-					return c.builder.CreateShl(x, y, ""), nil
+					result := c.builder.CreateShl(x, y, "")
+					return c.builder.CreateSelect(overflow, zero, result, ""), nil
 				case token.SHR: // >>
 					if signed {
+						// Shifting by width-1 gives the same result: 0 or -1
+						// depending on the sign.
+						y = c.builder.CreateSelect(overflow, llvm.ConstInt(y.Type(), width-1, false), y, "")
 						return c.builder.CreateAShr(x, y, ""), nil
 					} else {
-						return c.builder.CreateLShr(x, y, ""), nil
+						result := c.builder.CreateLShr(x, y, "")
+						return c.builder.CreateSelect(overflow, zero, result, ""), nil
 					}
 				default:
 					panic("unreachable")
@@ -2719,7 +3408,7 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 			case token.EQL: // ==
 				return c.builder.CreateFCmp(llvm.FloatOEQ, x, y, ""), nil
 			case token.NEQ: // !=
-				return c.builder.CreateFCmp(llvm.FloatONE, x, y, ""), nil
+				return c.builder.CreateFCmp(llvm.FloatUNE, x, y, ""), nil
 			case token.LSS: // <
 				return c.builder.CreateFCmp(llvm.FloatOLT, x, y, ""), nil
 			case token.LEQ: // <=
@@ -2731,6 +3420,45 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 			default:
 				return llvm.Value{}, errors.New("todo: binop on float: " + op.String())
 			}
+		} else if typ.Info()&types.IsComplex != 0 {
+			// Operations on complex numbers, which are stored as a vector of
+			// two floats.
+			switch op {
+			case token.ADD: // +
+				return c.builder.CreateFAdd(x, y, ""), nil
+			case token.SUB: // -
+				return c.builder.CreateFSub(x, y, ""), nil
+			case token.MUL: // *
+				// (a+bi) * (c+di) = (ac-bd) + (ad+bc)i
+				a, b := c.splitComplex(x)
+				cr, d := c.splitComplex(y)
+				r := c.builder.CreateFSub(c.builder.CreateFMul(a, cr, ""), c.builder.CreateFMul(b, d, ""), "")
+				i := c.builder.CreateFAdd(c.builder.CreateFMul(a, d, ""), c.builder.CreateFMul(b, cr, ""), "")
+				return c.createComplex(r, i), nil
+			case token.QUO: // /
+				// Division needs to handle overflow, infinities and NaN
+				// correctly, which is done in the runtime. Dividing
+				// complex64 numbers is done with complex128 precision, like
+				// the Go compiler does.
+				if typ.Kind() == types.Complex64 {
+					complex128Type := llvm.VectorType(c.ctx.DoubleType(), 2)
+					x = c.builder.CreateFPExt(x, complex128Type, "")
+					y = c.builder.CreateFPExt(y, complex128Type, "")
+					result := c.createRuntimeCall("complex128div", []llvm.Value{x, y}, "")
+					return c.builder.CreateFPTrunc(result, llvm.VectorType(c.ctx.FloatType(), 2), ""), nil
+				}
+				return c.createRuntimeCall("complex128div", []llvm.Value{x, y}, ""), nil
+			case token.EQL: // ==
+				cmp := c.builder.CreateFCmp(llvm.FloatOEQ, x, y, "")
+				r, i := c.splitComplex(cmp)
+				return c.builder.CreateAnd(r, i, ""), nil
+			case token.NEQ: // !=
+				cmp := c.builder.CreateFCmp(llvm.FloatUNE, x, y, "")
+				r, i := c.splitComplex(cmp)
+				return c.builder.CreateOr(r, i, ""), nil
+			default:
+				return llvm.Value{}, errors.New("todo: binop on complex number: " + op.String())
+			}
 		} else if typ.Info()&types.IsBoolean != 0 {
 			// Operations on booleans
 			switch op {
@@ -2806,10 +3534,12 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 		default:
 			return llvm.Value{}, errors.New("binop on interface: " + op.String())
 		}
-	case *types.Map, *types.Pointer:
+	case *types.Chan, *types.Map, *types.Pointer:
 		// Maps are in general not comparable, but can be compared against nil
 		// (which is a nil pointer). This means they can be trivially compared
 		// by treating them as a pointer.
+		// Channels behave as pointers in that they are equal as long as they
+		// are created with the same call to make or if both are nil.
 		switch op {
 		case token.EQL: // ==
 			return c.builder.CreateICmp(llvm.IntEQ, x, y, ""), nil
@@ -2831,6 +3561,48 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 		default:
 			return llvm.Value{}, errors.New("todo: binop on slice: " + op.String())
 		}
+	case *types.Array:
+		// Compare each array element and combine the result. From the spec:
+		//     Array values are comparable if values of the array element type
+		//     are comparable. Two array values are equal if their
+		//     corresponding elements are equal.
+		// The elements are compared in a loop in a separate function, so
+		// that comparing large arrays doesn't result in a lot of code.
+		result := llvm.ConstInt(c.ctx.Int1Type(), 1, false)
+		if typ.Len() != 0 {
+			fn, err := c.getArrayEqualFunc(typ)
+			if err != nil {
+				return llvm.Value{}, err
+			}
+			// The arrays are put in temporary stack slots, which are
+			// released again after the comparison, as this may be done in a
+			// loop.
+			stacksave := c.mod.NamedFunction("llvm.stacksave")
+			if stacksave.IsNil() {
+				stacksaveType := llvm.FunctionType(c.i8ptrType, nil, false)
+				stacksave = llvm.AddFunction(c.mod, "llvm.stacksave", stacksaveType)
+			}
+			stackrestore := c.mod.NamedFunction("llvm.stackrestore")
+			if stackrestore.IsNil() {
+				stackrestoreType := llvm.FunctionType(c.ctx.VoidType(), []llvm.Type{c.i8ptrType}, false)
+				stackrestore = llvm.AddFunction(c.mod, "llvm.stackrestore", stackrestoreType)
+			}
+			sp := c.builder.CreateCall(stacksave, nil, "array.sp")
+			xAlloca := c.builder.CreateAlloca(x.Type(), "array.x")
+			c.builder.CreateStore(x, xAlloca)
+			yAlloca := c.builder.CreateAlloca(y.Type(), "array.y")
+			c.builder.CreateStore(y, yAlloca)
+			result = c.builder.CreateCall(fn, []llvm.Value{xAlloca, yAlloca}, "array.equal")
+			c.builder.CreateCall(stackrestore, []llvm.Value{sp}, "")
+		}
+		switch op {
+		case token.EQL: // ==
+			return result, nil
+		case token.NEQ: // !=
+			return c.builder.CreateNot(result, ""), nil
+		default:
+			return llvm.Value{}, errors.New("unknown: binop on array: " + op.String())
+		}
 	case *types.Struct:
 		// Compare each struct field and combine the result. From the spec:
 		//     Struct values are comparable if all their fields are comparable.
@@ -2842,7 +3614,7 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 				// skip blank fields
 				continue
 			}
-			fieldType := typ.Field(i).Type()
+			fieldType := typ.Field(i).Type().Underlying()
 			xField := c.builder.CreateExtractValue(x, i, "")
 			yField := c.builder.CreateExtractValue(y, i, "")
 			fieldEqual, err := c.parseBinOp(token.EQL, fieldType, xField, yField)
@@ -2859,12 +3631,95 @@ func (c *Compiler) parseBinOp(op token.Token, typ types.Type, x, y llvm.Value) (
 		default:
 			return llvm.Value{}, errors.New("unknown: binop on struct: " + op.String())
 		}
-		return result, nil
 	default:
 		return llvm.Value{}, errors.New("todo: binop type: " + typ.String())
 	}
 }
 
+// getArrayEqualFunc returns the function that compares two arrays of the given
+// type, declaring it when necessary. It takes a pointer to both arrays and
+// returns whether they're equal. The body is created later, in
+// createArrayEqualFunc, as the builder is in the middle of another function
+// when it is needed.
+func (c *Compiler) getArrayEqualFunc(typ *types.Array) (llvm.Value, error) {
+	name := typ.String() + "$equal"
+	fn := c.mod.NamedFunction(name)
+	if !fn.IsNil() {
+		return fn, nil
+	}
+	llvmType, err := c.getLLVMType(typ)
+	if err != nil {
+		return llvm.Value{}, err
+	}
+	ptrType := llvm.PointerType(llvmType, 0)
+	fnType := llvm.FunctionType(c.ctx.Int1Type(), []llvm.Type{ptrType, ptrType}, false)
+	fn = llvm.AddFunction(c.mod, name, fnType)
+	fn.SetLinkage(llvm.InternalLinkage)
+	fn.SetUnnamedAddr(true)
+	c.arrayEqualFuncs = append(c.arrayEqualFuncs, typ)
+	return fn, nil
+}
+
+// createArrayEqualFunc creates the body of a function declared by
+// getArrayEqualFunc. It compares the elements one by one and stops at the first
+// element that is not equal.
+func (c *Compiler) createArrayEqualFunc(typ *types.Array) error {
+	fn := c.mod.NamedFunction(typ.String() + "$equal")
+	entry := c.ctx.AddBasicBlock(fn, "entry")
+	loop := c.ctx.AddBasicBlock(fn, "loop")
+	next := c.ctx.AddBasicBlock(fn, "next")
+	equal := c.ctx.AddBasicBlock(fn, "equal")
+	notEqual := c.ctx.AddBasicBlock(fn, "notequal")
+
+	c.builder.SetInsertPointAtEnd(entry)
+	c.builder.CreateBr(loop)
+
+	// Compare the element at the current index.
+	c.builder.SetInsertPointAtEnd(loop)
+	index := c.builder.CreatePHI(c.uintptrType, "index")
+	zero := llvm.ConstInt(c.uintptrType, 0, false)
+	xPtr := c.builder.CreateInBoundsGEP(fn.Param(0), []llvm.Value{zero, index}, "x.ptr")
+	yPtr := c.builder.CreateInBoundsGEP(fn.Param(1), []llvm.Value{zero, index}, "y.ptr")
+	xElem := c.builder.CreateLoad(xPtr, "x")
+	yElem := c.builder.CreateLoad(yPtr, "y")
+	elemEqual, err := c.parseBinOp(token.EQL, typ.Elem().Underlying(), xElem, yElem)
+	if err != nil {
+		return err
+	}
+	c.builder.CreateCondBr(elemEqual, next, notEqual)
+
+	// Continue with the next element, if there is one.
+	c.builder.SetInsertPointAtEnd(next)
+	nextIndex := c.builder.CreateAdd(index, llvm.ConstInt(c.uintptrType, 1, false), "index.next")
+	done := c.builder.CreateICmp(llvm.IntEQ, nextIndex, llvm.ConstInt(c.uintptrType, uint64(typ.Len()), false), "")
+	c.builder.CreateCondBr(done, equal, loop)
+	index.AddIncoming([]llvm.Value{zero, nextIndex}, []llvm.BasicBlock{entry, next})
+
+	c.builder.SetInsertPointAtEnd(equal)
+	c.builder.CreateRet(llvm.ConstInt(c.ctx.Int1Type(), 1, false))
+	c.builder.SetInsertPointAtEnd(notEqual)
+	c.builder.CreateRet(llvm.ConstInt(c.ctx.Int1Type(), 0, false))
+	return nil
+}
+
+// containsInterface returns whether comparing values of this type may compare
+// interfaces, which panics when their dynamic type is not comparable.
+func containsInterface(typ types.Type) bool {
+	switch typ := typ.Underlying().(type) {
+	case *types.Interface:
+		return true
+	case *types.Array:
+		return containsInterface(typ.Elem())
+	case *types.Struct:
+		for i := 0; i < typ.NumFields(); i++ {
+			if containsInterface(typ.Field(i).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error) {
 	switch typ := expr.Type().Underlying().(type) {
 	case *types.Basic:
@@ -2880,7 +3735,12 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 			}
 			return llvm.ConstInt(llvmType, n, false), nil
 		} else if typ.Info()&types.IsString != 0 {
-			str := constant.StringVal(expr.Value)
+			str := ""
+			if expr.Value != nil {
+				// A nil value is the result of converting a nil []byte or
+				// []rune to a string.
+				str = constant.StringVal(expr.Value)
+			}
 			strLen := llvm.ConstInt(c.lenType, uint64(len(str)), false)
 			objname := prefix + "$string"
 			global := llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(str)), objname)
@@ -2907,19 +3767,20 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 		} else if typ.Info()&types.IsFloat != 0 {
 			n, _ := constant.Float64Val(expr.Value)
 			return llvm.ConstFloat(llvmType, n), nil
-		} else if typ.Kind() == types.Complex128 {
-			r, err := c.parseConst(prefix, ssa.NewConst(constant.Real(expr.Value), types.Typ[types.Float64]))
+		} else if typ.Info()&types.IsComplex != 0 {
+			floatType := types.Typ[types.Float64]
+			if typ.Kind() == types.Complex64 {
+				floatType = types.Typ[types.Float32]
+			}
+			r, err := c.parseConst(prefix, ssa.NewConst(constant.Real(expr.Value), floatType))
 			if err != nil {
 				return llvm.Value{}, err
 			}
-			i, err := c.parseConst(prefix, ssa.NewConst(constant.Imag(expr.Value), types.Typ[types.Float64]))
+			i, err := c.parseConst(prefix, ssa.NewConst(constant.Imag(expr.Value), floatType))
 			if err != nil {
 				return llvm.Value{}, err
 			}
-			cplx := llvm.Undef(llvm.VectorType(c.ctx.DoubleType(), 2))
-			cplx = c.builder.CreateInsertElement(cplx, r, llvm.ConstInt(c.ctx.Int8Type(), 0, false), "")
-			cplx = c.builder.CreateInsertElement(cplx, i, llvm.ConstInt(c.ctx.Int8Type(), 1, false), "")
-			return cplx, nil
+			return llvm.ConstVector([]llvm.Value{r, i}, false), nil
 		} else {
 			return llvm.Value{}, errors.New("todo: unknown constant: " + expr.String())
 		}
@@ -2938,11 +3799,20 @@ func (c *Compiler) parseConst(prefix string, expr *ssa.Const) (llvm.Value, error
 		}
 		// Create a generic nil interface with no dynamic type (typecode=0).
 		fields := []llvm.Value{
-			llvm.ConstInt(c.ctx.Int16Type(), 0, false),
+			llvm.ConstInt(c.typecodeType, 0, false),
 			llvm.ConstPointerNull(c.i8ptrType),
 		}
 		itf := llvm.ConstNamedStruct(c.mod.GetTypeByName("runtime._interface"), fields)
 		return itf, nil
+	case *types.Chan:
+		if expr.Value != nil {
+			return llvm.Value{}, errors.New("non-nil channel constant")
+		}
+		llvmType, err := c.getLLVMType(typ)
+		if err != nil {
+			return llvm.Value{}, err
+		}
+		return llvm.ConstPointerNull(llvmType), nil
 	case *types.Pointer:
 		if expr.Value != nil {
 			return llvm.Value{}, errors.New("non-nil pointer constant")
@@ -3016,17 +3886,30 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value) (
 				// Cast to an i32 value as expected by
 				// runtime.stringFromUnicode.
 				if sizeFrom > 4 {
-					value = c.builder.CreateTrunc(value, c.ctx.Int32Type(), "")
-				} else if sizeFrom < 4 && typeTo.Info()&types.IsUnsigned != 0 {
+					// Values that don't fit in a rune are invalid code
+					// points. Replace them with -1, which is invalid as
+					// well and results in U+FFFD.
+					truncated := c.builder.CreateTrunc(value, c.ctx.Int32Type(), "")
+					var fits llvm.Value
+					if typeFrom.Info()&types.IsUnsigned != 0 {
+						fits = c.builder.CreateICmp(llvm.IntULE, value, llvm.ConstInt(value.Type(), 0x7fffffff, false), "")
+					} else {
+						extended := c.builder.CreateSExt(truncated, value.Type(), "")
+						fits = c.builder.CreateICmp(llvm.IntEQ, value, extended, "")
+					}
+					value = c.builder.CreateSelect(fits, truncated, llvm.ConstAllOnes(c.ctx.Int32Type()), "")
+				} else if sizeFrom < 4 && typeFrom.Info()&types.IsUnsigned != 0 {
 					value = c.builder.CreateZExt(value, c.ctx.Int32Type(), "")
 				} else if sizeFrom < 4 {
 					value = c.builder.CreateSExt(value, c.ctx.Int32Type(), "")
 				}
 				return c.createRuntimeCall("stringFromUnicode", []llvm.Value{value}, ""), nil
 			case *types.Slice:
-				switch typeFrom.Elem().(*types.Basic).Kind() {
+				switch typeFrom.Elem().Underlying().(*types.Basic).Kind() {
 				case types.Byte:
 					return c.createRuntimeCall("stringFromBytes", []llvm.Value{value}, ""), nil
+				case types.Rune:
+					return c.createRuntimeCall("stringFromRunes", []llvm.Value{value}, ""), nil
 				default:
 					return llvm.Value{}, errors.New("todo: convert to string: " + typeFrom.String())
 				}
@@ -3042,7 +3925,7 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value) (
 			// Conversion between two integers.
 			if sizeFrom > sizeTo {
 				return c.builder.CreateTrunc(value, llvmTypeTo, ""), nil
-			} else if typeTo.Info()&types.IsUnsigned != 0 { // if unsigned
+			} else if typeFrom.Info()&types.IsUnsigned != 0 { // if unsigned
 				return c.builder.CreateZExt(value, llvmTypeTo, ""), nil
 			} else { // if signed
 				return c.builder.CreateSExt(value, llvmTypeTo, ""), nil
@@ -3084,10 +3967,7 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value) (
 			i := c.builder.CreateExtractElement(value, llvm.ConstInt(c.ctx.Int32Type(), 1, false), "imag.f64")
 			r = c.builder.CreateFPTrunc(r, c.ctx.FloatType(), "real.f32")
 			i = c.builder.CreateFPTrunc(i, c.ctx.FloatType(), "imag.f32")
-			cplx := llvm.Undef(llvm.VectorType(c.ctx.FloatType(), 2))
-			cplx = c.builder.CreateInsertElement(cplx, r, llvm.ConstInt(c.ctx.Int8Type(), 0, false), "")
-			cplx = c.builder.CreateInsertElement(cplx, i, llvm.ConstInt(c.ctx.Int8Type(), 1, false), "")
-			return cplx, nil
+			return c.createComplex(r, i), nil
 		}
 
 		if typeFrom.Kind() == types.Complex64 && typeTo.Kind() == types.Complex128 {
@@ -3096,10 +3976,7 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value) (
 			i := c.builder.CreateExtractElement(value, llvm.ConstInt(c.ctx.Int32Type(), 1, false), "imag.f32")
 			r = c.builder.CreateFPExt(r, c.ctx.DoubleType(), "real.f64")
 			i = c.builder.CreateFPExt(i, c.ctx.DoubleType(), "imag.f64")
-			cplx := llvm.Undef(llvm.VectorType(c.ctx.DoubleType(), 2))
-			cplx = c.builder.CreateInsertElement(cplx, r, llvm.ConstInt(c.ctx.Int8Type(), 0, false), "")
-			cplx = c.builder.CreateInsertElement(cplx, i, llvm.ConstInt(c.ctx.Int8Type(), 1, false), "")
-			return cplx, nil
+			return c.createComplex(r, i), nil
 		}
 
 		return llvm.Value{}, errors.New("todo: convert: basic non-integer type: " + typeFrom.String() + " -> " + typeTo.String())
@@ -3113,6 +3990,8 @@ func (c *Compiler) parseConvert(typeFrom, typeTo types.Type, value llvm.Value) (
 		switch elemType.Kind() {
 		case types.Byte:
 			return c.createRuntimeCall("stringToBytes", []llvm.Value{value}, ""), nil
+		case types.Rune:
+			return c.createRuntimeCall("stringToRunes", []llvm.Value{value}, ""), nil
 		default:
 			return llvm.Value{}, errors.New("todo: convert from string: " + elemType.String())
 		}
@@ -3196,7 +4075,10 @@ func (c *Compiler) parseMakeClosure(frame *Frame, expr *ssa.MakeClosure) (llvm.V
 	if err != nil {
 		return llvm.Value{}, err
 	}
-	closure = c.builder.CreateInsertValue(closure, f.LLVMFn, 1, "")
+	// The function pointer of a blocking function has a different type: that
+	// of a coroutine.
+	fnPtr := c.builder.CreateBitCast(f.LLVMFn, typ.StructElementTypes()[1], "")
+	closure = c.builder.CreateInsertValue(closure, fnPtr, 1, "")
 	closure = c.builder.CreateInsertValue(closure, context, 0, "")
 	return closure, nil
 }
@@ -3215,6 +4097,8 @@ func (c *Compiler) parseUnOp(frame *Frame, unop *ssa.UnOp) (llvm.Value, error) {
 				return c.builder.CreateSub(llvm.ConstInt(x.Type(), 0, false), x, ""), nil
 			} else if typ.Info()&types.IsFloat != 0 {
 				return c.builder.CreateFSub(llvm.ConstFloat(x.Type(), 0.0), x, ""), nil
+			} else if typ.Info()&types.IsComplex != 0 {
+				return c.builder.CreateFNeg(x, ""), nil
 			} else {
 				return llvm.Value{}, errors.New("todo: unknown basic type for negate: " + typ.String())
 			}
@@ -3223,6 +4107,7 @@ func (c *Compiler) parseUnOp(frame *Frame, unop *ssa.UnOp) (llvm.Value, error) {
 		}
 	case token.MUL: // *x, dereference pointer
 		valType := unop.X.Type().(*types.Pointer).Elem()
+		c.emitNilCheck(frame, x, unop.X)
 		if c.targetData.TypeAllocSize(x.Type().ElementType()) == 0 {
 			// zero-length data
 			return c.getZeroValue(x.Type().ElementType())
@@ -3236,6 +4121,8 @@ func (c *Compiler) parseUnOp(frame *Frame, unop *ssa.UnOp) (llvm.Value, error) {
 		}
 	case token.XOR: // ^x, toggle all bits in integer
 		return c.builder.CreateXor(x, llvm.ConstInt(x.Type(), ^uint64(0), false), ""), nil
+	case token.ARROW: // <-x, receive from channel
+		return c.emitChanRecv(frame, unop, x)
 	default:
 		return llvm.Value{}, errors.New("todo: unknown unop")
 	}
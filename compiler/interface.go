@@ -3,6 +3,7 @@ package compiler
 import (
 	"errors"
 	"go/types"
+	"strconv"
 
 	"github.com/aykevl/go-llvm"
 	"github.com/aykevl/tinygo/ir"
@@ -14,7 +15,8 @@ import (
 // it will do an allocation of the right size and put that in the interface
 // value field.
 //
-// An interface value is a {typecode, value} tuple, or {i16, i8*} to be exact.
+// An interface value is a {typecode, value} tuple, or {i16, i8*} to be exact
+// (or {i32, i8*} on targets with 32-bit typecodes).
 func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, global string) (llvm.Value, error) {
 	var itfValue llvm.Value
 	size := c.targetData.TypeAllocSize(val.Type())
@@ -25,9 +27,7 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, global str
 			global.SetInitializer(val)
 			global.SetLinkage(llvm.InternalLinkage)
 			global.SetGlobalConstant(true)
-			zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
-			itfValueRaw := llvm.ConstInBoundsGEP(global, []llvm.Value{zero, zero})
-			itfValue = llvm.ConstBitCast(itfValueRaw, c.i8ptrType)
+			itfValue = llvm.ConstBitCast(global, c.i8ptrType)
 		} else {
 			// Allocate on the heap and put a pointer in the interface.
 			// TODO: escape analysis.
@@ -46,7 +46,12 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, global str
 			itfValue = c.builder.CreateIntToPtr(val, c.i8ptrType, "")
 		case llvm.PointerTypeKind:
 			itfValue = c.builder.CreateBitCast(val, c.i8ptrType, "")
-		case llvm.StructTypeKind:
+		case llvm.FloatTypeKind, llvm.DoubleTypeKind, llvm.VectorTypeKind:
+			// Floats (and complex numbers) can only be cast to a pointer via
+			// an integer of the same size.
+			intType := c.ctx.IntType(int(size) * 8)
+			itfValue = c.builder.CreateIntToPtr(c.builder.CreateBitCast(val, intType, ""), c.i8ptrType, "")
+		case llvm.StructTypeKind, llvm.ArrayTypeKind:
 			// A bitcast would be useful here, but bitcast doesn't allow
 			// aggregate types. So we'll bitcast it using an alloca.
 			// Hopefully this will get optimized away.
@@ -58,11 +63,7 @@ func (c *Compiler) parseMakeInterface(val llvm.Value, typ types.Type, global str
 			return llvm.Value{}, errors.New("todo: makeinterface: cast small type to i8*")
 		}
 	}
-	itfTypeNum, _ := c.ir.TypeNum(typ)
-	if itfTypeNum >= 1<<16 {
-		return llvm.Value{}, errors.New("interface typecodes do not fit in a 16-bit integer")
-	}
-	itf := llvm.ConstNamedStruct(c.mod.GetTypeByName("runtime._interface"), []llvm.Value{llvm.ConstInt(c.ctx.Int16Type(), uint64(itfTypeNum), false), llvm.Undef(c.i8ptrType)})
+	itf := llvm.ConstNamedStruct(c.mod.GetTypeByName("runtime._interface"), []llvm.Value{c.getTypecode(typ), llvm.Undef(c.i8ptrType)})
 	itf = c.builder.CreateInsertValue(itf, itfValue, 1, "")
 	return itf, nil
 }
@@ -96,33 +97,14 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) (llvm.Val
 		// This is slightly non-trivial: at runtime the list of methods
 		// needs to be checked to see whether it implements the interface.
 		// At the same time, the interface value itself is unchanged.
-		itfTypeNum := c.ir.InterfaceNum(itf)
-		itfTypeNumValue := llvm.ConstInt(c.ctx.Int16Type(), uint64(itfTypeNum), false)
-		commaOk = c.createRuntimeCall("interfaceImplements", []llvm.Value{actualTypeNum, itfTypeNumValue}, "")
+		itfTypeNum := c.getPlaceholder("tinygo.interface:"+ir.InterfaceKey(itf), c.typecodeType)
+		commaOk = c.createRuntimeCall("interfaceImplements", []llvm.Value{actualTypeNum, itfTypeNum}, "")
 
 	} else {
 		// Type assert on concrete type.
-		// This is easy: just compare the type number.
-		assertedTypeNum, typeExists := c.ir.TypeNum(expr.AssertedType)
-		if !typeExists {
-			// Static analysis has determined this type assert will never apply.
-			// Using undef here so that LLVM knows we'll never get here and
-			// can optimize accordingly.
-			undef := llvm.Undef(assertedType)
-			commaOk := llvm.ConstInt(c.ctx.Int1Type(), 0, false)
-			if expr.CommaOk {
-				return c.ctx.ConstStruct([]llvm.Value{undef, commaOk}, false), nil
-			} else {
-				c.createRuntimeCall("interfaceTypeAssert", []llvm.Value{commaOk}, "")
-				return undef, nil
-			}
-		}
-		if assertedTypeNum >= 1<<16 {
-			return llvm.Value{}, errors.New("interface typecodes do not fit in a 16-bit integer")
-		}
-
-		assertedTypeNumValue := llvm.ConstInt(c.ctx.Int16Type(), uint64(assertedTypeNum), false)
-		commaOk = c.builder.CreateICmp(llvm.IntEQ, assertedTypeNumValue, actualTypeNum, "")
+		// This is easy: just compare the type number. If the type is never
+		// put in an interface, the type number will not match any type.
+		commaOk = c.builder.CreateICmp(llvm.IntEQ, c.getTypecode(expr.AssertedType), actualTypeNum, "")
 	}
 
 	// Add 2 new basic blocks (that should get optimized away): one for the
@@ -161,7 +143,7 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) (llvm.Val
 			valueOk = c.builder.CreateLoad(valuePtrCast, "typeassert.value.ok")
 		} else if size == 0 {
 			valueOk, err = c.getZeroValue(assertedType)
-			if err == nil {
+			if err != nil {
 				return llvm.Value{}, err
 			}
 		} else {
@@ -171,7 +153,11 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) (llvm.Val
 				valueOk = c.builder.CreatePtrToInt(valuePtr, assertedType, "typeassert.value.ok")
 			case llvm.PointerTypeKind:
 				valueOk = c.builder.CreateBitCast(valuePtr, assertedType, "typeassert.value.ok")
-			case llvm.StructTypeKind:
+			case llvm.FloatTypeKind, llvm.DoubleTypeKind, llvm.VectorTypeKind:
+				intType := c.ctx.IntType(int(size) * 8)
+				valueInt := c.builder.CreatePtrToInt(valuePtr, intType, "")
+				valueOk = c.builder.CreateBitCast(valueInt, assertedType, "typeassert.value.ok")
+			case llvm.StructTypeKind, llvm.ArrayTypeKind:
 				// A bitcast would be useful here, but bitcast doesn't allow
 				// aggregate types. So we'll bitcast it using an alloca.
 				// Hopefully this will get optimized away.
@@ -200,6 +186,7 @@ func (c *Compiler) parseTypeAssert(frame *Frame, expr *ssa.TypeAssert) (llvm.Val
 		// This is kind of dirty as the branch above becomes mostly useless,
 		// but hopefully this gets optimized away.
 		c.createRuntimeCall("interfaceTypeAssert", []llvm.Value{commaOk}, "")
+		c.emitUnwindCheck(frame)
 		return phi, nil
 	}
 }
@@ -227,11 +214,14 @@ func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Valu
 		// used in a closure or bound method.
 		llvmFnType = llvmFnType.Subtypes()[1]
 	}
+	if c.ir.IsBlockingCall(instr) {
+		llvmFnType = llvm.PointerType(c.getCoroutineFunctionType(llvmFnType.ElementType()), 0)
+	}
 
 	typecode := c.builder.CreateExtractValue(itf, 0, "invoke.typecode")
 	values := []llvm.Value{
 		typecode,
-		llvm.ConstInt(c.ctx.Int16Type(), uint64(c.ir.MethodNum(instr.Method)), false),
+		c.getPlaceholder("tinygo.method:"+ir.MethodSignature(instr.Method), c.typecodeType),
 	}
 	fn := c.createRuntimeCall("interfaceMethod", values, "invoke.func")
 	fnCast := c.builder.CreateBitCast(fn, llvmFnType, "invoke.func.cast")
@@ -255,6 +245,23 @@ func (c *Compiler) getInvokeCall(frame *Frame, instr *ssa.CallCommon) (llvm.Valu
 	return fnCast, args, nil
 }
 
+// getTypecode returns the typecode of the given type. The typecode is only
+// known when all packages are linked, so a placeholder is returned instead.
+func (c *Compiler) getTypecode(typ types.Type) llvm.Value {
+	return c.getPlaceholder("tinygo.typecode:"+typ.String(), c.typecodeType)
+}
+
+// checkTypecodeWidth returns an error when n doesn't fit in a typecode. The
+// width of typecodes (and method and interface numbers, which use the same
+// type) depends on the target, see runtime.typecodeID.
+func (c *Compiler) checkTypecodeWidth(n int, what string) error {
+	width := c.typecodeType.IntTypeWidth()
+	if uint64(n) >= 1<<uint(width) {
+		return errors.New(what + " do not fit in a " + strconv.Itoa(width) + "-bit integer")
+	}
+	return nil
+}
+
 // Initialize runtime type information, for interfaces.
 // See src/runtime/interface.go for more details.
 func (c *Compiler) createInterfaceRTTI() error {
@@ -270,8 +277,8 @@ func (c *Compiler) createInterfaceRTTI() error {
 	rangeType := c.mod.GetTypeByName("runtime.methodSetRange")
 	for _, meta := range dynamicTypes {
 		rangeValues := []llvm.Value{
-			llvm.ConstInt(c.ctx.Int16Type(), uint64(startIndex), false),
-			llvm.ConstInt(c.ctx.Int16Type(), uint64(len(meta.Methods)), false),
+			llvm.ConstInt(c.typecodeType, uint64(startIndex), false),
+			llvm.ConstInt(c.typecodeType, uint64(len(meta.Methods)), false),
 		}
 		rangeValue := llvm.ConstNamedStruct(rangeType, rangeValues)
 		ranges = append(ranges, rangeValue)
@@ -292,7 +299,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 			fnPtr := llvm.ConstBitCast(fn, c.i8ptrType)
 			funcPointers = append(funcPointers, fnPtr)
 			signatureNum := c.ir.MethodNum(method.Obj().(*types.Func))
-			signature := llvm.ConstInt(c.ctx.Int16Type(), uint64(signatureNum), false)
+			signature := llvm.ConstInt(c.typecodeType, uint64(signatureNum), false)
 			signatures = append(signatures, signature)
 		}
 		startIndex += len(meta.Methods)
@@ -303,24 +310,27 @@ func (c *Compiler) createInterfaceRTTI() error {
 	interfaceLengths := make([]llvm.Value, len(interfaceTypes))
 	interfaceMethods := make([]llvm.Value, 0)
 	for i, itfType := range interfaceTypes {
-		if itfType.Type.NumMethods() > 0xff {
-			return errors.New("too many methods for interface " + itfType.Type.String())
-		}
-		interfaceIndex[i] = llvm.ConstInt(c.ctx.Int16Type(), uint64(i), false)
-		interfaceLengths[i] = llvm.ConstInt(c.ctx.Int8Type(), uint64(itfType.Type.NumMethods()), false)
+		interfaceIndex[i] = llvm.ConstInt(c.typecodeType, uint64(len(interfaceMethods)), false)
+		interfaceLengths[i] = llvm.ConstInt(c.typecodeType, uint64(itfType.Type.NumMethods()), false)
 		funcs := make([]*types.Func, itfType.Type.NumMethods())
 		for i := range funcs {
 			funcs[i] = itfType.Type.Method(i)
 		}
 		c.ir.SortFuncs(funcs)
 		for _, f := range funcs {
-			id := llvm.ConstInt(c.ctx.Int16Type(), uint64(c.ir.MethodNum(f)), false)
+			id := llvm.ConstInt(c.typecodeType, uint64(c.ir.MethodNum(f)), false)
 			interfaceMethods = append(interfaceMethods, id)
 		}
 	}
 
-	if len(ranges) >= 1<<16 {
-		return errors.New("method call numbers do not fit in a 16-bit integer")
+	if err := c.checkTypecodeWidth(len(ranges), "method call numbers"); err != nil {
+		return err
+	}
+	if err := c.checkTypecodeWidth(startIndex, "method set indices"); err != nil {
+		return err
+	}
+	if err := c.checkTypecodeWidth(len(interfaceMethods), "interface method indices"); err != nil {
+		return err
 	}
 
 	// Replace the pre-created arrays with the generated arrays.
@@ -340,7 +350,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 	funcArrayOldGlobal.ReplaceAllUsesWith(llvm.ConstBitCast(funcArrayNewGlobal, funcArrayOldGlobal.Type()))
 	funcArrayOldGlobal.EraseFromParentAsGlobal()
 	funcArrayNewGlobal.SetName("runtime.methodSetFunctions")
-	signatureArray := llvm.ConstArray(c.ctx.Int16Type(), signatures)
+	signatureArray := llvm.ConstArray(c.typecodeType, signatures)
 	signatureArrayNewGlobal := llvm.AddGlobal(c.mod, signatureArray.Type(), "runtime.methodSetSignatures.tmp")
 	signatureArrayNewGlobal.SetInitializer(signatureArray)
 	signatureArrayNewGlobal.SetLinkage(llvm.InternalLinkage)
@@ -348,7 +358,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 	signatureArrayOldGlobal.ReplaceAllUsesWith(llvm.ConstBitCast(signatureArrayNewGlobal, signatureArrayOldGlobal.Type()))
 	signatureArrayOldGlobal.EraseFromParentAsGlobal()
 	signatureArrayNewGlobal.SetName("runtime.methodSetSignatures")
-	interfaceIndexArray := llvm.ConstArray(c.ctx.Int16Type(), interfaceIndex)
+	interfaceIndexArray := llvm.ConstArray(c.typecodeType, interfaceIndex)
 	interfaceIndexArrayNewGlobal := llvm.AddGlobal(c.mod, interfaceIndexArray.Type(), "runtime.interfaceIndex.tmp")
 	interfaceIndexArrayNewGlobal.SetInitializer(interfaceIndexArray)
 	interfaceIndexArrayNewGlobal.SetLinkage(llvm.InternalLinkage)
@@ -356,7 +366,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 	interfaceIndexArrayOldGlobal.ReplaceAllUsesWith(llvm.ConstBitCast(interfaceIndexArrayNewGlobal, interfaceIndexArrayOldGlobal.Type()))
 	interfaceIndexArrayOldGlobal.EraseFromParentAsGlobal()
 	interfaceIndexArrayNewGlobal.SetName("runtime.interfaceIndex")
-	interfaceLengthsArray := llvm.ConstArray(c.ctx.Int8Type(), interfaceLengths)
+	interfaceLengthsArray := llvm.ConstArray(c.typecodeType, interfaceLengths)
 	interfaceLengthsArrayNewGlobal := llvm.AddGlobal(c.mod, interfaceLengthsArray.Type(), "runtime.interfaceLengths.tmp")
 	interfaceLengthsArrayNewGlobal.SetInitializer(interfaceLengthsArray)
 	interfaceLengthsArrayNewGlobal.SetLinkage(llvm.InternalLinkage)
@@ -364,7 +374,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 	interfaceLengthsArrayOldGlobal.ReplaceAllUsesWith(llvm.ConstBitCast(interfaceLengthsArrayNewGlobal, interfaceLengthsArrayOldGlobal.Type()))
 	interfaceLengthsArrayOldGlobal.EraseFromParentAsGlobal()
 	interfaceLengthsArrayNewGlobal.SetName("runtime.interfaceLengths")
-	interfaceMethodsArray := llvm.ConstArray(c.ctx.Int16Type(), interfaceMethods)
+	interfaceMethodsArray := llvm.ConstArray(c.typecodeType, interfaceMethods)
 	interfaceMethodsArrayNewGlobal := llvm.AddGlobal(c.mod, interfaceMethodsArray.Type(), "runtime.interfaceMethods.tmp")
 	interfaceMethodsArrayNewGlobal.SetInitializer(interfaceMethodsArray)
 	interfaceMethodsArrayNewGlobal.SetLinkage(llvm.InternalLinkage)
@@ -373,7 +383,7 @@ func (c *Compiler) createInterfaceRTTI() error {
 	interfaceMethodsArrayOldGlobal.EraseFromParentAsGlobal()
 	interfaceMethodsArrayNewGlobal.SetName("runtime.interfaceMethods")
 
-	c.mod.NamedGlobal("runtime.firstTypeWithMethods").SetInitializer(llvm.ConstInt(c.ctx.Int16Type(), uint64(c.ir.FirstDynamicType()), false))
+	c.mod.NamedGlobal("runtime.firstTypeWithMethods").SetInitializer(llvm.ConstInt(c.typecodeType, uint64(c.ir.FirstDynamicType()), false))
 
 	return nil
 }
@@ -381,7 +391,8 @@ func (c *Compiler) createInterfaceRTTI() error {
 // Wrap an interface method function pointer. The wrapper takes in a pointer to
 // the underlying value, dereferences it, and calls the real method. This
 // wrapper is only needed when the interface value actually doesn't fit in a
-// pointer and a pointer to the value must be created.
+// pointer and a pointer to the value must be created, or when the value is
+// stored in the pointer but cannot be passed like one (floats, for example).
 func (c *Compiler) wrapInterfaceInvoke(f *ir.Function) (llvm.Value, error) {
 	receiverType, err := c.getLLVMType(f.Params[0].Type())
 	if err != nil {
@@ -390,13 +401,27 @@ func (c *Compiler) wrapInterfaceInvoke(f *ir.Function) (llvm.Value, error) {
 	expandedReceiverType := c.expandFormalParamType(receiverType)
 
 	if c.targetData.TypeAllocSize(receiverType) <= c.targetData.TypeAllocSize(c.i8ptrType) && len(expandedReceiverType) == 1 {
-		// nothing to wrap
-		return f.LLVMFn, nil
+		switch receiverType.TypeKind() {
+		case llvm.IntegerTypeKind, llvm.PointerTypeKind:
+			// nothing to wrap
+			return f.LLVMFn, nil
+		}
 	}
 
 	// create wrapper function
 	fnType := f.LLVMFn.Type().ElementType()
-	paramTypes := append([]llvm.Type{c.i8ptrType}, fnType.ParamTypes()[len(expandedReceiverType):]...)
+	coroutineParams := 0
+	if c.ir.IsBlocking(f) {
+		// The wrapper passes the parent coroutine and the result pointer on to
+		// the real method and returns its coroutine handle.
+		coroutineParams = 1
+		if f.Signature.Results().Len() != 0 {
+			coroutineParams++
+		}
+	}
+	paramTypes := append([]llvm.Type{}, fnType.ParamTypes()[:coroutineParams]...)
+	paramTypes = append(paramTypes, c.i8ptrType)
+	paramTypes = append(paramTypes, fnType.ParamTypes()[coroutineParams+len(expandedReceiverType):]...)
 	wrapFnType := llvm.FunctionType(fnType.ReturnType(), paramTypes, false)
 	wrapper := llvm.AddFunction(c.mod, f.LinkName()+"$invoke", wrapFnType)
 	wrapper.SetLinkage(llvm.InternalLinkage)
@@ -416,6 +441,7 @@ func (c *Compiler) wrapInterfaceInvoke(f *ir.Function) (llvm.Value, error) {
 	block := c.ctx.AddBasicBlock(wrapper, "entry")
 	c.builder.SetInsertPointAtEnd(block)
 
+	receiverParam := wrapper.Param(coroutineParams)
 	var receiverPtr llvm.Value
 	if c.targetData.TypeAllocSize(receiverType) > c.targetData.TypeAllocSize(c.i8ptrType) {
 		// The receiver is passed in using a pointer. We have to load it here
@@ -423,24 +449,24 @@ func (c *Compiler) wrapInterfaceInvoke(f *ir.Function) (llvm.Value, error) {
 
 		// Load the underlying value.
 		receiverPtrType := llvm.PointerType(receiverType, 0)
-		receiverPtr = c.builder.CreateBitCast(wrapper.Param(0), receiverPtrType, "receiver.ptr")
-	} else if len(expandedReceiverType) != 1 {
-		// The value is stored in the interface, but it is of type struct which
-		// is expanded to multiple parameters (e.g. {i8, i8}). So we have to
-		// receive the struct as parameter, expand it, and pass it on to the
-		// real function.
-
-		// Cast the passed-in i8* to the struct value (using an alloca) and
-		// extract its values.
+		receiverPtr = c.builder.CreateBitCast(receiverParam, receiverPtrType, "receiver.ptr")
+	} else {
+		// The value is stored in the interface, but it is either of type
+		// struct which is expanded to multiple parameters (e.g. {i8, i8}) or a
+		// float which is not passed like a pointer. So we have to receive the
+		// value as parameter, convert it, and pass it on to the real function.
+
+		// Cast the passed-in i8* to the value (using an alloca) and extract
+		// its values.
 		alloca := c.builder.CreateAlloca(c.i8ptrType, "receiver.alloca")
-		c.builder.CreateStore(wrapper.Param(0), alloca)
+		c.builder.CreateStore(receiverParam, alloca)
 		receiverPtr = c.builder.CreateBitCast(alloca, llvm.PointerType(receiverType, 0), "receiver.ptr")
-	} else {
-		panic("unreachable")
 	}
 
 	receiverValue := c.builder.CreateLoad(receiverPtr, "receiver")
-	params := append(c.expandFormalParam(receiverValue), wrapper.Params()[1:]...)
+	params := append([]llvm.Value{}, wrapper.Params()[:coroutineParams]...)
+	params = append(params, c.expandFormalParam(receiverValue)...)
+	params = append(params, wrapper.Params()[coroutineParams+1:]...)
 	if fnType.ReturnType().TypeKind() == llvm.VoidTypeKind {
 		c.builder.CreateCall(f.LLVMFn, params, "")
 		c.builder.CreateRetVoid()
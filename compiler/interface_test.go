@@ -0,0 +1,39 @@
+package compiler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/aykevl/go-llvm"
+)
+
+func TestCheckTypecodeWidth(t *testing.T) {
+	ctx := llvm.NewContext()
+	defer ctx.Dispose()
+	for _, tc := range []struct {
+		typ  llvm.Type
+		n    int
+		fits bool
+	}{
+		{ctx.Int8Type(), 0, true},
+		{ctx.Int8Type(), 255, true},
+		{ctx.Int8Type(), 256, false},
+		{ctx.Int16Type(), 65535, true},
+		{ctx.Int16Type(), 65536, false},
+		{ctx.Int32Type(), 65536, true},
+	} {
+		c := &Compiler{typecodeType: tc.typ}
+		err := c.checkTypecodeWidth(tc.n, "interface typecodes")
+		if tc.fits && err != nil {
+			t.Errorf("%d in a %d-bit typecode: unexpected error: %s", tc.n, tc.typ.IntTypeWidth(), err)
+		}
+		if !tc.fits {
+			expected := "interface typecodes do not fit in a " + strconv.Itoa(tc.typ.IntTypeWidth()) + "-bit integer"
+			if err == nil {
+				t.Errorf("%d in a %d-bit typecode: expected an error", tc.n, tc.typ.IntTypeWidth())
+			} else if err.Error() != expected {
+				t.Errorf("%d in a %d-bit typecode: expected error %q, got %q", tc.n, tc.typ.IntTypeWidth(), expected, err.Error())
+			}
+		}
+	}
+}
@@ -4,12 +4,15 @@ package compiler
 
 import (
 	"errors"
+	"go/constant"
 	"go/types"
+	"math"
 
 	"github.com/aykevl/go-llvm"
+	"github.com/aykevl/tinygo/ir"
 )
 
-func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Value, commaOk bool) (llvm.Value, error) {
+func (c *Compiler) emitMapLookup(frame *Frame, keyType, valueType types.Type, m, key llvm.Value, commaOk bool) (llvm.Value, error) {
 	llvmValueType, err := c.getLLVMType(valueType)
 	if err != nil {
 		return llvm.Value{}, err
@@ -17,19 +20,24 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 	mapValueAlloca := c.builder.CreateAlloca(llvmValueType, "hashmap.value")
 	mapValuePtr := c.builder.CreateBitCast(mapValueAlloca, c.i8ptrType, "hashmap.valueptr")
 	var commaOkValue llvm.Value
+	keyType = keyType.Underlying()
 	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
 		// key is a string
 		params := []llvm.Value{m, key, mapValuePtr}
 		commaOkValue = c.createRuntimeCall("hashmapStringGet", params, "")
 	} else if hashmapIsBinaryKey(keyType) {
 		// key can be compared with runtime.memequal
-		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
-		c.builder.CreateStore(key, keyAlloca)
-		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		keyPtr := c.emitMapKeyPtr(key)
 		params := []llvm.Value{m, keyPtr, mapValuePtr}
 		commaOkValue = c.createRuntimeCall("hashmapBinaryGet", params, "")
 	} else {
-		return llvm.Value{}, errors.New("todo: map lookup key type: " + keyType.String())
+		// key is hashed and compared using its type descriptor
+		keyPtr := c.emitMapKeyPtr(key)
+		params := []llvm.Value{m, keyPtr, mapValuePtr}
+		commaOkValue = c.createRuntimeCall("hashmapGenericGet", params, "")
+		// Hashing the key panics when it contains an interface with an
+		// uncomparable dynamic type.
+		c.emitUnwindCheck(frame)
 	}
 	mapValue := c.builder.CreateLoad(mapValueAlloca, "")
 	if commaOk {
@@ -42,7 +50,7 @@ func (c *Compiler) emitMapLookup(keyType, valueType types.Type, m, key llvm.Valu
 	}
 }
 
-func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value) error {
+func (c *Compiler) emitMapUpdate(frame *Frame, keyType types.Type, m, key, value llvm.Value) error {
 	valueAlloca := c.builder.CreateAlloca(value.Type(), "hashmap.value")
 	c.builder.CreateStore(value, valueAlloca)
 	valuePtr := c.builder.CreateBitCast(valueAlloca, c.i8ptrType, "hashmap.valueptr")
@@ -54,18 +62,21 @@ func (c *Compiler) emitMapUpdate(keyType types.Type, m, key, value llvm.Value) e
 		return nil
 	} else if hashmapIsBinaryKey(keyType) {
 		// key can be compared with runtime.memequal
-		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
-		c.builder.CreateStore(key, keyAlloca)
-		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		keyPtr := c.emitMapKeyPtr(key)
 		params := []llvm.Value{m, keyPtr, valuePtr}
 		c.createRuntimeCall("hashmapBinarySet", params, "")
 		return nil
 	} else {
-		return errors.New("todo: map update key type: " + keyType.String())
+		// key is hashed and compared using its type descriptor
+		keyPtr := c.emitMapKeyPtr(key)
+		params := []llvm.Value{m, keyPtr, valuePtr}
+		c.createRuntimeCall("hashmapGenericSet", params, "")
+		c.emitUnwindCheck(frame)
+		return nil
 	}
 }
 
-func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value) error {
+func (c *Compiler) emitMapDelete(frame *Frame, keyType types.Type, m, key llvm.Value) error {
 	keyType = keyType.Underlying()
 	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
 		// key is a string
@@ -73,17 +84,27 @@ func (c *Compiler) emitMapDelete(keyType types.Type, m, key llvm.Value) error {
 		c.createRuntimeCall("hashmapStringDelete", params, "")
 		return nil
 	} else if hashmapIsBinaryKey(keyType) {
-		keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
-		c.builder.CreateStore(key, keyAlloca)
-		keyPtr := c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+		keyPtr := c.emitMapKeyPtr(key)
 		params := []llvm.Value{m, keyPtr}
 		c.createRuntimeCall("hashmapBinaryDelete", params, "")
 		return nil
 	} else {
-		return errors.New("todo: map delete key type: " + keyType.String())
+		keyPtr := c.emitMapKeyPtr(key)
+		params := []llvm.Value{m, keyPtr}
+		c.createRuntimeCall("hashmapGenericDelete", params, "")
+		c.emitUnwindCheck(frame)
+		return nil
 	}
 }
 
+// Store the key in an alloca and return an *i8 pointer to it, as expected by
+// the hashmap functions in the runtime that take a key pointer.
+func (c *Compiler) emitMapKeyPtr(key llvm.Value) llvm.Value {
+	keyAlloca := c.builder.CreateAlloca(key.Type(), "hashmap.key")
+	c.builder.CreateStore(key, keyAlloca)
+	return c.builder.CreateBitCast(keyAlloca, c.i8ptrType, "hashmap.keyptr")
+}
+
 // Get FNV-1a hash of this string.
 //
 // https://en.wikipedia.org/wiki/Fowler%E2%80%93Noll%E2%80%93Vo_hash_function#FNV-1a_hash
@@ -96,6 +117,242 @@ func hashmapHash(data []byte) uint32 {
 	return result
 }
 
+// Calculate the hash of a key of a map that is created at compile time, in the
+// same way as the runtime would hash this key.
+func (c *Compiler) getMapKeyHash(keyType types.Type, key ir.Value) (uint32, error) {
+	keyType = keyType.Underlying()
+	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
+		// key is a string
+		value, err := getConstKey(t, key)
+		if err != nil {
+			return 0, err
+		}
+		return hashmapHash([]byte(constant.StringVal(value))), nil
+	} else if hashmapIsBinaryKey(keyType) {
+		// key is hashed bytewise, so write it out like it is stored in memory
+		llvmKeyType, err := c.getLLVMType(keyType)
+		if err != nil {
+			return 0, err
+		}
+		keyBuf := make([]byte, c.targetData.TypeAllocSize(llvmKeyType))
+		err = c.writeBinaryMapKey(keyBuf, keyType, key)
+		if err != nil {
+			return 0, err
+		}
+		return hashmapHash(keyBuf), nil
+	} else {
+		// key is hashed using its type descriptor (see hashmapValueHash in the
+		// runtime)
+		keyBuf, err := c.appendGenericMapKey(nil, keyType, key)
+		if err != nil {
+			return 0, err
+		}
+		return hashmapHash(keyBuf), nil
+	}
+}
+
+// Write the in-memory representation of a key that is hashed bytewise to buf,
+// which must have the size of the key.
+func (c *Compiler) writeBinaryMapKey(buf []byte, keyType types.Type, key ir.Value) error {
+	switch keyType := keyType.Underlying().(type) {
+	case *types.Basic:
+		n, err := getConstKeyBits(keyType, key)
+		if err != nil {
+			return err
+		}
+		for i := range buf {
+			buf[i] = byte(n)
+			n >>= 8
+		}
+		return nil
+	case *types.Array:
+		array, ok := key.(*ir.ArrayValue)
+		if !ok {
+			return errors.New("todo: init: map key not implemented: " + keyType.String())
+		}
+		elemSize := uint64(len(buf)) / uint64(keyType.Len())
+		for i, elem := range array.Elems {
+			offset := uint64(i) * elemSize
+			err := c.writeBinaryMapKey(buf[offset:offset+elemSize], keyType.Elem(), elem)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *types.Struct:
+		structValue, ok := key.(*ir.StructValue)
+		if !ok {
+			return errors.New("todo: init: map key not implemented: " + keyType.String())
+		}
+		llvmKeyType, err := c.getLLVMType(keyType)
+		if err != nil {
+			return err
+		}
+		for i, field := range structValue.Fields {
+			fieldType := keyType.Field(i).Type()
+			llvmFieldType, err := c.getLLVMType(fieldType)
+			if err != nil {
+				return err
+			}
+			offset := c.targetData.ElementOffset(llvmKeyType, i)
+			size := c.targetData.TypeAllocSize(llvmFieldType)
+			err = c.writeBinaryMapKey(buf[offset:offset+size], fieldType, field)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return errors.New("todo: init: map key not implemented: " + keyType.String())
+	}
+}
+
+// Append the data that is hashed by the runtime for a key that is hashed using
+// its type descriptor. Keep this in sync with hashmapValueHash in the runtime.
+func (c *Compiler) appendGenericMapKey(buf []byte, keyType types.Type, key ir.Value) ([]byte, error) {
+	switch keyType := keyType.Underlying().(type) {
+	case *types.Basic:
+		if keyType.Info()&(types.IsFloat|types.IsComplex|types.IsString) == 0 {
+			// Booleans are hashed as a single byte, integers as 64-bit
+			// integers.
+			n, err := getConstKeyBits(keyType, key)
+			if err != nil {
+				return nil, err
+			}
+			if keyType.Info()&types.IsBoolean != 0 {
+				return append(buf, byte(n)), nil
+			}
+			return appendUint64(buf, n), nil
+		}
+		value, err := getConstKey(keyType, key)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case keyType.Info()&types.IsFloat != 0:
+			return appendFloatMapKey(buf, keyType, value), nil
+		case keyType.Info()&types.IsComplex != 0:
+			buf = appendFloatMapKey(buf, keyType, constant.Real(value))
+			return appendFloatMapKey(buf, keyType, constant.Imag(value)), nil
+		default: // string
+			return append(buf, constant.StringVal(value)...), nil
+		}
+	case *types.Interface:
+		// Hash the typecode (as a little-endian integer of the typecode width)
+		// and then the value.
+		if key, ok := key.(*ir.ConstValue); ok && key.Expr.IsNil() {
+			// nil interface (typecode 0)
+			return c.appendTypecode(buf, 0), nil
+		}
+		itf, ok := key.(*ir.InterfaceValue)
+		if !ok {
+			return nil, errors.New("todo: init: map key not implemented: " + keyType.String())
+		}
+		if itf.Elem == nil {
+			// nil interface (typecode 0)
+			return c.appendTypecode(buf, 0), nil
+		}
+		buf = c.appendTypecode(buf, c.getTypeNum(itf.Type))
+		return c.appendGenericMapKey(buf, itf.Type, itf.Elem)
+	case *types.Array:
+		array, ok := key.(*ir.ArrayValue)
+		if !ok {
+			return nil, errors.New("todo: init: map key not implemented: " + keyType.String())
+		}
+		for _, elem := range array.Elems {
+			var err error
+			buf, err = c.appendGenericMapKey(buf, keyType.Elem(), elem)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case *types.Struct:
+		structValue, ok := key.(*ir.StructValue)
+		if !ok {
+			return nil, errors.New("todo: init: map key not implemented: " + keyType.String())
+		}
+		for i, field := range structValue.Fields {
+			if keyType.Field(i).Name() == "_" {
+				// Blank fields are ignored when comparing structs.
+				continue
+			}
+			var err error
+			buf, err = c.appendGenericMapKey(buf, keyType.Field(i).Type(), field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, errors.New("todo: init: map key not implemented: " + keyType.String())
+	}
+}
+
+// Append a float (or half of a complex number) as it is hashed by the runtime:
+// as a float64 where -0 is replaced by +0.
+func appendFloatMapKey(buf []byte, keyType *types.Basic, value constant.Value) []byte {
+	f, _ := constant.Float64Val(value)
+	if keyType.Kind() == types.Float32 || keyType.Kind() == types.Complex64 {
+		f = float64(float32(f))
+	}
+	if f == 0 {
+		f = 0 // convert -0 to +0
+	}
+	return appendUint64(buf, math.Float64bits(f))
+}
+
+// Append a 64-bit integer in little endian byte order.
+func appendUint64(buf []byte, n uint64) []byte {
+	for i := 0; i < 8; i++ {
+		buf = append(buf, byte(n))
+		n >>= 8
+	}
+	return buf
+}
+
+// Return the constant value of an interpreted map key of a basic type.
+func getConstKey(keyType *types.Basic, key ir.Value) (constant.Value, error) {
+	switch key := key.(type) {
+	case *ir.ConstValue:
+		return key.Expr.Value, nil
+	case *ir.ZeroBasicValue:
+		switch {
+		case keyType.Info()&types.IsBoolean != 0:
+			return constant.MakeBool(false), nil
+		case keyType.Info()&types.IsString != 0:
+			return constant.MakeString(""), nil
+		default:
+			return constant.MakeInt64(0), nil
+		}
+	default:
+		return nil, errors.New("todo: init: map key not implemented: " + keyType.String())
+	}
+}
+
+// Return the bits of a boolean or integer map key, zero extended to 64 bits.
+func getConstKeyBits(keyType *types.Basic, key ir.Value) (uint64, error) {
+	value, err := getConstKey(keyType, key)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case keyType.Info()&types.IsBoolean != 0:
+		if constant.BoolVal(value) {
+			return 1, nil
+		}
+		return 0, nil
+	case keyType.Info()&types.IsUnsigned != 0:
+		n, _ := constant.Uint64Val(value)
+		return n, nil
+	case keyType.Info()&types.IsInteger != 0:
+		n, _ := constant.Int64Val(value)
+		return uint64(n), nil
+	default:
+		return 0, errors.New("todo: init: map key not implemented: " + keyType.String())
+	}
+}
+
 // Get the topmost 8 bits of the hash, without using a special value (like 0).
 func hashmapTopHash(hash uint32) uint8 {
 	tophash := uint8(hash >> 24)
@@ -106,12 +363,38 @@ func hashmapTopHash(hash uint32) uint8 {
 	return tophash
 }
 
-// Returns true if this key type does not contain strings, interfaces etc., so
-// can be compared with runtime.memequal.
+// How keys of a map are hashed and compared by the runtime. Keep these in sync
+// with the hashmapKeyBinary etc. constants in the runtime.
+const (
+	hashmapKeyBinary = iota
+	hashmapKeyString
+	hashmapKeyGeneric
+)
+
+// Return how keys of the given type are hashed and compared by the runtime.
+func hashmapKeyKind(keyType types.Type) uint64 {
+	keyType = keyType.Underlying()
+	if t, ok := keyType.(*types.Basic); ok && t.Info()&types.IsString != 0 {
+		return hashmapKeyString
+	} else if hashmapIsBinaryKey(keyType) {
+		return hashmapKeyBinary
+	} else {
+		return hashmapKeyGeneric
+	}
+}
+
+// Returns true if this key type does not contain strings, interfaces, floats
+// etc., so can be compared with runtime.memequal.
+//
+// Keep this in sync with Type.isBinaryKey in the reflect package.
 func hashmapIsBinaryKey(keyType types.Type) bool {
 	switch keyType := keyType.(type) {
 	case *types.Basic:
-		return keyType.Info()&(types.IsBoolean|types.IsInteger) != 0
+		return keyType.Info()&(types.IsBoolean|types.IsInteger) != 0 || keyType.Kind() == types.UnsafePointer
+	case *types.Pointer, *types.Chan:
+		return true
+	case *types.Array:
+		return hashmapIsBinaryKey(keyType.Elem().Underlying())
 	case *types.Struct:
 		for i := 0; i < keyType.NumFields(); i++ {
 			fieldType := keyType.Field(i).Type().Underlying()
@@ -124,3 +407,12 @@ func hashmapIsBinaryKey(keyType types.Type) bool {
 		return false
 	}
 }
+
+// appendTypecode appends the typecode to buf in the same way it is stored in
+// memory by the runtime: as a little-endian integer of the typecode width.
+func (c *Compiler) appendTypecode(buf []byte, typecode int) []byte {
+	for i := 0; i < c.typecodeType.IntTypeWidth(); i += 8 {
+		buf = append(buf, byte(typecode>>uint(i)))
+	}
+	return buf
+}
@@ -0,0 +1,381 @@
+package compiler
+
+// This file compiles every package to a separate LLVM module, which is linked
+// into the module of the whole program. Compiled packages are stored in a
+// PackageCache (if there is one), so that they can be reused by the next build
+// when neither the package nor the packages it imports have changed.
+//
+// Some things depend on the whole program and are only known when all packages
+// are linked: typecodes, interface and method numbers and pcs. Packages refer
+// to them with placeholders, which are external globals with a name like
+// tinygo.typecode:main.T, that are replaced with the real number at the link
+// stage. Functions that do not belong to a single package, like package
+// initializers and wrappers for methods, are compiled at the link stage.
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/printer"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aykevl/go-llvm"
+	"github.com/aykevl/tinygo/ir"
+	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/ssa"
+)
+
+// PackageCache stores the LLVM bitcode of compiled packages between builds.
+// Names are like the import path of the package with an extension, keys are
+// hashes of everything the file depends on.
+type PackageCache interface {
+	// Load returns the path of the file with the given name and key in the
+	// cache, or "" if there is no such file.
+	Load(name, key string) (string, error)
+
+	// Store moves the file at tmppath into the cache and returns its new path.
+	Store(tmppath, name, key string) (string, error)
+}
+
+// isLinkStageFunction returns whether the function is compiled at the link
+// stage instead of in the module of a package: package initializers (which
+// depend on the interpreter) and functions that do not belong to a package,
+// like wrappers for methods.
+func isLinkStageFunction(f *ir.Function) bool {
+	return f.Pkg == nil || f.Synthetic == "package initializer"
+}
+
+// linkPackages compiles all packages that have functions to a module of their
+// own, or loads them from the package cache, and links them into the current
+// module.
+func (c *Compiler) linkPackages(lprogram *loader.Program) error {
+	c.sourceHashes = make(map[*types.Package]string)
+	for _, pkg := range c.ir.Packages {
+		var fns []*ir.Function
+		for _, f := range c.ir.Functions {
+			if f.Pkg == pkg && len(f.Blocks) != 0 && f.CName() == "" && !isLinkStageFunction(f) {
+				fns = append(fns, f)
+			}
+		}
+		if len(fns) == 0 {
+			continue
+		}
+		path, cached, err := c.getPackageBitcode(lprogram, pkg, fns)
+		if err != nil {
+			return err
+		}
+		mod, err := c.ctx.ParseBitcodeFile(path)
+		if !cached {
+			os.Remove(path)
+		}
+		if err != nil {
+			return errors.New("could not load bitcode of package " + pkg.Pkg.Path() + ": " + err.Error())
+		}
+		err = llvm.LinkModules(c.mod, mod)
+		if err != nil {
+			return errors.New("could not link package " + pkg.Pkg.Path() + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// getPackageBitcode returns the path of a bitcode file with the given functions
+// of the package, either from the package cache or freshly compiled. It also
+// returns whether the file is in the cache: if not, it is a temporary file.
+//
+// A package is cached in two steps. The package key describes the package
+// itself: its source code, that of its imports and the analysis results that
+// affect its code. It is used to find the facts of the package: the names of
+// the functions it uses. The bitcode is stored under a key that includes the
+// package key and for each used function whether it is blocking and whether
+// it needs a context parameter, which depends on the whole program.
+func (c *Compiler) getPackageBitcode(lprogram *loader.Program, pkg *ssa.Package, fns []*ir.Function) (string, bool, error) {
+	name := pkg.Pkg.Path()
+	key, err := c.packageKey(lprogram, pkg, fns)
+	if err != nil {
+		return "", false, err
+	}
+	cache := c.PackageCache
+	if c.DumpSSA {
+		cache = nil // the SSA is only printed when compiling
+	}
+
+	if cache != nil {
+		factsPath, err := cache.Load(name+".facts", key)
+		if err != nil {
+			return "", false, err
+		}
+		if factsPath != "" {
+			facts, err := ioutil.ReadFile(factsPath)
+			if err != nil {
+				return "", false, err
+			}
+			bitcodePath, err := cache.Load(name+".bc", c.bitcodeKey(key, strings.Fields(string(facts))))
+			if err != nil {
+				return "", false, err
+			}
+			if bitcodePath != "" {
+				return bitcodePath, true, nil
+			}
+		}
+	}
+
+	f, err := ioutil.TempFile("", "tinygo-package")
+	if err != nil {
+		return "", false, err
+	}
+	bitcodePath := f.Name()
+	names, err := c.compilePackage(pkg, fns, f)
+	f.Close()
+	if err != nil {
+		os.Remove(bitcodePath)
+		return "", false, err
+	}
+	if cache == nil {
+		return bitcodePath, false, nil
+	}
+
+	bitcodePath, err = cache.Store(bitcodePath, name+".bc", c.bitcodeKey(key, names))
+	if err != nil {
+		return "", false, err
+	}
+	f, err = ioutil.TempFile("", "tinygo-package")
+	if err != nil {
+		return "", false, err
+	}
+	_, err = io.WriteString(f, strings.Join(names, "\n"))
+	f.Close()
+	if err == nil {
+		_, err = cache.Store(f.Name(), name+".facts", key)
+	}
+	os.Remove(f.Name())
+	if err != nil {
+		return "", false, err
+	}
+	return bitcodePath, true, nil
+}
+
+// packageKey returns the key of the package in the package cache. See
+// getPackageBitcode.
+func (c *Compiler) packageKey(lprogram *loader.Program, pkg *ssa.Package, fns []*ir.Function) (string, error) {
+	sourceHash, err := c.sourceHash(lprogram, pkg.Pkg)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "triple %s\n", c.Triple)
+	fmt.Fprintf(h, "goos %s goarch %s\n", c.GOOS, c.GOARCH)
+	fmt.Fprintf(h, "datalayout %s\n", c.targetData.String())
+	fmt.Fprintf(h, "debug %t\n", c.Debug)
+	fmt.Fprintf(h, "nilchecks %t\n", c.NilChecks)
+	fmt.Fprintf(h, "tracebacks %t\n", c.Tracebacks)
+	fmt.Fprintf(h, "tags %s\n", strings.Join(c.BuildTags, " "))
+	fmt.Fprintf(h, "source %s\n", sourceHash)
+	for _, f := range fns {
+		fmt.Fprintf(h, "func %s\n", f.LinkName())
+	}
+	io.WriteString(h, c.ir.CallingConventionKey())
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bitcodeKey returns the key of the bitcode of a package in the package cache,
+// given the package key and the names of the functions it uses. See
+// getPackageBitcode.
+func (c *Compiler) bitcodeKey(packageKey string, names []string) string {
+	h := sha256.New()
+	io.WriteString(h, packageKey+"\n")
+	for _, name := range names {
+		f := c.functions[name]
+		if f == nil {
+			fmt.Fprintf(h, "%s -\n", name) // not used in this program
+			continue
+		}
+		fmt.Fprintf(h, "%s %t %t\n", name, c.ir.IsBlocking(f), c.ir.FunctionNeedsContext(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sourceHash returns a hash of the source code of the package and all packages
+// it imports (directly or indirectly).
+func (c *Compiler) sourceHash(lprogram *loader.Program, pkg *types.Package) (string, error) {
+	if hash, ok := c.sourceHashes[pkg]; ok {
+		return hash, nil
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "package %s\n", pkg.Path())
+	if info := lprogram.AllPackages[pkg]; info != nil {
+		for _, file := range info.Files {
+			filename := lprogram.Fset.File(file.Pos()).Name()
+			fmt.Fprintf(h, "file %s\n", filename)
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				// Generated files, like the main package of a test, do not
+				// exist on disk.
+				err = printer.Fprint(h, lprogram.Fset, file)
+				if err != nil {
+					return "", err
+				}
+				continue
+			}
+			h.Write(data)
+		}
+	}
+	imports := pkg.Imports()
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path() < imports[j].Path()
+	})
+	for _, imported := range imports {
+		hash, err := c.sourceHash(lprogram, imported)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "import %s %s\n", imported.Path(), hash)
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+	c.sourceHashes[pkg] = hash
+	return hash, nil
+}
+
+// compilePackage compiles the given functions of a package to a new module and
+// writes it as bitcode to the file. It returns the names of the functions the
+// module defines or uses.
+func (c *Compiler) compilePackage(pkg *ssa.Package, fns []*ir.Function, f *os.File) ([]string, error) {
+	programState := c.moduleState
+	c.moduleState = c.newModuleState(pkg.Pkg.Path())
+	defer func() {
+		c.dibuilder.Destroy()
+		c.mod.Dispose()
+		c.moduleState = programState
+	}()
+
+	c.cu = c.dibuilder.CreateCompileUnit(llvm.DICompileUnit{
+		Language:  llvm.DW_LANG_Go,
+		File:      pkg.Pkg.Path(),
+		Dir:       "",
+		Producer:  "TinyGo",
+		Optimized: true,
+	})
+
+	err := c.declareGlobals(false)
+	if err != nil {
+		return nil, err
+	}
+	defined := make(map[*ir.Function]bool)
+	for _, f := range fns {
+		defined[f] = true
+	}
+	frames, err := c.declareFunctions(func(f *ir.Function) bool {
+		return defined[f]
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, frame := range frames {
+		if defined[frame.fn] {
+			err := c.parseFunc(frame)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = c.createWrappers()
+	if err != nil {
+		return nil, err
+	}
+	c.finalizeModule()
+
+	// Remove the declarations that are not used, to keep the module small.
+	// The remaining functions are the ones this package depends on.
+	var names []string
+	for fn := c.mod.FirstFunction(); !fn.IsNil(); {
+		next := llvm.NextFunction(fn)
+		if fn.IsDeclaration() && fn.FirstUse().IsNil() {
+			fn.EraseFromParentAsFunction()
+		} else if c.functions[fn.Name()] != nil {
+			names = append(names, fn.Name())
+		}
+		fn = next
+	}
+	for global := c.mod.FirstGlobal(); !global.IsNil(); {
+		next := llvm.NextGlobal(global)
+		if global.IsDeclaration() && global.FirstUse().IsNil() {
+			global.EraseFromParentAsGlobal()
+		}
+		global = next
+	}
+
+	err = llvm.WriteBitcodeToFile(c.mod, f)
+	if err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// getPlaceholder returns a constant of the given integer type for a number that
+// is only known at the link stage, like a typecode. See resolvePlaceholders.
+func (c *Compiler) getPlaceholder(name string, typ llvm.Type) llvm.Value {
+	global := c.mod.NamedGlobal(name)
+	if global.IsNil() {
+		global = llvm.AddGlobal(c.mod, c.ctx.Int8Type(), name)
+		global.SetGlobalConstant(true)
+	}
+	return llvm.ConstPtrToInt(global, typ)
+}
+
+// resolvePlaceholders replaces all placeholders in the module with the number
+// they stand for. Placeholders are external globals that are only used as
+// ptrtoint constants of the integer type of the number, so replacing the
+// global with an inttoptr constant of the number folds them into the number
+// itself.
+func (c *Compiler) resolvePlaceholders() error {
+	numTypes := len(c.ir.AllTypes())
+	for global := c.mod.FirstGlobal(); !global.IsNil(); {
+		next := llvm.NextGlobal(global)
+		name := global.Name()
+		if !strings.HasPrefix(name, "tinygo.") {
+			global = next
+			continue
+		}
+		kind := name[len("tinygo."):strings.IndexByte(name, ':')]
+		value := name[len("tinygo.")+len(kind)+1:]
+		var n int
+		var err error
+		intType := c.typecodeType
+		switch kind {
+		case "typecode":
+			num, ok := c.ir.TypeNumByName(value)
+			if !ok {
+				// The type is never put in an interface, so use a typecode that
+				// doesn't match any type.
+				num = numTypes
+			}
+			n, err = num, c.checkTypecodeWidth(num, "interface typecodes")
+		case "interface":
+			num, ok := c.ir.InterfaceNumByKey(value)
+			if !ok {
+				return errors.New("unknown interface in type assert: " + value)
+			}
+			n = num
+		case "method":
+			n = c.ir.MethodNumByName(value)
+		case "pc":
+			intType = c.uintptrType
+			n, err = c.addPCEntry(value)
+		default:
+			return errors.New("unknown placeholder: " + name)
+		}
+		if err != nil {
+			return err
+		}
+		global.ReplaceAllUsesWith(llvm.ConstIntToPtr(llvm.ConstInt(intType, uint64(n), false), global.Type()))
+		global.EraseFromParentAsGlobal()
+		global = next
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package compiler
+
+// This file emits the type descriptors used by the reflect package. See
+// src/reflect/type.go for a description of their layout.
+
+import (
+	"errors"
+	"go/types"
+	"strconv"
+
+	"github.com/aykevl/go-llvm"
+)
+
+// Kinds as used in the reflect package.
+const (
+	reflectInvalid = iota
+	reflectBool
+	reflectInt
+	reflectInt8
+	reflectInt16
+	reflectInt32
+	reflectInt64
+	reflectUint
+	reflectUint8
+	reflectUint16
+	reflectUint32
+	reflectUint64
+	reflectUintptr
+	reflectFloat32
+	reflectFloat64
+	reflectComplex64
+	reflectComplex128
+	reflectArray
+	reflectChan
+	reflectFunc
+	reflectInterface
+	reflectMap
+	reflectPtr
+	reflectSlice
+	reflectString
+	reflectStruct
+	reflectUnsafePointer
+)
+
+var basicReflectKinds = map[types.BasicKind]int{
+	types.Bool:          reflectBool,
+	types.Int:           reflectInt,
+	types.Int8:          reflectInt8,
+	types.Int16:         reflectInt16,
+	types.Int32:         reflectInt32,
+	types.Int64:         reflectInt64,
+	types.Uint:          reflectUint,
+	types.Uint8:         reflectUint8,
+	types.Uint16:        reflectUint16,
+	types.Uint32:        reflectUint32,
+	types.Uint64:        reflectUint64,
+	types.Uintptr:       reflectUintptr,
+	types.Float32:       reflectFloat32,
+	types.Float64:       reflectFloat64,
+	types.Complex64:     reflectComplex64,
+	types.Complex128:    reflectComplex128,
+	types.String:        reflectString,
+	types.UnsafePointer: reflectUnsafePointer,
+}
+
+// createTypeDescriptors fills in the type descriptors of the reflect package,
+// with one descriptor for every type that has a typecode. Nothing is emitted
+// when the reflect package is not used.
+func (c *Compiler) createTypeDescriptors() error {
+	if c.mod.NamedGlobal("reflect.typeDescriptors").IsNil() {
+		return nil
+	}
+	descriptorType := c.mod.GetTypeByName("reflect.typeDescriptor")
+	fieldType := c.mod.GetTypeByName("reflect.structField")
+	descriptorTypes := descriptorType.StructElementTypes()
+	fieldTypes := fieldType.StructElementTypes()
+
+	allTypes := c.ir.AllTypes()
+	if err := c.checkTypecodeWidth(len(allTypes)-1, "interface typecodes"); err != nil {
+		return err
+	}
+	descriptors := make([]llvm.Value, len(allTypes))
+	var fields []llvm.Value
+	for i, typ := range allTypes {
+		kind := reflectInvalid
+		var elem, key types.Type
+		length := uint64(0)
+		size := uint64(0)
+		firstField := len(fields)
+		name := ""
+		if typ != nil {
+			name = types.TypeString(typ, packageName)
+			llvmType, err := c.getLLVMType(typ)
+			if err != nil {
+				return err
+			}
+			size = c.targetData.TypeAllocSize(llvmType)
+			switch typ := typ.Underlying().(type) {
+			case *types.Basic:
+				kind = basicReflectKinds[typ.Kind()]
+			case *types.Array:
+				kind = reflectArray
+				elem = typ.Elem()
+				key = types.NewSlice(typ.Elem())
+				length = uint64(typ.Len())
+			case *types.Chan:
+				kind = reflectChan
+				elem = typ.Elem()
+			case *types.Signature:
+				kind = reflectFunc
+			case *types.Interface:
+				kind = reflectInterface
+			case *types.Map:
+				kind = reflectMap
+				elem = typ.Elem()
+				key = typ.Key()
+			case *types.Pointer:
+				kind = reflectPtr
+				elem = typ.Elem()
+			case *types.Slice:
+				kind = reflectSlice
+				elem = typ.Elem()
+			case *types.Struct:
+				kind = reflectStruct
+				length = uint64(typ.NumFields())
+				for j := 0; j < typ.NumFields(); j++ {
+					field := typ.Field(j)
+					name := c.createConstString("reflect.structField."+strconv.Itoa(len(fields)), field.Name())
+					offset := c.targetData.ElementOffset(llvmType, j)
+					fields = append(fields, llvm.ConstNamedStruct(fieldType, []llvm.Value{
+						name,
+						llvm.ConstInt(fieldTypes[1], uint64(c.getTypeNum(field.Type())), false),
+						llvm.ConstInt(fieldTypes[2], offset, false),
+					}))
+				}
+			default:
+				return errors.New("todo: type descriptor for " + typ.String())
+			}
+		}
+		descriptors[i] = llvm.ConstNamedStruct(descriptorType, []llvm.Value{
+			llvm.ConstInt(descriptorTypes[0], uint64(kind), false),
+			llvm.ConstInt(descriptorTypes[1], uint64(c.getTypeNum(elem)), false),
+			llvm.ConstInt(descriptorTypes[2], uint64(c.getTypeNum(key)), false),
+			llvm.ConstInt(descriptorTypes[3], uint64(firstField), false),
+			llvm.ConstInt(descriptorTypes[4], length, false),
+			llvm.ConstInt(descriptorTypes[5], size, false),
+			c.createConstString("reflect.typeDescriptor.name."+strconv.Itoa(i), name),
+		})
+	}
+	if len(fields) >= 1<<16 {
+		return errors.New("struct fields do not fit in a 16-bit integer")
+	}
+
+	c.replaceGlobalArray("reflect.typeDescriptors", llvm.ConstArray(descriptorType, descriptors))
+	c.replaceGlobalArray("reflect.structFields", llvm.ConstArray(fieldType, fields))
+	return nil
+}
+
+// packageName qualifies type names with the package name instead of the full
+// import path, like gc does: a type T in package foo/bar is called bar.T.
+func packageName(pkg *types.Package) string {
+	return pkg.Name()
+}
+
+// getTypeNum returns the typecode of the given type, or 0 (the typecode of
+// nil) if there is no type.
+func (c *Compiler) getTypeNum(typ types.Type) int {
+	if typ == nil {
+		return 0
+	}
+	num, _ := c.ir.TypeNum(typ)
+	return num
+}
+
+// createConstString creates a constant string global and returns it as a
+// runtime._string value.
+func (c *Compiler) createConstString(name, str string) llvm.Value {
+	global := llvm.AddGlobal(c.mod, llvm.ArrayType(c.ctx.Int8Type(), len(str)), name)
+	global.SetInitializer(c.ctx.ConstString(str, false))
+	global.SetLinkage(llvm.InternalLinkage)
+	global.SetGlobalConstant(true)
+	global.SetUnnamedAddr(true)
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	strPtr := llvm.ConstInBoundsGEP(global, []llvm.Value{zero, zero})
+	strLen := llvm.ConstInt(c.lenType, uint64(len(str)), false)
+	return llvm.ConstNamedStruct(c.mod.GetTypeByName("runtime._string"), []llvm.Value{strPtr, strLen})
+}
+
+// replaceGlobalArray replaces a zero-length array global, which is declared in
+// Go source code as a placeholder, with a constant global containing the given
+// array.
+func (c *Compiler) replaceGlobalArray(name string, array llvm.Value) {
+	oldGlobal := c.mod.NamedGlobal(name)
+	newGlobal := llvm.AddGlobal(c.mod, array.Type(), name+".tmp")
+	newGlobal.SetInitializer(array)
+	newGlobal.SetLinkage(llvm.InternalLinkage)
+	newGlobal.SetGlobalConstant(true)
+	oldGlobal.ReplaceAllUsesWith(llvm.ConstBitCast(newGlobal, oldGlobal.Type()))
+	oldGlobal.EraseFromParentAsGlobal()
+	newGlobal.SetName(name)
+}
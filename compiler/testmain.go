@@ -0,0 +1,171 @@
+package compiler
+
+// This file generates the main package of a test binary. It imports the
+// package under test (including its _test.go files) and its external test
+// package, and runs all test functions it finds in them.
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// A test function, as found in a _test.go file.
+type testFunc struct {
+	pkg  string // local name of the package in the generated main package
+	name string
+}
+
+// loadTestPackage configures the loader to load the package with the given
+// path together with its tests, and creates a main package that runs these
+// tests.
+func (c *Compiler) loadTestPackage(config *loader.Config, pkgPath string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	bp, err := config.Build.Import(pkgPath, cwd, 0)
+	if err != nil {
+		return err
+	}
+
+	// Find all test functions.
+	var tests []testFunc
+	for _, filename := range bp.TestGoFiles {
+		names, err := findTests(filepath.Join(bp.Dir, filename))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tests = append(tests, testFunc{"_test", name})
+		}
+	}
+	numTests := len(tests)
+	for _, filename := range bp.XTestGoFiles {
+		names, err := findTests(filepath.Join(bp.Dir, filename))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tests = append(tests, testFunc{"_xtest", name})
+		}
+	}
+
+	// The loader would create the external test package as a package that
+	// cannot be imported, so present it as a regular package instead.
+	pkg := *bp
+	pkg.XTestGoFiles = nil
+	xtest := *bp
+	xtest.ImportPath += "_test"
+	xtest.Name += "_test"
+	xtest.GoFiles = bp.XTestGoFiles
+	xtest.CgoFiles = nil
+	xtest.TestGoFiles = nil
+	xtest.XTestGoFiles = nil
+	config.FindPackage = func(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+		switch importPath {
+		case pkg.ImportPath:
+			return &pkg, nil
+		case xtest.ImportPath:
+			return &xtest, nil
+		default:
+			return ctxt.Import(importPath, fromDir, mode)
+		}
+	}
+	config.ImportWithTests(pkg.ImportPath)
+
+	// Generate the main package.
+	buf := &bytes.Buffer{}
+	buf.WriteString("package main\n\nimport (\n\t\"os\"\n\t\"testing\"\n\n")
+	if numTests != 0 {
+		fmt.Fprintf(buf, "\t_test %s\n", strconv.Quote(pkg.ImportPath))
+	} else {
+		fmt.Fprintf(buf, "\t_ %s\n", strconv.Quote(pkg.ImportPath))
+	}
+	if len(tests) != numTests {
+		fmt.Fprintf(buf, "\t_xtest %s\n", strconv.Quote(xtest.ImportPath))
+	} else if len(xtest.GoFiles) != 0 {
+		fmt.Fprintf(buf, "\t_ %s\n", strconv.Quote(xtest.ImportPath))
+	}
+	buf.WriteString(")\n\nvar tests = []testing.InternalTest{\n")
+	for _, test := range tests {
+		fmt.Fprintf(buf, "\t{%s, %s.%s},\n", strconv.Quote(test.name), test.pkg, test.name)
+	}
+	buf.WriteString("}\n\nfunc main() {\n\tos.Exit(testing.MainStart(tests).Run())\n}\n")
+	if config.Fset == nil {
+		config.Fset = token.NewFileSet()
+	}
+	file, err := parser.ParseFile(config.Fset, filepath.Join(bp.Dir, "_testmain.go"), buf.Bytes(), 0)
+	if err != nil {
+		return errors.New("could not parse generated test main: " + err.Error())
+	}
+	config.CreateFromFiles("main", file)
+	return nil
+}
+
+// findTests returns the names of the test functions in the given file, in the
+// order in which they are declared.
+func findTests(filename string) ([]string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), filename, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, decl := range file.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Recv != nil || !isTestName(fn.Name.Name) {
+			continue
+		}
+		if isTestFunc(fn) {
+			names = append(names, fn.Name.Name)
+		}
+	}
+	return names, nil
+}
+
+// isTestName returns whether the name looks like the name of a test function,
+// like go test does: Test, followed by nothing or by a character that is not a
+// lower case letter.
+func isTestName(name string) bool {
+	if len(name) < 4 || name[:4] != "Test" {
+		return false
+	}
+	if len(name) == 4 {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[4:])
+	return !unicode.IsLower(r)
+}
+
+// isTestFunc returns whether the function has the signature of a test
+// function: func(t *testing.T).
+func isTestFunc(fn *ast.FuncDecl) bool {
+	if fn.Type.Results != nil && len(fn.Type.Results.List) != 0 {
+		return false
+	}
+	params := fn.Type.Params.List
+	if len(params) != 1 || len(params[0].Names) > 1 {
+		return false
+	}
+	ptr, ok := params[0].Type.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+	// Like go test, only check the type name and not the package name, as the
+	// testing package may have been imported under another name.
+	if sel, ok := ptr.X.(*ast.SelectorExpr); ok {
+		return sel.Sel.Name == "T"
+	}
+	return false
+}
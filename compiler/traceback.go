@@ -0,0 +1,173 @@
+package compiler
+
+// This file emits the call frames and the pc table that are used for stack
+// traces, runtime.Caller and runtime.FuncForPC. See src/runtime/stack.go for
+// how they are used at runtime.
+
+import (
+	"errors"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"github.com/aykevl/go-llvm"
+	"golang.org/x/tools/go/ssa"
+)
+
+// A source location in the pc table.
+type pcEntry struct {
+	fn   string
+	file string
+	line int
+}
+
+// hasCallFrame returns whether the function keeps a call frame on the call
+// stack. Functions in the runtime and wrappers generated by the compiler do not
+// show up in stack traces.
+func (c *Compiler) hasCallFrame(frame *Frame) bool {
+	if !c.Tracebacks || frame.fn.Pkg == nil || frame.fn.Pkg.Pkg.Path() == "runtime" {
+		return false
+	}
+	return frame.fn.Synthetic == "" || frame.fn.Synthetic == "package initializer"
+}
+
+// emitCallFramePush creates the call frame of this function and pushes it on
+// the call stack of the running goroutine.
+func (c *Compiler) emitCallFramePush(frame *Frame) {
+	callStack := c.mod.NamedGlobal("runtime.callStack")
+	frameType := c.mod.GetTypeByName("runtime.callFrame")
+	frame.callFrame = c.createEntryBlockAlloca(frame, frameType, "callFrame")
+	parent := c.builder.CreateLoad(callStack, "callFrame.parent")
+	frameValue := c.builder.CreateInsertValue(llvm.ConstNull(frameType), parent, 0, "")
+	c.builder.CreateStore(frameValue, frame.callFrame)
+	c.builder.CreateStore(frame.callFrame, callStack)
+}
+
+// emitCallFramePop removes the call frame of this function from the call stack,
+// just before it returns.
+func (c *Compiler) emitCallFramePop(frame *Frame) {
+	if frame.callFrame.IsNil() {
+		return
+	}
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	parentPtr := c.builder.CreateInBoundsGEP(frame.callFrame, []llvm.Value{zero, zero}, "callFrame.parent.ptr")
+	parent := c.builder.CreateLoad(parentPtr, "callFrame.parent")
+	c.builder.CreateStore(parent, c.mod.NamedGlobal("runtime.callStack"))
+}
+
+// emitCallFrameRestore makes the call frame of this function the top of the
+// call stack again, when a coroutine is resumed or after starting a goroutine.
+func (c *Compiler) emitCallFrameRestore(frame *Frame) {
+	if frame.callFrame.IsNil() {
+		return
+	}
+	c.builder.CreateStore(frame.callFrame, c.mod.NamedGlobal("runtime.callStack"))
+}
+
+// emitCallFramePC stores the source location of the instruction in the call
+// frame. This is only done for the first instruction of a basic block and
+// when the line changes, as the location only matters for calls.
+func (c *Compiler) emitCallFramePC(frame *Frame, instr ssa.Instruction) {
+	if frame.callFrame.IsNil() {
+		return
+	}
+	switch instr.(type) {
+	case *ssa.Phi:
+		return // must be at the start of the block
+	case *ssa.Jump, *ssa.If:
+		return // never calls anything
+	}
+	pos := c.ir.Program.Fset.Position(instr.Pos())
+	if !pos.IsValid() {
+		return
+	}
+	if frame.pcBlock == instr.Block() && frame.pcPos.Line == pos.Line && frame.pcPos.Filename == pos.Filename {
+		return
+	}
+	frame.pcBlock = instr.Block()
+	frame.pcPos = pos
+	pc := c.getPC(pcFuncName(frame.fn.Function), pos)
+	one := llvm.ConstInt(c.ctx.Int32Type(), 1, false)
+	zero := llvm.ConstInt(c.ctx.Int32Type(), 0, false)
+	pcPtr := c.builder.CreateInBoundsGEP(frame.callFrame, []llvm.Value{zero, one}, "callFrame.pc.ptr")
+	c.builder.CreateStore(pc, pcPtr)
+}
+
+// getPC returns the pc of the given source location in the pc table. A pc is an
+// index into the table, plus one. The table is only created when all packages
+// are linked, so a placeholder is returned instead.
+func (c *Compiler) getPC(fn string, pos token.Position) llvm.Value {
+	return c.getPlaceholder("tinygo.pc:"+fn+" "+pos.Filename+":"+strconv.Itoa(pos.Line), c.uintptrType)
+}
+
+// addPCEntry adds the source location of a pc placeholder (the part after
+// "tinygo.pc:") to the pc table and returns its pc.
+func (c *Compiler) addPCEntry(location string) (int, error) {
+	space := strings.IndexByte(location, ' ')
+	colon := strings.LastIndexByte(location, ':')
+	if space < 0 || colon < space {
+		return 0, errors.New("invalid pc placeholder: " + location)
+	}
+	line, err := strconv.Atoi(location[colon+1:])
+	if err != nil {
+		return 0, errors.New("invalid pc placeholder: " + location)
+	}
+	c.pcEntries = append(c.pcEntries, pcEntry{location[:space], location[space+1 : colon], line})
+	return len(c.pcEntries), nil
+}
+
+// pcFuncName returns the name of a function as gc reports it in stack traces,
+// like main.(*T).String or main.main.func1.
+func pcFuncName(fn *ssa.Function) string {
+	if parent := fn.Parent(); parent != nil {
+		// Anonymous function, with a name like main$1.
+		return pcFuncName(parent) + ".func" + fn.Name()[strings.LastIndexByte(fn.Name(), '$')+1:]
+	}
+	name := fn.Name()
+	if recv := fn.Signature.Recv(); recv != nil {
+		switch typ := recv.Type().(type) {
+		case *types.Pointer:
+			name = "(*" + typ.Elem().(*types.Named).Obj().Name() + ")." + name
+		case *types.Named:
+			name = typ.Obj().Name() + "." + name
+		}
+	}
+	return fn.Pkg.Pkg.Path() + "." + name
+}
+
+// createPCTable fills in the pc table of the runtime, with the source location
+// of each pc that was emitted.
+func (c *Compiler) createPCTable() {
+	if !c.Tracebacks {
+		return
+	}
+	funcType := c.mod.GetTypeByName("runtime.Func")
+	funcs := make(map[string]int)
+	files := make(map[string]int)
+	var funcNames, fileNames, entries []llvm.Value
+	for _, entry := range c.pcEntries {
+		fn, ok := funcs[entry.fn]
+		if !ok {
+			fn = len(funcNames)
+			funcs[entry.fn] = fn
+			funcNames = append(funcNames, c.createConstString("runtime.funcNames."+entry.fn, entry.fn))
+		}
+		file, ok := files[entry.file]
+		if !ok {
+			file = len(fileNames)
+			files[entry.file] = file
+			fileNames = append(fileNames, c.createConstString("runtime.fileNames."+entry.file, entry.file))
+		}
+		entries = append(entries, llvm.ConstNamedStruct(funcType, []llvm.Value{
+			llvm.ConstInt(c.ctx.Int32Type(), uint64(fn), false),
+			llvm.ConstInt(c.ctx.Int32Type(), uint64(file), false),
+			llvm.ConstInt(c.ctx.Int32Type(), uint64(entry.line), false),
+		}))
+	}
+	stringType := c.mod.GetTypeByName("runtime._string")
+	c.replaceGlobalArray("runtime.pcTable", llvm.ConstArray(funcType, entries))
+	c.replaceGlobalArray("runtime.funcNames", llvm.ConstArray(stringType, funcNames))
+	c.replaceGlobalArray("runtime.fileNames", llvm.ConstArray(stringType, fileNames))
+	c.mod.NamedGlobal("runtime.pcTableLen").SetInitializer(llvm.ConstInt(c.uintptrType, uint64(len(entries)), false))
+}
@@ -113,7 +113,8 @@ func (p *Program) interpret(instrs []ssa.Instruction, paramKeys []*ssa.Parameter
 				continue
 			}
 			if callee.String() == "os.NewFile" {
-				// Emulate the creation of os.Stdin, os.Stdout and os.Stderr.
+				// Emulate the creation of os.Stdin, os.Stdout and os.Stderr,
+				// like newFile in the os package does.
 				resultPtrType := callee.Signature.Results().At(0).Type().(*types.Pointer)
 				resultStructOuterType := resultPtrType.Elem().Underlying().(*types.Struct)
 				if resultStructOuterType.NumFields() != 1 {
@@ -126,6 +127,14 @@ func (p *Program) interpret(instrs []ssa.Instruction, paramKeys []*ssa.Parameter
 				if err != nil {
 					return i, err
 				}
+				sysfd, err := p.getValue(common.Args[0], locals)
+				if err != nil {
+					return i, err
+				}
+				fd, ok := constant.Uint64Val(sysfd.(*ConstValue).Expr.Value)
+				if !ok {
+					panic("expected a constant file descriptor in os.NewFile")
+				}
 				for fieldIndex := 0; fieldIndex < fileInnerStructType.NumFields(); fieldIndex++ {
 					field := fileInnerStructType.Field(fieldIndex)
 					if field.Name() == "name" {
@@ -135,19 +144,26 @@ func (p *Program) interpret(instrs []ssa.Instruction, paramKeys []*ssa.Parameter
 							return i, err
 						}
 						fileInner.(*StructValue).Fields[fieldIndex] = name
+					} else if field.Name() == "stdoutOrErr" {
+						// Writes to these files raise SIGPIPE on a broken pipe.
+						stdoutOrErr := constant.MakeBool(fd == 1 || fd == 2)
+						fileInner.(*StructValue).Fields[fieldIndex] = &ConstValue{Expr: ssa.NewConst(stdoutOrErr, field.Type())}
 					} else if field.Type().String() == "internal/poll.FD" {
-						// Set the file descriptor field.
+						// Set the file descriptor field and mark it as a
+						// stream.
 						field := field.Type().Underlying().(*types.Struct)
 						for subfieldIndex := 0; subfieldIndex < field.NumFields(); subfieldIndex++ {
 							subfield := field.Field(subfieldIndex)
-							if subfield.Name() == "Sysfd" {
-								sysfd, err := p.getValue(common.Args[0], locals)
-								if err != nil {
-									return i, err
-								}
-								sysfd = &ConstValue{Expr: ssa.NewConst(sysfd.(*ConstValue).Expr.Value, subfield.Type())}
-								fileInner.(*StructValue).Fields[fieldIndex].(*StructValue).Fields[subfieldIndex] = sysfd
+							var value constant.Value
+							switch subfield.Name() {
+							case "Sysfd":
+								value = constant.MakeUint64(fd)
+							case "IsStream", "ZeroReadIsEOF":
+								value = constant.MakeBool(true)
+							default:
+								continue
 							}
+							fileInner.(*StructValue).Fields[fieldIndex].(*StructValue).Fields[subfieldIndex] = &ConstValue{Expr: ssa.NewConst(value, subfield.Type())}
 						}
 					}
 				}
@@ -279,7 +295,11 @@ func (p *Program) interpret(instrs []ssa.Instruction, paramKeys []*ssa.Parameter
 				return i, errors.New("todo: init IndexAddr index: " + instr.Index.String())
 			}
 		case *ssa.MakeInterface:
-			locals[instr] = &InterfaceValue{instr.X.Type(), locals[instr.X]}
+			x, err := p.getValue(instr.X, locals)
+			if err != nil {
+				return i, err
+			}
+			locals[instr] = &InterfaceValue{instr.X.Type(), x}
 		case *ssa.MakeMap:
 			locals[instr] = &MapValue{instr.Type().Underlying().(*types.Map), nil, nil}
 		case *ssa.MapUpdate:
@@ -306,21 +326,47 @@ func (p *Program) interpret(instrs []ssa.Instruction, paramKeys []*ssa.Parameter
 				results[i] = val
 			}
 		case *ssa.Slice:
-			// Turn a just-allocated array into a slice.
-			if instr.Low != nil || instr.High != nil || instr.Max != nil {
-				return i, errors.New("init: slice expression with bounds")
-			}
 			source, err := p.getValue(instr.X, locals)
 			if err != nil {
 				return i, err
 			}
+			var slice SliceValue
 			switch source := source.(type) {
+			case *ConstValue: // string
+				str := constant.StringVal(source.Expr.Value)
+				low, high, _, ok := p.getSliceIndices(instr, locals, uint64(len(str)), uint64(len(str)))
+				if !ok {
+					return i, nil
+				}
+				locals[instr] = &ConstValue{ssa.NewConst(constant.MakeString(str[low:high]), instr.Type())}
+				continue
+			case *GlobalValue: // pointer to global array
+				array, ok := source.Global.initializer.(*ArrayValue)
+				if !ok {
+					return i, errors.New("init: unknown slice type")
+				}
+				slice = SliceValue{Array: array, Global: source.Global, Len: uint64(len(array.Elems)), Cap: uint64(len(array.Elems))}
 			case *PointerValue: // pointer to array
+				if source.Elem == nil {
+					return i, nil // nil pointer, leave the panic to the runtime
+				}
 				array := (*source.Elem).(*ArrayValue)
-				locals[instr] = &SliceValue{instr.Type().Underlying().(*types.Slice), array}
+				slice = SliceValue{Array: array, Len: uint64(len(array.Elems)), Cap: uint64(len(array.Elems))}
+			case *SliceValue:
+				slice = *source
 			default:
-				return i, errors.New("init: unknown slice type")
+				// For example a string that isn't a constant.
+				return i, nil
+			}
+			low, high, max, ok := p.getSliceIndices(instr, locals, slice.Len, slice.Cap)
+			if !ok {
+				return i, nil
 			}
+			slice.Type = instr.Type().Underlying().(*types.Slice)
+			slice.Offset += low
+			slice.Len = high - low
+			slice.Cap = max - low
+			locals[instr] = &slice
 		case *ssa.Store:
 			if addr, ok := instr.Addr.(*ssa.Global); ok {
 				if strings.HasPrefix(instr.Addr.Name(), "__cgofn__cgo_") || strings.HasPrefix(instr.Addr.Name(), "_cgo_") {
@@ -393,6 +439,13 @@ func canInterpret(callee *ssa.Function) bool {
 		case *ssa.MapUpdate:
 		case *ssa.Return:
 		case *ssa.Slice:
+			// Only slice expressions with constant indices are supported.
+			instr := instr.(*ssa.Slice)
+			for _, index := range []ssa.Value{instr.Low, instr.High, instr.Max} {
+				if _, ok := index.(*ssa.Const); index != nil && !ok {
+					return false
+				}
+			}
 		case *ssa.Store:
 		case *ssa.UnOp:
 		default:
@@ -431,6 +484,38 @@ func (p *Program) getValue(value ssa.Value, locals map[ssa.Value]Value) (Value,
 	}
 }
 
+// Return the indices of a slice expression, given the length and capacity of
+// the value that is sliced. It returns false when the indices are not constant
+// or out of range.
+func (p *Program) getSliceIndices(instr *ssa.Slice, locals map[ssa.Value]Value, length, capacity uint64) (low, high, max uint64, ok bool) {
+	low, high, max = 0, length, capacity
+	for _, index := range []struct {
+		value ssa.Value
+		ptr   *uint64
+	}{{instr.Low, &low}, {instr.High, &high}, {instr.Max, &max}} {
+		if index.value == nil {
+			continue
+		}
+		value, err := p.getValue(index.value, locals)
+		if err != nil {
+			return 0, 0, 0, false
+		}
+		cnst, isConst := value.(*ConstValue)
+		if !isConst {
+			return 0, 0, 0, false
+		}
+		n, exact := constant.Uint64Val(cnst.Expr.Value)
+		if !exact {
+			return 0, 0, 0, false
+		}
+		*index.ptr = n
+	}
+	if !(low <= high && high <= max && max <= capacity) {
+		return 0, 0, 0, false
+	}
+	return low, high, max, true
+}
+
 func (p *Program) getZeroValue(t types.Type) (Value, error) {
 	switch typ := t.Underlying().(type) {
 	case *types.Array:
@@ -464,7 +549,7 @@ func (p *Program) getZeroValue(t types.Type) (Value, error) {
 		}
 		return &StructValue{t, elems}, nil
 	case *types.Slice:
-		return &SliceValue{typ, nil}, nil
+		return &SliceValue{Type: typ}, nil
 	default:
 		return nil, errors.New("todo: init: unknown global type: " + typ.String())
 	}
@@ -521,8 +606,12 @@ type StructValue struct {
 }
 
 type SliceValue struct {
-	Type  *types.Slice
-	Array *ArrayValue
+	Type   *types.Slice
+	Array  *ArrayValue // backing array, or nil for a nil slice
+	Global *Global     // global that stores the backing array, if any
+	Offset uint64      // index of the first element in the backing array
+	Len    uint64
+	Cap    uint64
 }
 
 type MapValue struct {
@@ -21,6 +21,7 @@ import (
 type Program struct {
 	Program              *ssa.Program
 	mainPkg              *ssa.Package
+	Packages             []*ssa.Package // in import order
 	Functions            []*Function
 	functionMap          map[*ssa.Function]*Function
 	Globals              []*Global
@@ -28,12 +29,19 @@ type Program struct {
 	comments             map[string]*ast.CommentGroup
 	NamedTypes           []*NamedType
 	needsScheduler       bool
+	usesRecover          bool
+	usesDefer            bool
 	goCalls              []*ssa.Go
 	typesWithMethods     map[string]*TypeWithMethods // see AnalyseInterfaceConversions
 	typesWithoutMethods  map[string]int              // see AnalyseInterfaceConversions
+	typeList             []types.Type                // types without methods, indexed by type number
 	methodSignatureNames map[string]int              // see MethodNum
 	interfaces           map[string]*Interface       // see AnalyseInterfaceConversions
 	fpWithContext        map[string]struct{}         // see AnalyseFunctionPointers
+	fpCallers            map[string][]*Function      // see AnalyseCallgraph
+	invokeCallers        map[string][]*Function      // see AnalyseCallgraph
+	blockingSignatures   map[string]struct{}         // see AnalyseBlockingRecursive
+	blockingMethods      map[string]struct{}         // see AnalyseBlockingRecursive
 }
 
 // Function or method.
@@ -44,9 +52,11 @@ type Function struct {
 	exported     bool        // go:export
 	nobounds     bool        // go:nobounds
 	blocking     bool        // calculated by AnalyseBlockingRecursive
+	recovers     bool        // calls recover(), calculated by AnalyseCallgraph
 	flag         bool        // used by dead code elimination
 	interrupt    bool        // go:interrupt
 	addressTaken bool        // used as function pointer, calculated by AnalyseFunctionPointers
+	dynamic      bool        // may be called through a function pointer, calculated by AnalyseFunctionPointers
 	parents      []*Function // calculated by AnalyseCallgraph
 	children     []*Function // calculated by AnalyseCallgraph
 }
@@ -116,11 +126,12 @@ func NewProgram(lprogram *loader.Program, mainPath string) *Program {
 	program.Build()
 
 	// Find the main package, which is a bit difficult when running a .go file
-	// directly.
+	// directly or when it is the generated main package of a test (which may
+	// test another package named main).
 	mainPkg := program.ImportedPackage(mainPath)
 	if mainPkg == nil {
 		for _, pkgInfo := range program.AllPackages() {
-			if pkgInfo.Pkg.Name() == "main" {
+			if pkgInfo.Pkg.Path() == "main" {
 				if mainPkg != nil {
 					panic("more than one main package found")
 				}
@@ -181,6 +192,7 @@ func NewProgram(lprogram *loader.Program, mainPath string) *Program {
 	p := &Program{
 		Program:              program,
 		mainPkg:              mainPkg,
+		Packages:             packageList,
 		functionMap:          make(map[*ssa.Function]*Function),
 		globalMap:            make(map[*ssa.Global]*Global),
 		methodSignatureNames: make(map[string]int),
@@ -1,6 +1,7 @@
 package ir
 
 import (
+	"go/token"
 	"go/types"
 	"sort"
 	"strings"
@@ -71,38 +72,77 @@ func InterfaceKey(itf *types.Interface) string {
 	return strings.Join(methodStrings, ";")
 }
 
-// Fill in parents of all functions.
+// Fill in parents of all functions. Also mark functions as blocking when they
+// directly contain a blocking operation (a call to time.Sleep, a channel
+// send/receive, a select without default case or acquiring a semaphore of the
+// sync package), and check whether defer and recover() are used anywhere. Calls through
+// function pointers and interfaces are recorded by signature, to be resolved by
+// AnalyseBlockingRecursive.
 //
 // All packages need to be added before this pass can run, or it will produce
 // incorrect results.
 func (p *Program) AnalyseCallgraph() {
+	p.usesRecover = false
+	p.usesDefer = false
+	p.fpCallers = map[string][]*Function{}
+	p.invokeCallers = map[string][]*Function{}
 	for _, f := range p.Functions {
 		// Clear, if AnalyseCallgraph has been called before.
 		f.children = nil
 		f.parents = nil
+		f.recovers = false
 
 		for _, block := range f.Blocks {
 			for _, instr := range block.Instrs {
 				switch instr := instr.(type) {
 				case *ssa.Call:
-					if instr.Common().IsInvoke() {
+					call := instr.Common()
+					if call.IsInvoke() {
+						name := MethodSignature(call.Method)
+						p.invokeCallers[name] = append(p.invokeCallers[name], f)
 						continue
 					}
-					switch call := instr.Call.Value.(type) {
-					case *ssa.Builtin:
-						// ignore
-					case *ssa.Function:
-						if isCGoInternal(call.Name()) {
-							continue
-						}
-						child := p.GetFunction(call)
-						if child.CName() != "" {
-							continue // assume non-blocking
-						}
-						if child.RelString(nil) == "time.Sleep" {
-							f.blocking = true
+					if builtin, ok := call.Value.(*ssa.Builtin); ok {
+						if builtin.Name() == "recover" {
+							p.usesRecover = true
+							f.recovers = true
 						}
-						f.children = append(f.children, child)
+						continue
+					}
+					callee := call.StaticCallee()
+					if callee == nil {
+						// Call through a function pointer.
+						sig := Signature(call.Signature())
+						p.fpCallers[sig] = append(p.fpCallers[sig], f)
+						continue
+					}
+					if isCGoInternal(callee.Name()) {
+						continue
+					}
+					child := p.GetFunction(callee)
+					if child.CName() != "" {
+						continue // assume non-blocking
+					}
+					switch child.RelString(nil) {
+					case "time.Sleep", "sync.runtime_Semacquire":
+						f.blocking = true
+					}
+					f.children = append(f.children, child)
+				case *ssa.Defer:
+					// Panics are never unwound through the runtime, so its
+					// deferred calls don't need unwinding.
+					if f.Pkg == nil || f.Pkg.Pkg.Path() != "runtime" {
+						p.usesDefer = true
+					}
+				case *ssa.Send:
+					f.blocking = true
+				case *ssa.UnOp:
+					if instr.Op == token.ARROW {
+						f.blocking = true
+					}
+				case *ssa.Select:
+					if instr.Blocking {
+						f.blocking = true
 					}
 				}
 			}
@@ -116,11 +156,22 @@ func (p *Program) AnalyseCallgraph() {
 }
 
 // Find all types that are put in an interface.
+//
+// Types that are part of these types (such as the element type of a pointer or
+// the types of struct fields) also get a type number, so that they can be
+// described in the runtime type information used by the reflect package. They
+// don't get a method set, as values of these types are never put in an
+// interface by the program itself.
+//
+// Interface types that are used in type asserts get an interface number.
 func (p *Program) AnalyseInterfaceConversions() {
 	// Clear, if AnalyseTypes has been called before.
 	p.typesWithoutMethods = map[string]int{"nil": 0}
 	p.typesWithMethods = map[string]*TypeWithMethods{}
+	p.typeList = []types.Type{nil}
+	p.interfaces = map[string]*Interface{}
 
+	var mapKeyTypes []types.Type
 	for _, f := range p.Functions {
 		for _, block := range f.Blocks {
 			for _, instr := range block.Instrs {
@@ -141,11 +192,74 @@ func (p *Program) AnalyseInterfaceConversions() {
 						p.typesWithMethods[name] = t
 					} else if _, ok := p.typesWithoutMethods[name]; !ok && len(methods) == 0 {
 						p.typesWithoutMethods[name] = len(p.typesWithoutMethods)
+						p.typeList = append(p.typeList, instr.X.Type())
 					}
+				case *ssa.TypeAssert:
+					// Type asserts on interface types need an interface
+					// number.
+					if itf, ok := instr.AssertedType.Underlying().(*types.Interface); ok {
+						p.InterfaceNum(itf)
+					}
+				case *ssa.MakeMap:
+					// The runtime uses the type descriptor of the key type
+					// for keys that can't be hashed bytewise.
+					mapKeyTypes = append(mapKeyTypes, instr.Type().Underlying().(*types.Map).Key())
 				}
 			}
 		}
 	}
+	for _, t := range mapKeyTypes {
+		p.addType(t)
+	}
+
+	// Add all types that are referenced by the types found above.
+	for _, t := range p.typeList[1:] {
+		p.addReferencedTypes(t)
+	}
+	for _, t := range p.AllDynamicTypes() {
+		p.addReferencedTypes(t.t)
+	}
+}
+
+// addReferencedTypes gives all types that are part of the given type (element
+// types, field types, etc.) a type number, recursively.
+func (p *Program) addReferencedTypes(t types.Type) {
+	var referenced []types.Type
+	switch t := t.Underlying().(type) {
+	case *types.Array:
+		// The slice type is needed to slice an array with reflect.
+		referenced = append(referenced, t.Elem(), types.NewSlice(t.Elem()))
+	case *types.Chan:
+		referenced = append(referenced, t.Elem())
+	case *types.Map:
+		referenced = append(referenced, t.Key(), t.Elem())
+	case *types.Pointer:
+		referenced = append(referenced, t.Elem())
+	case *types.Slice:
+		referenced = append(referenced, t.Elem())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			referenced = append(referenced, t.Field(i).Type())
+		}
+	}
+	for _, ref := range referenced {
+		p.addType(ref)
+	}
+}
+
+// addType gives the type and all types it references a type number, if it
+// doesn't have one already.
+func (p *Program) addType(t types.Type) {
+	name := t.String()
+	if _, ok := p.typesWithMethods[name]; ok {
+		return
+	}
+	if _, ok := p.typesWithoutMethods[name]; ok {
+		return
+	}
+	p.typesWithoutMethods[name] = len(p.typesWithoutMethods)
+	p.typeList = append(p.typeList, t)
+	p.addReferencedTypes(t)
 }
 
 // Analyse which function pointer signatures need a context parameter.
@@ -164,6 +278,7 @@ func (p *Program) AnalyseFunctionPointers() {
 						case *ssa.Function:
 							f := p.GetFunction(arg)
 							f.addressTaken = true
+							f.dynamic = true
 						}
 					}
 				case *ssa.DebugRef:
@@ -177,6 +292,9 @@ func (p *Program) AnalyseFunctionPointers() {
 						case *ssa.Function:
 							f := p.GetFunction(operand)
 							f.addressTaken = true
+							if _, ok := instr.(*ssa.MakeClosure); !ok {
+								f.dynamic = true
+							}
 						}
 					}
 				}
@@ -185,17 +303,82 @@ func (p *Program) AnalyseFunctionPointers() {
 					fn := instr.Fn.(*ssa.Function)
 					sig := Signature(fn.Signature)
 					p.fpWithContext[sig] = struct{}{}
+					if closureEscapes(instr) {
+						p.GetFunction(fn).dynamic = true
+					}
+				}
+			}
+		}
+	}
+}
+
+// closureEscapes returns whether the closure is used as a value, instead of
+// only being called directly (in a call, go or defer instruction).
+func closureEscapes(closure *ssa.MakeClosure) bool {
+	for _, ref := range *closure.Referrers() {
+		switch ref := ref.(type) {
+		case *ssa.DebugRef:
+		case ssa.CallInstruction:
+			if ref.Common().Value != closure {
+				return true
+			}
+			for _, arg := range ref.Common().Args {
+				if arg == closure {
+					return true
 				}
 			}
+		default:
+			return true
 		}
 	}
+	return false
 }
 
 // Analyse which functions are recursively blocking.
 //
-// Depends on AnalyseCallgraph.
+// Calls through function pointers and interfaces are resolved by signature: if
+// any function that may be called through a function pointer with a given
+// signature is blocking, all of them are treated as blocking and so is every
+// call through a function pointer of that signature. The same is done for
+// interface methods, by method signature. This is necessary as they must all
+// use the same calling convention.
+//
+// Depends on AnalyseCallgraph, AnalyseInterfaceConversions and
+// AnalyseFunctionPointers.
 func (p *Program) AnalyseBlockingRecursive() {
+	p.blockingSignatures = map[string]struct{}{}
+	p.blockingMethods = map[string]struct{}{}
+
+	// Collect all functions that may be called dynamically.
+	fpTargets := map[string][]*Function{}
+	for _, f := range p.Functions {
+		if f.dynamic {
+			sig := Signature(f.Signature)
+			fpTargets[sig] = append(fpTargets[sig], f)
+		}
+	}
+	invokeTargets := map[string][]*Function{}
+	methodNames := map[*Function][]string{}
+	for _, t := range p.AllDynamicTypes() {
+		for name, sel := range t.Methods {
+			f := p.GetFunction(p.Program.MethodValue(sel))
+			if f == nil {
+				continue
+			}
+			invokeTargets[name] = append(invokeTargets[name], f)
+			methodNames[f] = append(methodNames[f], name)
+		}
+	}
+
 	worklist := make([]*Function, 0)
+	markBlocking := func(functions []*Function) {
+		for _, f := range functions {
+			if !f.blocking {
+				f.blocking = true
+				worklist = append(worklist, f)
+			}
+		}
+	}
 
 	// Fill worklist with directly blocking functions.
 	for _, f := range p.Functions {
@@ -212,10 +395,20 @@ func (p *Program) AnalyseBlockingRecursive() {
 		// Pick the topmost.
 		f := worklist[len(worklist)-1]
 		worklist = worklist[:len(worklist)-1]
-		for _, parent := range f.parents {
-			if !parent.blocking {
-				parent.blocking = true
-				worklist = append(worklist, parent)
+		markBlocking(f.parents)
+		if f.dynamic {
+			sig := Signature(f.Signature)
+			if _, ok := p.blockingSignatures[sig]; !ok {
+				p.blockingSignatures[sig] = struct{}{}
+				markBlocking(fpTargets[sig])
+				markBlocking(p.fpCallers[sig])
+			}
+		}
+		for _, name := range methodNames[f] {
+			if _, ok := p.blockingMethods[name]; !ok {
+				p.blockingMethods[name] = struct{}{}
+				markBlocking(invokeTargets[name])
+				markBlocking(p.invokeCallers[name])
 			}
 		}
 	}
@@ -239,14 +432,8 @@ func (p *Program) AnalyseGoCalls() {
 		}
 	}
 	for _, instr := range p.goCalls {
-		switch instr := instr.Call.Value.(type) {
-		case *ssa.Builtin:
-		case *ssa.Function:
-			if p.functionMap[instr].blocking {
-				p.needsScheduler = true
-			}
-		default:
-			panic("unknown go call function type")
+		if p.isBlockingCall(&instr.Call) {
+			p.needsScheduler = true
 		}
 	}
 }
@@ -338,6 +525,38 @@ func (p *Program) NeedsScheduler() bool {
 	return p.needsScheduler
 }
 
+// Whether panics need to unwind the stack, running deferred calls on the way.
+// This is only necessary when the program defers a call or calls recover(),
+// otherwise panics are simply fatal.
+//
+// Depends on AnalyseCallgraph.
+func (p *Program) NeedsUnwinding() bool {
+	return p.usesRecover || p.usesDefer
+}
+
+// Whether the reflect package is used outside the runtime, for example by fmt.
+// Type names can then be read with reflect.Type.String.
+func (p *Program) UsesReflect() bool {
+	for _, pkg := range p.Packages {
+		if path := pkg.Pkg.Path(); path == "runtime" || path == "reflect" {
+			continue
+		}
+		for _, imported := range pkg.Pkg.Imports() {
+			if imported.Path() == "reflect" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether this function calls recover() directly.
+//
+// Depends on AnalyseCallgraph.
+func (f *Function) CallsRecover() bool {
+	return f.recovers
+}
+
 // Whether this function blocks. Builtins are also accepted for convenience.
 // They will always be non-blocking.
 //
@@ -349,15 +568,83 @@ func (p *Program) IsBlocking(f *Function) bool {
 	return f.blocking
 }
 
+// IsBlockingCall returns whether this call (or go statement) calls a blocking
+// function, in which case it must be called as a coroutine. Builtins never
+// block.
+func (p *Program) IsBlockingCall(call *ssa.CallCommon) bool {
+	if !p.needsScheduler {
+		return false
+	}
+	return p.isBlockingCall(call)
+}
+
+func (p *Program) isBlockingCall(call *ssa.CallCommon) bool {
+	if call.IsInvoke() {
+		_, blocking := p.blockingMethods[MethodSignature(call.Method)]
+		return blocking
+	}
+	if _, ok := call.Value.(*ssa.Builtin); ok {
+		return false
+	}
+	if fn := call.StaticCallee(); fn != nil {
+		return p.GetFunction(fn).blocking
+	}
+	_, blocking := p.blockingSignatures[Signature(call.Signature())]
+	return blocking
+}
+
+// CallingConventionKey describes the analysis results that determine how
+// functions are called through function pointers and interface methods, and
+// whether the scheduler and stack unwinding are used. Code that was compiled
+// for one program can only be reused in another program with the same key.
+//
+// Depends on AnalyseBlockingRecursive and AnalyseGoCalls.
+func (p *Program) CallingConventionKey() string {
+	var lines []string
+	for sig := range p.fpWithContext {
+		lines = append(lines, "context "+sig)
+	}
+	for sig := range p.blockingSignatures {
+		lines = append(lines, "blocking "+sig)
+	}
+	for name := range p.blockingMethods {
+		lines = append(lines, "blocking method "+name)
+	}
+	sort.Strings(lines)
+	if p.needsScheduler {
+		lines = append(lines, "scheduler")
+	}
+	if p.NeedsUnwinding() {
+		lines = append(lines, "unwind")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// IsBlockingSignature returns whether calls through a function pointer with
+// this signature are blocking.
+func (p *Program) IsBlockingSignature(sig *types.Signature) bool {
+	if !p.needsScheduler {
+		return false
+	}
+	_, blocking := p.blockingSignatures[Signature(sig)]
+	return blocking
+}
+
 // Return the type number and whether this type is actually used. Used in
 // interface conversions (type is always used) and type asserts (type may not be
 // used, meaning assert is always false in this program).
 //
 // May only be used after all packages have been added to the analyser.
 func (p *Program) TypeNum(typ types.Type) (int, bool) {
-	if n, ok := p.typesWithoutMethods[typ.String()]; ok {
+	return p.TypeNumByName(typ.String())
+}
+
+// TypeNumByName is like TypeNum, but takes the name of the type as returned by
+// its String method.
+func (p *Program) TypeNumByName(name string) (int, bool) {
+	if n, ok := p.typesWithoutMethods[name]; ok {
 		return n, true
-	} else if meta, ok := p.typesWithMethods[typ.String()]; ok {
+	} else if meta, ok := p.typesWithMethods[name]; ok {
 		return len(p.typesWithoutMethods) + meta.Num, true
 	} else {
 		return -1, false // type is never put in an interface
@@ -377,14 +664,31 @@ func (p *Program) InterfaceNum(itfType *types.Interface) int {
 	}
 }
 
+// InterfaceNumByKey returns the numeric interface ID of the interface type with
+// the given InterfaceKey, if this interface is used in a type assert.
+//
+// Depends on AnalyseInterfaceConversions.
+func (p *Program) InterfaceNumByKey(key string) (int, bool) {
+	itf, ok := p.interfaces[key]
+	if !ok {
+		return -1, false
+	}
+	return itf.Num, true
+}
+
 // MethodNum returns the numeric ID of this method, to be used in method lookups
 // on interfaces for example.
 func (p *Program) MethodNum(method *types.Func) int {
-	name := MethodSignature(method)
+	return p.MethodNumByName(MethodSignature(method))
+}
+
+// MethodNumByName is like MethodNum, but takes the method signature as returned
+// by MethodSignature.
+func (p *Program) MethodNumByName(name string) int {
 	if _, ok := p.methodSignatureNames[name]; !ok {
 		p.methodSignatureNames[name] = len(p.methodSignatureNames)
 	}
-	return p.methodSignatureNames[MethodSignature(method)]
+	return p.methodSignatureNames[name]
 }
 
 // The start index of the first dynamic type that has methods.
@@ -405,6 +709,17 @@ func (p *Program) AllDynamicTypes() []*TypeWithMethods {
 	return l
 }
 
+// Return all types that have a type number, sorted by type number. The first
+// type is nil, for the nil interface.
+func (p *Program) AllTypes() []types.Type {
+	l := make([]types.Type, 0, len(p.typeList)+len(p.typesWithMethods))
+	l = append(l, p.typeList...)
+	for _, meta := range p.AllDynamicTypes() {
+		l = append(l, meta.t)
+	}
+	return l
+}
+
 // Return all interface types, sorted by interface ID.
 func (p *Program) AllInterfaces() []*Interface {
 	l := make([]*Interface, len(p.interfaces))
@@ -417,7 +732,7 @@ func (p *Program) AllInterfaces() []*Interface {
 func (p *Program) FunctionNeedsContext(f *Function) bool {
 	if !f.addressTaken {
 		if f.Signature.Recv() != nil {
-			_, hasInterfaceConversion := p.TypeNum(f.Signature.Recv().Type())
+			_, hasInterfaceConversion := p.typesWithMethods[f.Signature.Recv().Type().String()]
 			if hasInterfaceConversion && p.SignatureNeedsContext(f.Signature) {
 				return true
 			}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,8 +11,11 @@ import (
 	"os/exec"
 	"os/signal"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aykevl/go-llvm"
 	"github.com/aykevl/tinygo/compiler"
@@ -30,19 +34,32 @@ type BuildConfig struct {
 	debug      bool
 	printSizes string
 	initInterp bool
+	nilChecks  bool
+	testConfig bool
 }
 
 // Helper function for Compiler object.
 func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, action func(string) error) error {
 	compilerConfig := compiler.Config{
 		Triple:     spec.Triple,
+		GOOS:       spec.GOOS,
+		GOARCH:     spec.GOARCH,
 		Debug:      config.debug,
 		DumpSSA:    config.dumpSSA,
 		RootDir:    sourceDir(),
 		GOPATH:     getGopath(),
 		BuildTags:  append(spec.BuildTags, "tinygo"),
 		InitInterp: config.initInterp,
+		NilChecks:  config.nilChecks,
+		Tracebacks: spec.Traceback,
+		TypeNames:  spec.TypeNames,
+		TestConfig: config.testConfig,
 	}
+	packageCache, err := newPackageCache()
+	if err != nil {
+		return err
+	}
+	compilerConfig.PackageCache = packageCache
 	c, err := compiler.NewCompiler(pkgName, compilerConfig)
 	if err != nil {
 		return err
@@ -147,13 +164,12 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 
 		// Load builtins library from the cache, possibly compiling it on the
 		// fly.
-		var cachePath string
+		var librt string
 		if spec.CompilerRT {
-			librt, err := loadBuiltins(spec.Triple)
+			librt, err = loadBuiltins(spec.Triple)
 			if err != nil {
 				return err
 			}
-			cachePath, _ = filepath.Split(librt)
 		}
 
 		// Link the object file with the system compiler.
@@ -161,7 +177,7 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 		tmppath := executable // final file
 		args := append(spec.PreLinkArgs, "-o", executable, objfile)
 		if spec.CompilerRT {
-			args = append(args, "-L", cachePath, "-lrt-"+spec.Triple)
+			args = append(args, librt)
 		}
 		cmd := exec.Command(spec.Linker, args...)
 		cmd.Stdout = os.Stdout
@@ -172,22 +188,33 @@ func Compile(pkgName, outpath string, spec *TargetSpec, config *BuildConfig, act
 			return err
 		}
 
-		if config.printSizes == "short" || config.printSizes == "full" {
+		if config.printSizes == "short" || config.printSizes == "full" || config.printSizes == "json" || spec.FlashSize != 0 || spec.RAMSize != 0 {
 			sizes, err := Sizes(executable)
 			if err != nil {
 				return err
 			}
 			if config.printSizes == "short" {
 				fmt.Printf("   code    data     bss |   flash     ram\n")
-				fmt.Printf("%7d %7d %7d | %7d %7d\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Code+sizes.Data, sizes.Data+sizes.BSS)
-			} else {
+				fmt.Printf("%7d %7d %7d | %7d %7d\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Flash(), sizes.RAM())
+			} else if config.printSizes == "json" {
+				data, err := json.MarshalIndent(sizes, "", "\t")
+				if err != nil {
+					return err
+				}
+				fmt.Printf("%s\n", data)
+			} else if config.printSizes == "full" {
 				fmt.Printf("   code  rodata    data     bss |   flash     ram | package\n")
 				for _, name := range sizes.SortedPackageNames() {
 					pkgSize := sizes.Packages[name]
 					fmt.Printf("%7d %7d %7d %7d | %7d %7d | %s\n", pkgSize.Code, pkgSize.ROData, pkgSize.Data, pkgSize.BSS, pkgSize.Flash(), pkgSize.RAM(), name)
 				}
 				fmt.Printf("%7d %7d %7d %7d | %7d %7d | (sum)\n", sizes.Sum.Code, sizes.Sum.ROData, sizes.Sum.Data, sizes.Sum.BSS, sizes.Sum.Flash(), sizes.Sum.RAM())
-				fmt.Printf("%7d       - %7d %7d | %7d %7d | (all)\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Code+sizes.Data, sizes.Data+sizes.BSS)
+				fmt.Printf("%7d       - %7d %7d | %7d %7d | (all)\n", sizes.Code, sizes.Data, sizes.BSS, sizes.Flash(), sizes.RAM())
+			}
+
+			err = checkSizeBudget(spec, sizes)
+			if err != nil {
+				return err
 			}
 		}
 
@@ -339,8 +366,9 @@ func FlashGDB(pkgName, target, port string, ocdOutput bool, config *BuildConfig)
 // Run the specified package directly (using JIT or interpretation).
 func Run(pkgName string) error {
 	config := compiler.Config{
-		RootDir: sourceDir(),
-		GOPATH:  getGopath(),
+		RootDir:   sourceDir(),
+		GOPATH:    getGopath(),
+		NilChecks: true,
 	}
 	c, err := compiler.NewCompiler(pkgName, config)
 	if err != nil {
@@ -399,6 +427,143 @@ func Emulate(pkgName, target string, config *BuildConfig) error {
 	})
 }
 
+// Compile and run the tests of the given package, either on the host or in the
+// emulator of the target. The result is reported like go test does and is
+// returned as well.
+func Test(pkgName, target string, config *BuildConfig) (bool, error) {
+	spec, err := LoadTarget(target)
+	if err != nil {
+		return false, err
+	}
+	if target != "" && len(spec.Emulator) == 0 {
+		return false, errors.New("no emulator configured for this target")
+	}
+
+	testConfig := *config
+	testConfig.testConfig = true
+	passed := false
+	err = Compile(pkgName, ".elf", spec, &testConfig, func(tmppath string) error {
+		var cmd *exec.Cmd
+		if len(spec.Emulator) == 0 {
+			cmd = exec.Command(tmppath)
+		} else {
+			args := append(spec.Emulator[1:], tmppath)
+			cmd = exec.Command(spec.Emulator[0], args...)
+		}
+		// The test binary exits with a non-zero status when a test failed, or
+		// when it crashed before reporting the result.
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		start := time.Now()
+		err := cmd.Run()
+		if _, ok := err.(*exec.ExitError); !ok && err != nil {
+			return err
+		}
+		duration := time.Since(start)
+		passed = err == nil
+		if passed {
+			fmt.Printf("ok  \t%s\t%.3fs\n", pkgName, duration.Seconds())
+		} else {
+			fmt.Printf("FAIL\t%s\t%.3fs\n", pkgName, duration.Seconds())
+		}
+		return nil
+	})
+	return passed, err
+}
+
+// Check whether the program fits in the flash and RAM of the target, if the
+// target specifies them.
+func checkSizeBudget(spec *TargetSpec, sizes *ProgramSize) error {
+	if spec.FlashSize != 0 && sizes.Flash() > spec.FlashSize {
+		return errors.New("program too large: uses " + strconv.FormatUint(sizes.Flash(), 10) + " bytes of flash, but only " + strconv.FormatUint(spec.FlashSize, 10) + " bytes are available")
+	}
+	if spec.RAMSize != 0 && sizes.RAM() > spec.RAMSize {
+		return errors.New("program too large: uses " + strconv.FormatUint(sizes.RAM(), 10) + " bytes of RAM, but only " + strconv.FormatUint(spec.RAMSize, 10) + " bytes are available")
+	}
+	return nil
+}
+
+// Print how much the program at newPath grew compared to the program at
+// oldPath, by package and by symbol. Packages and symbols that did not change
+// are left out.
+func SizeDiff(oldPath, newPath string) error {
+	oldSizes, err := Sizes(oldPath)
+	if err != nil {
+		return err
+	}
+	newSizes, err := Sizes(newPath)
+	if err != nil {
+		return err
+	}
+	writeSizeDiff(os.Stdout, oldSizes, newSizes)
+	return nil
+}
+
+// Write the difference between two program sizes, as printed by SizeDiff.
+func writeSizeDiff(w io.Writer, oldSizes, newSizes *ProgramSize) {
+	packages := map[string]struct{}{}
+	for name := range oldSizes.Packages {
+		packages[name] = struct{}{}
+	}
+	for name := range newSizes.Packages {
+		packages[name] = struct{}{}
+	}
+	names := make([]string, 0, len(packages))
+	for name := range packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(w, "   flash   delta |     ram   delta | package\n")
+	for _, name := range names {
+		oldPkg := oldSizes.Packages[name]
+		if oldPkg == nil {
+			oldPkg = &PackageSize{}
+		}
+		newPkg := newSizes.Packages[name]
+		if newPkg == nil {
+			newPkg = &PackageSize{}
+		}
+		if oldPkg.Flash() == newPkg.Flash() && oldPkg.RAM() == newPkg.RAM() {
+			continue
+		}
+		fmt.Fprintf(w, "%7d %+7d | %7d %+7d | %s\n", newPkg.Flash(), sizeDelta(oldPkg.Flash(), newPkg.Flash()), newPkg.RAM(), sizeDelta(oldPkg.RAM(), newPkg.RAM()), name)
+	}
+	fmt.Fprintf(w, "%7d %+7d | %7d %+7d | (sum)\n", newSizes.Sum.Flash(), sizeDelta(oldSizes.Sum.Flash(), newSizes.Sum.Flash()), newSizes.Sum.RAM(), sizeDelta(oldSizes.Sum.RAM(), newSizes.Sum.RAM()))
+	fmt.Fprintf(w, "%7d %+7d | %7d %+7d | (all)\n", newSizes.Flash(), sizeDelta(oldSizes.Flash(), newSizes.Flash()), newSizes.RAM(), sizeDelta(oldSizes.RAM(), newSizes.RAM()))
+
+	// Symbols are sorted by growth, so that the largest growth comes first.
+	oldSymbols := oldSizes.SymbolSizes()
+	newSymbols := newSizes.SymbolSizes()
+	var symbols []string
+	for name, size := range oldSymbols {
+		if newSymbols[name] != size {
+			symbols = append(symbols, name)
+		}
+	}
+	for name := range newSymbols {
+		if _, ok := oldSymbols[name]; !ok {
+			symbols = append(symbols, name)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		delta_i := sizeDelta(oldSymbols[symbols[i]], newSymbols[symbols[i]])
+		delta_j := sizeDelta(oldSymbols[symbols[j]], newSymbols[symbols[j]])
+		if delta_i != delta_j {
+			return delta_i > delta_j
+		}
+		return symbols[i] < symbols[j]
+	})
+	fmt.Fprintf(w, "\n    old     new   delta | symbol\n")
+	for _, name := range symbols {
+		fmt.Fprintf(w, "%7d %7d %+7d | %s\n", oldSymbols[name], newSymbols[name], sizeDelta(oldSymbols[name], newSymbols[name]), name)
+	}
+}
+
+// Return the difference between two sizes, which may be negative.
+func sizeDelta(oldSize, newSize uint64) int64 {
+	return int64(newSize) - int64(oldSize)
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "usage: %s command [-printir] [-target=<target>] -o <output> <input>\n", os.Args[0])
 	fmt.Fprintln(os.Stderr, "\ncommands:")
@@ -406,7 +571,9 @@ func usage() {
 	fmt.Fprintln(os.Stderr, "  run:   compile and run immediately")
 	fmt.Fprintln(os.Stderr, "  flash: compile and flash to the device")
 	fmt.Fprintln(os.Stderr, "  gdb:   run/flash and immediately enter GDB")
-	fmt.Fprintln(os.Stderr, "  clean: empty cache directory ("+cacheDir()+")")
+	fmt.Fprintln(os.Stderr, "  test:  compile and run the tests of a package")
+	fmt.Fprintln(os.Stderr, "  size-diff: compare the size of two programs (ELF files)")
+	fmt.Fprintln(os.Stderr, "  clean: empty cache directory ("+cacheDir()+"), or print its size with -cache-stats")
 	fmt.Fprintln(os.Stderr, "  help:  print this help text")
 	fmt.Fprintln(os.Stderr, "\nflags:")
 	flag.PrintDefaults()
@@ -431,12 +598,14 @@ func main() {
 	opt := flag.String("opt", "z", "optimization level: 0, 1, 2, s, z")
 	printIR := flag.Bool("printir", false, "print LLVM IR")
 	dumpSSA := flag.Bool("dumpssa", false, "dump internal Go SSA")
-	target := flag.String("target", "", "LLVM target")
-	printSize := flag.String("size", "", "print sizes (none, short, full)")
+	target := flag.String("target", "", "target name, target .json file or LLVM triple")
+	printSize := flag.String("size", "", "print sizes (none, short, full, json)")
 	nodebug := flag.Bool("no-debug", false, "disable DWARF debug symbol generation")
 	ocdOutput := flag.Bool("ocd-output", false, "print OCD daemon output during debug")
 	initInterp := flag.Bool("initinterp", false, "enable experimental partial evaluator of generated IR")
+	noNilChecks := flag.Bool("no-nilcheck", false, "disable nil pointer checks")
 	port := flag.String("port", "/dev/ttyACM0", "flash port")
+	cacheStatistics := flag.Bool("cache-stats", false, "clean: print the size of the cache instead of removing it")
 
 	if len(os.Args) < 2 {
 		fmt.Fprintln(os.Stderr, "No command-line arguments supplied.")
@@ -453,6 +622,7 @@ func main() {
 		debug:      !*nodebug,
 		printSizes: *printSize,
 		initInterp: *initInterp,
+		nilChecks:  !*noNilChecks,
 	}
 
 	os.Setenv("CC", "clang -target="+*target)
@@ -506,7 +676,41 @@ func main() {
 			err := Emulate(flag.Arg(0), *target, config)
 			handleCompilerError(err)
 		}
+	case "test":
+		if flag.NArg() > 1 {
+			fmt.Fprintln(os.Stderr, "Only one package can be tested at a time.")
+			usage()
+			os.Exit(1)
+		}
+		pkgName := "."
+		if flag.NArg() == 1 {
+			pkgName = flag.Arg(0)
+		}
+		passed, err := Test(pkgName, *target, config)
+		handleCompilerError(err)
+		if !passed {
+			os.Exit(1)
+		}
+	case "size-diff":
+		if flag.NArg() != 2 {
+			fmt.Fprintln(os.Stderr, "Two programs must be specified to compare.")
+			usage()
+			os.Exit(1)
+		}
+		err := SizeDiff(flag.Arg(0), flag.Arg(1))
+		handleCompilerError(err)
 	case "clean":
+		if *cacheStatistics {
+			count, size, err := cacheStats()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "cannot read cache:", err)
+				os.Exit(1)
+			}
+			fmt.Printf("cache directory: %s\n", cacheDir())
+			fmt.Printf("entries:         %d\n", count)
+			fmt.Printf("size:            %.1f MiB (limit %d MiB)\n", float64(size)/(1024*1024), cacheMaxSize/(1024*1024))
+			return
+		}
 		// remove cache directory
 		dir := cacheDir()
 		err := os.RemoveAll(dir)
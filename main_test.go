@@ -2,6 +2,7 @@ package main
 
 // This file tests the compiler by running Go files in testdata/*.go and
 // comparing their output with the expected output in testdata/*.txt.
+// It also runs the tests of the packages in testdata/test, like `tinygo test`.
 
 import (
 	"bufio"
@@ -66,6 +67,7 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		debug:      false,
 		printSizes: "",
 		initInterp: false,
+		nilChecks:  true,
 	}
 	binary := filepath.Join(tmpdir, "test")
 	err = Build(path, binary, target, config)
@@ -125,3 +127,30 @@ func runTest(path, tmpdir string, target string, t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestTest(t *testing.T) {
+	// The external test package imports the package under test by its full
+	// import path, so this only works in a checkout in GOPATH.
+	tests := []struct {
+		pkg    string
+		passed bool
+	}{
+		{"./" + TESTDATA + "/test/pass", true},
+		{"./" + TESTDATA + "/test/fail", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.pkg, func(t *testing.T) {
+			config := &BuildConfig{
+				opt:       "z",
+				nilChecks: true,
+			}
+			passed, err := Test(tc.pkg, "", config)
+			if err != nil {
+				t.Fatal("failed to run tests:", err)
+			}
+			if passed != tc.passed {
+				t.Errorf("passed: got %v, want %v", passed, tc.passed)
+			}
+		})
+	}
+}
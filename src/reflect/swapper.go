@@ -1,5 +1,27 @@
 package reflect
 
+import (
+	"unsafe"
+)
+
 func Swapper(slice interface{}) func(i, j int) {
-	panic("unimplemented: reflect.Swapper")
+	v := ValueOf(slice)
+	if v.Kind() != Slice {
+		panic(&ValueError{"Swapper", v.Kind()})
+	}
+	length := v.Len()
+	elemSize := v.typecode.Elem().Size()
+	data := *(*unsafe.Pointer)(v.pointer())
+	return func(i, j int) {
+		if uint(i) >= uint(length) || uint(j) >= uint(length) {
+			panic("reflect: slice index out of range")
+		}
+		x := uintptr(data) + uintptr(i)*elemSize
+		y := uintptr(data) + uintptr(j)*elemSize
+		for k := uintptr(0); k < elemSize; k++ {
+			px := (*uint8)(unsafe.Pointer(x + k))
+			py := (*uint8)(unsafe.Pointer(y + k))
+			*px, *py = *py, *px
+		}
+	}
 }
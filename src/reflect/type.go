@@ -1,10 +1,21 @@
 package reflect
 
-// A Kind is the number that the compiler uses for this type.
+import (
+	"unsafe"
+)
+
+// The compiler emits a type descriptor for every type that has a typecode
+// (every type that is put in an interface and all types that are part of those
+// types, like the element type of a slice). The descriptors are stored in the
+// typeDescriptors array, indexed by typecode. Struct fields are stored in the
+// structFields array, where each struct type has a range of fields starting
+// at the fields index of its descriptor.
 //
-// Not used directly. These types are all replaced with the number the compiler
-// uses internally for the type.
-type Kind uint16
+// The layout of typeDescriptor and structField must be kept in sync with
+// compiler/reflect.go.
+
+// A Kind is the kind of type that a Type represents.
+type Kind uint8
 
 // Copied from reflect/type.go
 // https://golang.org/src/reflect/type.go?s=8302:8316#L217
@@ -38,46 +49,193 @@ const (
 	UnsafePointer
 )
 
-// The typecode as used in an interface{}.
-type Type uint16
+var kindNames = [...]string{
+	Invalid:       "invalid",
+	Bool:          "bool",
+	Int:           "int",
+	Int8:          "int8",
+	Int16:         "int16",
+	Int32:         "int32",
+	Int64:         "int64",
+	Uint:          "uint",
+	Uint8:         "uint8",
+	Uint16:        "uint16",
+	Uint32:        "uint32",
+	Uint64:        "uint64",
+	Uintptr:       "uintptr",
+	Float32:       "float32",
+	Float64:       "float64",
+	Complex64:     "complex64",
+	Complex128:    "complex128",
+	Array:         "array",
+	Chan:          "chan",
+	Func:          "func",
+	Interface:     "interface",
+	Map:           "map",
+	Ptr:           "ptr",
+	Slice:         "slice",
+	String:        "string",
+	Struct:        "struct",
+	UnsafePointer: "unsafe.Pointer",
+}
+
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "kind" + itoa(int(k))
+}
+
+type typeDescriptor struct {
+	kind   Kind
+	elem   Type    // element type of arrays, channels, maps, pointers and slices
+	key    Type    // key type of maps, or the slice type of the element type of arrays
+	fields uint16  // index of the first field of a struct in structFields
+	length uintptr // length of an array, or the number of fields of a struct
+	size   uintptr
+	name   string // the type as written in Go source code, like main.T or []int
+}
+
+type structField struct {
+	name   string
+	typ    Type
+	offset uintptr
+}
+
+// Global constants that will be set by the compiler. The arrays are of size 0,
+// which is a dummy value, but will be bigger after the compiler has filled them
+// in.
+var (
+	typeDescriptors [0]typeDescriptor
+	structFields    [0]structField
+)
 
 func TypeOf(i interface{}) Type {
 	return ValueOf(i).typecode
 }
 
+// Return the type descriptor of this type.
+//go:nobounds
+func (t Type) descriptor() *typeDescriptor {
+	return &typeDescriptors[t]
+}
+
 func (t Type) String() string {
-	return "T"
+	if t == 0 {
+		return "nil"
+	}
+	return t.descriptor().name
 }
 
 func (t Type) Kind() Kind {
-	return Invalid // TODO
+	return t.descriptor().kind
 }
 
 func (t Type) Elem() Type {
-	panic("unimplemented: (reflect.Type).Elem()")
+	switch t.Kind() {
+	case Array, Chan, Map, Ptr, Slice:
+		return t.descriptor().elem
+	default:
+		panic(&ValueError{"Type.Elem", t.Kind()})
+	}
+}
+
+func (t Type) Key() Type {
+	if t.Kind() != Map {
+		panic(&ValueError{"Type.Key", t.Kind()})
+	}
+	return t.descriptor().key
 }
 
+//go:nobounds
 func (t Type) Field(i int) StructField {
-	panic("unimplemented: (reflect.Type).Field()")
+	if uint(i) >= uint(t.NumField()) {
+		panic("reflect: Field index out of range")
+	}
+	field := &structFields[int(t.descriptor().fields)+i]
+	return StructField{
+		Name:   field.name,
+		Type:   field.typ,
+		Offset: field.offset,
+	}
 }
 
 func (t Type) Bits() int {
-	panic("unimplemented: (reflect.Type).Bits()")
+	switch t.Kind() {
+	case Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Float32, Float64, Complex64, Complex128:
+		return int(t.Size()) * 8
+	default:
+		panic(&ValueError{"Type.Bits", t.Kind()})
+	}
 }
 
 func (t Type) Len() int {
-	panic("unimplemented: (reflect.Type).Len()")
+	if t.Kind() != Array {
+		panic(&ValueError{"Type.Len", t.Kind()})
+	}
+	return int(t.descriptor().length)
 }
 
 func (t Type) NumField() int {
-	panic("unimplemented: (reflect.Type).NumField()")
+	if t.Kind() != Struct {
+		panic(&ValueError{"Type.NumField", t.Kind()})
+	}
+	return int(t.descriptor().length)
 }
 
 func (t Type) Size() uintptr {
-	panic("unimplemented: (reflect.Type).Size()")
+	return t.descriptor().size
+}
+
+// Return true if map keys of this type are hashed and compared bytewise by the
+// runtime. Keep this in sync with hashmapIsBinaryKey in the compiler.
+func (t Type) isBinaryKey() bool {
+	switch t.Kind() {
+	case Bool, Int, Int8, Int16, Int32, Int64, Uint, Uint8, Uint16, Uint32, Uint64, Uintptr, Chan, Ptr, UnsafePointer:
+		return true
+	case Array:
+		return t.Elem().isBinaryKey()
+	case Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !t.Field(i).Type.isBinaryKey() {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
 }
 
 type StructField struct {
-	Name string
-	Type Type
+	Name   string
+	Type   Type
+	Offset uintptr
 }
+
+// Convert an integer to a string, for error messages.
+func itoa(n int) string {
+	if n == 0 {
+		return "0"
+	}
+	negative := n < 0
+	if negative {
+		n = -n
+	}
+	var buf [20]byte
+	i := len(buf)
+	for n != 0 {
+		i--
+		buf[i] = byte('0' + n%10)
+		n /= 10
+	}
+	if negative {
+		i--
+		buf[i] = '-'
+	}
+	return string(buf[i:])
+}
+
+// Size of a pointer, and thus the largest value that is stored directly in an
+// interface.
+const ptrSize = unsafe.Sizeof(uintptr(0))
@@ -0,0 +1,7 @@
+// +build !avr,!cortexm0
+
+package reflect
+
+// The typecode as used in an interface{}. It has the same width as
+// runtime.typecodeID.
+type Type uint32
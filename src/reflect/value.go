@@ -1,152 +1,657 @@
 package reflect
 
 import (
-	_ "unsafe" // for go:linkname
+	"unsafe"
 )
 
-// This is the same thing as an interface{}.
+// A Value is an interface{} with some extra flags. Like in an interface, the
+// value field contains the value itself when it fits in a pointer and a pointer
+// to the value otherwise.
+//
+// Values that are addressable (like the element a pointer points to) have the
+// valueFlagIndirect flag set. The value field then always contains a pointer to
+// the value, so that the value can be changed.
 type Value struct {
 	typecode Type
-	value    *uint8
+	value    unsafe.Pointer
+	flags    valueFlags
 }
 
+type valueFlags uint8
+
+const (
+	valueFlagIndirect valueFlags = 1 << iota // value is a pointer to the data
+	valueFlagReadOnly                        // value was reached via an unexported struct field
+)
+
+// The layout of an interface{}, see runtime._interface.
+type interfaceHeader struct {
+	typecode Type
+	value    unsafe.Pointer
+}
+
+// The layout of a slice. The length type is 16 bits wide on 8-bit and 16-bit
+// platforms and 32 bits wide everywhere else.
+type sliceHeader16 struct {
+	data unsafe.Pointer
+	len  uint16
+	cap  uint16
+}
+
+type sliceHeader32 struct {
+	data unsafe.Pointer
+	len  uint32
+	cap  uint32
+}
+
+// The layout of a map iterator, see runtime.hashmapIterator.
+type hashmapIterator struct {
+	buckets      unsafe.Pointer
+	numBuckets   uintptr
+	bucketNumber uintptr
+	bucket       unsafe.Pointer
+	bucketIndex  uint8
+	startBucket  uintptr
+	startIndex   uint8
+}
+
+//go:linkname hashmapLen runtime.hashmapLen
+func hashmapLen(m unsafe.Pointer) int
+
+//go:linkname hashmapNext runtime.hashmapNext
+func hashmapNext(m unsafe.Pointer, it *hashmapIterator, key, value unsafe.Pointer) bool
+
+//go:linkname hashmapBinaryGet runtime.hashmapBinaryGet
+func hashmapBinaryGet(m unsafe.Pointer, key, value unsafe.Pointer) bool
+
+//go:linkname hashmapStringGet runtime.hashmapStringGet
+func hashmapStringGet(m unsafe.Pointer, key string, value unsafe.Pointer) bool
+
+//go:linkname hashmapGenericGet runtime.hashmapGenericGet
+func hashmapGenericGet(m unsafe.Pointer, key, value unsafe.Pointer) bool
+
+//go:linkname chanLen runtime.chanLen
+func chanLen(ch unsafe.Pointer) int
+
+//go:linkname chanCap runtime.chanCap
+func chanCap(ch unsafe.Pointer) int
+
 func Indirect(v Value) Value {
+	if v.Kind() != Ptr {
+		return v
+	}
+	return v.Elem()
+}
+
+func ValueOf(i interface{}) Value {
+	itf := (*interfaceHeader)(unsafe.Pointer(&i))
+	return Value{
+		typecode: itf.typecode,
+		value:    itf.value,
+	}
+}
+
+// Return a value of the given type that is read from the given pointer. The
+// value is not addressable. Large values are not copied, so the caller must
+// make sure the memory is not modified afterwards.
+func loadValue(typecode Type, ptr unsafe.Pointer) Value {
+	v := Value{typecode: typecode}
+	if typecode.Size() > ptrSize {
+		v.value = ptr
+	} else {
+		memcpy(unsafe.Pointer(&v.value), ptr, typecode.Size())
+	}
 	return v
 }
 
-func ValueOf(i interface{}) Value
+// Return a pointer to the data of this value. For small values that are not
+// addressable, this points to the value field of v itself.
+func (v *Value) pointer() unsafe.Pointer {
+	if v.isIndirect() || v.typecode.Size() > ptrSize {
+		return v.value
+	}
+	return unsafe.Pointer(&v.value)
+}
 
-//go:linkname _ValueOf reflect.ValueOf
-func _ValueOf(i Value) Value {
-	return i
+func (v Value) isIndirect() bool {
+	return v.flags&valueFlagIndirect != 0
 }
 
-func (v Value) Interface() interface{}
+func (v Value) Interface() interface{} {
+	itf := interfaceHeader{typecode: v.typecode}
+	size := v.typecode.Size()
+	if !v.isIndirect() {
+		itf.value = v.value
+	} else if size > ptrSize {
+		// Copy the value, so that it won't change when the original value is
+		// modified.
+		itf.value = alloc(size)
+		memcpy(itf.value, v.value, size)
+	} else {
+		memcpy(unsafe.Pointer(&itf.value), v.value, size)
+	}
+	return *(*interface{})(unsafe.Pointer(&itf))
+}
 
 func (v Value) Type() Type {
 	return v.typecode
 }
 
 func (v Value) Kind() Kind {
-	return Invalid // TODO
+	return v.typecode.Kind()
 }
 
 func (v Value) IsNil() bool {
-	panic("unimplemented: (reflect.Value).IsNil()")
+	switch v.Kind() {
+	case Chan, Map, Ptr, UnsafePointer, Slice:
+		// The pointer is the first field of slices.
+		return *(*unsafe.Pointer)(v.pointer()) == nil
+	case Func:
+		// A func value is either a function pointer or a {context, function
+		// pointer} pair for functions that need a context.
+		ptr := v.pointer()
+		if v.typecode.Size() > ptrSize {
+			ptr = unsafe.Pointer(uintptr(ptr) + ptrSize)
+		}
+		return *(*unsafe.Pointer)(ptr) == nil
+	case Interface:
+		return (*interfaceHeader)(v.pointer()).typecode == 0
+	default:
+		panic(&ValueError{"IsNil", v.Kind()})
+	}
 }
 
 func (v Value) Pointer() uintptr {
-	panic("unimplemented: (reflect.Value).Pointer()")
+	switch v.Kind() {
+	case Chan, Map, Ptr, UnsafePointer, Slice:
+		return uintptr(*(*unsafe.Pointer)(v.pointer()))
+	case Func:
+		ptr := v.pointer()
+		if v.typecode.Size() > ptrSize {
+			ptr = unsafe.Pointer(uintptr(ptr) + ptrSize)
+		}
+		return uintptr(*(*unsafe.Pointer)(ptr))
+	default:
+		panic(&ValueError{"Pointer", v.Kind()})
+	}
 }
 
 func (v Value) IsValid() bool {
-	panic("unimplemented: (reflect.Value).IsValid()")
+	return v.typecode != 0
 }
 
 func (v Value) CanInterface() bool {
-	panic("unimplemented: (reflect.Value).CanInterface()")
+	return v.IsValid()
 }
 
 func (v Value) CanAddr() bool {
-	panic("unimplemented: (reflect.Value).CanAddr()")
+	return v.isIndirect()
 }
 
 func (v Value) Addr() Value {
+	// There may not be a typecode for a pointer to this type.
 	panic("unimplemented: (reflect.Value).Addr()")
 }
 
 func (v Value) CanSet() bool {
-	panic("unimplemented: (reflect.Value).CanSet()")
+	return v.isIndirect() && v.flags&valueFlagReadOnly == 0
 }
 
 func (v Value) Bool() bool {
-	panic("unimplemented: (reflect.Value).Bool()")
+	if v.Kind() != Bool {
+		panic(&ValueError{"Bool", v.Kind()})
+	}
+	return *(*bool)(v.pointer())
 }
 
 func (v Value) Int() int64 {
-	panic("unimplemented: (reflect.Value).Int()")
+	ptr := v.pointer()
+	switch v.Kind() {
+	case Int:
+		if v.typecode.Size() == 8 {
+			return *(*int64)(ptr)
+		}
+		return int64(*(*int32)(ptr))
+	case Int8:
+		return int64(*(*int8)(ptr))
+	case Int16:
+		return int64(*(*int16)(ptr))
+	case Int32:
+		return int64(*(*int32)(ptr))
+	case Int64:
+		return *(*int64)(ptr)
+	default:
+		panic(&ValueError{"Int", v.Kind()})
+	}
 }
 
 func (v Value) Uint() uint64 {
-	panic("unimplemented: (reflect.Value).Uint()")
+	ptr := v.pointer()
+	switch v.Kind() {
+	case Uint, Uintptr:
+		switch v.typecode.Size() {
+		case 2:
+			return uint64(*(*uint16)(ptr))
+		case 4:
+			return uint64(*(*uint32)(ptr))
+		default:
+			return *(*uint64)(ptr)
+		}
+	case Uint8:
+		return uint64(*(*uint8)(ptr))
+	case Uint16:
+		return uint64(*(*uint16)(ptr))
+	case Uint32:
+		return uint64(*(*uint32)(ptr))
+	case Uint64:
+		return *(*uint64)(ptr)
+	default:
+		panic(&ValueError{"Uint", v.Kind()})
+	}
 }
 
 func (v Value) Float() float64 {
-	panic("unimplemented: (reflect.Value).Float()")
+	switch v.Kind() {
+	case Float32:
+		return float64(*(*float32)(v.pointer()))
+	case Float64:
+		return *(*float64)(v.pointer())
+	default:
+		panic(&ValueError{"Float", v.Kind()})
+	}
 }
 
 func (v Value) Complex() complex128 {
-	panic("unimplemented: (reflect.Value).Complex()")
+	switch v.Kind() {
+	case Complex64:
+		return complex128(*(*complex64)(v.pointer()))
+	case Complex128:
+		return *(*complex128)(v.pointer())
+	default:
+		panic(&ValueError{"Complex", v.Kind()})
+	}
 }
 
 func (v Value) String() string {
-	panic("unimplemented: (reflect.Value).String()")
+	switch v.Kind() {
+	case String:
+		return *(*string)(v.pointer())
+	case Invalid:
+		return "<invalid Value>"
+	default:
+		// Like the real reflect package, don't panic for other types.
+		return "<" + v.typecode.String() + " Value>"
+	}
 }
 
 func (v Value) Bytes() []byte {
-	panic("unimplemented: (reflect.Value).Bytes()")
+	switch v.Kind() {
+	case Slice:
+		if v.typecode.Elem().Kind() == Uint8 {
+			return *(*[]byte)(v.pointer())
+		}
+	case Array:
+		if v.typecode.Elem().Kind() == Uint8 && v.CanAddr() {
+			return v.Slice(0, v.Len()).Bytes()
+		}
+	}
+	panic(&ValueError{"Bytes", v.Kind()})
 }
 
 func (v Value) Slice(i, j int) Value {
-	panic("unimplemented: (reflect.Value).Slice()")
+	switch v.Kind() {
+	case Slice:
+		length := v.Cap()
+		if i < 0 || j < i || j > length {
+			panic("reflect.Value.Slice: slice index out of bounds")
+		}
+		elemSize := v.typecode.Elem().Size()
+		data := unsafe.Pointer(uintptr(*(*unsafe.Pointer)(v.pointer())) + uintptr(i)*elemSize)
+		return makeSliceValue(v.typecode, data, j-i, length-i)
+	case Array:
+		if !v.CanAddr() {
+			panic("reflect.Value.Slice: slice of unaddressable array")
+		}
+		length := v.Len()
+		if i < 0 || j < i || j > length {
+			panic("reflect.Value.Slice: slice index out of bounds")
+		}
+		elemSize := v.typecode.Elem().Size()
+		data := unsafe.Pointer(uintptr(v.value) + uintptr(i)*elemSize)
+		return makeSliceValue(v.typecode.descriptor().key, data, j-i, length-i)
+	case String:
+		s := *(*string)(v.pointer())
+		if i < 0 || j < i || j > len(s) {
+			panic("reflect.Value.Slice: string slice index out of bounds")
+		}
+		s = s[i:j]
+		return loadValue(v.typecode, unsafe.Pointer(&s))
+	default:
+		panic(&ValueError{"Slice", v.Kind()})
+	}
 }
 
 func (v Value) Len() int {
-	panic("unimplemented: (reflect.Value).Len()")
+	switch v.Kind() {
+	case Array:
+		return v.typecode.Len()
+	case Chan:
+		return chanLen(*(*unsafe.Pointer)(v.pointer()))
+	case Map:
+		return hashmapLen(*(*unsafe.Pointer)(v.pointer()))
+	case Slice:
+		return len(*(*[]byte)(v.pointer()))
+	case String:
+		return len(*(*string)(v.pointer()))
+	default:
+		panic(&ValueError{"Len", v.Kind()})
+	}
+}
+
+func (v Value) Cap() int {
+	switch v.Kind() {
+	case Array:
+		return v.typecode.Len()
+	case Chan:
+		return chanCap(*(*unsafe.Pointer)(v.pointer()))
+	case Slice:
+		return cap(*(*[]byte)(v.pointer()))
+	default:
+		panic(&ValueError{"Cap", v.Kind()})
+	}
 }
 
 func (v Value) NumField() int {
-	panic("unimplemented: (reflect.Value).NumField()")
+	return v.typecode.NumField()
 }
 
 func (v Value) Elem() Value {
-	panic("unimplemented: (reflect.Value).Elem()")
+	switch v.Kind() {
+	case Ptr:
+		ptr := *(*unsafe.Pointer)(v.pointer())
+		if ptr == nil {
+			return Value{}
+		}
+		return Value{
+			typecode: v.typecode.Elem(),
+			value:    ptr,
+			flags:    valueFlagIndirect,
+		}
+	case Interface:
+		itf := (*interfaceHeader)(v.pointer())
+		return Value{
+			typecode: itf.typecode,
+			value:    itf.value,
+		}
+	default:
+		panic(&ValueError{"Elem", v.Kind()})
+	}
 }
 
 func (v Value) Field(i int) Value {
-	panic("unimplemented: (reflect.Value).Field()")
+	field := v.typecode.Field(i)
+	ptr := unsafe.Pointer(uintptr(v.pointer()) + field.Offset)
+	if !v.isIndirect() {
+		return loadValue(field.Type, ptr)
+	}
+	flags := v.flags
+	if c := field.Name[0]; c < 'A' || c > 'Z' {
+		flags |= valueFlagReadOnly
+	}
+	return Value{
+		typecode: field.Type,
+		value:    ptr,
+		flags:    flags,
+	}
 }
 
 func (v Value) Index(i int) Value {
-	panic("unimplemented: (reflect.Value).Index()")
+	switch v.Kind() {
+	case Slice:
+		if uint(i) >= uint(v.Len()) {
+			panic("reflect: slice index out of range")
+		}
+		elemType := v.typecode.Elem()
+		data := *(*unsafe.Pointer)(v.pointer())
+		return Value{
+			typecode: elemType,
+			value:    unsafe.Pointer(uintptr(data) + uintptr(i)*elemType.Size()),
+			flags:    valueFlagIndirect | v.flags&valueFlagReadOnly,
+		}
+	case Array:
+		if uint(i) >= uint(v.Len()) {
+			panic("reflect: array index out of range")
+		}
+		elemType := v.typecode.Elem()
+		ptr := unsafe.Pointer(uintptr(v.pointer()) + uintptr(i)*elemType.Size())
+		if !v.isIndirect() {
+			return loadValue(elemType, ptr)
+		}
+		return Value{
+			typecode: elemType,
+			value:    ptr,
+			flags:    v.flags,
+		}
+	case String:
+		s := *(*string)(v.pointer())
+		if uint(i) >= uint(len(s)) {
+			panic("reflect: string index out of range")
+		}
+		// Use ValueOf to get the typecode of byte.
+		return ValueOf(s[i])
+	default:
+		panic(&ValueError{"Index", v.Kind()})
+	}
 }
 
 func (v Value) MapKeys() []Value {
-	panic("unimplemented: (reflect.Value).MapKeys()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapKeys", v.Kind()})
+	}
+	m := *(*unsafe.Pointer)(v.pointer())
+	keyType := v.typecode.Key()
+	keys := make([]Value, 0, v.Len())
+	if m == nil {
+		return keys
+	}
+	it := hashmapIterator{}
+	value := alloc(v.typecode.Elem().Size())
+	for {
+		key := alloc(keyType.Size())
+		if !hashmapNext(m, &it, key, value) {
+			break
+		}
+		keys = append(keys, loadValue(keyType, key))
+	}
+	return keys
 }
 
 func (v Value) MapIndex(key Value) Value {
-	panic("unimplemented: (reflect.Value).MapIndex()")
+	if v.Kind() != Map {
+		panic(&ValueError{"MapIndex", v.Kind()})
+	}
+	if key.typecode != v.typecode.Key() {
+		panic("reflect.Value.MapIndex: incompatible key type")
+	}
+	m := *(*unsafe.Pointer)(v.pointer())
+	if m == nil {
+		return Value{}
+	}
+	elemType := v.typecode.Elem()
+	value := alloc(elemType.Size())
+	var ok bool
+	if key.Kind() == String {
+		ok = hashmapStringGet(m, *(*string)(key.pointer()), value)
+	} else if key.typecode.isBinaryKey() {
+		ok = hashmapBinaryGet(m, key.pointer(), value)
+	} else {
+		ok = hashmapGenericGet(m, key.pointer(), value)
+	}
+	if !ok {
+		return Value{}
+	}
+	return loadValue(elemType, value)
+}
+
+// Check whether this value can be changed.
+func (v Value) checkSettable() {
+	if !v.isIndirect() {
+		panic("reflect: value is not addressable")
+	}
+	if v.flags&valueFlagReadOnly != 0 {
+		panic("reflect: value obtained using unexported field")
+	}
 }
 
 func (v Value) Set(x Value) {
-	panic("unimplemented: (reflect.Value).Set()")
+	v.checkSettable()
+	if v.Kind() == Interface {
+		*(*interface{})(v.value) = x.Interface()
+		return
+	}
+	if x.typecode != v.typecode {
+		panic("reflect.Value.Set: value of type " + x.typecode.String() + " is not assignable to type " + v.typecode.String())
+	}
+	memcpy(v.value, x.pointer(), v.typecode.Size())
 }
 
 func (v Value) SetBool(x bool) {
-	panic("unimplemented: (reflect.Value).SetBool()")
+	v.checkSettable()
+	if v.Kind() != Bool {
+		panic(&ValueError{"SetBool", v.Kind()})
+	}
+	*(*bool)(v.value) = x
 }
 
 func (v Value) SetInt(x int64) {
-	panic("unimplemented: (reflect.Value).SetInt()")
+	v.checkSettable()
+	switch v.Kind() {
+	case Int:
+		if v.typecode.Size() == 8 {
+			*(*int64)(v.value) = x
+		} else {
+			*(*int32)(v.value) = int32(x)
+		}
+	case Int8:
+		*(*int8)(v.value) = int8(x)
+	case Int16:
+		*(*int16)(v.value) = int16(x)
+	case Int32:
+		*(*int32)(v.value) = int32(x)
+	case Int64:
+		*(*int64)(v.value) = x
+	default:
+		panic(&ValueError{"SetInt", v.Kind()})
+	}
 }
 
 func (v Value) SetUint(x uint64) {
-	panic("unimplemented: (reflect.Value).SetUint()")
+	v.checkSettable()
+	switch v.Kind() {
+	case Uint, Uintptr:
+		switch v.typecode.Size() {
+		case 2:
+			*(*uint16)(v.value) = uint16(x)
+		case 4:
+			*(*uint32)(v.value) = uint32(x)
+		default:
+			*(*uint64)(v.value) = x
+		}
+	case Uint8:
+		*(*uint8)(v.value) = uint8(x)
+	case Uint16:
+		*(*uint16)(v.value) = uint16(x)
+	case Uint32:
+		*(*uint32)(v.value) = uint32(x)
+	case Uint64:
+		*(*uint64)(v.value) = x
+	default:
+		panic(&ValueError{"SetUint", v.Kind()})
+	}
 }
 
 func (v Value) SetFloat(x float64) {
-	panic("unimplemented: (reflect.Value).SetFloat()")
+	v.checkSettable()
+	switch v.Kind() {
+	case Float32:
+		*(*float32)(v.value) = float32(x)
+	case Float64:
+		*(*float64)(v.value) = x
+	default:
+		panic(&ValueError{"SetFloat", v.Kind()})
+	}
 }
 
 func (v Value) SetComplex(x complex128) {
-	panic("unimplemented: (reflect.Value).SetComplex()")
+	v.checkSettable()
+	switch v.Kind() {
+	case Complex64:
+		*(*complex64)(v.value) = complex64(x)
+	case Complex128:
+		*(*complex128)(v.value) = x
+	default:
+		panic(&ValueError{"SetComplex", v.Kind()})
+	}
 }
 
 func (v Value) SetString(x string) {
-	panic("unimplemented: (reflect.Value).SetString()")
+	v.checkSettable()
+	if v.Kind() != String {
+		panic(&ValueError{"SetString", v.Kind()})
+	}
+	*(*string)(v.value) = x
 }
 
 func MakeSlice(typ Type, len, cap int) Value {
-	panic("unimplemented: reflect.MakeSlice()")
+	if typ.Kind() != Slice {
+		panic("reflect.MakeSlice of non-slice type")
+	}
+	if len < 0 || cap < len {
+		panic("reflect.MakeSlice: len out of range")
+	}
+	data := alloc(uintptr(cap) * typ.Elem().Size())
+	return makeSliceValue(typ, data, len, cap)
+}
+
+// Create a new (non-addressable) slice value from the given slice header
+// fields.
+func makeSliceValue(typ Type, data unsafe.Pointer, len, cap int) Value {
+	var header unsafe.Pointer
+	if ptrSize < 4 {
+		header = unsafe.Pointer(&sliceHeader16{data, uint16(len), uint16(cap)})
+	} else {
+		header = unsafe.Pointer(&sliceHeader32{data, uint32(len), uint32(cap)})
+	}
+	return Value{
+		typecode: typ,
+		value:    header,
+	}
+}
+
+type ValueError struct {
+	Method string
+	Kind   Kind
+}
+
+func (e *ValueError) Error() string {
+	if e.Kind == Invalid {
+		return "reflect: call of reflect.Value." + e.Method + " on zero Value"
+	}
+	return "reflect: call of reflect.Value." + e.Method + " on " + e.Kind.String() + " Value"
+}
+
+// Allocate zeroed memory of the given size on the heap.
+func alloc(size uintptr) unsafe.Pointer {
+	if size == 0 {
+		return nil
+	}
+	buf := make([]byte, int(size))
+	return unsafe.Pointer(&buf[0])
+}
+
+// Copy size bytes from src to dst. The regions must not overlap.
+func memcpy(dst, src unsafe.Pointer, size uintptr) {
+	for i := uintptr(0); i < size; i++ {
+		*(*uint8)(unsafe.Pointer(uintptr(dst) + i)) = *(*uint8)(unsafe.Pointer(uintptr(src) + i))
+	}
 }
@@ -9,3 +9,9 @@ type lenType uint32
 
 // The bitness of the CPU (e.g. 8, 32, 64).
 const TargetBits = 64
+
+// System call numbers of linux/amd64, see syscall.go.
+const (
+	sysWrite     = 1
+	sysExitGroup = 231
+)
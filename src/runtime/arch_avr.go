@@ -16,3 +16,15 @@ const TargetBits = 8
 
 //go:extern _heap_start
 var heapStart unsafe.Pointer
+
+//go:extern _heap_end
+var heapEnd unsafe.Pointer
+
+//go:extern _globals_start
+var globalsStart unsafe.Pointer
+
+//go:extern _globals_end
+var globalsEnd unsafe.Pointer
+
+//go:extern _stack_top
+var stackTop unsafe.Pointer
@@ -14,5 +14,24 @@ type lenType uint32
 // The bitness of the CPU (e.g. 8, 32, 64).
 const TargetBits = 32
 
+// System call numbers of linux/arm, see syscall.go. Microcontrollers use the
+// syscall package of linux/arm.
+const (
+	sysWrite     = 4
+	sysExitGroup = 248
+)
+
 //go:extern _heap_start
 var heapStart unsafe.Pointer
+
+//go:extern _heap_end
+var heapEnd unsafe.Pointer
+
+//go:extern _globals_start
+var globalsStart unsafe.Pointer
+
+//go:extern _globals_end
+var globalsEnd unsafe.Pointer
+
+//go:extern _stack_top
+var stackTop unsafe.Pointer
@@ -0,0 +1,48 @@
+package runtime
+
+// This file implements the functions of internal/bytealg that are written in
+// assembly in the standard library. All targets are compiled with the wasm
+// build tag, for which these functions have no Go implementation.
+
+//go:linkname indexByte internal/bytealg.IndexByte
+func indexByte(b []byte, c byte) int {
+	for i, x := range b {
+		if x == c {
+			return i
+		}
+	}
+	return -1
+}
+
+//go:linkname indexByteString internal/bytealg.IndexByteString
+func indexByteString(s string, c byte) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			return i
+		}
+	}
+	return -1
+}
+
+//go:linkname compareBytes internal/bytealg.Compare
+func compareBytes(a, b []byte) int {
+	l := len(a)
+	if len(b) < l {
+		l = len(b)
+	}
+	for i := 0; i < l; i++ {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	if len(a) < len(b) {
+		return -1
+	}
+	if len(a) > len(b) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,41 @@
+package runtime
+
+// This file implements the functions of internal/bytealg that are only written
+// in assembly on amd64.
+
+//go:linkname countBytes internal/bytealg.Count
+func countBytes(b []byte, c byte) int {
+	n := 0
+	for _, x := range b {
+		if x == c {
+			n++
+		}
+	}
+	return n
+}
+
+//go:linkname countString internal/bytealg.CountString
+func countString(s string, c byte) int {
+	n := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == c {
+			n++
+		}
+	}
+	return n
+}
+
+//go:linkname indexBytes internal/bytealg.Index
+func indexBytes(a, b []byte) int {
+	return indexString(string(a), string(b))
+}
+
+//go:linkname indexString internal/bytealg.IndexString
+func indexString(a, b string) int {
+	for i := 0; i+len(b) <= len(a); i++ {
+		if a[i:i+len(b)] == b {
+			return i
+		}
+	}
+	return -1
+}
@@ -1,8 +1,399 @@
 package runtime
 
 // This file implements the 'chan' type and send/receive/select operations.
+//
+// A channel has an (optional) ring buffer for buffered channels and two queues
+// of blocked goroutines: one for senders and one for receivers. A goroutine
+// that cannot complete its operation immediately puts itself in one of these
+// queues and sets its task state accordingly, so that the scheduler won't run
+// it again. The goroutine on the other side of the channel will complete the
+// operation once it arrives and re-activate the waiting goroutine.
+//
+// A goroutine blocked in a select statement is put in the queues of all the
+// channels in the select. The first operation that completes removes it from
+// all other queues again.
+//
+// The compiler calls chanSend, chanRecv and chanSelect with the current
+// coroutine and suspends the coroutine right after the call. When the
+// operation could be completed directly, the task state is still runnable so
+// the coroutine will be resumed by the scheduler soon.
 
-// dummy
+import (
+	"unsafe"
+)
 
 type channel struct {
+	elementSize uintptr
+	bufSize     uintptr        // capacity of the buffer, 0 for unbuffered channels
+	bufUsed     uintptr        // number of values currently in the buffer
+	bufHead     uintptr        // index of the oldest value in the buffer
+	buf         unsafe.Pointer // ring buffer of bufSize values
+	closed      bool
+	senders     *channelBlockedList // blocked senders
+	receivers   *channelBlockedList // blocked receivers
+}
+
+// A goroutine that is blocked on a channel, as stored in the senders or
+// receivers queue of the channel.
+type channelBlockedList struct {
+	next  *channelBlockedList
+	t     *coroutine
+	value unsafe.Pointer    // value to send, or where to store the received value
+	index uint32            // index of the select case (0 for a plain send/receive)
+	cases []chanSelectState // all cases of a select, nil for a plain send/receive
+}
+
+// A single case of a select statement, as passed in by the compiler.
+type chanSelectState struct {
+	ch     *channel
+	value  unsafe.Pointer // value to send, or where to store the received value
+	isSend bool
+}
+
+// Number of goroutines that are currently blocked on a channel operation. The
+// scheduler only waits for timers to expire when there are blocked goroutines
+// that might be woken up by them.
+var chanBlockedTasks int
+
+// Create a new channel.
+//
+// This is a compiler intrinsic.
+func chanMake(elementSize uintptr, bufSize uintptr) *channel {
+	ch := &channel{
+		elementSize: elementSize,
+		bufSize:     bufSize,
+	}
+	if bufSize != 0 {
+		ch.buf = alloc(elementSize * bufSize)
+	}
+	return ch
+}
+
+// Return the number of values in the channel buffer, for len(ch).
+func chanLen(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufUsed)
+}
+
+// Return the capacity of the channel buffer, for cap(ch).
+func chanCap(ch *channel) int {
+	if ch == nil {
+		return 0
+	}
+	return int(ch.bufSize)
+}
+
+// Send a single value over the channel. The value is read from the given
+// pointer. If the value cannot be sent directly (no receiver is waiting and the
+// buffer is full), the sender is blocked until a receiver arrives.
+//
+// The sender is nil when there is no scheduler. Only a timer can then complete
+// the operation, otherwise blocking is a deadlock.
+//
+// This is a compiler intrinsic.
+func chanSend(sender *coroutine, ch *channel, value unsafe.Pointer) {
+	for !ch.trySend(value) {
+		if sender == nil {
+			chanWaitNoScheduler()
+			continue
+		}
+		// Wait for a receiver. A send on a nil channel blocks forever, so
+		// it isn't counted as a blocked task that a timer could wake up.
+		sender.promise().state = TASK_STATE_CHAN_SEND
+		if ch != nil {
+			chanBlockedTasks++
+			chanQueuePush(&ch.senders, &channelBlockedList{t: sender, value: value})
+		}
+		return
+	}
+}
+
+// Receive a single value from the channel and store it in the given pointer.
+// If there is no value available, the receiver is blocked until a sender
+// arrives or the channel is closed.
+//
+// The return value indicates whether a value was received (as opposed to the
+// zero value from a closed channel), but is only valid when there is no
+// scheduler. When there is a scheduler, the receiver must read this value
+// from the task state after it has been resumed, using getTaskPromiseData.
+//
+// This is a compiler intrinsic.
+func chanRecv(receiver *coroutine, ch *channel, value unsafe.Pointer) bool {
+	for {
+		if ready, ok := ch.tryRecv(value); ready {
+			chanOperationDone(receiver, 0, ok)
+			return ok
+		}
+		if receiver == nil {
+			chanWaitNoScheduler()
+			continue
+		}
+		// Wait for a sender. A receive from a nil channel blocks forever, so
+		// it isn't counted as a blocked task either.
+		receiver.promise().state = TASK_STATE_CHAN_RECV
+		if ch != nil {
+			chanBlockedTasks++
+			chanQueuePush(&ch.receivers, &channelBlockedList{t: receiver, value: value})
+		}
+		return false
+	}
+}
+
+// Run a select statement. It returns the index of the case that was chosen
+// (or -1 for the default case) and, for a receive, whether a value was
+// received. When none of the cases is ready and there is no default case, the
+// goroutine is blocked on all channels at the same time.
+//
+// Like with chanRecv, the return values are only valid when there is no
+// scheduler. Otherwise, the task state contains the chosen case index (shifted
+// left by one) and the commaOk value (in the lowest bit).
+//
+// This is a compiler intrinsic.
+func chanSelect(t *coroutine, cases []chanSelectState, hasDefault bool) (int, bool) {
+	for {
+		// Check all cases, starting at a random case so that every ready case
+		// has the same chance of being selected.
+		start := 0
+		if len(cases) > 1 {
+			start = int(fastrand() % uint32(len(cases)))
+		}
+		for i := range cases {
+			index := (start + i) % len(cases)
+			state := &cases[index]
+			if state.isSend {
+				if state.ch.trySend(state.value) {
+					chanOperationDone(t, index, false)
+					return index, false
+				}
+			} else {
+				if ready, ok := state.ch.tryRecv(state.value); ready {
+					chanOperationDone(t, index, ok)
+					return index, ok
+				}
+			}
+		}
+		if hasDefault {
+			return -1, false
+		}
+		if t == nil {
+			chanWaitNoScheduler()
+			continue
+		}
+		// Wait on all channels. Nil channels are never ready, so there is no
+		// need to wait on them. A select on only nil channels blocks forever
+		// and isn't counted as a blocked task.
+		t.promise().state = TASK_STATE_CHAN_SELECT
+		counted := false
+		for i, state := range cases {
+			if state.ch == nil {
+				continue
+			}
+			if !counted {
+				chanBlockedTasks++
+				counted = true
+			}
+			blocked := &channelBlockedList{t: t, value: state.value, index: uint32(i), cases: cases}
+			if state.isSend {
+				chanQueuePush(&state.ch.senders, blocked)
+			} else {
+				chanQueuePush(&state.ch.receivers, blocked)
+			}
+		}
+		return -1, false
+	}
+}
+
+// Close the given channel. All blocked receivers are woken up and will receive
+// the zero value. All blocked senders are woken up and will panic once they
+// resume, see chanSendResume.
+//
+// This is a compiler intrinsic.
+func chanClose(ch *channel) {
+	if ch == nil {
+		runtimePanic("close of nil channel")
+		return
+	}
+	if ch.closed {
+		runtimePanic("close of closed channel")
+		return
+	}
+	ch.closed = true
+	for {
+		sender := chanQueuePop(&ch.senders)
+		if sender == nil {
+			break
+		}
+		sender.t.promise().data = chanResult(sender.index, false) | chanSendClosed
+		chanBlockedTasks--
+		activateTask(sender.t)
+	}
+	for {
+		receiver := chanQueuePop(&ch.receivers)
+		if receiver == nil {
+			break
+		}
+		memzero(receiver.value, ch.elementSize)
+		receiver.wake(false)
+	}
+}
+
+// Try to send a value over the channel without blocking. It returns whether
+// the value was sent.
+func (ch *channel) trySend(value unsafe.Pointer) bool {
+	if ch == nil {
+		return false
+	}
+	if ch.closed {
+		runtimePanic("send on closed channel")
+		return true // not sent, but the caller should not block
+	}
+	if receiver := chanQueuePop(&ch.receivers); receiver != nil {
+		// There is a receiver waiting, which implies that the buffer is empty.
+		// Hand over the value directly.
+		memcpy(receiver.value, value, ch.elementSize)
+		receiver.wake(true)
+		return true
+	}
+	if ch.bufUsed < ch.bufSize {
+		// There is space left in the buffer.
+		index := (ch.bufHead + ch.bufUsed) % ch.bufSize
+		memcpy(ch.bufPtr(index), value, ch.elementSize)
+		ch.bufUsed++
+		return true
+	}
+	return false
+}
+
+// Try to receive a value from the channel without blocking. It returns whether
+// the receive completed and whether an actual value was received (as opposed
+// to the zero value from a closed channel).
+func (ch *channel) tryRecv(value unsafe.Pointer) (ready, ok bool) {
+	if ch == nil {
+		return false, false
+	}
+	if ch.bufUsed != 0 {
+		// Take the oldest value from the buffer.
+		memcpy(value, ch.bufPtr(ch.bufHead), ch.elementSize)
+		ch.bufHead = (ch.bufHead + 1) % ch.bufSize
+		ch.bufUsed--
+		if sender := chanQueuePop(&ch.senders); sender != nil {
+			// Move the value of a blocked sender into the space that was just
+			// freed.
+			index := (ch.bufHead + ch.bufUsed) % ch.bufSize
+			memcpy(ch.bufPtr(index), sender.value, ch.elementSize)
+			ch.bufUsed++
+			sender.wake(true)
+		}
+		return true, true
+	}
+	if sender := chanQueuePop(&ch.senders); sender != nil {
+		// Unbuffered channel with a sender waiting: take the value directly.
+		memcpy(value, sender.value, ch.elementSize)
+		sender.wake(true)
+		return true, true
+	}
+	if ch.closed {
+		// Receiving from a closed channel returns the zero value.
+		memzero(value, ch.elementSize)
+		return true, false
+	}
+	return false, false
+}
+
+// Return a pointer to the value at the given index in the channel buffer.
+func (ch *channel) bufPtr(index uintptr) unsafe.Pointer {
+	return unsafe.Pointer(uintptr(ch.buf) + index*ch.elementSize)
+}
+
+// Store the result of a channel operation that completed immediately in the
+// task state, where the compiler will look for it after the task is resumed.
+func chanOperationDone(t *coroutine, index int, ok bool) {
+	if t != nil {
+		t.promise().data = chanResult(uint32(index), ok)
+	}
+}
+
+// Flag in the data field of the task state of a blocked sender, set when the
+// channel was closed instead of receiving the value.
+const chanSendClosed = 1 << 31
+
+// Encode the result of a channel operation for the data field of the task
+// state: the select case index in the upper bits and commaOk in the lowest bit.
+func chanResult(index uint32, ok bool) uint32 {
+	if ok {
+		return index<<1 | 1
+	}
+	return index << 1
+}
+
+// Check whether the channel of a send (or a send case of a select) was closed
+// while the goroutine was blocked on it, and panic in that case. The flag is
+// cleared again, so that the rest of the result can be read as usual.
+//
+// This is a compiler intrinsic, called after a coroutine that may have blocked
+// on a send has been resumed.
+func chanSendResume(t *coroutine) {
+	promise := t.promise()
+	if promise.data&chanSendClosed != 0 {
+		promise.data &^= chanSendClosed
+		runtimePanic("send on closed channel")
+	}
+}
+
+// Wake up a blocked goroutine after the operation it was waiting for has been
+// completed by the other side of the channel.
+func (b *channelBlockedList) wake(ok bool) {
+	b.t.promise().data = chanResult(b.index, ok)
+	chanBlockedTasks--
+	activateTask(b.t)
+}
+
+// Wait for the next timer to fire when a channel operation cannot complete and
+// there is no scheduler. Nothing else can complete the operation in that case,
+// so without any timers this is a deadlock.
+func chanWaitNoScheduler() {
+	if timerQueue == nil {
+		runtimeFatal("all goroutines are asleep - deadlock!")
+	}
+	sleepTicks(timerTimeLeft())
+	fireTimers()
+}
+
+// Add a blocked goroutine to the end of a channel wait queue.
+func chanQueuePush(queue **channelBlockedList, b *channelBlockedList) {
+	for *queue != nil {
+		queue = &(*queue).next
+	}
+	*queue = b
+}
+
+// Remove the first blocked goroutine from a channel wait queue. Returns nil if
+// the queue is empty. When the goroutine is blocked in a select statement, it
+// is also removed from the queues of the other channels in the select.
+func chanQueuePop(queue **channelBlockedList) *channelBlockedList {
+	b := *queue
+	if b == nil {
+		return nil
+	}
+	*queue = b.next
+	for _, state := range b.cases {
+		if state.ch != nil {
+			chanQueueRemove(&state.ch.senders, b.t)
+			chanQueueRemove(&state.ch.receivers, b.t)
+		}
+	}
+	return b
+}
+
+// Remove all entries of the given goroutine from a channel wait queue.
+func chanQueueRemove(queue **channelBlockedList, t *coroutine) {
+	for *queue != nil {
+		if (*queue).t == t {
+			*queue = (*queue).next
+		} else {
+			queue = &(*queue).next
+		}
+	}
 }
@@ -11,6 +11,8 @@ package runtime
 //   * On return, runtime.rundefers is called which calls all deferred functions
 //     from the head of the linked list until it has gone through all defer
 //     frames.
+//   * On a panic, runtime.panicRunDefers is called instead, which does the same
+//     but also checks whether the panic was recovered. See panic.go.
 
 import "unsafe"
 
@@ -21,9 +23,16 @@ type _defer struct {
 	next     *_defer
 }
 
-func rundefers(stack *_defer) {
-	for stack != nil {
-		stack.callback(stack)
-		stack = stack.next
+// Run all deferred calls of a function before it returns. Every deferred call
+// is removed from the list before it is called. If a deferred call panics, the
+// remaining deferred calls are run while unwinding the stack.
+func rundefers(stack **_defer) {
+	for *stack != nil {
+		d := *stack
+		*stack = d.next
+		d.callback(d)
+		if unwinding {
+			return
+		}
 	}
 }
@@ -1,7 +1,11 @@
-// +build !linux
+// +build !linux,!tinygo.arm,!avr
 
 package runtime
 
+// This file implements a simple bump allocator without garbage collector. It is
+// used on WebAssembly, where local variables are not stored in linear memory
+// and thus cannot be scanned by a conservative garbage collector.
+
 import (
 	"unsafe"
 )
@@ -23,7 +27,7 @@ func alloc(size uintptr) unsafe.Pointer {
 }
 
 func free(ptr unsafe.Pointer) {
-	// TODO: use a GC
+	// Memory is never freed.
 }
 
 func GC() {
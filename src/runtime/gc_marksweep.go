@@ -0,0 +1,295 @@
+// +build tinygo.arm avr
+
+package runtime
+
+// This file implements a conservative mark-sweep garbage collector for
+// baremetal targets.
+//
+// The heap (from heapStart to heapEnd, as defined in the linker script) is
+// split in two parts: a pool of fixed-size blocks and, at the end of the heap,
+// the state of every block in two bits. An object occupies one or more
+// consecutive blocks: a head block followed by zero or more tail blocks.
+//
+// The collector is conservative: every word in the globals, on the stack and
+// in reachable objects that looks like a pointer into the pool is treated as a
+// pointer. The frames of blocked goroutines are heap-allocated coroutine
+// frames, so they are found through the scheduler queues (which are globals)
+// or through the channels they're blocked on, like any other object.
+
+import (
+	"unsafe"
+)
+
+// Size of a single block in the pool. Objects are rounded up to a multiple of
+// this size.
+const bytesPerBlock = 4 * unsafe.Sizeof(uintptr(0))
+
+// Number of block states stored in a single byte of metadata.
+const blocksPerStateByte = 4
+
+// Index of a block in the pool.
+type gcBlock uintptr
+
+// State of a single block, as stored in the metadata.
+type blockState uint8
+
+const (
+	blockStateFree blockState = 0 // not in use
+	blockStateHead blockState = 1 // first block of an object
+	blockStateTail blockState = 2 // following block of an object
+	blockStateMark blockState = 3 // head block that was marked as reachable
+	blockStateMask blockState = 3
+)
+
+var (
+	heapInitialized bool
+	poolStart       uintptr        // address of the first block
+	endBlock        gcBlock        // number of blocks in the pool
+	metadataStart   unsafe.Pointer // block states, after the last block
+	nextAlloc       gcBlock        // block to start searching for free blocks
+)
+
+// Objects that have been marked, but whose contents haven't been scanned yet.
+// When this queue is full, markQueueOverflow is set and all marked objects are
+// scanned again once the queue is empty.
+var (
+	markQueue         [16]gcBlock
+	markQueueLen      int
+	markQueueOverflow bool
+)
+
+// Address returned for allocations of zero bytes.
+var zeroSizedAlloc uint8
+
+// Return the current stack pointer. Callee-saved registers are saved on the
+// stack of the calling function before the stack pointer is read, so that
+// pointers that are only stored in a register will be found when scanning the
+// stack.
+//
+// This is a compiler intrinsic.
+func getCurrentStackPointer() uintptr
+
+// Split the heap in a pool of blocks and their metadata. All blocks start out
+// free.
+func initHeap() {
+	start := align(uintptr(unsafe.Pointer(&heapStart)))
+	end := uintptr(unsafe.Pointer(&heapEnd))
+	// Every block needs bytesPerBlock bytes in the pool plus a quarter byte of
+	// metadata.
+	endBlock = gcBlock((end-start)/(bytesPerBlock*blocksPerStateByte+1)) * blocksPerStateByte
+	poolStart = start
+	metadataStart = unsafe.Pointer(start + uintptr(endBlock)*bytesPerBlock)
+	memzero(metadataStart, uintptr(endBlock/blocksPerStateByte))
+	heapInitialized = true
+}
+
+// Return the block that contains the given address.
+func blockFromAddr(addr uintptr) gcBlock {
+	return gcBlock((addr - poolStart) / bytesPerBlock)
+}
+
+// Return the address of the start of this block.
+func (b gcBlock) address() uintptr {
+	return poolStart + uintptr(b)*bytesPerBlock
+}
+
+// Return the state of this block.
+func (b gcBlock) state() blockState {
+	stateByte := (*uint8)(unsafe.Pointer(uintptr(metadataStart) + uintptr(b/blocksPerStateByte)))
+	return blockState(*stateByte>>((b%blocksPerStateByte)*2)) & blockStateMask
+}
+
+// Change the state of this block.
+func (b gcBlock) setState(newState blockState) {
+	stateByte := (*uint8)(unsafe.Pointer(uintptr(metadataStart) + uintptr(b/blocksPerStateByte)))
+	shift := (b % blocksPerStateByte) * 2
+	*stateByte = *stateByte&^(uint8(blockStateMask)<<shift) | uint8(newState)<<shift
+}
+
+// Return the head block of the object this block belongs to.
+func (b gcBlock) findHead() gcBlock {
+	for b.state() == blockStateTail {
+		b--
+	}
+	return b
+}
+
+// Return the first block after the object that starts at this head block.
+func (b gcBlock) findNext() gcBlock {
+	b++
+	for b < endBlock && b.state() == blockStateTail {
+		b++
+	}
+	return b
+}
+
+// Allocate a zeroed object of the given size. When there is not enough free
+// memory, a garbage collection cycle is run first.
+func alloc(size uintptr) unsafe.Pointer {
+	if size == 0 {
+		return unsafe.Pointer(&zeroSizedAlloc)
+	}
+	if !heapInitialized {
+		initHeap()
+	}
+	neededBlocks := (size + (bytesPerBlock - 1)) / bytesPerBlock
+	block, ok := findFreeBlocks(neededBlocks)
+	if !ok {
+		GC()
+		block, ok = findFreeBlocks(neededBlocks)
+		if !ok {
+			runtimeFatal("out of memory")
+		}
+	}
+
+	block.setState(blockStateHead)
+	for i := gcBlock(1); i < gcBlock(neededBlocks); i++ {
+		(block + i).setState(blockStateTail)
+	}
+	nextAlloc = block + gcBlock(neededBlocks)
+	ptr := unsafe.Pointer(block.address())
+	memzero(ptr, neededBlocks*bytesPerBlock)
+	return ptr
+}
+
+// Find a run of free blocks of the given length. The search starts at
+// nextAlloc and wraps around at the end of the pool, so that the heap is used
+// like a bump allocator until the first garbage collection cycle.
+func findFreeBlocks(neededBlocks uintptr) (gcBlock, bool) {
+	if neededBlocks > uintptr(endBlock) {
+		return 0, false
+	}
+	numFreeBlocks := uintptr(0)
+	index := nextAlloc
+	for searched := gcBlock(0); searched < endBlock+gcBlock(neededBlocks); searched++ {
+		if index == endBlock {
+			// A run of free blocks cannot wrap around.
+			index = 0
+			numFreeBlocks = 0
+		}
+		if index.state() == blockStateFree {
+			numFreeBlocks++
+		} else {
+			numFreeBlocks = 0
+		}
+		index++
+		if numFreeBlocks == neededBlocks {
+			return index - gcBlock(neededBlocks), true
+		}
+	}
+	return 0, false
+}
+
+// Free the object at the given address. It must not be used afterwards.
+func free(ptr unsafe.Pointer) {
+	if !looksLikePointer(uintptr(ptr)) {
+		return
+	}
+	block := blockFromAddr(uintptr(ptr))
+	if block.state() != blockStateHead {
+		return
+	}
+	next := block.findNext()
+	for ; block < next; block++ {
+		block.setState(blockStateFree)
+	}
+}
+
+// Run a garbage collection cycle: mark all objects that are reachable from the
+// globals or the stack and free all other objects.
+func GC() {
+	if !heapInitialized {
+		return
+	}
+	markRoots(uintptr(unsafe.Pointer(&globalsStart)), uintptr(unsafe.Pointer(&globalsEnd)))
+	markRoots(getCurrentStackPointer(), uintptr(unsafe.Pointer(&stackTop)))
+	finishMark()
+	sweep()
+}
+
+// Return whether the given value points into the pool.
+func looksLikePointer(addr uintptr) bool {
+	return addr >= poolStart && addr < uintptr(metadataStart)
+}
+
+// Mark all objects that are pointed to by a word in the given memory range.
+func markRoots(start, end uintptr) {
+	const wordSize = unsafe.Sizeof(uintptr(0))
+	const wordAlign = unsafe.Alignof(uintptr(0))
+	for addr := start; addr+wordSize <= end; addr += wordAlign {
+		markRoot(*(*uintptr)(unsafe.Pointer(addr)))
+	}
+}
+
+// Mark the object the given value points to (if any) and queue it for
+// scanning.
+func markRoot(addr uintptr) {
+	if !looksLikePointer(addr) {
+		return
+	}
+	block := blockFromAddr(addr)
+	if block.state() == blockStateFree {
+		// Stale pointer to an object that was already freed.
+		return
+	}
+	head := block.findHead()
+	if head.state() == blockStateMark {
+		return
+	}
+	head.setState(blockStateMark)
+	if markQueueLen == len(markQueue) {
+		markQueueOverflow = true
+		return
+	}
+	markQueue[markQueueLen] = head
+	markQueueLen++
+}
+
+// Scan all queued objects until everything reachable has been marked.
+func finishMark() {
+	for {
+		for markQueueLen != 0 {
+			markQueueLen--
+			head := markQueue[markQueueLen]
+			markRoots(head.address(), head.findNext().address())
+		}
+		if !markQueueOverflow {
+			return
+		}
+		// Not all marked objects fit in the queue. Scan all marked objects
+		// again, which finds the ones that were never scanned.
+		markQueueOverflow = false
+		for block := gcBlock(0); block < endBlock; block++ {
+			if block.state() == blockStateMark {
+				markRoots(block.address(), block.findNext().address())
+			}
+		}
+	}
+}
+
+// Free all objects that were not marked and clear the mark of the others.
+func sweep() {
+	freeCurrentObject := false
+	for block := gcBlock(0); block < endBlock; block++ {
+		switch block.state() {
+		case blockStateHead:
+			block.setState(blockStateFree)
+			freeCurrentObject = true
+		case blockStateTail:
+			if freeCurrentObject {
+				block.setState(blockStateFree)
+			}
+		case blockStateMark:
+			block.setState(blockStateHead)
+			freeCurrentObject = false
+		}
+	}
+}
+
+func KeepAlive(x interface{}) {
+	// Unimplemented. Only required with SetFinalizer().
+}
+
+func SetFinalizer(obj interface{}, finalizer interface{}) {
+	// Unimplemented.
+}
@@ -6,19 +6,29 @@ package runtime
 //     https://golang.org/src/runtime/hashmap.go
 
 import (
+	"reflect"
 	"unsafe"
 )
 
 // The underlying hashmap structure for Go.
 type hashmap struct {
-	next       *hashmap       // hashmap after evacuate (for iterators)
 	buckets    unsafe.Pointer // pointer to array of buckets
 	count      lenType
-	keySize    uint8 // maybe this can store the key type as well? E.g. keysize == 5 means string?
+	keySize    uint8
 	valueSize  uint8
 	bucketBits uint8
+	keyKind    uint8      // how keys are hashed and compared, see hashmapKeyBinary etc.
+	keyType    typecodeID // typecode of the key, used for keys that aren't hashed bytewise
 }
 
+// The way keys of a hashmap are hashed and compared. Keep these in sync with
+// compiler/map.go.
+const (
+	hashmapKeyBinary  = iota // keys are hashed and compared bytewise
+	hashmapKeyString         // keys are strings
+	hashmapKeyGeneric        // keys are hashed and compared using their type descriptor
+)
+
 // A hashmap bucket. A bucket is a container of 8 key/value pairs: first the
 // following two entries, then the 8 keys, then the 8 values. This somewhat odd
 // ordering is to make sure the keys and values are well aligned when one of
@@ -30,17 +40,33 @@ type hashmapBucket struct {
 	// allocated but as they're of variable size they can't be shown here.
 }
 
+// The state of a range over a map. The iterator starts at a random bucket and
+// at a random slot within each bucket, so that programs don't depend on the
+// iteration order.
+//
+// The map may be modified while iterating. When the map grows, the iterator
+// keeps walking over the buckets it started with (which are not modified
+// anymore) and looks up every key in the new buckets, to skip deleted entries
+// and to return the current value.
 type hashmapIterator struct {
-	bucketNumber uintptr
-	bucket       *hashmapBucket
-	bucketIndex  uint8
+	buckets      unsafe.Pointer // buckets at the start of the iteration
+	numBuckets   uintptr        // number of buckets, or 0 if the iteration hasn't started
+	bucketNumber uintptr        // number of buckets visited (including the current one)
+	bucket       *hashmapBucket // current bucket in the chain
+	bucketIndex  uint8          // number of slots visited in the current bucket
+	startBucket  uintptr        // first bucket to visit
+	startIndex   uint8          // first slot to visit in each bucket
 }
 
 // Get FNV-1a hash of this key.
 //
 // https://en.wikipedia.org/wiki/Fowler%E2%80%93Noll%E2%80%93Vo_hash_function#FNV-1a_hash
 func hashmapHash(ptr unsafe.Pointer, n uintptr) uint32 {
-	var result uint32 = 2166136261 // FNV offset basis
+	return hashmapHashAdd(2166136261, ptr, n) // FNV offset basis
+}
+
+// Continue the FNV-1a hash with the given data.
+func hashmapHashAdd(result uint32, ptr unsafe.Pointer, n uintptr) uint32 {
 	for i := uintptr(0); i < n; i++ {
 		c := *(*uint8)(unsafe.Pointer(uintptr(ptr) + i))
 		result ^= uint32(c) // XOR with byte
@@ -59,16 +85,68 @@ func hashmapTopHash(hash uint32) uint8 {
 	return tophash
 }
 
-// Create a new hashmap with the given keySize and valueSize.
-func hashmapMake(keySize, valueSize uint8) *hashmap {
-	bucketBufSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(keySize)*8 + uintptr(valueSize)*8
-	bucket := alloc(bucketBufSize)
-	return &hashmap{
-		buckets:    bucket,
+// Hash the key at the given address, depending on the key kind of the map.
+func hashmapKeyHash(m *hashmap, key unsafe.Pointer) uint32 {
+	switch m.keyKind {
+	case hashmapKeyString:
+		return hashmapStringHash(*(*string)(key))
+	case hashmapKeyGeneric:
+		return hashmapGenericHash(m, key)
+	default:
+		return hashmapHash(key, uintptr(m.keySize))
+	}
+}
+
+// Compare the keys at the given addresses, depending on the key kind of the
+// map.
+func hashmapKeyEqual(m *hashmap, x, y unsafe.Pointer) bool {
+	switch m.keyKind {
+	case hashmapKeyString:
+		return *(*string)(x) == *(*string)(y)
+	case hashmapKeyGeneric:
+		return hashmapGenericEqual(m, x, y)
+	default:
+		return memequal(x, y, uintptr(m.keySize))
+	}
+}
+
+// Create a new hashmap with the given keySize and valueSize. The keyKind is one
+// of hashmapKeyBinary etc. and keyType is the typecode of the key type.
+func hashmapMake(keySize, valueSize, keyKind uint8, keyType typecodeID) *hashmap {
+	m := &hashmap{
 		keySize:    keySize,
 		valueSize:  valueSize,
 		bucketBits: 0,
+		keyKind:    keyKind,
+		keyType:    keyType,
 	}
+	m.buckets = alloc(hashmapBucketSize(m))
+	return m
+}
+
+// Return the size of a single bucket, including keys and values.
+func hashmapBucketSize(m *hashmap) uintptr {
+	return unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
+}
+
+// Return the bucket (the start of a chain) for the given hash.
+func hashmapBucketFor(m *hashmap, hash uint32) *hashmapBucket {
+	numBuckets := uintptr(1) << m.bucketBits
+	bucketNumber := (uintptr(hash) & (numBuckets - 1))
+	bucketAddr := uintptr(m.buckets) + hashmapBucketSize(m)*bucketNumber
+	return (*hashmapBucket)(unsafe.Pointer(bucketAddr))
+}
+
+// Return the address of the key in the given slot of a bucket.
+func hashmapSlotKey(m *hashmap, bucket *hashmapBucket, i uintptr) unsafe.Pointer {
+	slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*i
+	return unsafe.Pointer(uintptr(unsafe.Pointer(bucket)) + slotKeyOffset)
+}
+
+// Return the address of the value in the given slot of a bucket.
+func hashmapSlotValue(m *hashmap, bucket *hashmapBucket, i uintptr) unsafe.Pointer {
+	slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*i
+	return unsafe.Pointer(uintptr(unsafe.Pointer(bucket)) + slotValueOffset)
 }
 
 // Return the number of entries in this hashmap, called from the len builtin.
@@ -82,85 +160,101 @@ func hashmapLen(m *hashmap) int {
 
 // Set a specified key to a given value. Grow the map if necessary.
 //go:nobounds
-func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
-	numBuckets := uintptr(1) << m.bucketBits
-	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
-	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
-
+func hashmapSet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32) {
 	tophash := hashmapTopHash(hash)
 
 	// See whether the key already exists somewhere.
-	var emptySlotKey unsafe.Pointer
-	var emptySlotValue unsafe.Pointer
-	var emptySlotTophash *byte
-	for bucket != nil {
+	for bucket := hashmapBucketFor(m, hash); bucket != nil; bucket = bucket.next {
 		for i := uintptr(0); i < 8; i++ {
-			slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
-			slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
-			slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(i)
-			slotValue := unsafe.Pointer(bucketAddr + slotValueOffset)
-			if bucket.tophash[i] == 0 && emptySlotKey == nil {
-				// Found an empty slot, store it for if we couldn't find an
-				// existing slot.
-				emptySlotKey = slotKey
-				emptySlotValue = slotValue
-				emptySlotTophash = &bucket.tophash[i]
-			}
 			if bucket.tophash[i] == tophash {
 				// Could be an existing value that's the same.
-				if keyEqual(key, slotKey, uintptr(m.keySize)) {
+				if hashmapKeyEqual(m, key, hashmapSlotKey(m, bucket, i)) {
 					// found same key, replace it
-					memcpy(slotValue, value, uintptr(m.valueSize))
+					memcpy(hashmapSlotValue(m, bucket, i), value, uintptr(m.valueSize))
+					return
+				}
+				if unwinding {
+					// Comparing the keys panicked.
 					return
 				}
 			}
 		}
-		bucket = bucket.next
 	}
-	if emptySlotKey != nil {
-		m.count++
-		memcpy(emptySlotKey, key, uintptr(m.keySize))
-		memcpy(emptySlotValue, value, uintptr(m.valueSize))
-		*emptySlotTophash = tophash
-		return
+
+	// This is a new key. Grow the map when the buckets are on average more
+	// than 3/4 full, to keep the chains short.
+	numBuckets := uintptr(1) << m.bucketBits
+	if uintptr(m.count) >= numBuckets*6 {
+		hashmapGrow(m)
 	}
-	panic("todo: hashmap: grow bucket")
+	hashmapInsert(m, key, value, hash)
+	m.count++
 }
 
-// Get the value of a specified key, or zero the value if not found.
+// Insert a key that is not yet present in the map into the first empty slot
+// of its chain, adding a new bucket to the chain if all buckets are full.
 //go:nobounds
-func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) bool {
-	numBuckets := uintptr(1) << m.bucketBits
-	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
-	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
+func hashmapInsert(m *hashmap, key, value unsafe.Pointer, hash uint32) {
+	bucket := hashmapBucketFor(m, hash)
+	for {
+		for i := uintptr(0); i < 8; i++ {
+			if bucket.tophash[i] == 0 {
+				memcpy(hashmapSlotKey(m, bucket, i), key, uintptr(m.keySize))
+				memcpy(hashmapSlotValue(m, bucket, i), value, uintptr(m.valueSize))
+				bucket.tophash[i] = hashmapTopHash(hash)
+				return
+			}
+		}
+		if bucket.next == nil {
+			bucket.next = (*hashmapBucket)(alloc(hashmapBucketSize(m)))
+		}
+		bucket = bucket.next
+	}
+}
 
-	tophash := uint8(hash >> 24)
-	if tophash < 1 {
-		// 0 means empty slot, so make it bigger.
-		tophash += 1
+// Double the number of buckets and move all entries to the new buckets. The
+// old buckets are not modified, as a map iterator may still be using them.
+//go:nobounds
+func hashmapGrow(m *hashmap) {
+	oldBuckets := m.buckets
+	oldNumBuckets := uintptr(1) << m.bucketBits
+	m.bucketBits++
+	m.buckets = alloc(hashmapBucketSize(m) << m.bucketBits)
+	for bucketNumber := uintptr(0); bucketNumber < oldNumBuckets; bucketNumber++ {
+		bucketAddr := uintptr(oldBuckets) + hashmapBucketSize(m)*bucketNumber
+		for bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr)); bucket != nil; bucket = bucket.next {
+			for i := uintptr(0); i < 8; i++ {
+				if bucket.tophash[i] == 0 {
+					continue
+				}
+				key := hashmapSlotKey(m, bucket, i)
+				hashmapInsert(m, key, hashmapSlotValue(m, bucket, i), hashmapKeyHash(m, key))
+			}
+		}
 	}
+}
+
+// Get the value of a specified key, or zero the value if not found.
+//go:nobounds
+func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint32) bool {
+	tophash := hashmapTopHash(hash)
 
 	// Try to find the key.
-	for bucket != nil {
+	for bucket := hashmapBucketFor(m, hash); bucket != nil; bucket = bucket.next {
 		for i := uintptr(0); i < 8; i++ {
-			slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
-			slotKey := unsafe.Pointer(uintptr(unsafe.Pointer(bucket)) + slotKeyOffset)
-			slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(i)
-			slotValue := unsafe.Pointer(uintptr(unsafe.Pointer(bucket)) + slotValueOffset)
 			if bucket.tophash[i] == tophash {
 				// This could be the key we're looking for.
-				if keyEqual(key, slotKey, uintptr(m.keySize)) {
+				if hashmapKeyEqual(m, key, hashmapSlotKey(m, bucket, i)) {
 					// Found the key, copy it.
-					memcpy(value, slotValue, uintptr(m.valueSize))
+					memcpy(value, hashmapSlotValue(m, bucket, i), uintptr(m.valueSize))
 					return true
 				}
+				if unwinding {
+					// Comparing the keys panicked.
+					return false
+				}
 			}
 		}
-		bucket = bucket.next
 	}
 
 	// Did not find the key.
@@ -171,42 +265,43 @@ func hashmapGet(m *hashmap, key unsafe.Pointer, value unsafe.Pointer, hash uint3
 // Delete a given key from the map. No-op when the key does not exist in the
 // map.
 //go:nobounds
-func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32, keyEqual func(x, y unsafe.Pointer, n uintptr) bool) {
-	numBuckets := uintptr(1) << m.bucketBits
-	bucketNumber := (uintptr(hash) & (numBuckets - 1))
-	bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-	bucketAddr := uintptr(m.buckets) + bucketSize*bucketNumber
-	bucket := (*hashmapBucket)(unsafe.Pointer(bucketAddr))
-
-	tophash := uint8(hash >> 24)
-	if tophash < 1 {
-		// 0 means empty slot, so make it bigger.
-		tophash += 1
-	}
+func hashmapDelete(m *hashmap, key unsafe.Pointer, hash uint32) {
+	tophash := hashmapTopHash(hash)
 
 	// Try to find the key.
-	for bucket != nil {
+	for bucket := hashmapBucketFor(m, hash); bucket != nil; bucket = bucket.next {
 		for i := uintptr(0); i < 8; i++ {
-			slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(i)
-			slotKey := unsafe.Pointer(uintptr(unsafe.Pointer(bucket)) + slotKeyOffset)
 			if bucket.tophash[i] == tophash {
 				// This could be the key we're looking for.
-				if keyEqual(key, slotKey, uintptr(m.keySize)) {
+				if hashmapKeyEqual(m, key, hashmapSlotKey(m, bucket, i)) {
 					// Found the key, delete it.
 					bucket.tophash[i] = 0
 					m.count--
 					return
 				}
+				if unwinding {
+					// Comparing the keys panicked.
+					return
+				}
 			}
 		}
-		bucket = bucket.next
 	}
 }
 
 // Iterate over a hashmap.
 //go:nobounds
 func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) bool {
-	numBuckets := uintptr(1) << m.bucketBits
+	if m == nil {
+		// A nil map has no entries.
+		return false
+	}
+	if it.numBuckets == 0 {
+		// Start the iteration at a random position.
+		it.buckets = m.buckets
+		it.numBuckets = uintptr(1) << m.bucketBits
+		it.startBucket = uintptr(fastrand()) & (it.numBuckets - 1)
+		it.startIndex = uint8(fastrand() & 7)
+	}
 	for {
 		if it.bucketIndex >= 8 {
 			// end of bucket, move to the next in the chain
@@ -214,30 +309,34 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 			it.bucket = it.bucket.next
 		}
 		if it.bucket == nil {
-			if it.bucketNumber >= numBuckets {
+			if it.bucketNumber >= it.numBuckets {
 				// went through all buckets
 				return false
 			}
-			bucketSize := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*8
-			bucketAddr := uintptr(m.buckets) + bucketSize*it.bucketNumber
+			bucketNumber := (it.startBucket + it.bucketNumber) & (it.numBuckets - 1)
+			bucketAddr := uintptr(it.buckets) + hashmapBucketSize(m)*bucketNumber
 			it.bucket = (*hashmapBucket)(unsafe.Pointer(bucketAddr))
 			it.bucketNumber++ // next bucket
 		}
-		if it.bucket.tophash[it.bucketIndex] == 0 {
+		i := uintptr((it.startIndex + it.bucketIndex) & 7)
+		it.bucketIndex++
+		if it.bucket.tophash[i] == 0 {
 			// slot is empty - move on
-			it.bucketIndex++
 			continue
 		}
 
-		bucketAddr := uintptr(unsafe.Pointer(it.bucket))
-		slotKeyOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*uintptr(it.bucketIndex)
-		slotKey := unsafe.Pointer(bucketAddr + slotKeyOffset)
-		slotValueOffset := unsafe.Sizeof(hashmapBucket{}) + uintptr(m.keySize)*8 + uintptr(m.valueSize)*uintptr(it.bucketIndex)
-		slotValue := unsafe.Pointer(bucketAddr + slotValueOffset)
-		memcpy(key, slotKey, uintptr(m.keySize))
-		memcpy(value, slotValue, uintptr(m.valueSize))
-		it.bucketIndex++
-
+		memcpy(key, hashmapSlotKey(m, it.bucket, i), uintptr(m.keySize))
+		if it.buckets != m.buckets && hashmapKeyEqual(m, key, key) {
+			// The map has grown during the iteration, so this entry may have
+			// been deleted or changed since. Look it up in the current
+			// buckets. (Keys that aren't equal to themselves, like NaN, can't
+			// be looked up but also can't be deleted or changed).
+			if !hashmapGet(m, key, value, hashmapKeyHash(m, key)) {
+				continue
+			}
+			return true
+		}
+		memcpy(value, hashmapSlotValue(m, it.bucket, i), uintptr(m.valueSize))
 		return true
 	}
 }
@@ -246,25 +345,21 @@ func hashmapNext(m *hashmap, it *hashmapIterator, key, value unsafe.Pointer) boo
 
 func hashmapBinarySet(m *hashmap, key, value unsafe.Pointer) {
 	hash := hashmapHash(key, uintptr(m.keySize))
-	hashmapSet(m, key, value, hash, memequal)
+	hashmapSet(m, key, value, hash)
 }
 
 func hashmapBinaryGet(m *hashmap, key, value unsafe.Pointer) bool {
 	hash := hashmapHash(key, uintptr(m.keySize))
-	return hashmapGet(m, key, value, hash, memequal)
+	return hashmapGet(m, key, value, hash)
 }
 
 func hashmapBinaryDelete(m *hashmap, key unsafe.Pointer) {
 	hash := hashmapHash(key, uintptr(m.keySize))
-	hashmapDelete(m, key, hash, memequal)
+	hashmapDelete(m, key, hash)
 }
 
 // Hashmap with string keys (a common case).
 
-func hashmapStringEqual(x, y unsafe.Pointer, n uintptr) bool {
-	return *(*string)(x) == *(*string)(y)
-}
-
 func hashmapStringHash(s string) uint32 {
 	_s := (*_string)(unsafe.Pointer(&s))
 	return hashmapHash(unsafe.Pointer(_s.ptr), uintptr(_s.length))
@@ -272,15 +367,135 @@ func hashmapStringHash(s string) uint32 {
 
 func hashmapStringSet(m *hashmap, key string, value unsafe.Pointer) {
 	hash := hashmapStringHash(key)
-	hashmapSet(m, unsafe.Pointer(&key), value, hash, hashmapStringEqual)
+	hashmapSet(m, unsafe.Pointer(&key), value, hash)
 }
 
 func hashmapStringGet(m *hashmap, key string, value unsafe.Pointer) bool {
 	hash := hashmapStringHash(key)
-	return hashmapGet(m, unsafe.Pointer(&key), value, hash, hashmapStringEqual)
+	return hashmapGet(m, unsafe.Pointer(&key), value, hash)
 }
 
 func hashmapStringDelete(m *hashmap, key string) {
 	hash := hashmapStringHash(key)
-	hashmapDelete(m, unsafe.Pointer(&key), hash, hashmapStringEqual)
+	hashmapDelete(m, unsafe.Pointer(&key), hash)
+}
+
+// Hashmap with keys that can't be hashed and compared bytewise, like floats,
+// interfaces and structs containing strings. These keys are hashed and compared
+// by walking over the type descriptor of the key type.
+
+// Return the key at the given address as a reflect.Value.
+func hashmapGenericKey(m *hashmap, key unsafe.Pointer) reflect.Value {
+	itf := _interface{typecode: m.keyType}
+	if uintptr(m.keySize) > unsafe.Sizeof(uintptr(0)) {
+		itf.value = (*uint8)(key)
+	} else {
+		// Small values are stored directly in the interface.
+		memcpy(unsafe.Pointer(&itf.value), key, uintptr(m.keySize))
+	}
+	return reflect.ValueOf(*(*interface{})(unsafe.Pointer(&itf)))
+}
+
+func hashmapGenericEqual(m *hashmap, x, y unsafe.Pointer) bool {
+	return reflectValueEqual(hashmapGenericKey(m, x), hashmapGenericKey(m, y))
+}
+
+func hashmapGenericHash(m *hashmap, key unsafe.Pointer) uint32 {
+	return hashmapValueHash(2166136261, hashmapGenericKey(m, key)) // FNV offset basis
+}
+
+// Hash a value of a comparable type. Values that are equal according to
+// reflectValueEqual have the same hash: for example, the floats +0 and -0.
+//
+// The compiler calculates the same hash for maps that are created at compile
+// time. Keep this in sync with compiler/map.go.
+func hashmapValueHash(hash uint32, v reflect.Value) uint32 {
+	switch v.Kind() {
+	case reflect.Bool:
+		b := v.Bool()
+		return hashmapHashAdd(hash, unsafe.Pointer(&b), 1)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		return hashmapHashAdd(hash, unsafe.Pointer(&n), 8)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		return hashmapHashAdd(hash, unsafe.Pointer(&n), 8)
+	case reflect.Float32, reflect.Float64:
+		return hashmapFloatHash(hash, v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		c := v.Complex()
+		return hashmapFloatHash(hashmapFloatHash(hash, real(c)), imag(c))
+	case reflect.String:
+		s := v.String()
+		_s := (*_string)(unsafe.Pointer(&s))
+		return hashmapHashAdd(hash, unsafe.Pointer(_s.ptr), uintptr(_s.length))
+	case reflect.Chan, reflect.Ptr, reflect.UnsafePointer:
+		p := v.Pointer()
+		return hashmapHashAdd(hash, unsafe.Pointer(&p), unsafe.Sizeof(p))
+	case reflect.Interface:
+		// Hash the dynamic type and then the dynamic value.
+		elem := v.Elem()
+		typecode := typecodeID(elem.Type())
+		hash = hashmapHashAdd(hash, unsafe.Pointer(&typecode), unsafe.Sizeof(typecode))
+		if typecode == 0 {
+			// nil interface
+			return hash
+		}
+		return hashmapValueHash(hash, elem)
+	case reflect.Array:
+		for i := 0; i < v.Len() && !unwinding; i++ {
+			hash = hashmapValueHash(hash, v.Index(i))
+		}
+		return hash
+	case reflect.Struct:
+		for i := 0; i < v.NumField() && !unwinding; i++ {
+			if v.Type().Field(i).Name == "_" {
+				// Blank fields are ignored when comparing structs.
+				continue
+			}
+			hash = hashmapValueHash(hash, v.Field(i))
+		}
+		return hash
+	default:
+		// The caller must check for the panic before using the hash.
+		runtimePanic("hash of unhashable type")
+		return 0
+	}
+}
+
+// Hash a float. The floats +0 and -0 are equal, so they must have the same
+// hash.
+func hashmapFloatHash(hash uint32, f float64) uint32 {
+	if f == 0 {
+		f = 0 // convert -0 to +0
+	}
+	return hashmapHashAdd(hash, unsafe.Pointer(&f), 8)
+}
+
+// The hash of a generic key panics when the dynamic type of an interface in
+// the key isn't comparable, so these functions return early while unwinding,
+// before the map is modified.
+
+func hashmapGenericSet(m *hashmap, key, value unsafe.Pointer) {
+	hash := hashmapGenericHash(m, key)
+	if unwinding {
+		return
+	}
+	hashmapSet(m, key, value, hash)
+}
+
+func hashmapGenericGet(m *hashmap, key, value unsafe.Pointer) bool {
+	hash := hashmapGenericHash(m, key)
+	if unwinding {
+		return false
+	}
+	return hashmapGet(m, key, value, hash)
+}
+
+func hashmapGenericDelete(m *hashmap, key unsafe.Pointer) {
+	hash := hashmapGenericHash(m, key)
+	if unwinding {
+		return
+	}
+	hashmapDelete(m, key, hash)
 }
@@ -9,7 +9,8 @@ package runtime
 // contain the name and the signature of the function (to save space), think of
 // signatures as interned strings at compile time.
 //
-// The typecode is a small number unique for the Go type. All typecodes <
+// The typecode is a small number unique for the Go type. It is 16 bits wide on
+// small targets and 32 bits wide elsewhere, see typecodeID. All typecodes <
 // firstTypeWithMethods do not have any methods and typecodes >=
 // firstTypeWithMethods all have at least one method. This means that
 // methodSetRanges does not need to contain types without methods and is thus
@@ -20,35 +21,40 @@ package runtime
 // just indexes into methodSetSignatures and methodSetFunctions which contains
 // the mapping from uniqued signature to function pointer.
 
+import (
+	"reflect"
+	"unsafe"
+)
+
 type _interface struct {
-	typecode uint16
+	typecode typecodeID
 	value    *uint8
 }
 
 // This struct indicates the range of methods in the methodSetSignatures and
 // methodSetFunctions arrays that belong to this named type.
 type methodSetRange struct {
-	index  uint16 // start index into interfaceSignatures and interfaceFunctions
-	length uint16 // number of methods
+	index  typecodeID // start index into interfaceSignatures and interfaceFunctions
+	length typecodeID // number of methods
 }
 
 // Global constants that will be set by the compiler. The arrays are of size 0,
 // which is a dummy value, but will be bigger after the compiler has filled them
 // in.
 var (
-	firstTypeWithMethods uint16            // the lowest typecode that has at least one method
+	firstTypeWithMethods typecodeID        // the lowest typecode that has at least one method
 	methodSetRanges      [0]methodSetRange // indices into methodSetSignatures and methodSetFunctions
-	methodSetSignatures  [0]uint16         // uniqued method ID
+	methodSetSignatures  [0]typecodeID     // uniqued method ID
 	methodSetFunctions   [0]*uint8         // function pointer of method
-	interfaceIndex       [0]uint16         // mapping from interface ID to an index in interfaceMethods
-	interfaceLengths     [0]uint8          // mapping from interface ID to the number of methods it has
-	interfaceMethods     [0]uint16         // the method an interface implements (list of method IDs)
+	interfaceIndex       [0]typecodeID     // mapping from interface ID to an index in interfaceMethods
+	interfaceLengths     [0]typecodeID     // mapping from interface ID to the number of methods it has
+	interfaceMethods     [0]typecodeID     // the method an interface implements (list of method IDs)
 )
 
 // Get the function pointer for the method on the interface.
 // This is a compiler intrinsic.
 //go:nobounds
-func interfaceMethod(typecode uint16, method uint16) *uint8 {
+func interfaceMethod(typecode typecodeID, method typecodeID) *uint8 {
 	// This function doesn't do bounds checking as the supplied method must be
 	// in the list of signatures. The compiler will only emit
 	// runtime.interfaceMethod calls when the method actually exists on this
@@ -72,18 +78,75 @@ func interfaceEqual(x, y _interface) bool {
 		// Both interfaces are nil, so they are equal.
 		return true
 	}
-	// TODO: depends on reflection.
-	panic("unimplemented: interface equality")
+	vx := reflect.ValueOf(*(*interface{})(unsafe.Pointer(&x)))
+	vy := reflect.ValueOf(*(*interface{})(unsafe.Pointer(&y)))
+	return reflectValueEqual(vx, vy)
+}
+
+// Return true iff both values are equal, following the rules of the ==
+// operator. Both values must be of the same type. Comparing values of types
+// that are not comparable (like slices) results in a runtime panic.
+func reflectValueEqual(x, y reflect.Value) bool {
+	switch x.Kind() {
+	case reflect.Invalid:
+		// Both are the contents of a nil interface.
+		return true
+	case reflect.Bool:
+		return x.Bool() == y.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return x.Int() == y.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return x.Uint() == y.Uint()
+	case reflect.Float32, reflect.Float64:
+		return x.Float() == y.Float()
+	case reflect.Complex64, reflect.Complex128:
+		cx := x.Complex()
+		cy := y.Complex()
+		return real(cx) == real(cy) && imag(cx) == imag(cy)
+	case reflect.String:
+		return x.String() == y.String()
+	case reflect.Chan, reflect.Ptr, reflect.UnsafePointer:
+		return x.Pointer() == y.Pointer()
+	case reflect.Interface:
+		x = x.Elem()
+		y = y.Elem()
+		if x.Type() != y.Type() {
+			// Different dynamic type so always unequal.
+			return false
+		}
+		return reflectValueEqual(x, y)
+	case reflect.Array:
+		for i := 0; i < x.Len(); i++ {
+			if !reflectValueEqual(x.Index(i), y.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < x.NumField(); i++ {
+			if x.Type().Field(i).Name == "_" {
+				// Blank fields are ignored when comparing structs.
+				continue
+			}
+			if !reflectValueEqual(x.Field(i), y.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		runtimePanic("comparing uncomparable type " + x.Type().String())
+		return false
+	}
 }
 
 // Return true iff the type implements all methods needed by the interface. This
 // means the type satisfies the interface.
 // This is a compiler intrinsic.
 //go:nobounds
-func interfaceImplements(typecode, interfaceNum uint16) bool {
+func interfaceImplements(typecode, interfaceNum typecodeID) bool {
 	// method set indices of the interface
 	itfIndex := interfaceIndex[interfaceNum]
-	itfIndexEnd := itfIndex + uint16(interfaceLengths[interfaceNum])
+	itfIndexEnd := itfIndex + interfaceLengths[interfaceNum]
 
 	if itfIndex == itfIndexEnd {
 		// This interface has no methods, so it satisfies all types.
@@ -1,4 +1,4 @@
-// +build js,tinygo.arm avr
+// +build avr
 
 package runtime
 
@@ -1,30 +1,224 @@
 package runtime
 
+import "unsafe"
+
 // trap is a compiler hint that this function cannot be executed. It is
 // translated into either a trap instruction or a call to abort().
 //go:linkname trap llvm.trap
 func trap()
 
+// Panics are implemented by unwinding the stack. A panic is recorded in
+// currentPanic and sets the unwinding flag, after which the panicking function
+// simply returns. The compiler inserts a check for this flag after every call
+// (and after every runtime call that may panic). When it is set, the calling
+// function runs its deferred calls and returns as well, until a deferred call
+// recovers the panic. The function that deferred this call then continues in
+// its recover block, which returns to its caller as usual.
+//
+// Unwinding is only necessary when the program defers a call or calls
+// recover() somewhere. Without either, there is nothing to run on a panic and
+// it is reported immediately.
+//
+// A panic that is not recovered is reported when it has unwound the whole
+// goroutine, after all deferred calls ran (see panicTaskDone).
+//
+// Like in gc, recover() only stops a panic when it is called directly by a
+// deferred call. panicRunDefers sets directDeferCall just before running a
+// deferred call, and the compiler inserts a call to deferCallEnter at the start
+// of every function that calls recover(). The wrapper that runs a deferred call
+// clears the flag when the deferred function doesn't call recover() itself, so
+// functions called by it never see the flag.
+
+// A panic that is in progress. A panic that happens while running deferred
+// calls is put on top of the panic that was already in progress.
+type panicState struct {
+	value     interface{}
+	recovered bool
+	next      *panicState
+	pcs       []uintptr // stack at the time of the panic, see savePanicStack
+}
+
+// A panic that is passed on to a parent coroutine when a coroutine returns
+// because of a panic. The parent continues unwinding once it is resumed.
+type taskPanic struct {
+	t     *coroutine
+	panic *panicState
+	next  *taskPanic
+}
+
+// Set by the compiler when the program defers a call or calls recover().
+var unwindEnabled bool
+
+var (
+	currentPanic    *panicState // panic in progress in the running goroutine
+	unwinding       bool        // whether returning from functions because of a panic
+	taskPanics      *taskPanic  // panics waiting for a parent coroutine
+	directDeferCall bool        // whether a deferred call is being started by panicRunDefers
+)
+
+// Error is the interface implemented by run time panics, like an index out of
+// range.
+type Error interface {
+	error
+
+	// RuntimeError is a no-op function but serves to distinguish types that
+	// are run time errors from ordinary errors.
+	RuntimeError()
+}
+
+// A value that has a String method, like a fmt.Stringer. Panic values that
+// implement it are printed using this method.
+type stringer interface {
+	String() string
+}
+
+// The panic value of a run time panic.
+type runtimeError string
+
+func (e runtimeError) Error() string {
+	return "runtime error: " + string(e)
+}
+
+func (e runtimeError) RuntimeError() {}
+
 // Builtin function panic(msg), used as a compiler intrinsic.
 func _panic(message interface{}) {
-	printstring("panic: ")
-	printitf(message)
-	printnl()
-	abort()
+	if !unwindEnabled {
+		panicAbort(message)
+	}
+	currentPanic = &panicState{value: message, next: currentPanic, pcs: savePanicStack()}
+	unwinding = true
 }
 
-// Cause a runtime panic, which is (currently) always a string.
+// Cause a runtime panic, which is (currently) always a string. Like any other
+// panic, it returns while unwinding the stack.
 func runtimePanic(msg string) {
-	printstring("panic: runtime error: ")
+	_panic(runtimeError(msg))
+}
+
+// Report a fatal error in the runtime, that cannot be recovered.
+func runtimeFatal(msg string) {
+	printstring("fatal error: ")
 	println(msg)
 	abort()
 }
 
-// Try to recover a panicking goroutine.
-func _recover() interface{} {
-	// Deferred functions are currently not executed during panic, so there is
-	// no way this can return anything besides nil.
-	return nil
+// Report a panic that was not recovered and abort.
+func panicAbort(message interface{}) {
+	var pcs [maxBacktrace]uintptr
+	n := Callers(1, pcs[:])
+	panicAbortBacktrace(message, pcs[:n])
+}
+
+// Report a panic that was not recovered, with the stack at the time of the
+// panic, and abort.
+func panicAbortBacktrace(message interface{}, pcs []uintptr) {
+	printstring("panic: ")
+	switch msg := message.(type) {
+	case runtimeError:
+		// Print directly, without allocating a new string as Error() does.
+		printstring("runtime error: ")
+		printstring(string(msg))
+	case error:
+		printstring(msg.Error())
+	case stringer:
+		printstring(msg.String())
+	default:
+		printitf(message)
+	}
+	printnl()
+	printBacktrace(pcs)
+	abort()
+}
+
+// Try to recover a panicking goroutine. This only returns the panic value
+// when called directly from a deferred call that is run because of a panic,
+// which is passed in by the compiler as the result of deferCallEnter.
+func _recover(deferCall bool) interface{} {
+	p := currentPanic
+	if !deferCall || p == nil || unwinding || p.recovered {
+		return nil
+	}
+	p.recovered = true
+	return p.value
+}
+
+// Called at the start of every function that calls recover(). It returns
+// whether this function was called directly as a deferred call by
+// panicRunDefers, so that recover() may stop the panic.
+//
+// This is a compiler intrinsic.
+func deferCallEnter() bool {
+	deferCall := directDeferCall
+	directDeferCall = false
+	return deferCall
+}
+
+// Run the deferred calls of a function that is unwinding because of a panic.
+// When one of the deferred calls recovered the panic, it returns true and the
+// function continues in its recover block. Otherwise, the function continues
+// unwinding.
+//
+// This is a compiler intrinsic.
+func panicRunDefers(stack **_defer) bool {
+	p := currentPanic
+	unwinding = false
+	for *stack != nil {
+		d := *stack
+		*stack = d.next
+		directDeferCall = true
+		d.callback(d)
+		directDeferCall = false
+		if unwinding {
+			// The deferred call panicked, and the new panic replaces the
+			// panic that was in progress.
+			currentPanic.next = p.next
+			p = currentPanic
+			unwinding = false
+		}
+	}
+	if p.recovered {
+		currentPanic = p.next
+		return true
+	}
+	unwinding = true
+	return false
+}
+
+// Called when a coroutine returns, before the parent is re-activated. When the
+// coroutine returned because of a panic, the panic is passed on to the parent
+// which will continue unwinding once it resumes.
+//
+// The parent is nil at the root of a goroutine or when main.main or an init
+// function returns. A panic that is still unwinding at that point was not
+// recovered, which is fatal.
+//
+// This is a compiler intrinsic.
+func panicTaskDone(parent *coroutine) {
+	if !unwinding {
+		return
+	}
+	if parent == nil {
+		panicAbortBacktrace(currentPanic.value, currentPanic.pcs)
+	}
+	taskPanics = &taskPanic{t: parent, panic: currentPanic, next: taskPanics}
+	currentPanic = nil
+	unwinding = false
+}
+
+// Called when a coroutine resumes after calling a blocking function. It
+// continues unwinding when the callee returned because of a panic.
+//
+// This is a compiler intrinsic.
+func panicTaskResume(t *coroutine) {
+	for list := &taskPanics; *list != nil; list = &(*list).next {
+		if (*list).t == t {
+			currentPanic = (*list).panic
+			unwinding = true
+			*list = (*list).next
+			return
+		}
+	}
 }
 
 // Check for bounds in *ssa.Index, *ssa.IndexAddr and *ssa.Lookup.
@@ -42,6 +236,43 @@ func lookupBoundsCheckLong(length lenType, index int64) {
 	}
 }
 
+// Check for a nil pointer before it is dereferenced.
+func nilPointerCheck(ptr unsafe.Pointer) {
+	if ptr == nil {
+		runtimePanic("invalid memory address or nil pointer dereference")
+	}
+}
+
+// Check for division by zero in integer division and remainder operations.
+func divideByZeroCheck(divisor uint) {
+	if divisor == 0 {
+		runtimePanic("integer divide by zero")
+	}
+}
+
+// Check for division by zero in integer division and remainder operations.
+// Supports 64-bit divisors.
+func divideByZeroCheckLong(divisor uint64) {
+	if divisor == 0 {
+		runtimePanic("integer divide by zero")
+	}
+}
+
+// Check for negative shift counts in shift operations.
+func shiftNegativeCheck(shift int) {
+	if shift < 0 {
+		runtimePanic("negative shift amount")
+	}
+}
+
+// Check for negative shift counts in shift operations. Supports 64-bit shift
+// counts.
+func shiftNegativeCheckLong(shift int64) {
+	if shift < 0 {
+		runtimePanic("negative shift amount")
+	}
+}
+
 // Check for bounds in *ssa.Slice.
 func sliceBoundsCheck(capacity lenType, low, high uint) {
 	if !(0 <= low && low <= high && high <= uint(capacity)) {
@@ -56,6 +287,21 @@ func sliceBoundsCheckLong(capacity lenType, low, high uint64) {
 	}
 }
 
+// Check for bounds in *ssa.Slice with a max index (s[low:high:max]).
+func sliceBoundsCheck3(capacity lenType, low, high, max uint) {
+	if !(0 <= low && low <= high && high <= max && max <= uint(capacity)) {
+		runtimePanic("slice out of range")
+	}
+}
+
+// Check for bounds in *ssa.Slice with a max index (s[low:high:max]). Supports
+// 64-bit indexes.
+func sliceBoundsCheck3Long(capacity lenType, low, high, max uint64) {
+	if !(0 <= low && low <= high && high <= max && max <= uint64(capacity)) {
+		runtimePanic("slice out of range")
+	}
+}
+
 // Check for bounds in *ssa.MakeSlice.
 func sliceBoundsCheckMake(length, capacity uint) {
 	if !(0 <= length && length <= capacity) {
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"reflect"
 	"unsafe"
 )
 
@@ -39,7 +40,7 @@ func printuint16(n uint16) {
 	printuint32(uint32(n))
 }
 
-func printint16(n uint16) {
+func printint16(n int16) {
 	printint32(int32(n))
 }
 
@@ -197,18 +198,80 @@ func printnl() {
 	putchar('\n')
 }
 
+// Set by the compiler when the program includes type names, see printitf.
+var typeNamesEnabled bool
+
+// Print an interface value in the same way gc prints a panic value: values of
+// basic types are printed as-is, values of other named basic types are printed
+// with their type name, and other values are printed as their type name and
+// value pointer. Without type names, the typecode and value are printed
+// instead.
 func printitf(msg interface{}) {
+	itf := *(*_interface)(unsafe.Pointer(&msg))
+	if itf.typecode == 0 {
+		// Checked here instead of in the type switch, as comparing interfaces
+		// needs the reflect package.
+		print("nil")
+		return
+	}
 	switch msg := msg.(type) {
+	case bool:
+		print(msg)
+	case int:
+		print(msg)
+	case int8:
+		print(msg)
+	case int16:
+		print(msg)
+	case int32:
+		print(msg)
+	case int64:
+		print(msg)
+	case uint:
+		print(msg)
+	case uint8:
+		print(msg)
+	case uint16:
+		print(msg)
+	case uint32:
+		print(msg)
+	case uint64:
+		print(msg)
+	case uintptr:
+		print(msg)
+	case float32:
+		print(msg)
+	case float64:
+		print(msg)
+	case complex64:
+		print(msg)
+	case complex128:
+		print(msg)
 	case string:
 		print(msg)
 	default:
-		// cast to underlying type
-		itf := *(*_interface)(unsafe.Pointer(&msg))
-		putchar('(')
-		print(itf.typecode)
-		putchar(':')
-		print(itf.value)
-		putchar(')')
+		if !typeNamesEnabled {
+			print("(", itf.typecode, ":", itf.value, ")")
+			return
+		}
+		v := reflect.ValueOf(msg)
+		typeName := v.Type().String()
+		switch v.Kind() {
+		case reflect.Bool:
+			print(typeName, "(", v.Bool(), ")")
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			print(typeName, "(", v.Int(), ")")
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			print(typeName, "(", v.Uint(), ")")
+		case reflect.Float32, reflect.Float64:
+			print(typeName, "(", v.Float(), ")")
+		case reflect.Complex64, reflect.Complex128:
+			print(typeName, v.Complex())
+		case reflect.String:
+			print(typeName, "(\"", v.String(), "\")")
+		default:
+			print("(", typeName, ") ", itf.value)
+		}
 	}
 }
 
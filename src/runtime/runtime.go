@@ -92,6 +92,27 @@ func now() (sec int64, nsec int32, mono int64) {
 	return
 }
 
+var fastrandState uint32
+
+// Return a pseudorandom number, for example to pick a random case in a select
+// statement or the starting point of a map iteration. This uses xorshift,
+// which is fast and small but certainly not cryptographically secure. It is
+// seeded from the system timer on first use.
+func fastrand() uint32 {
+	x := fastrandState
+	if x == 0 {
+		x = uint32(ticks()) ^ 0x9e3779b9
+		if x == 0 {
+			x = 0x9e3779b9
+		}
+	}
+	x ^= x << 13
+	x ^= x >> 17
+	x ^= x << 5
+	fastrandState = x
+	return x
+}
+
 // Copied from the Go runtime source code.
 //go:linkname os_sigpipe os.sigpipe
 func os_sigpipe() {
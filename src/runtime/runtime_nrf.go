@@ -24,6 +24,11 @@ func main() {
 	abort()
 }
 
+// Called by os.Exit. There is nothing to return to, so stop like after main.
+func exit(code int) {
+	abort()
+}
+
 func init() {
 	machine.UART0.Configure(machine.UARTConfig{})
 	initLFCLK()
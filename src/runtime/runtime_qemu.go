@@ -21,7 +21,17 @@ func main() {
 	preinit()
 	initAll()
 	mainWrapper()
-	arm.SemihostingCall(arm.SemihostingReportException, arm.SemihostingApplicationExit)
+	exit(0)
+}
+
+// Called by os.Exit. Stop QEMU, which exits with status 0 on an application
+// exit and with status 1 otherwise.
+func exit(code int) {
+	reason := arm.SemihostingApplicationExit
+	if code != 0 {
+		reason = arm.SemihostingRunTimeErrorUnknown
+	}
+	arm.SemihostingCall(arm.SemihostingReportException, uintptr(reason))
 	abort()
 }
 
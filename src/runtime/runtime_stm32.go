@@ -18,6 +18,11 @@ func main() {
 	abort()
 }
 
+// Called by os.Exit. There is nothing to return to, so stop like after main.
+func exit(code int) {
+	abort()
+}
+
 func putchar(c byte) {
 	// TODO
 }
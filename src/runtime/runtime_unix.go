@@ -1,4 +1,4 @@
-// +build linux
+// +build linux,!baremetal
 
 package runtime
 
@@ -10,6 +10,7 @@ func _Cfunc_putchar(c int) int
 func _Cfunc_usleep(usec uint) int
 func _Cfunc_calloc(nmemb, size uintptr) unsafe.Pointer
 func _Cfunc_abort()
+func _Cfunc_exit(status int)
 func _Cfunc_clock_gettime(clk_id uint, ts *timespec)
 
 type timeUnit int64
@@ -63,10 +64,14 @@ func abort() {
 	_Cfunc_abort()
 }
 
+func exit(code int) {
+	_Cfunc_exit(code)
+}
+
 func alloc(size uintptr) unsafe.Pointer {
 	buf := _Cfunc_calloc(1, size)
 	if buf == nil {
-		runtimePanic("cannot allocate memory")
+		runtimeFatal("cannot allocate memory")
 	}
 	return buf
 }
@@ -3,9 +3,10 @@ package runtime
 // This file implements the Go scheduler using coroutines.
 // A goroutine contains a whole stack. A coroutine is just a single function.
 // How do we use coroutines for goroutines, then?
-//   * Every function that contains a blocking call (like sleep) is marked
-//     blocking, and all it's parents (callers) are marked blocking as well
-//     transitively until the root (main.main or a go statement).
+//   * Every function that contains a blocking call (like sleep, a channel
+//     operation or waiting on a mutex) is marked blocking, and all it's
+//     parents (callers) are marked blocking as well transitively until the
+//     root (main.main or a go statement).
 //   * A blocking function that calls a non-blocking function is called as
 //     usual.
 //   * A blocking function that calls a blocking function passes its own
@@ -61,7 +62,11 @@ type taskState struct {
 const (
 	TASK_STATE_RUNNABLE = iota
 	TASK_STATE_SLEEP
-	TASK_STATE_CALL // waiting for a sub-coroutine
+	TASK_STATE_CALL        // waiting for a sub-coroutine
+	TASK_STATE_CHAN_SEND   // waiting for a receiver on a channel
+	TASK_STATE_CHAN_RECV   // waiting for a sender on a channel
+	TASK_STATE_CHAN_SELECT // waiting in a select statement
+	TASK_STATE_SEMA        // waiting on a semaphore (for the sync package)
 )
 
 // Queues used by the scheduler.
@@ -111,6 +116,28 @@ func waitForAsyncCall(caller *coroutine) {
 	promise.state = TASK_STATE_CALL
 }
 
+// Return the data field of the task state. It is used to pass a result (like
+// the commaOk value of a channel receive) to a task that has been resumed.
+//
+// This is a compiler intrinsic.
+func getTaskPromiseData(t *coroutine) uint32 {
+	return t.promise().data
+}
+
+// Make a blocked task runnable again and add it to the run queue. This is used
+// to re-activate the parent of a finished subroutine and to wake up a task that
+// was waiting on a channel.
+//
+// This is a compiler intrinsic.
+func activateTask(t *coroutine) {
+	if t == nil {
+		return
+	}
+	scheduleLogTask("  set runnable:", t)
+	t.promise().state = TASK_STATE_RUNNABLE
+	runqueuePushBack(t)
+}
+
 // Add a task to the runnable or sleep queue, depending on the state.
 //
 // This is a compiler intrinsic.
@@ -124,6 +151,12 @@ func yieldToScheduler(t *coroutine) {
 	if promise.state == TASK_STATE_CALL {
 		scheduleLogTask("  set waiting for call:", t)
 		return // calling an async task, the subroutine will re-active the parent
+	} else if promise.state == TASK_STATE_CHAN_SEND || promise.state == TASK_STATE_CHAN_RECV || promise.state == TASK_STATE_CHAN_SELECT {
+		scheduleLogTask("  set waiting on channel:", t)
+		return // the other side of the channel will re-activate this task
+	} else if promise.state == TASK_STATE_SEMA {
+		scheduleLogTask("  set waiting on semaphore:", t)
+		return // semrelease will re-activate this task
 	} else if promise.state == TASK_STATE_SLEEP && promise.data != 0 {
 		scheduleLogTask("  set sleeping:", t)
 		addSleepTask(t)
@@ -141,10 +174,14 @@ func runqueuePushBack(t *coroutine) {
 		t.destroy()
 		return
 	}
+	if t.promise().next != nil || t == runqueueBack {
+		// Already in the run queue. This happens when a subroutine finishes
+		// before the parent has suspended itself: the parent is re-activated
+		// before it yields to the scheduler.
+		scheduleLogTask("  already in runqueue:", t)
+		return
+	}
 	if schedulerDebug {
-		if t.promise().next != nil {
-			panic("runtime: runqueuePushBack: expected next task to be nil")
-		}
 		if t.promise().state != TASK_STATE_RUNNABLE {
 			panic("runtime: runqueuePushBack: expected task state to be runnable")
 		}
@@ -246,6 +283,10 @@ func scheduler(main *coroutine) {
 		scheduleLog("\n  schedule")
 		now := ticks()
 
+		// Fire timers that have expired. They may wake up tasks that were
+		// waiting on a timer channel.
+		fireTimers()
+
 		// Add tasks that are done sleeping to the end of the runqueue so they
 		// will be executed soon.
 		if sleepQueue != nil && now-sleepQueueBaseTime >= timeUnit(sleepQueue.promise().data) {
@@ -261,15 +302,26 @@ func scheduler(main *coroutine) {
 
 		t := runqueuePopFront()
 		if t == nil {
-			if sleepQueue == nil {
-				// No more tasks to execute.
+			if sleepQueue == nil && (timerQueue == nil || chanBlockedTasks == 0) {
+				// No more tasks to execute. Pending timers are ignored when
+				// there is no goroutine that could be waiting for them.
 				// It would be nice if we could detect deadlocks here, because
 				// there might still be functions waiting on each other in a
 				// deadlock.
 				scheduleLog("  no tasks left!")
 				return
 			}
-			timeLeft := timeUnit(sleepQueue.promise().data) - (now - sleepQueueBaseTime)
+			// Sleep until the first sleeping task or timer should be woken
+			// up.
+			var timeLeft timeUnit
+			if sleepQueue != nil {
+				timeLeft = timeUnit(sleepQueue.promise().data) - (now - sleepQueueBaseTime)
+			}
+			if timerQueue != nil {
+				if timerLeft := timerTimeLeft(); sleepQueue == nil || timerLeft < timeLeft {
+					timeLeft = timerLeft
+				}
+			}
 			if schedulerDebug {
 				println("  sleeping...", sleepQueue, uint32(timeLeft))
 			}
@@ -280,6 +332,7 @@ func scheduler(main *coroutine) {
 		// Run the given task.
 		scheduleLog("  <- runqueuePopFront")
 		scheduleLogTask("  run:", t)
+		callStack = nil // restored by the task when it is resumed
 		t.resume()
 
 		// Add the just resumed task to the run queue or the sleep queue.
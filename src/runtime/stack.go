@@ -1,16 +1,152 @@
 package runtime
 
+// This file implements runtime.Caller and friends, and the backtrace that is
+// printed on a fatal panic.
+//
+// Stack traces are only available on targets that enable them in the target
+// specification. The compiler then gives each function (outside the runtime) a
+// callFrame on the stack that is linked into callStack on entry and unlinked
+// on return. While the function runs, the pc field of the frame contains the
+// source location it is at. These are not real program counters: a pc is an
+// index (plus one) into pcTable, which maps it to a function and a file:line
+// position. A pc of 0 is an unknown location.
+//
+// Coroutines restore callStack when they are resumed, so that it always
+// describes the stack of the running goroutine.
+
+// The stack frame of a function, as emitted by the compiler.
+type callFrame struct {
+	parent *callFrame
+	pc     uintptr
+}
+
+// Func describes a function in the running program. It is the entry of a pc
+// in pcTable: its source location, as indices into funcNames and fileNames.
 type Func struct {
+	fn   uint32
+	file uint32
+	line uint32
+}
+
+// The innermost frame of the running goroutine, or nil when stack traces are
+// disabled.
+var callStack *callFrame
+
+// Global constants that will be set by the compiler. The arrays are of size 0,
+// which is a dummy value, but will be bigger after the compiler has filled them
+// in.
+var (
+	pcTableLen uintptr   // the number of entries in pcTable
+	pcTable    [0]Func   // source location of each pc
+	funcNames  [0]string // function names, like main.(*T).String
+	fileNames  [0]string // source file names
+)
+
+// The maximum number of frames that are printed in a backtrace.
+const maxBacktrace = 32
+
+// Return the source location of the given pc, or nil if it is unknown.
+//go:nobounds
+func findPC(pc uintptr) *Func {
+	if pc == 0 || pc > pcTableLen {
+		return nil
+	}
+	return &pcTable[pc-1]
 }
 
+// FuncForPC returns a *Func describing the function that contains the given
+// pc, or else nil.
 func FuncForPC(pc uintptr) *Func {
-	return nil
+	return findPC(pc)
 }
 
+// Name returns the name of the function, including the package path, like
+// main.(*T).String.
+//go:nobounds
 func (f *Func) Name() string {
-	return ""
+	if f == nil {
+		return ""
+	}
+	return funcNames[f.fn]
 }
 
+// FileLine returns the file name and line number of the source code
+// corresponding to the pc.
+//go:nobounds
+func (f *Func) FileLine(pc uintptr) (file string, line int) {
+	info := findPC(pc)
+	if info == nil {
+		return "?", 0
+	}
+	return fileNames[info.file], int(info.line)
+}
+
+// Caller returns the function and source location of the function call at the
+// given depth in the stack of the calling goroutine. An argument of 0 is the
+// caller of Caller.
+//go:nobounds
 func Caller(skip int) (pc uintptr, file string, line int, ok bool) {
-	return 0, "", 0, false
+	frame := callStack
+	for ; frame != nil && skip > 0; skip-- {
+		frame = frame.parent
+	}
+	if frame == nil {
+		return 0, "", 0, false
+	}
+	info := findPC(frame.pc)
+	if info == nil {
+		return frame.pc, "", 0, false
+	}
+	return frame.pc, fileNames[info.file], int(info.line), true
+}
+
+// Callers fills the slice pc with the program counters of function calls on
+// the stack of the calling goroutine and returns the number of entries
+// written. An argument of 1 starts at the caller of Callers. Functions in the
+// runtime do not have a stack frame, so a skip of 0 (Callers itself) is the
+// same as 1.
+func Callers(skip int, pc []uintptr) int {
+	frame := callStack
+	for ; frame != nil && skip > 1; skip-- {
+		frame = frame.parent
+	}
+	n := 0
+	for ; frame != nil && n < len(pc); frame = frame.parent {
+		pc[n] = frame.pc
+		n++
+	}
+	return n
+}
+
+// Return the program counters of the running goroutine, for printing them
+// later when the panic turns out to be fatal. It returns nil when stack traces
+// are disabled.
+func savePanicStack() []uintptr {
+	if callStack == nil {
+		return nil
+	}
+	pcs := make([]uintptr, maxBacktrace)
+	return pcs[:Callers(1, pcs)]
+}
+
+// Print a backtrace, in a format similar to the one of gc.
+func printBacktrace(pcs []uintptr) {
+	if len(pcs) == 0 {
+		return
+	}
+	printnl()
+	for _, pc := range pcs {
+		f := FuncForPC(pc)
+		if f == nil {
+			printstring("?()\n")
+			continue
+		}
+		file, line := f.FileLine(pc)
+		printstring(f.Name())
+		printstring("(...)\n\t")
+		printstring(file)
+		printstring(":")
+		printint32(int32(line))
+		printnl()
+	}
 }
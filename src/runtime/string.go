@@ -14,8 +14,7 @@ type _string struct {
 
 // The iterator state for a range over a string.
 type stringIterator struct {
-	byteindex  lenType
-	rangeindex lenType
+	byteindex lenType
 }
 
 // Return true iff the strings match.
@@ -90,6 +89,42 @@ func stringToBytes(x _string) (slice struct {
 	return
 }
 
+// Create a string from a []rune slice, encoding each rune in UTF-8.
+func stringFromRunes(runeSlice []rune) (s _string) {
+	// Determine the length of the resulting string.
+	for _, r := range runeSlice {
+		_, length := encodeUTF8(r)
+		s.length += length
+	}
+	// Encode all runes.
+	buf := alloc(uintptr(s.length))
+	s.ptr = (*byte)(buf)
+	index := uintptr(0)
+	for _, r := range runeSlice {
+		array, length := encodeUTF8(r)
+		memcpy(unsafe.Pointer(uintptr(buf)+index), unsafe.Pointer(&array), uintptr(length))
+		index += uintptr(length)
+	}
+	return
+}
+
+// Convert a string to a []rune slice, decoding it as UTF-8.
+func stringToRunes(s string) []rune {
+	// Count the number of runes in the string.
+	n := 0
+	for range s {
+		n++
+	}
+	// Decode all runes.
+	runeSlice := make([]rune, n)
+	index := 0
+	for _, r := range s {
+		runeSlice[index] = r
+		index++
+	}
+	return runeSlice
+}
+
 // Create a string from a Unicode code point.
 func stringFromUnicode(x rune) _string {
 	array, length := encodeUTF8(x)
@@ -100,34 +135,39 @@ func stringFromUnicode(x rune) _string {
 }
 
 // Iterate over a string.
-// Returns (ok, key, value).
+// Returns (ok, key, value), where key is the byte index of the rune.
 func stringNext(s string, it *stringIterator) (bool, int, rune) {
 	if len(s) <= int(it.byteindex) {
 		return false, 0, 0
 	}
-	r, length := decodeUTF8(s, it.byteindex)
+	index := it.byteindex
+	r, length := decodeUTF8(s, index)
 	it.byteindex += length
-	it.rangeindex += 1
-	return true, int(it.rangeindex), r
+	return true, int(index), r
 }
 
-// Convert a Unicode code point into an array of bytes and its length.
+// Convert a Unicode code point into an array of bytes and its length. Invalid
+// code points (negative values, surrogate halves, and values above U+10FFFF)
+// are encoded as U+FFFD.
 func encodeUTF8(x rune) ([4]byte, lenType) {
 	// https://stackoverflow.com/questions/6240055/manually-converting-unicode-codepoints-into-utf-8-and-utf-16
 	// Note: this code can probably be optimized (in size and speed).
 	switch {
-	case x <= 0x7f:
+	case 0 <= x && x <= 0x7f:
 		return [4]byte{byte(x), 0, 0, 0}, 1
-	case x <= 0x7ff:
+	case 0x80 <= x && x <= 0x7ff:
 		b1 := 0xc0 | byte(x>>6)
 		b2 := 0x80 | byte(x&0x3f)
 		return [4]byte{b1, b2, 0, 0}, 2
-	case x <= 0xffff:
+	case 0xd800 <= x && x <= 0xdfff:
+		// Surrogate halves are invalid in UTF-8.
+		return [4]byte{0xef, 0xbf, 0xbd, 0}, 3
+	case 0x800 <= x && x <= 0xffff:
 		b1 := 0xe0 | byte(x>>12)
 		b2 := 0x80 | byte((x>>6)&0x3f)
 		b3 := 0x80 | byte((x>>0)&0x3f)
 		return [4]byte{b1, b2, b3, 0}, 3
-	case x <= 0x10ffff:
+	case 0x10000 <= x && x <= 0x10ffff:
 		b1 := 0xf0 | byte(x>>18)
 		b2 := 0x80 | byte((x>>12)&0x3f)
 		b3 := 0x80 | byte((x>>6)&0x3f)
@@ -139,30 +179,43 @@ func encodeUTF8(x rune) ([4]byte, lenType) {
 	}
 }
 
-// Decode a single UTF-8 character from a string.
+// Decode a single UTF-8 character from a string. Invalid UTF-8 (unexpected
+// continuation bytes, truncated or overlong sequences, surrogate halves and
+// values above U+10FFFF) is decoded as U+FFFD with a length of 1, just like
+// the gc runtime does.
 //go:nobounds
 func decodeUTF8(s string, index lenType) (rune, lenType) {
 	remaining := lenType(len(s)) - index // must be >= 1 before calling this function
 	x := s[index]
 	switch {
-	case x&0x80 == 0x00: // 0xxxxxxx
+	case x < 0x80: // 0xxxxxxx
 		return rune(x), 1
-	case x&0xe0 == 0xc0: // 110xxxxx
-		if remaining < 2 {
-			return 0xfffd, 1
+	case 0xc0 <= x && x < 0xe0: // 110xxxxx
+		if remaining >= 2 && isContinuationByte(s[index+1]) {
+			r := (rune(x&0x1f) << 6) | (rune(s[index+1]) & 0x3f)
+			if r > 0x7f {
+				return r, 2
+			}
 		}
-		return (rune(x&0x1f) << 6) | (rune(s[index+1]) & 0x3f), 2
-	case x&0xf0 == 0xe0: // 1110xxxx
-		if remaining < 3 {
-			return 0xfffd, 1
+	case 0xe0 <= x && x < 0xf0: // 1110xxxx
+		if remaining >= 3 && isContinuationByte(s[index+1]) && isContinuationByte(s[index+2]) {
+			r := (rune(x&0x0f) << 12) | ((rune(s[index+1]) & 0x3f) << 6) | (rune(s[index+2]) & 0x3f)
+			if r > 0x7ff && !(0xd800 <= r && r <= 0xdfff) {
+				return r, 3
+			}
 		}
-		return (rune(x&0x0f) << 12) | ((rune(s[index+1]) & 0x3f) << 6) | (rune(s[index+2]) & 0x3f), 3
-	case x&0xf8 == 0xf0: // 11110xxx
-		if remaining < 4 {
-			return 0xfffd, 1
+	case 0xf0 <= x && x < 0xf8: // 11110xxx
+		if remaining >= 4 && isContinuationByte(s[index+1]) && isContinuationByte(s[index+2]) && isContinuationByte(s[index+3]) {
+			r := (rune(x&0x07) << 18) | ((rune(s[index+1]) & 0x3f) << 12) | ((rune(s[index+2]) & 0x3f) << 6) | (rune(s[index+3]) & 0x3f)
+			if r > 0xffff && r <= 0x10ffff {
+				return r, 4
+			}
 		}
-		return (rune(x&0x07) << 18) | ((rune(s[index+1]) & 0x3f) << 12) | ((rune(s[index+2]) & 0x3f) << 6) | (rune(s[index+3]) & 0x3f), 4
-	default:
-		return 0xfffd, 1
 	}
+	return 0xfffd, 1
+}
+
+// Return true if this byte is a continuation byte in UTF-8 (10xxxxxx).
+func isContinuationByte(b byte) bool {
+	return b&0xc0 == 0x80
 }
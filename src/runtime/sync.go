@@ -1,13 +1,64 @@
 package runtime
 
-// This file contains stub implementations for internal/poll.
+// This file implements semaphores, which are used by the sync package to block
+// goroutines, and stubs for internal/poll.
 
-//go:linkname semacquire internal/poll.runtime_Semacquire
-func semacquire(sema *uint32) {
-	panic("todo: semacquire")
+// A goroutine that is blocked on a semaphore.
+type semaBlockedList struct {
+	next *semaBlockedList
+	t    *coroutine
+	sema *uint32
 }
 
-//go:linkname semrelease internal/poll.runtime_Semrelease
+// All goroutines that are blocked on a semaphore, in the order in which they
+// started waiting. There are usually very few of them, so a single list for
+// all semaphores is good enough.
+var semaBlockedTasks *semaBlockedList
+
+// Wait until *sema is greater than zero and then decrement it. The caller is
+// blocked (without being put back in the run queue) when the semaphore is not
+// available, until it is woken up by semrelease.
+//
+// This is a compiler intrinsic for sync.runtime_Semacquire.
+func semacquire(t *coroutine, sema *uint32) {
+	if *sema != 0 {
+		*sema--
+		return
+	}
+	if t == nil {
+		// There is no scheduler, so there is nothing that could release the
+		// semaphore.
+		runtimeFatal("all goroutines are asleep - deadlock!")
+	}
+	t.promise().state = TASK_STATE_SEMA
+	queue := &semaBlockedTasks
+	for *queue != nil {
+		queue = &(*queue).next
+	}
+	*queue = &semaBlockedList{t: t, sema: sema}
+}
+
+// Increment *sema, or hand it over directly to the goroutine that has been
+// waiting the longest on it.
+//
+//go:linkname semrelease sync.runtime_Semrelease
 func semrelease(sema *uint32) {
+	for queue := &semaBlockedTasks; *queue != nil; queue = &(*queue).next {
+		if b := *queue; b.sema == sema {
+			*queue = b.next
+			activateTask(b.t)
+			return
+		}
+	}
+	*sema++
+}
+
+//go:linkname pollSemacquire internal/poll.runtime_Semacquire
+func pollSemacquire(sema *uint32) {
+	panic("todo: semacquire")
+}
+
+//go:linkname pollSemrelease internal/poll.runtime_Semrelease
+func pollSemrelease(sema *uint32) {
 	panic("todo: semrelease")
 }
@@ -1,13 +1,52 @@
+// +build linux
+
 package runtime
 
-// This file implements syscall.Syscall and the like.
+import (
+	"unsafe"
+)
+
+// This file implements syscall.Syscall and the like. Only writes to stdout and
+// stderr and exiting the process are supported, other system calls fail with
+// ENOSYS.
+
+const (
+	errnoEBADF  = 9
+	errnoENOSYS = 38
+)
 
 //go:linkname syscall_Syscall syscall.Syscall
 func syscall_Syscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err uintptr) {
-	panic("syscall")
+	return syscall(trap, a1, a2, a3)
 }
 
 //go:linkname syscall_Syscall6 syscall.Syscall6
 func syscall_Syscall6(trap, a1, a2, a3, a4, a5, a6 uintptr) (r1, r2 uintptr, err uintptr) {
-	panic("syscall6")
+	return syscall(trap, a1, a2, a3)
+}
+
+//go:linkname syscall_RawSyscall syscall.RawSyscall
+func syscall_RawSyscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err uintptr) {
+	return syscall(trap, a1, a2, a3)
+}
+
+//go:linkname syscall_RawSyscall6 syscall.RawSyscall6
+func syscall_RawSyscall6(trap, a1, a2, a3, a4, a5, a6 uintptr) (r1, r2 uintptr, err uintptr) {
+	return syscall(trap, a1, a2, a3)
+}
+
+func syscall(trap, a1, a2, a3 uintptr) (r1, r2 uintptr, err uintptr) {
+	switch trap {
+	case sysWrite:
+		if a1 != 1 && a1 != 2 {
+			return ^uintptr(0), 0, errnoEBADF
+		}
+		for i := uintptr(0); i < a3; i++ {
+			putchar(*(*byte)(unsafe.Pointer(a2 + i)))
+		}
+		return a3, 0, 0
+	case sysExitGroup:
+		exit(int(a1))
+	}
+	return ^uintptr(0), 0, errnoENOSYS
 }
@@ -0,0 +1,94 @@
+package runtime
+
+// This file implements the timers of the time package, which are used by
+// time.After, time.NewTimer, time.NewTicker and friends. A timer calls a
+// function when it expires, which usually sends the current time on a channel.
+// That way a goroutine can wait for a timeout in a select statement while
+// waiting on other channels at the same time.
+//
+// Timers are kept in a list sorted by expiry time. The scheduler fires them
+// while it also manages the sleep queue, and sleeps until the first sleeping
+// task or timer needs to be woken up. Without a scheduler, a channel operation
+// that would block waits for the next timer instead.
+
+// Timer as used by the time package. Must be kept in sync with
+// time.runtimeTimer.
+type timer struct {
+	tb     uintptr // unused
+	i      int     // unused
+	when   int64
+	period int64
+	f      func(interface{}, uintptr)
+	arg    interface{}
+	seq    uintptr
+}
+
+// A single entry in the timer queue.
+type timerNode struct {
+	next  *timerNode
+	timer *timer
+}
+
+// Active timers, sorted by expiry time.
+var timerQueue *timerNode
+
+//go:linkname startTimer time.startTimer
+func startTimer(t *timer) {
+	addTimer(&timerNode{timer: t})
+}
+
+//go:linkname stopTimer time.stopTimer
+func stopTimer(t *timer) bool {
+	for queue := &timerQueue; *queue != nil; queue = &(*queue).next {
+		if (*queue).timer == t {
+			*queue = (*queue).next
+			return true
+		}
+	}
+	return false
+}
+
+//go:linkname runtimeNano time.runtimeNano
+func runtimeNano() int64 {
+	return int64(ticks()) * tickMicros
+}
+
+// Insert a timer in the timer queue, after all timers that expire at the same
+// time or earlier.
+func addTimer(n *timerNode) {
+	queue := &timerQueue
+	for *queue != nil && (*queue).timer.when <= n.timer.when {
+		queue = &(*queue).next
+	}
+	n.next = *queue
+	*queue = n
+}
+
+// Call the functions of all timers that have expired. Periodic timers (from a
+// time.Ticker) are added to the queue again.
+func fireTimers() {
+	for timerQueue != nil {
+		n := timerQueue
+		t := n.timer
+		if t.when > runtimeNano() {
+			return
+		}
+		timerQueue = n.next
+		if t.period > 0 {
+			t.when += t.period
+			addTimer(n)
+		}
+		t.f(t.arg, t.seq)
+	}
+}
+
+// Return the time until the first timer expires. The timer queue must not be
+// empty.
+func timerTimeLeft() timeUnit {
+	d := timerQueue.timer.when - runtimeNano()
+	if d <= 0 {
+		return 0
+	}
+	// Round up, to not wake up too early.
+	return timeUnit((d + tickMicros - 1) / tickMicros)
+}
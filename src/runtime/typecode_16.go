@@ -0,0 +1,7 @@
+// +build avr cortexm0
+
+package runtime
+
+// The type used for typecodes, method numbers and interface numbers. Small
+// targets have few types and little memory, so 16 bits is enough there.
+type typecodeID uint16
@@ -0,0 +1,8 @@
+// +build !avr,!cortexm0
+
+package runtime
+
+// The type used for typecodes, method numbers and interface numbers. Larger
+// programs may have more than 65536 of them, so use 32 bits on all targets
+// that are not very small.
+type typecodeID uint32
@@ -0,0 +1,41 @@
+package sync
+
+// Cond implements a condition variable, a rendezvous point for goroutines
+// waiting for or announcing the occurrence of an event.
+type Cond struct {
+	// L is held while observing or changing the condition.
+	L Locker
+
+	waiters uint32 // number of goroutines blocked in Wait
+	sema    uint32
+}
+
+// NewCond returns a new Cond with Locker l.
+func NewCond(l Locker) *Cond {
+	return &Cond{L: l}
+}
+
+// Wait atomically unlocks c.L and suspends execution of the calling goroutine.
+// After later resuming execution, Wait locks c.L before returning. Wait cannot
+// return unless awoken by Broadcast or Signal.
+func (c *Cond) Wait() {
+	c.waiters++
+	c.L.Unlock()
+	runtime_Semacquire(&c.sema)
+	c.L.Lock()
+}
+
+// Signal wakes one goroutine waiting on c, if there is any.
+func (c *Cond) Signal() {
+	if c.waiters != 0 {
+		c.waiters--
+		runtime_Semrelease(&c.sema)
+	}
+}
+
+// Broadcast wakes all goroutines waiting on c.
+func (c *Cond) Broadcast() {
+	for ; c.waiters != 0; c.waiters-- {
+		runtime_Semrelease(&c.sema)
+	}
+}
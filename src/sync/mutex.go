@@ -1,52 +1,117 @@
 package sync
 
-// These mutexes assume there is only one thread of operation: no goroutines,
-// interrupts or anything else.
+// These mutexes assume there is only one thread of operation: no interrupts or
+// anything else. Goroutines are cooperatively scheduled, so they can only be
+// preempted at a blocking operation.
 
+// A Locker represents an object that can be locked and unlocked.
+type Locker interface {
+	Lock()
+	Unlock()
+}
+
+// A Mutex is a mutual exclusion lock. The zero value is an unlocked mutex.
 type Mutex struct {
-	locked bool
+	locked  bool
+	waiters uint32 // number of goroutines blocked in Lock
+	sema    uint32
 }
 
+// Lock locks m. If the lock is already in use, the calling goroutine blocks
+// until the mutex is available.
 func (m *Mutex) Lock() {
 	if m.locked {
-		panic("todo: block on locked mutex")
+		// Wait until Unlock hands over the lock to this goroutine.
+		m.waiters++
+		runtime_Semacquire(&m.sema)
+		return
 	}
 	m.locked = true
 }
 
+// Unlock unlocks m. If there are goroutines waiting for the lock, it is passed
+// directly to the one that has been waiting the longest.
 func (m *Mutex) Unlock() {
 	if !m.locked {
 		panic("sync: unlock of unlocked Mutex")
 	}
+	if m.waiters != 0 {
+		// Keep the mutex locked, it now belongs to the woken goroutine.
+		m.waiters--
+		runtime_Semrelease(&m.sema)
+		return
+	}
 	m.locked = false
 }
 
+// An RWMutex is a reader/writer mutual exclusion lock. The lock can be held by
+// an arbitrary number of readers or a single writer. Once a writer is waiting
+// for the lock, new readers block until the writer has released it again.
 type RWMutex struct {
-	m       Mutex
-	readers uint32
+	w              Mutex  // held by the active or waiting writer
+	writer         bool   // a writer holds the lock or waits for readers to leave
+	readers        uint32 // number of readers holding the lock
+	readersWaiting uint32 // number of readers blocked on a writer
+	readerSema     uint32
+	writerSema     uint32
 }
 
+// Lock locks rw for writing. It blocks until all other writers and readers
+// have released the lock.
 func (rw *RWMutex) Lock() {
-	rw.m.Lock()
+	rw.w.Lock()
+	rw.writer = true
+	if rw.readers != 0 {
+		// Wait for the last reader to call RUnlock.
+		runtime_Semacquire(&rw.writerSema)
+	}
 }
 
+// Unlock unlocks rw for writing. Readers blocked on this writer get the lock
+// before any other writer.
 func (rw *RWMutex) Unlock() {
-	rw.m.Unlock()
+	if !rw.writer {
+		panic("sync: Unlock of unlocked RWMutex")
+	}
+	rw.writer = false
+	for ; rw.readersWaiting != 0; rw.readersWaiting-- {
+		rw.readers++
+		runtime_Semrelease(&rw.readerSema)
+	}
+	rw.w.Unlock()
 }
 
+// RLock locks rw for reading. It blocks while a writer holds the lock or is
+// waiting for it.
 func (rw *RWMutex) RLock() {
-	if rw.readers == 0 {
-		rw.m.Lock()
+	if rw.writer {
+		// Unlock will account for this reader when it hands over the lock.
+		rw.readersWaiting++
+		runtime_Semacquire(&rw.readerSema)
+		return
 	}
 	rw.readers++
 }
 
+// RUnlock undoes a single RLock call.
 func (rw *RWMutex) RUnlock() {
 	if rw.readers == 0 {
-		panic("sync: unlock of unlocked RWMutex")
+		panic("sync: RUnlock of unlocked RWMutex")
 	}
 	rw.readers--
-	if rw.readers == 0 {
-		rw.m.Unlock()
+	if rw.readers == 0 && rw.writer {
+		// Wake up the writer waiting in Lock.
+		runtime_Semrelease(&rw.writerSema)
 	}
 }
+
+// RLocker returns a Locker interface that implements the Lock and Unlock
+// methods by calling rw.RLock and rw.RUnlock.
+func (rw *RWMutex) RLocker() Locker {
+	return (*rlocker)(rw)
+}
+
+type rlocker RWMutex
+
+func (r *rlocker) Lock()   { (*RWMutex)(r).RLock() }
+func (r *rlocker) Unlock() { (*RWMutex)(r).RUnlock() }
@@ -1,16 +1,27 @@
 package sync
 
+// Once is an object that will perform exactly one action.
 type Once struct {
 	done bool
 	m    Mutex
 }
 
+// Do calls the function f if and only if Do is being called for the first time
+// for this instance of Once. Other callers block until the first call of f has
+// returned.
 func (o *Once) Do(f func()) {
+	if o.done {
+		return
+	}
 	o.m.Lock()
 	defer o.m.Unlock()
 	if o.done {
 		return
 	}
-	o.done = true
+	// Only mark it as done when f has returned (or panicked), so that callers
+	// don't take the shortcut above while f is still running.
+	defer func() {
+		o.done = true
+	}()
 	f()
 }
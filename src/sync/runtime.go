@@ -0,0 +1,14 @@
+package sync
+
+// These functions are implemented in the runtime.
+
+// Wait until *s > 0 and then decrement it. The calling goroutine is blocked
+// until another goroutine calls runtime_Semrelease when the semaphore is not
+// available.
+//
+// This is a compiler intrinsic: any function that calls it is a blocking
+// function.
+func runtime_Semacquire(s *uint32)
+
+// Increment *s and wake up a goroutine blocked in runtime_Semacquire, if any.
+func runtime_Semrelease(s *uint32)
@@ -0,0 +1,40 @@
+package sync
+
+// A WaitGroup waits for a collection of goroutines to finish. The main
+// goroutine calls Add to set the number of goroutines to wait for, then each of
+// the goroutines runs and calls Done when finished. At the same time, Wait can
+// be used to block until all goroutines have finished.
+type WaitGroup struct {
+	counter int
+	waiters uint32 // number of goroutines blocked in Wait
+	sema    uint32
+}
+
+// Add adds delta, which may be negative, to the WaitGroup counter. If the
+// counter becomes zero, all goroutines blocked on Wait are released.
+func (wg *WaitGroup) Add(delta int) {
+	wg.counter += delta
+	if wg.counter < 0 {
+		panic("sync: negative WaitGroup counter")
+	}
+	if wg.counter != 0 {
+		return
+	}
+	for ; wg.waiters != 0; wg.waiters-- {
+		runtime_Semrelease(&wg.sema)
+	}
+}
+
+// Done decrements the WaitGroup counter by one.
+func (wg *WaitGroup) Done() {
+	wg.Add(-1)
+}
+
+// Wait blocks until the WaitGroup counter is zero.
+func (wg *WaitGroup) Wait() {
+	if wg.counter == 0 {
+		return
+	}
+	wg.waiters++
+	runtime_Semacquire(&wg.sema)
+}
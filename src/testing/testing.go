@@ -0,0 +1,241 @@
+// Package testing implements a subset of the Go "testing" package, for test
+// binaries built with `tinygo test`. See https://godoc.org/testing for details.
+//
+// Tests are run one after another, including subtests. Benchmarks, examples,
+// TestMain and command line flags are not supported. The output is the same as
+// the output of `go test` without -v: the log of failed tests, followed by PASS
+// or FAIL on the last line.
+package testing
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// InternalTest is a test function with its name. It is used by the generated
+// main package of a test binary.
+type InternalTest struct {
+	Name string
+	F    func(*T)
+}
+
+// M is a test binary, as returned by MainStart.
+type M struct {
+	tests []InternalTest
+}
+
+// MainStart is called by the generated main package of a test binary. It is
+// not meant to be called directly.
+func MainStart(tests []InternalTest) *M {
+	return &M{tests: tests}
+}
+
+// Run runs all tests and reports the result. It returns the exit code for the
+// test binary: 0 when all tests passed and 1 otherwise.
+func (m *M) Run() int {
+	if len(m.tests) == 0 {
+		fmt.Println("testing: warning: no tests to run")
+	}
+	failed := false
+	for _, test := range m.tests {
+		t := &T{name: test.Name}
+		t.run(test.F)
+		if t.failed {
+			failed = true
+		}
+	}
+	if failed {
+		fmt.Println("FAIL")
+		return 1
+	}
+	fmt.Println("PASS")
+	return 0
+}
+
+// Short reports whether the -test.short flag is set, which is never the case.
+func Short() bool {
+	return false
+}
+
+// Verbose reports whether the -test.v flag is set, which is never the case.
+func Verbose() bool {
+	return false
+}
+
+// T is a type passed to Test functions to manage test state and support
+// formatted test logs.
+type T struct {
+	name    string
+	parent  *T
+	output  string // log of this test and the report of its subtests
+	failed  bool
+	skipped bool
+}
+
+// The value that is panicked with to stop a test early, from FailNow or
+// SkipNow. It is recovered when the test function returns.
+type testExit struct{}
+
+// Run the test function, recovering from FailNow and SkipNow, and report the
+// result.
+func (t *T) run(f func(*T)) {
+	start := time.Now()
+	defer func() {
+		err := recover()
+		if _, ok := err.(testExit); err != nil && !ok {
+			// The test panicked: report it as failed and pass the panic on.
+			t.failed = true
+			t.report(time.Since(start))
+			panic(err)
+		}
+		t.report(time.Since(start))
+	}()
+	f(t)
+}
+
+// Report the result of the test: either to the parent test in the case of a
+// subtest, or directly to the output. Only failed tests are reported.
+func (t *T) report(duration time.Duration) {
+	if !t.failed {
+		return
+	}
+	centis := duration / (10 * time.Millisecond)
+	report := fmt.Sprintf("--- FAIL: %s (%d.%02ds)\n", t.name, centis/100, centis%100) + t.output
+	if t.parent == nil {
+		fmt.Print(report)
+		return
+	}
+	// The report of a subtest is indented in the log of its parent.
+	for _, line := range strings.SplitAfter(report, "\n") {
+		if line != "" {
+			t.parent.output += "    " + line
+		}
+	}
+}
+
+// Name returns the name of the running test or subtest.
+func (t *T) Name() string {
+	return t.name
+}
+
+// Fail marks the function as having failed but continues execution.
+func (t *T) Fail() {
+	for ; t != nil; t = t.parent {
+		t.failed = true
+	}
+}
+
+// Failed reports whether the function has failed.
+func (t *T) Failed() bool {
+	return t.failed
+}
+
+// FailNow marks the function as having failed and stops its execution. Like
+// with the testing package of Go, it must be called from the goroutine running
+// the test.
+func (t *T) FailNow() {
+	t.Fail()
+	panic(testExit{})
+}
+
+// Log formats its arguments using default formatting, analogous to Println,
+// and records the text in the error log. The log is only printed when the test
+// fails.
+func (t *T) Log(args ...interface{}) {
+	t.log(fmt.Sprintln(args...))
+}
+
+// Logf formats its arguments according to the format, analogous to Printf,
+// and records the text in the error log.
+func (t *T) Logf(format string, args ...interface{}) {
+	t.log(fmt.Sprintf(format, args...))
+}
+
+// Error is equivalent to Log followed by Fail.
+func (t *T) Error(args ...interface{}) {
+	t.log(fmt.Sprintln(args...))
+	t.Fail()
+}
+
+// Errorf is equivalent to Logf followed by Fail.
+func (t *T) Errorf(format string, args ...interface{}) {
+	t.log(fmt.Sprintf(format, args...))
+	t.Fail()
+}
+
+// Fatal is equivalent to Log followed by FailNow.
+func (t *T) Fatal(args ...interface{}) {
+	t.log(fmt.Sprintln(args...))
+	t.FailNow()
+}
+
+// Fatalf is equivalent to Logf followed by FailNow.
+func (t *T) Fatalf(format string, args ...interface{}) {
+	t.log(fmt.Sprintf(format, args...))
+	t.FailNow()
+}
+
+// Skip is equivalent to Log followed by SkipNow.
+func (t *T) Skip(args ...interface{}) {
+	t.log(fmt.Sprintln(args...))
+	t.SkipNow()
+}
+
+// Skipf is equivalent to Logf followed by SkipNow.
+func (t *T) Skipf(format string, args ...interface{}) {
+	t.log(fmt.Sprintf(format, args...))
+	t.SkipNow()
+}
+
+// SkipNow marks the test as having been skipped and stops its execution.
+func (t *T) SkipNow() {
+	t.skipped = true
+	panic(testExit{})
+}
+
+// Skipped reports whether the test was skipped.
+func (t *T) Skipped() bool {
+	return t.skipped
+}
+
+// Helper marks the calling function as a test helper function. It is
+// accepted, but the file and line number in the log are always those of the
+// function that called the logging method.
+func (t *T) Helper() {
+}
+
+// Parallel signals that this test may be run in parallel with other tests. It
+// is accepted, but tests are always run one after another.
+func (t *T) Parallel() {
+}
+
+// Run runs f as a subtest of t called name. It reports whether f succeeded.
+func (t *T) Run(name string, f func(t *T)) bool {
+	sub := &T{
+		name:   t.name + "/" + strings.Replace(name, " ", "_", -1),
+		parent: t,
+	}
+	sub.run(f)
+	return !sub.failed
+}
+
+// Add the message to the log of the test, prefixed with the file and line
+// number of the test function that called the logging method. The message is
+// indented like go test does.
+func (t *T) log(s string) {
+	// Skip log and the logging method, like Errorf.
+	_, file, line, ok := runtime.Caller(2)
+	if ok {
+		if index := strings.LastIndex(file, "/"); index >= 0 {
+			file = file[index+1:]
+		}
+	} else {
+		file = "???"
+		line = 1
+	}
+	s = strings.TrimSuffix(s, "\n")
+	s = strings.Replace(s, "\n", "\n        ", -1)
+	t.output += fmt.Sprintf("    %s:%d: %s\n", file, line, s)
+}
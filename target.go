@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -17,7 +18,10 @@ import (
 // https://doc.rust-lang.org/nightly/nightly-rustc/rustc_target/spec/struct.TargetOptions.html
 // https://github.com/shepmaster/rust-arduino-blink-led-no-core-with-cargo/blob/master/blink/arduino.json
 type TargetSpec struct {
+	Inherits    []string `json:"inherits"`
 	Triple      string   `json:"llvm-target"`
+	GOOS        string   `json:"goos"`
+	GOARCH      string   `json:"goarch"`
 	BuildTags   []string `json:"build-tags"`
 	Linker      string   `json:"linker"`
 	CompilerRT  bool     `json:"compiler-rt"`
@@ -28,42 +32,164 @@ type TargetSpec struct {
 	OCDDaemon   []string `json:"ocd-daemon"`
 	GDB         string   `json:"gdb"`
 	GDBCmds     []string `json:"gdb-initial-cmds"`
+	Traceback   bool     `json:"traceback"`
+	TypeNames   bool     `json:"type-names"`
+	FlashSize   uint64   `json:"flash-size"` // in bytes, 0 if unknown
+	RAMSize     uint64   `json:"ram-size"`   // in bytes, 0 if unknown
 }
 
-// Load a target specification
+// Properties that are lists, but form a single command. They replace the
+// inherited value instead of being appended to it. The GDB commands are a
+// single script as well, as they usually end with a command to continue
+// running the program.
+var targetCommandProperties = map[string]bool{
+	"emulator":         true,
+	"ocd-daemon":       true,
+	"gdb-initial-cmds": true,
+}
+
+// Load a target specification. The target is either the name of a target in
+// the targets directory (like "pca10040"), the path to a target specification
+// file ending in .json, or an LLVM triple for which the default specification
+// is used.
 func LoadTarget(target string) (*TargetSpec, error) {
 	if target == "" {
 		target = llvm.DefaultTargetTriple()
 	}
 
+	if strings.HasSuffix(target, ".json") {
+		return loadTargetFile(target)
+	}
+
+	// See whether there is a target specification for this target (e.g.
+	// Arduino).
+	path := filepath.Join(sourceDir(), "targets", strings.ToLower(target)+".json")
+	if _, err := os.Stat(path); err == nil {
+		return loadTargetFile(path)
+	} else if !os.IsNotExist(err) {
+		// Expected a 'file not found' error, got something else.
+		return nil, err
+	}
+
+	// No target spec available. Use the default one.
 	spec := &TargetSpec{
 		Triple:      target,
+		GOOS:        runtime.GOOS,
+		GOARCH:      runtime.GOARCH,
 		BuildTags:   []string{runtime.GOOS, runtime.GOARCH},
 		Linker:      "cc",
 		PreLinkArgs: []string{"-no-pie"}, // WARNING: clang < 5.0 requires -nopie
 		Objcopy:     "objcopy",
 		GDB:         "gdb",
 		GDBCmds:     []string{"run"},
+		Traceback:   true,
+		TypeNames:   true,
 	}
+	return spec, nil
+}
 
-	// See whether there is a target specification for this target (e.g.
-	// Arduino).
-	path := filepath.Join(sourceDir(), "targets", strings.ToLower(target)+".json")
-	if fp, err := os.Open(path); err == nil {
-		defer fp.Close()
-		*spec = TargetSpec{} // reset all fields
-		err := json.NewDecoder(fp).Decode(spec)
-		if err != nil {
-			return nil, err
+// Load the target specification file at the given path, together with the
+// target specifications it inherits from.
+func loadTargetFile(path string) (*TargetSpec, error) {
+	properties, err := loadTargetProperties(path, nil, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(properties)
+	if err != nil {
+		return nil, err
+	}
+	spec := &TargetSpec{}
+	err = json.Unmarshal(data, spec)
+	if err != nil {
+		return nil, errors.New("invalid target specification " + path + ": " + err.Error())
+	}
+	return spec, nil
+}
+
+// Read the properties of the target specification file at the given path and
+// merge them with those of the targets listed in "inherits", in order. The
+// rules for merging a property with an inherited one are:
+//
+//   - lists are appended to the inherited list, except for lists that form a
+//     command (see targetCommandProperties)
+//   - all other values replace the inherited value
+//
+// Inherited targets are either the name of a target in the targets directory
+// or the path of a .json file, relative to the file that inherits from it. The
+// files that are being loaded are passed in loading, to detect cycles. Files
+// that have already been loaded are recorded in loaded: a target that is
+// inherited more than once (for example by two of the inherited targets) is
+// only applied the first time, so that its lists are not appended twice.
+func loadTargetProperties(path string, loading []string, loaded map[string]bool) (map[string]interface{}, error) {
+	path = filepath.Clean(path)
+	for _, other := range loading {
+		if other == path {
+			return nil, errors.New("target specification " + path + " inherits from itself, directly or indirectly")
 		}
-	} else if !os.IsNotExist(err) {
-		// Expected a 'file not found' error, got something else.
+	}
+	if loaded[path] {
+		return nil, nil
+	}
+	loading = append(loading, path)
+
+	fp, err := os.Open(path)
+	if err != nil {
 		return nil, err
-	} else {
-		// No target spec available. Use the default one.
+	}
+	defer fp.Close()
+	var spec map[string]interface{}
+	err = json.NewDecoder(fp).Decode(&spec)
+	if err != nil {
+		return nil, errors.New("invalid target specification " + path + ": " + err.Error())
 	}
 
-	return spec, nil
+	var inherits []string
+	if value, ok := spec["inherits"]; ok {
+		list, ok := value.([]interface{})
+		for _, name := range list {
+			if name, ok := name.(string); ok {
+				inherits = append(inherits, name)
+			}
+		}
+		if !ok || len(inherits) != len(list) {
+			return nil, errors.New("invalid target specification " + path + ": inherits must be a list of strings")
+		}
+		delete(spec, "inherits")
+	}
+
+	properties := make(map[string]interface{})
+	for _, name := range inherits {
+		var parentPath string
+		if strings.HasSuffix(name, ".json") {
+			parentPath = filepath.Join(filepath.Dir(path), name)
+		} else {
+			parentPath = filepath.Join(sourceDir(), "targets", strings.ToLower(name)+".json")
+		}
+		parent, err := loadTargetProperties(parentPath, loading, loaded)
+		if os.IsNotExist(err) {
+			return nil, errors.New("target specification " + path + " inherits from unknown target " + name)
+		} else if err != nil {
+			return nil, err
+		}
+		mergeTargetProperties(properties, parent)
+	}
+	mergeTargetProperties(properties, spec)
+	loaded[path] = true
+	return properties, nil
+}
+
+// Merge the target properties in src into dst, following the rules described
+// in loadTargetProperties.
+func mergeTargetProperties(dst, src map[string]interface{}) {
+	for key, value := range src {
+		if list, ok := value.([]interface{}); ok && !targetCommandProperties[key] {
+			if inherited, ok := dst[key].([]interface{}); ok {
+				value = append(inherited[:len(inherited):len(inherited)], list...)
+			}
+		}
+		dst[key] = value
+	}
 }
 
 // Return the source directory of this package, or "." when it cannot be
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadTargetFile(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string // the target to load is target.json
+		want  *TargetSpec
+		err   string // part of the expected error message
+	}{
+		{
+			name: "list append",
+			files: map[string]string{
+				"target.json": `{"inherits": ["parent.json"], "build-tags": ["child"], "pre-link-args": ["-b"]}`,
+				"parent.json": `{"build-tags": ["parent"], "pre-link-args": ["-a"]}`,
+			},
+			want: &TargetSpec{
+				BuildTags:   []string{"parent", "child"},
+				PreLinkArgs: []string{"-a", "-b"},
+			},
+		},
+		{
+			name: "command replace",
+			files: map[string]string{
+				"target.json": `{"inherits": ["parent.json"], "emulator": ["child-emu"], "ocd-daemon": ["child-ocd"], "gdb-initial-cmds": ["load", "c"]}`,
+				"parent.json": `{"emulator": ["parent-emu", "-x"], "ocd-daemon": ["parent-ocd", "-y"], "gdb-initial-cmds": ["run"]}`,
+			},
+			want: &TargetSpec{
+				Emulator:  []string{"child-emu"},
+				OCDDaemon: []string{"child-ocd"},
+				GDBCmds:   []string{"load", "c"},
+			},
+		},
+		{
+			name: "scalar override",
+			files: map[string]string{
+				"target.json": `{"inherits": ["parent.json"], "linker": "child-ld", "flash-size": 1024}`,
+				"parent.json": `{"linker": "parent-ld", "objcopy": "parent-objcopy", "flash-size": 2048}`,
+			},
+			want: &TargetSpec{
+				Linker:    "child-ld",
+				Objcopy:   "parent-objcopy",
+				FlashSize: 1024,
+			},
+		},
+		{
+			name: "relative parent",
+			files: map[string]string{
+				"target.json":              `{"inherits": ["boards/board.json"]}`,
+				"boards/board.json":        `{"inherits": ["../chips/chip.json"], "build-tags": ["board"]}`,
+				"chips/chip.json":          `{"inherits": ["family/family.json"], "build-tags": ["chip"]}`,
+				"chips/family/family.json": `{"build-tags": ["family"]}`,
+			},
+			want: &TargetSpec{
+				BuildTags: []string{"family", "chip", "board"},
+			},
+		},
+		{
+			name: "diamond",
+			files: map[string]string{
+				"target.json": `{"inherits": ["left.json", "right.json"], "build-tags": ["target"]}`,
+				"left.json":   `{"inherits": ["base.json"], "build-tags": ["left"]}`,
+				"right.json":  `{"inherits": ["base.json"], "build-tags": ["right"]}`,
+				"base.json":   `{"build-tags": ["base"], "linker": "base-ld"}`,
+			},
+			want: &TargetSpec{
+				BuildTags: []string{"base", "left", "right", "target"},
+				Linker:    "base-ld",
+			},
+		},
+		{
+			name: "cycle",
+			files: map[string]string{
+				"target.json": `{"inherits": ["a.json"]}`,
+				"a.json":      `{"inherits": ["b.json"]}`,
+				"b.json":      `{"inherits": ["a.json"]}`,
+			},
+			err: "a.json inherits from itself, directly or indirectly",
+		},
+		{
+			name: "unknown target",
+			files: map[string]string{
+				"target.json": `{"inherits": ["no-such-target"]}`,
+			},
+			err: "inherits from unknown target no-such-target",
+		},
+		{
+			name: "unknown file",
+			files: map[string]string{
+				"target.json": `{"inherits": ["missing.json"]}`,
+			},
+			err: "inherits from unknown target missing.json",
+		},
+		{
+			name: "invalid inherits",
+			files: map[string]string{
+				"target.json": `{"inherits": "parent.json"}`,
+			},
+			err: "inherits must be a list of strings",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "tinygo-target")
+			if err != nil {
+				t.Fatal("could not create temporary directory:", err)
+			}
+			defer os.RemoveAll(dir)
+			for name, contents := range tc.files {
+				path := filepath.Join(dir, name)
+				err := os.MkdirAll(filepath.Dir(path), 0777)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = ioutil.WriteFile(path, []byte(contents), 0666)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			spec, err := LoadTarget(filepath.Join(dir, "target.json"))
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected error containing %q, got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal("could not load target:", err)
+			}
+			if !reflect.DeepEqual(spec, tc.want) {
+				t.Errorf("unexpected target spec\ngot:  %+v\nwant: %+v", spec, tc.want)
+			}
+		})
+	}
+}
+
+// Test which files are selected by build constraints like linux and !linux on
+// targets that set goos, by looking for the constant of the selected file in
+// the generated IR.
+func TestGOOS(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	for _, tc := range []struct {
+		target   string
+		selected string
+	}{
+		{"qemu", "selected-linux"}, // Cortex-M targets use goos linux
+		{"wasm", "selected-other"}, // goos is taken from the triple
+	} {
+		spec, err := LoadTarget(tc.target)
+		if err != nil {
+			t.Errorf("%s: could not load target: %s", tc.target, err)
+			continue
+		}
+		config := &BuildConfig{opt: "0"}
+		outpath := filepath.Join(tmpdir, "goos.ll")
+		err = Compile("./"+TESTDATA+"/goos", outpath, spec, config, nil)
+		if err != nil {
+			t.Errorf("%s: could not compile: %s", tc.target, err)
+			continue
+		}
+		ir, err := ioutil.ReadFile(outpath)
+		if err != nil {
+			t.Fatal("could not read IR:", err)
+		}
+		for _, selected := range []string{"selected-linux", "selected-other"} {
+			if found := bytes.Contains(ir, []byte(`c"`+selected+`"`)); found != (selected == tc.selected) {
+				t.Errorf("%s: %s found in the IR: %v", tc.target, selected, found)
+			}
+		}
+	}
+}
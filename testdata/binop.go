@@ -39,6 +39,71 @@ func main() {
 	println(s2 == Struct2{"foo", 0.0, 7})
 	println(s2 == Struct2{"foo", 1.0, 5})
 	println(s2 == Struct2{"foo", 1.0, 7})
+
+	println("nested structs")
+	nan := zero / zero
+	println(s3 == Struct3{Struct1{3, true}, "bar", [2]int{1, 2}})
+	println(s3 == Struct3{Struct1{3, false}, "bar", [2]int{1, 2}})
+	println(s3 == Struct3{Struct1{3, true}, "baz", [2]int{1, 2}})
+	println(s3 != Struct3{Struct1{3, true}, "bar", [2]int{1, 3}})
+	println(Struct4{1.5, 2} == Struct4{1.5, 2})
+	println(Struct4{nan, 2} == Struct4{nan, 2})
+	println(Struct4{0.0, 2} == Struct4{-zero, 2})
+	println(Struct5{x: 3, y: "foo"} == Struct5{x: 3, y: "foo"})
+	println(Struct5{x: 3, y: "foo"} == Struct5{x: 3, y: "bar"})
+	println(Struct5{x: 3, y: "foo"} == Struct5{x: uint8(3), y: "foo"})
+
+	println("array equality")
+	println(a1 == [3]int{1, 2, 3})
+	println(a1 == [3]int{1, 2, 4})
+	println(a1 != [3]int{1, 2, 3})
+	println(a1 != [3]int{0, 2, 3})
+	println([2]string{"foo", a} == [2]string{"foo", "a"})
+	println([2]string{"foo", a} == [2]string{"foo", "b"})
+	println([2]float64{1, nan} == [2]float64{1, nan})
+	println([0]int{} == [0]int{})
+	println([2][2]int{{1, 2}, {3, 4}} == [2][2]int{{1, 2}, {3, 4}})
+	println([2][2]int{{1, 2}, {3, 4}} == [2][2]int{{1, 2}, {3, 5}})
+	println([2]Struct1{{1, true}, {2, false}} == [2]Struct1{{1, true}, {2, false}})
+	println([2]Struct1{{1, true}, {2, false}} == [2]Struct1{{1, true}, {2, true}})
+	println([2]interface{}{1, "foo"} == [2]interface{}{1, "foo"})
+	println([2]interface{}{1, "foo"} == [2]interface{}{1, "bar"})
+	var big1, big2 [1000]int
+	big1[999] = 1
+	println(big1 == big2)
+	big2[999] = 1
+	println(big1 == big2)
+	equal := 0
+	for i := 0; i < 10000; i++ {
+		if big1 == big2 {
+			equal++
+		}
+	}
+	println("equal in loop:", equal)
+
+	println("interface equality")
+	var i1 interface{} = s1
+	println(i1 == interface{}(Struct1{3, true}))
+	println(i1 == interface{}(Struct1{3, false}))
+	println(i1 == interface{}(s3))
+	println(interface{}([2]string{"a", "b"}) == interface{}([2]string{"a", "b"}))
+	println(interface{}([2]string{"a", "b"}) == interface{}([2]string{"a", "c"}))
+	println(interface{}(s3) == interface{}(Struct3{Struct1{3, true}, "bar", [2]int{1, 2}}))
+	println(interface{}(nan) == interface{}(nan))
+	println(interface{}(1) == interface{}(int8(1)))
+	println(interface{}(nil) == nil, i1 == nil)
+	uncomparable(interface{}([]int{1}), interface{}([]int{1}))
+	uncomparable(interface{}(Struct5{x: []int{1}}), interface{}(Struct5{x: []int{1}}))
+	uncomparable(interface{}([1]interface{}{map[int]int{}}), interface{}([1]interface{}{map[int]int{}}))
+	println(interface{}([]int{1}) == interface{}(1))
+}
+
+func uncomparable(x, y interface{}) {
+	defer func() {
+		_, ok := recover().(error)
+		println("recovered runtime error:", ok)
+	}()
+	println(x == y)
 }
 
 var x = true
@@ -47,6 +112,9 @@ var y = false
 var a = "a"
 var s1 = Struct1{3, true}
 var s2 = Struct2{"foo", 0.0, 5}
+var s3 = Struct3{Struct1{3, true}, "bar", [2]int{1, 2}}
+var a1 = [3]int{1, 2, 3}
+var zero = 0.0
 
 type Struct1 struct {
 	i int
@@ -58,3 +126,19 @@ type Struct2 struct {
 	_ float64
 	i int
 }
+
+type Struct3 struct {
+	s1 Struct1
+	s  string
+	a  [2]int
+}
+
+type Struct4 struct {
+	f float64
+	i int
+}
+
+type Struct5 struct {
+	x interface{}
+	y string
+}
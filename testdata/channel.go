@@ -0,0 +1,123 @@
+package main
+
+import "time"
+
+type pair struct {
+	a int
+	b int
+}
+
+func main() {
+	// unbuffered channel
+	ch := make(chan int)
+	go sender(ch)
+	n, ok := <-ch
+	println("recv from open channel:", n, ok)
+	for n := range ch {
+		println("received num:", n)
+	}
+	n, ok = <-ch
+	println("recv from closed channel:", n, ok)
+
+	// buffered channel
+	buf := make(chan int, 3)
+	buf <- 1
+	buf <- 2
+	println("buffered len/cap:", len(buf), cap(buf))
+	println("buffered recv:", <-buf)
+	buf <- 3
+	buf <- 4
+	println("buffered recv:", <-buf, <-buf, <-buf)
+	close(buf)
+	n, ok = <-buf
+	println("recv from closed buffered channel:", n, ok)
+
+	// struct values, received in a blocking subroutine
+	pairs := make(chan pair)
+	go sendPair(pairs)
+	recvPair(pairs)
+
+	// sender that blocks on a full buffered channel
+	full := make(chan int, 1)
+	go fill(full)
+	time.Sleep(1 * time.Millisecond)
+	println("received from full channel:", <-full, <-full, <-full)
+
+	// iterator-style channel
+	iter := make(chan int)
+	go iterator(iter, 100)
+	sum := 0
+	for i := range iter {
+		sum += i
+	}
+	println("sum(100):", sum)
+
+	// senders that are blocked when the channel is closed
+	blocked := make(chan int)
+	done := make(chan bool)
+	go blockedSend(blocked, done)
+	go blockedSelectSend(blocked, done)
+	time.Sleep(1 * time.Millisecond)
+	close(blocked)
+	<-done
+	<-done
+	println("closed channel with blocked senders")
+}
+
+func sender(ch chan int) {
+	for i := 1; i <= 5; i++ {
+		ch <- i
+	}
+	close(ch)
+}
+
+func sendPair(ch chan pair) {
+	ch <- pair{3, 5}
+}
+
+func recvPair(ch chan pair) {
+	p := <-ch
+	println("received pair:", p.a, p.b)
+}
+
+func fill(ch chan int) {
+	for i := 1; i <= 3; i++ {
+		ch <- i
+		println("sent to full channel:", i)
+	}
+}
+
+func iterator(ch chan int, top int) {
+	for i := 0; i < top; i++ {
+		ch <- i
+	}
+	close(ch)
+}
+
+func blockedSend(ch chan int, done chan bool) {
+	sendRecover(ch)
+	done <- true
+}
+
+func sendRecover(ch chan int) {
+	defer func() {
+		println("blocked send recovered:", recover().(error).Error())
+	}()
+	ch <- 1
+	println("blocked send completed")
+}
+
+func blockedSelectSend(ch chan int, done chan bool) {
+	selectSendRecover(ch)
+	done <- true
+}
+
+func selectSendRecover(ch chan int) {
+	defer func() {
+		println("blocked select recovered:", recover().(error).Error())
+	}()
+	select {
+	case ch <- 2:
+		println("blocked select completed")
+	}
+}
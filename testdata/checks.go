@@ -0,0 +1,133 @@
+package main
+
+// Test the run time checks and corner cases of integer arithmetic and pointer
+// dereferences, as defined by the Go spec.
+
+import "math"
+
+type point struct {
+	x, y int
+}
+
+func (p point) sum() int {
+	return p.x + p.y
+}
+
+type summer interface {
+	sum() int
+}
+
+var (
+	zero     = 0
+	minusOne = -1
+	shift8   = uint(8)
+	shift64  = uint(64)
+	shift200 = uint64(200)
+	nilPoint *point
+)
+
+func main() {
+	shifts()
+	division()
+	nilPointers()
+}
+
+func shifts() {
+	x := uint8(0x81)
+	y := int8(-128)
+	z := int32(-5)
+	println("shift uint8:", x<<shift8, x>>shift8, x<<(shift8-1), x>>(shift8-1))
+	println("shift int8:", y<<shift8, y>>shift8, y>>(shift8-1), y>>shift200)
+	println("shift int32:", z<<shift64, z>>shift64, uint32(z)>>shift64, z>>shift200)
+	println("shift int64:", int64(1)<<shift64, int64(-1)<<(shift64-1), uint64(1)<<shift200)
+	small := uint8(3)
+	println("shift uint8 count:", 1<<small, int64(-16)>>small, uint64(1)<<(small*30))
+
+	n := -1
+	println("shift signed count:", 1<<uint(n+2), 1<<(n+5))
+	negativeShift(n)
+}
+
+func negativeShift(n int) {
+	defer printError()
+	println(1 << n)
+}
+
+func division() {
+	println("min int / -1:", math.MinInt64/int64(minusOne), math.MinInt64%int64(minusOne))
+	println("min int32 / -1:", math.MinInt32/int32(minusOne), math.MinInt32%int32(minusOne))
+	println("min int8 / -1:", math.MinInt8/int8(minusOne), math.MinInt8%int8(minusOne))
+	println("division:", -7/2, -7%2, 7/int(minusOne), uint8(250)/uint8(minusOne))
+	divideByZero(5, zero)
+	remainderByZero(5, zero)
+	divideByZero8(5, uint8(zero))
+	divideByZero64(5, int64(zero))
+	println("float division:", 1/float64(zero) > math.MaxFloat64)
+}
+
+func divideByZero(a, b int) {
+	defer printError()
+	println(a / b)
+}
+
+func remainderByZero(a, b int) {
+	defer printError()
+	println(a % b)
+}
+
+func divideByZero8(a, b uint8) {
+	defer printError()
+	println(a / b)
+}
+
+func divideByZero64(a, b int64) {
+	defer printError()
+	println(a / b)
+}
+
+func nilPointers() {
+	loadNil()
+	storeNil()
+	fieldNil()
+	arrayNil()
+	methodNil()
+	p := &point{1, 2}
+	println("non-nil:", p.x, p.sum())
+}
+
+func loadNil() {
+	defer printError()
+	println((*nilPoint).x)
+}
+
+func storeNil() {
+	defer printError()
+	var p *int
+	*p = 3
+	println("not reached")
+}
+
+func fieldNil() {
+	defer printError()
+	nilPoint.y = 5
+	println("not reached")
+}
+
+func arrayNil() {
+	defer printError()
+	var arr *[4]int
+	arr[1] = 5
+	println("not reached")
+}
+
+func methodNil() {
+	defer printError()
+	var s summer = nilPoint
+	println(s.sum())
+}
+
+func printError() {
+	if err, ok := recover().(error); ok {
+		println("recovered error:", err.Error())
+	}
+}
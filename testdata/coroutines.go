@@ -9,6 +9,158 @@ func main() {
 	println("main 2")
 	time.Sleep(2 * time.Millisecond)
 	println("main 3")
+
+	// Blocking functions with return values.
+	println("wait result:", wait(5))
+	n, s := waitTwo(3)
+	println("wait results:", n, s)
+	p := waitPoint()
+	println("wait struct:", p.x, p.y)
+	println("wait recursive:", waitSum(4))
+	go waitTwo(1) // the results are discarded
+	time.Sleep(2 * time.Millisecond)
+	println("main 4")
+
+	// Goroutines on methods, interface methods and closures.
+	d := &driver{name: "uart", count: 2}
+	go d.run()
+	time.Sleep(5 * time.Millisecond)
+	go d.status(7)
+	time.Sleep(time.Millisecond)
+	var itf statusReporter = sample{3}
+	go itf.status(8)
+	time.Sleep(time.Millisecond)
+	msg := "closure"
+	count := 3
+	go func() {
+		time.Sleep(time.Millisecond)
+		count++
+		println(msg, count)
+	}()
+	time.Sleep(3 * time.Millisecond)
+	println("after closure:", count)
+	go func(prefix string) {
+		println(prefix, msg)
+	}("plain")
+	time.Sleep(time.Millisecond)
+	f := d.status
+	go f(9)
+	time.Sleep(time.Millisecond)
+	go println("builtin")
+	time.Sleep(time.Millisecond)
+	println("main 5")
+
+	// Blocking calls through interfaces and function pointers.
+	var r reader = &uart{data: "hello"}
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	println("read:", n, string(buf[:n]), err == nil)
+	n, err = r.Read(buf)
+	println("read:", n, err != nil)
+	r = &uart{data: "go"}
+	go readAll(r)
+	time.Sleep(5 * time.Millisecond)
+	var c counter = 5
+	r = c
+	n, _ = r.Read(buf)
+	println("read counter:", n)
+	delay := wait
+	println("func value:", delay(4))
+	sleeper := func(n int) int {
+		time.Sleep(time.Millisecond)
+		return n + count
+	}
+	println("closure value:", apply(sleeper, 1), apply(double, 2))
+	u := &uart{data: "bound"}
+	read := u.Read
+	n, _ = read(buf)
+	println("bound method:", string(buf[:n]))
+	println("main 6")
+}
+
+type reader interface {
+	Read(buf []byte) (int, error)
+}
+
+type eofError struct{}
+
+func (eofError) Error() string {
+	return "EOF"
+}
+
+// uart is a reader that waits for every byte to arrive.
+type uart struct {
+	data string
+}
+
+func (u *uart) Read(buf []byte) (int, error) {
+	if len(u.data) == 0 {
+		return 0, eofError{}
+	}
+	n := 0
+	for n < len(buf) && n < len(u.data) {
+		time.Sleep(time.Millisecond / 4)
+		buf[n] = u.data[n]
+		n++
+	}
+	u.data = u.data[n:]
+	return n, nil
+}
+
+// counter is a reader that does not block, but must be called as a blocking
+// method as it has the same method signature as uart.Read.
+type counter int
+
+func (c counter) Read(buf []byte) (int, error) {
+	return int(c), nil
+}
+
+func readAll(r reader) {
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			println("readAll:", err.Error())
+			return
+		}
+		println("readAll:", string(buf[:n]))
+	}
+}
+
+func apply(f func(int) int, n int) int {
+	return f(n)
+}
+
+func double(n int) int {
+	return n * 2
+}
+
+type statusReporter interface {
+	status(int)
+}
+
+type driver struct {
+	name  string
+	count int
+}
+
+func (d *driver) run() {
+	for i := 0; i < d.count; i++ {
+		time.Sleep(time.Millisecond)
+		println(d.name, "tick", i)
+	}
+}
+
+func (d *driver) status(code int) {
+	println(d.name, "status", code)
+}
+
+type sample struct {
+	value int
+}
+
+func (s sample) status(code int) {
+	println("sample", s.value, "status", code)
 }
 
 func sub() {
@@ -16,3 +168,32 @@ func sub() {
 	time.Sleep(2 * time.Millisecond)
 	println("sub 2")
 }
+
+type point struct {
+	x, y int16
+}
+
+func wait(n int) int {
+	time.Sleep(time.Millisecond)
+	return n * 2
+}
+
+func waitTwo(n int) (int, string) {
+	time.Sleep(time.Millisecond)
+	println("waited", n)
+	return wait(n) + 1, "done"
+}
+
+func waitPoint() point {
+	time.Sleep(time.Millisecond)
+	return point{3, -4}
+}
+
+func waitSum(n int) (sum int) {
+	if n == 0 {
+		return 0
+	}
+	time.Sleep(time.Millisecond)
+	sum = n + waitSum(n-1)
+	return
+}
@@ -1,5 +1,7 @@
 package main
 
+import "math"
+
 func main() {
 	// sanity
 	println(3.14159265358979323846)
@@ -56,4 +58,24 @@ func main() {
 	// cast complex
 	println(complex64(c128))
 	println(complex128(c64))
+
+	// complex arithmetic
+	x := complex(1.5, 2)
+	y := complex(-3, 0.5)
+	println(x+y, x-y, x*y, x/y)
+	println(-x, x == y, x != y, x == complex(1.5, 2), x != complex(1.5, 2))
+	x64 := complex64(x)
+	y64 := complex64(y)
+	println(x64+y64, x64-y64, x64*y64, x64/y64)
+	println(-x64, x64 == y64, x64 != y64, real(x64*y64), imag(x64/y64))
+
+	// complex division by zero and infinity
+	var zero complex128
+	println(x/zero, zero/zero == zero/zero, x/complex(math.Inf(1), 0))
+
+	// named complex types
+	type signal complex64
+	s := signal(complex(float32(2), -1))
+	s = s * s
+	println(s, real(s), imag(s), complex(real(s), float32(0.5)))
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type point struct {
+	X, Y int
+	tag  string
+}
+
+type node struct {
+	Value int8
+	Next  *node
+}
+
+type celsius float64
+
+func (c celsius) String() string {
+	return strconv.FormatFloat(float64(c), 'f', 1, 64) + "C"
+}
+
+type counter int
+
+func (c *counter) String() string {
+	return fmt.Sprintf("counter(%d)", int(*c))
+}
+
+type codeError struct {
+	code int
+}
+
+func (e codeError) Error() string {
+	return fmt.Sprint("error code ", e.code)
+}
+
+func main() {
+	// integers
+	fmt.Printf("%d %5d|%-5d|%05d %+d % d\n", 42, 42, 42, -42, 3, 4)
+	fmt.Printf("%x %X %#x %o %#o %b %08b\n", 255, 255, 255, 8, 8, 5, 5)
+	fmt.Printf("%c %q %U %v\n", 'A', 'x', 0x1F600, 'a')
+	fmt.Printf("%d %d %d %d\n", int8(-128), uint8(200), int64(-1<<40), uint64(1<<63))
+
+	// floats
+	fmt.Printf("%f %.2f %8.3f|%-8.1f|\n", 3.14159, 3.14159, 3.14159, 2.5)
+	fmt.Printf("%e %.3E %g %g %G\n", 1234.5678, 1234.5678, 0.000012, 1e21, 1e-10)
+	fmt.Printf("%v %v %05.1f %6.2f%%\n", 1.5, float32(0.1), -2.25, 99.5)
+
+	// strings and byte slices
+	fmt.Printf("%s|%10s|%-10s|%.3s|\n", "foo", "bar", "baz", "abcdef")
+	fmt.Printf("%q %+q %x % X\n", "quo\"te", "héllo", "hi", []byte("hi"))
+	data := [4]byte{0xde, 0xad, 0xbe, 0xef}
+	fmt.Printf("%x %v %s\n", data, data[:2], []byte("bytes"))
+
+	// booleans and nil
+	fmt.Printf("%t %v %v\n", true, false, nil)
+	var np *node
+	var ns []int
+	var nm map[string]int
+	fmt.Println(np, ns, nm)
+
+	// composite values
+	p := point{1, -2, "origin"}
+	fmt.Printf("%v %+v\n", p, p)
+	fmt.Printf("%v %+v\n", &node{Value: 5}, node{Value: 6})
+	fmt.Printf("%v %d %s %q\n", []int{1, 2, 3}, [2]int16{4, 5}, []string{"a", "b"}, []string{"c"})
+	fmt.Println(map[string]int{"one": 1}, [][]int{{1}, {2, 3}}, []interface{}{1, "a", nil, 2.5})
+
+	// methods
+	c := counter(7)
+	fmt.Println(celsius(21.5), &c, c)
+	var err error = codeError{4}
+	fmt.Printf("%v|%s|%d\n", err, err, err)
+	fmt.Println(errors.New("plain error"), fmt.Errorf("wrapped: %v", err))
+
+	// argument handling
+	fmt.Printf("%*d|%-*d|%[2]d %[1]d\n", 5, 42, 3, 7)
+	fmt.Printf("%d %s\n", 1)
+	fmt.Printf("%d\n", "str")
+	fmt.Printf("%d%%\n", 50)
+	fmt.Printf("%!\n")
+	fmt.Printf("%T %T %T %T\n", 1, "s", 2.5, true)
+
+	// Sprint and friends
+	s := fmt.Sprintf("%08.3f", -3.5)
+	fmt.Println(s, len(s), fmt.Sprint("a", 1, 2, "b"), fmt.Sprintln("x", 3) == "x 3\n")
+	fmt.Print("a", "b", 1, 2, 3.5, "\n")
+	fmt.Fprintln(os.Stdout, "stdout")
+
+	// strings
+	fmt.Println(strings.ToUpper("hello"), strings.Repeat("ab", 3), strings.Split("a,b,c", ","))
+	fmt.Println(strings.Contains("seafood", "foo"), strings.Index("chicken", "ken"), strings.Count("cheese", "e"))
+	fmt.Println(strings.Fields("  a b  c "), strings.TrimSpace("  x  "), strings.Replace("oink oink", "k", "ky", -1))
+	fmt.Println(strings.Join([]string{"x", "y"}, "-"), strings.HasPrefix("golang", "go"), strings.Title("hi there"))
+	var sb strings.Builder
+	sb.WriteString("build")
+	sb.WriteByte('!')
+	fmt.Println(sb.String())
+
+	// strconv
+	n, err := strconv.Atoi("1234")
+	fmt.Println(n, err)
+	_, err = strconv.Atoi("12a")
+	fmt.Println(err)
+	f, _ := strconv.ParseFloat("2.5e3", 64)
+	b, _ := strconv.ParseBool("true")
+	fmt.Println(f, b, strconv.Itoa(-99), strconv.Quote("tab\t"), strconv.FormatInt(255, 16))
+
+	// os
+	os.Stdout.WriteString("os.Stdout.WriteString\n")
+	fmt.Println(os.Getenv("TINYGO_UNSET_VARIABLE") == "")
+	os.Exit(0)
+}
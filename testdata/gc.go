@@ -0,0 +1,89 @@
+package main
+
+// Allocate much more memory than the heap of a small target, so that the
+// garbage collector has to free objects and reuse their memory, while checking
+// that objects that are still reachable are left alone.
+
+type node struct {
+	next  *node
+	value int
+	data  [4]int
+}
+
+func newNode(next *node, value int) *node {
+	n := &node{next: next, value: value}
+	for i := range n.data {
+		n.data[i] = value*10 + i
+	}
+	return n
+}
+
+// Reachable from a global. Scanning this slice marks more objects at once than
+// fit in the mark queue of the collector.
+var kept []*node
+
+// The last garbage object, so that allocations are not optimized away.
+var sink []byte
+
+func main() {
+	kept = make([]*node, 100)
+	for i := range kept {
+		kept[i] = newNode(nil, i)
+	}
+
+	// Only reachable from the stack.
+	var list *node
+
+	total := 0
+	for i := 0; i < 5000; i++ {
+		size := 16 + (i*37)%480
+		garbage := make([]byte, size)
+		for j := range garbage {
+			garbage[j] = byte(i)
+		}
+		sink = garbage
+		total += size
+		if i%250 == 0 {
+			list = newNode(list, i)
+		}
+	}
+	println("allocated more than 1MB:", total > 1024*1024)
+
+	ok := true
+	for i, n := range kept {
+		if !checkNode(n, i) {
+			println("corrupted node in slice:", i)
+			ok = false
+		}
+	}
+	count := 0
+	for n := list; n != nil; n = n.next {
+		if !checkNode(n, 4750-count*250) {
+			println("corrupted node in list:", count)
+			ok = false
+		}
+		count++
+	}
+	println("list length:", count)
+	last := 4999
+	for _, b := range sink {
+		if b != byte(last) {
+			println("corrupted last allocation")
+			ok = false
+			break
+		}
+	}
+	println("reachable objects intact:", ok)
+}
+
+func checkNode(n *node, value int) bool {
+	if n.value != value {
+		return false
+	}
+	for i, v := range n.data {
+		if v != value*10+i {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,9 @@
+// Package main is built by TestGOOS in target_test.go. It prints the name of
+// the file that was selected by the build constraints.
+package main
+
+import "runtime"
+
+func main() {
+	println(runtime.GOOS, selected)
+}
@@ -13,6 +13,15 @@ func main() {
 	println("v5:", len(v5), v5 == nil)
 	println("v6:", v6)
 	println("v7:", cap(v7), string(v7))
+	println("v8:", len(v8), cap(v8), v8[0], v8[1])
+	println("v9:", len(v9), cap(v9), v9[0], v9[1])
+	println("v10:", len(v10), cap(v10), v10[0], v10[1])
+	println("v11:", v11, len(v11))
+
+	// slices of globals share the backing array
+	v8[0] = 10
+	v10[1] = 11
+	println("shared:", buf[2], v9[1], v3[2], v3alias[2])
 }
 
 type (
@@ -28,6 +37,14 @@ var (
 	v3 = []int{2, 3, 5, 7}
 	v4 map[string]int
 	v5 = map[string]int{}
+
+	buf     = [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	v8      = buf[2:4]
+	v9      = buf[1:3:5]
+	v10     = v3[1:3]
+	v11     = "hello world"[6:]
+	v3alias = v3[:]
+
 	v6 = float64(v1) < 2.6
 	v7 = []byte("foo")
 )
@@ -22,6 +22,19 @@ func main() {
 	println("Stringer.(*Thing).String():", itf.(Stringer).String())
 
 	println("nested switch:", nestedSwitch('v', 3))
+
+	// Type asserts on interfaces with more than one method.
+	printInterfaces(thing)
+	printInterfaces(array)
+	printInterfaces(Number(3))
+}
+
+func printInterfaces(val interface{}) {
+	_, isStringer := val.(Stringer)
+	_, isTuple := val.(Tuple)
+	_, isDoubler := val.(Doubler)
+	_, isPrinter := val.(Printer)
+	println("interfaces:", isStringer, isTuple, isDoubler, isPrinter)
 }
 
 func printItf(val interface{}) {
@@ -75,6 +88,10 @@ type Stringer interface {
 	String() string
 }
 
+type Printer interface {
+	Print()
+}
+
 type Foo int
 
 type Number int
@@ -1,5 +1,17 @@
 package main
 
+import "math"
+
+type route struct {
+	method string
+	path   string
+}
+
+type handler func() string
+
+func index() string    { return "index" }
+func notFound() string { return "not found" }
+
 var testmap1 = map[string]int{"data": 3}
 var testmap2 = map[string]int{
 	"one":    1,
@@ -16,6 +28,25 @@ var testmap2 = map[string]int{
 	"twelve": 12,
 }
 
+var handlers = map[interface{}]handler{
+	404:                 notFound,
+	"/":                 index,
+	route{"GET", "/"}:   index,
+	[2]string{"a", "b"}: notFound,
+	2.5:                 index,
+	nil:                 notFound,
+}
+
+var routes = map[route]int{
+	{"GET", "/"}:       1,
+	{"POST", "/login"}: 2,
+}
+
+var floatmap = map[float64]string{
+	0:   "zero",
+	1.5: "one and a half",
+}
+
 func main() {
 	m := map[string]int{"answer": 42, "foo": 3}
 	readMap(m, "answer")
@@ -31,16 +62,182 @@ func main() {
 	var nilmap map[string]int
 	println(m == nil, m != nil, len(m))
 	println(nilmap == nil, nilmap != nil, len(nilmap))
+
+	// maps with keys that can't be compared bytewise
+	interfaceKeys(handlers)
+	structKeys(routes)
+	floatKeys(floatmap)
+	arrayKeys()
+
+	// modifying a map while iterating over it
+	growMap()
+	rangeDelete()
+	rangeInsert()
+	rangeOrder()
 }
 
 func readMap(m map[string]int, key string) {
 	println("map length:", len(m))
 	println("map read:", key, "=", m[key])
-	for k, v := range m {
-		println(" ", k, "=", v)
+	// Iteration order is random, so print the entries sorted by key.
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+	for _, k := range keys {
+		println(" ", k, "=", m[k])
 	}
 }
 func lookup(m map[string]int, key string) {
 	value, ok := m[key]
 	println("lookup with comma-ok:", key, value, ok)
 }
+
+func interfaceKeys(m map[interface{}]handler) {
+	println("interface keys:", len(m))
+	for _, key := range []interface{}{404, int8(127), "/", "/x", route{"GET", "/"}, route{"GET", "/x"}, [2]string{"a", "b"}, 2.5, nil} {
+		if h, ok := m[key]; ok {
+			println("  found:", h())
+		} else {
+			println("  not found")
+		}
+	}
+	m[int8(127)] = index
+	m[route{"GET", "/"}] = notFound
+	delete(m, 404)
+	delete(m, nil)
+	println("  after update:", len(m), m[int8(127)](), m[route{"GET", "/"}](), m[404] == nil, m[nil] == nil)
+
+	// Interfaces of different types with the same value.
+	local := map[interface{}]int{}
+	local[1] = 1
+	local[uint(1)] = 2
+	local[1.0] = 3
+	local["1"] = 4
+	local[true] = 5
+	println("  local:", len(local), local[1], local[uint(1)], local[1.0], local["1"], local[true], local[false])
+}
+
+func structKeys(m map[route]int) {
+	println("struct keys:", len(m), m[route{"GET", "/"}], m[route{"POST", "/login"}], m[route{"GET", "/login"}])
+	path := "/log"
+	path += "out"
+	m[route{"POST", path}] = 3
+	println("  logout:", len(m), m[route{"POST", "/logout"}])
+	m[route{"POST", "/logout"}] = 4
+	delete(m, route{"GET", "/"})
+	println("  updated:", len(m), m[route{"POST", path}], m[route{"GET", "/"}])
+}
+
+func floatKeys(m map[float64]string) {
+	negativeZero := math.Copysign(0, -1)
+	println("float keys:", len(m), m[0], m[negativeZero], m[1.5])
+	m[negativeZero] = "negative zero"
+	println("  zero:", len(m), m[0])
+	nan := math.NaN()
+	m[nan] = "nan"
+	m[nan] = "nan"
+	_, ok := m[nan]
+	println("  nan:", len(m), ok)
+
+	m32 := map[float32]int{1.25: 1}
+	m32[float32(negativeZero)] = 2
+	println("  float32:", len(m32), m32[1.25], m32[0])
+}
+
+func arrayKeys() {
+	m := map[[2]string]int{}
+	m[[2]string{"a", "b"}] = 1
+	m[[2]string{"b", "a"}] = 2
+	key := [2]string{"a", ""}
+	key[1] = "b"
+	println("array keys:", len(m), m[key], m[[2]string{"b", "a"}], m[[2]string{"a", "a"}])
+}
+
+func growMap() {
+	m := map[int]int{}
+	for i := 0; i < 100; i++ {
+		m[i] = i * 2
+	}
+	sum := 0
+	for k, v := range m {
+		if v != k*2 {
+			println("  wrong value:", k, v)
+		}
+		sum += k
+	}
+	println("grow:", len(m), m[0], m[50], m[99], sum)
+}
+
+func rangeDelete() {
+	m := map[int]int{}
+	for i := 0; i < 100; i++ {
+		m[i] = i
+	}
+
+	// Deleted entries that haven't been reached yet must not be returned.
+	seen := 0
+	for k := range m {
+		delete(m, k^1)
+		seen++
+	}
+	println("range delete:", seen, len(m))
+
+	// Delete all entries.
+	for k := range m {
+		delete(m, k)
+	}
+	println("  all deleted:", len(m))
+}
+
+func rangeInsert() {
+	m := map[int]int{}
+	for i := 0; i < 10; i++ {
+		m[i] = 0
+	}
+
+	// Each entry that was present at the start must be returned exactly once,
+	// even when the map grows. Entries that are updated must be returned with
+	// their new value.
+	seen := 0
+	stale := 0
+	for k, v := range m {
+		if k < 10 {
+			seen++
+			if v == 0 {
+				stale++
+			}
+			for i := 0; i < 10; i++ {
+				m[i] = 1
+			}
+			m[k+100] = 0
+			m[k+200] = 0
+		}
+	}
+	println("range insert:", seen, stale, len(m))
+}
+
+func rangeOrder() {
+	m := map[int]bool{}
+	for i := 0; i < 8; i++ {
+		m[i] = true
+	}
+	first := -1
+	different := false
+	for i := 0; i < 50; i++ {
+		for k := range m {
+			if first < 0 {
+				first = k
+			} else if k != first {
+				different = true
+			}
+			break
+		}
+	}
+	println("random order:", different)
+}
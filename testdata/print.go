@@ -34,6 +34,8 @@ func main() {
 
 	// print interface
 	println(interface{}(nil))
+	println(interface{}(42), interface{}("foo"), interface{}(true))
+	println(interface{}(myInt(-5)), interface{}(myString("bar")), interface{}(myBool(true)))
 
 	// print map
 	println(map[string]int{"three": 3, "five": 5})
@@ -43,3 +45,9 @@ func main() {
 	// print bool
 	println(true, false)
 }
+
+type myInt int
+
+type myString string
+
+type myBool bool
@@ -0,0 +1,196 @@
+package main
+
+import "time"
+
+func main() {
+	println("recover outside panic:", recover() == nil)
+
+	// simple panic and recover
+	println("result:", safeDivide(10, 2))
+	println("result:", safeDivide(1, 0))
+
+	// deferred calls run in order while unwinding through multiple frames
+	outer()
+
+	// runtime errors
+	indexOutOfRange()
+	closeTwice()
+	badTypeAssert()
+	unhashableMapKey()
+	uncomparableInterfaces()
+
+	// panic in a deferred call replaces the original panic
+	rePanic()
+
+	// recover in a goroutine that blocks
+	done := make(chan bool)
+	go worker(done)
+	<-done
+
+	// a blocking function that panics, recovered by its caller
+	recoverBlocking()
+
+	// a blocking function that returns a value set by its deferred call
+	println("blocking: result:", sleepAndDivide(0))
+
+	// recover only stops a panic when called directly by a deferred call
+	indirectRecover()
+}
+
+func safeDivide(a, b int) (result int) {
+	defer func() {
+		if r := recover(); r != nil {
+			println("recovered:", r.(string))
+			result = -1
+		}
+	}()
+	if b == 0 {
+		panic("division by zero")
+	}
+	return a / b
+}
+
+func outer() {
+	defer func() {
+		println("outer: recovered:", recover().(string))
+	}()
+	defer printMessage("outer: deferred call")
+	middle()
+	println("outer: not reached")
+}
+
+func middle() {
+	defer printMessage("middle: deferred call 1")
+	defer printMessage("middle: deferred call 2")
+	inner()
+	println("middle: not reached")
+}
+
+func inner() {
+	panic("inner panic")
+}
+
+func indexOutOfRange() {
+	defer printError()
+	var arr []int
+	n := 3
+	println(arr[n])
+}
+
+func closeTwice() {
+	defer printError()
+	ch := make(chan int)
+	close(ch)
+	close(ch)
+}
+
+func badTypeAssert() {
+	defer printError()
+	var itf interface{} = 3
+	println(itf.(string))
+}
+
+func unhashableMapKey() {
+	m := map[interface{}]int{"a": 1}
+	setMapKey(m, []int{1})
+	setMapKey(m, struct{ a, b func() }{})
+	getMapKey(m, []int{1})
+	deleteMapKey(m, []int{1})
+	println("map length:", len(m), m["a"])
+}
+
+func setMapKey(m map[interface{}]int, key interface{}) {
+	defer printError()
+	m[key] = 2
+	println("set: not reached")
+}
+
+func getMapKey(m map[interface{}]int, key interface{}) {
+	defer printError()
+	println("get: not reached", m[key])
+}
+
+func deleteMapKey(m map[interface{}]int, key interface{}) {
+	defer printError()
+	delete(m, key)
+	println("delete: not reached")
+}
+
+func uncomparableInterfaces() {
+	defer printError()
+	var x, y interface{} = []int{1}, []int{1}
+	println("compare: not reached", x == y)
+}
+
+func printError() {
+	if err, ok := recover().(error); ok {
+		println("recovered error:", err.Error())
+	}
+}
+
+func rePanic() {
+	defer func() {
+		println("re-panic: recovered:", recover().(string))
+	}()
+	defer func() {
+		panic("second panic")
+	}()
+	panic("first panic")
+}
+
+func worker(done chan bool) {
+	sleepAndRecover()
+	done <- true
+}
+
+func sleepAndRecover() {
+	defer func() {
+		println("worker: recovered:", recover().(string))
+	}()
+	time.Sleep(time.Millisecond)
+	panic("worker panic")
+}
+
+func recoverBlocking() {
+	defer func() {
+		println("blocking: recovered:", recover().(string))
+	}()
+	sleepAndPanic()
+	println("blocking: not reached")
+}
+
+func sleepAndPanic() {
+	time.Sleep(time.Millisecond)
+	panic("panic after sleep")
+}
+
+func sleepAndDivide(n int) (result int) {
+	defer func() {
+		if recover() != nil {
+			result = -1
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	if n == 0 {
+		panic("division by zero")
+	}
+	return 10 / n
+}
+
+func printMessage(msg string) {
+	println(msg)
+}
+
+func indirectRecover() {
+	defer func() {
+		println("indirect: recovered:", recover().(string))
+	}()
+	defer func() {
+		println("indirect: helper recovered:", tryRecover() != nil)
+	}()
+	panic("indirect panic")
+}
+
+func tryRecover() interface{} {
+	return recover()
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+)
+
+type point struct {
+	X, Y   int16
+	label  string
+	parent *point
+}
+
+type celsius float32
+
+type myInt int
+
+type myString string
+
+type myBool bool
+
+func main() {
+	println("kinds:")
+	showValue(true)
+	showValue(-5)
+	showValue(int8(-3))
+	showValue(uint16(300))
+	showValue(uintptr(8))
+	showValue(uint64(1) << 40)
+	showValue(3.5)
+	showValue(celsius(21.5))
+	showValue("foo")
+	showValue([]byte("abc"))
+	showValue([3]int32{1, 2, 3})
+	showValue(point{X: 1, Y: -2, label: "p"})
+	showValue(&point{X: 3})
+	showValue(map[string]int{"one": 1})
+	showValue(make(chan int, 2))
+	showValue(complex(1, 2))
+
+	println("\nstruct:")
+	t := reflect.TypeOf(point{})
+	println("size:", int(t.Size()), "fields:", t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		println("field:", field.Name, field.Type.Kind().String(), int(field.Offset))
+	}
+	p := point{X: 5, Y: 7, label: "origin"}
+	v := reflect.ValueOf(p)
+	println("X:", v.Field(0).Int(), "Y:", v.Field(1).Int(), "label:", v.Field(2).String())
+	println("CanSet:", v.Field(0).CanSet())
+
+	println("\npointer:")
+	e := reflect.ValueOf(&p).Elem()
+	println("CanSet:", e.Field(0).CanSet(), e.Field(2).CanSet())
+	e.Field(0).SetInt(-100)
+	e.Field(1).Set(reflect.ValueOf(int16(42)))
+	println("X:", p.X, "Y:", p.Y)
+	println("parent is nil:", e.Field(3).IsNil())
+	var nilPoint *point
+	println("nil Elem valid:", reflect.ValueOf(nilPoint).Elem().IsValid())
+
+	println("\nslice:")
+	s := []string{"a", "b", "c", "d"}
+	sv := reflect.ValueOf(s)
+	println("len:", sv.Len(), "cap:", sv.Cap(), "s[2]:", sv.Index(2).String())
+	sv.Index(0).SetString("z")
+	println("s[0]:", s[0])
+	sub := sv.Slice(1, 3)
+	println("sub:", sub.Len(), sub.Index(0).String(), sub.Index(1).String())
+	made := reflect.MakeSlice(reflect.TypeOf([]int{}), 3, 5)
+	made.Index(1).SetInt(9)
+	ints := made.Interface().([]int)
+	println("made:", len(ints), cap(ints), ints[0], ints[1], ints[2])
+	swap := reflect.Swapper(s)
+	swap(0, 3)
+	println("swapped:", s[0], s[1], s[2], s[3])
+
+	println("\narray:")
+	a := [3]int32{4, 5, 6}
+	av := reflect.ValueOf(a)
+	println("len:", av.Len(), "a[1]:", av.Index(1).Int())
+	reflect.ValueOf(&a).Elem().Index(2).SetInt(60)
+	println("a[2]:", a[2])
+
+	println("\nmap:")
+	m := map[string]int{"answer": 42}
+	mv := reflect.ValueOf(m)
+	println("len:", mv.Len(), "answer:", mv.MapIndex(reflect.ValueOf("answer")).Int())
+	println("missing valid:", mv.MapIndex(reflect.ValueOf("missing")).IsValid())
+	for _, key := range mv.MapKeys() {
+		println("key:", key.String())
+	}
+
+	println("\ninterface:")
+	var itf interface{} = &p
+	iv := reflect.ValueOf(&itf).Elem()
+	println("kind:", iv.Kind().String(), "elem:", iv.Elem().Kind().String())
+	iv.Set(reflect.ValueOf(3.25))
+	println("itf:", itf.(float64))
+	f := reflect.ValueOf(celsius(-4.5)).Interface().(celsius)
+	println("celsius:", f)
+
+	println("types:")
+	showType(5)
+	showType(celsius(21.5))
+	showType([]byte("abc"))
+	showType([3]int32{1, 2, 3})
+	showType(point{X: 1})
+	showType(&point{X: 3})
+	showType(map[string]*point{})
+	showType(make(chan celsius))
+	showType(reflect.Value{})
+
+	// Interface values are printed with their type name when the program
+	// uses reflect.
+	println("print:")
+	println(interface{}(myInt(-5)), interface{}(myString("bar")), interface{}(myBool(true)))
+}
+
+func showType(x interface{}) {
+	println(reflect.TypeOf(x).String())
+}
+
+func showValue(x interface{}) {
+	v := reflect.ValueOf(x)
+	t := v.Type()
+	print(t.Kind().String(), " ", int(t.Size()), ": ")
+	switch t.Kind() {
+	case reflect.Bool:
+		println(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		println(t.Bits(), v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		println(t.Bits(), v.Uint())
+	case reflect.Float32, reflect.Float64:
+		println(t.Bits(), v.Float())
+	case reflect.Complex64, reflect.Complex128:
+		println(t.Bits(), v.Complex())
+	case reflect.String:
+		println(v.Len(), v.String())
+	case reflect.Slice:
+		println(v.Len(), t.Elem().Kind().String(), string(v.Bytes()))
+	case reflect.Array:
+		println(t.Len(), t.Elem().Kind().String(), v.Index(2).Int())
+	case reflect.Struct:
+		println(t.NumField(), v.Field(2).String())
+	case reflect.Ptr:
+		println(t.Elem().Kind().String(), v.Elem().Field(0).Int())
+	case reflect.Map:
+		println(t.Key().Kind().String(), t.Elem().Kind().String(), v.Len())
+	case reflect.Chan:
+		println(t.Elem().Kind().String(), v.Len(), v.Cap())
+	default:
+		println("?")
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import "time"
+
+func main() {
+	// non-blocking operations with a default case
+	ch := make(chan int, 1)
+	select {
+	case n := <-ch:
+		println("unexpected receive:", n)
+	default:
+		println("nothing to receive")
+	}
+	select {
+	case ch <- 5:
+		println("sent to buffered channel")
+	default:
+		println("unexpected default")
+	}
+	select {
+	case ch <- 6:
+		println("unexpected send")
+	default:
+		println("buffer full")
+	}
+
+	// select that is ready immediately
+	select {
+	case n, ok := <-ch:
+		println("received from buffered channel:", n, ok)
+	case <-time.After(time.Second):
+		println("unexpected timeout")
+	}
+
+	// wait on multiple channels
+	a := make(chan int)
+	b := make(chan string)
+	go sendDelayed(a, b)
+	for i := 0; i < 2; i++ {
+		select {
+		case n := <-a:
+			println("received from a:", n)
+		case s := <-b:
+			println("received from b:", s)
+		}
+	}
+
+	// send and receive cases in the same select
+	results := make(chan int)
+	go worker(results)
+	var out chan int // nil channel: this case is never selected
+	for count := 0; count < 3; {
+		select {
+		case n := <-results:
+			println("worker result:", n)
+			count++
+		case out <- 1:
+			println("unexpected send on nil channel")
+		}
+	}
+
+	// timeout
+	never := make(chan int)
+	select {
+	case <-never:
+		println("unexpected receive")
+	case <-time.After(2 * time.Millisecond):
+		println("timeout")
+	}
+
+	// timer racing with a sender
+	select {
+	case n := <-slowSender(1 * time.Millisecond):
+		println("sender won:", n)
+	case <-time.After(50 * time.Millisecond):
+		println("unexpected timeout")
+	}
+
+	// closed channel
+	done := make(chan struct{})
+	go closeAfter(done)
+	select {
+	case _, ok := <-done:
+		println("done channel closed:", !ok)
+	}
+
+	// ticker
+	ticker := time.NewTicker(time.Millisecond)
+	for i := 0; i < 3; i++ {
+		<-ticker.C
+		println("tick", i)
+	}
+	ticker.Stop()
+
+	// A goroutine blocked on a nil channel can never be woken up, so it must
+	// not keep the program waiting for a pending timer after main returns.
+	go blockOnNil()
+	time.After(time.Hour)
+	time.Sleep(time.Millisecond)
+}
+
+func sendDelayed(a chan int, b chan string) {
+	time.Sleep(time.Millisecond)
+	b <- "hello"
+	time.Sleep(time.Millisecond)
+	a <- 42
+}
+
+func worker(results chan int) {
+	for i := 1; i <= 3; i++ {
+		results <- i * 10
+	}
+}
+
+func slowSender(d time.Duration) chan int {
+	ch := make(chan int)
+	go sendAfter(ch, d)
+	return ch
+}
+
+func sendAfter(ch chan int, d time.Duration) {
+	time.Sleep(d)
+	ch <- 7
+}
+
+func closeAfter(ch chan struct{}) {
+	time.Sleep(time.Millisecond)
+	close(ch)
+}
+
+func blockOnNil() {
+	var ch chan int
+	<-ch
+	println("unexpected receive from nil channel")
+}
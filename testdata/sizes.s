@@ -0,0 +1,54 @@
+// Source of sizes.elf, which is used by TestSizes in binutils_test.go. It has
+// symbols of every kind and sections that are neither code nor data, like a
+// note section. Build it with:
+//
+//     gcc -nostdlib -static -Wl,-n,-z,max-page-size=16,--build-id=none -o testdata/sizes.elf testdata/sizes.s
+
+	.text
+	.globl _start
+	.type _start, %function
+_start:
+	call main.main
+	jmp _start
+	.size _start, .-_start
+
+	.globl main.main
+	.type main.main, %function
+main.main:
+	.fill 16, 1, 0x90
+	ret
+	.size main.main, .-main.main
+
+	.globl runtime.alloc
+	.type runtime.alloc, %function
+runtime.alloc:
+	.fill 7, 1, 0x90
+	ret
+	.size runtime.alloc, .-runtime.alloc
+
+	.section .rodata
+	.globl main.table
+	.type main.table, %object
+main.table:
+	.fill 12, 1, 1
+	.size main.table, .-main.table
+
+	.data
+	.globl runtime.heapStart
+	.type runtime.heapStart, %object
+runtime.heapStart:
+	.quad 0
+	.size runtime.heapStart, .-runtime.heapStart
+
+	.bss
+	.globl main.buf
+	.type main.buf, %object
+main.buf:
+	.zero 32
+	.size main.buf, .-main.buf
+
+	.section .note.tinygo, "a", %note
+	.long 7, 4, 1
+	.asciz "tinygo"
+	.balign 4
+	.long 0
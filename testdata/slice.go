@@ -8,6 +8,8 @@ func main() {
 	printslice("foo", foo)
 	printslice("bar", bar)
 	printslice("foo[1:2]", foo[1:2])
+	printslice("foo[1:2:3]", foo[1:2:3])
+	printslice("foo[:0:0]", foo[:0:0])
 	println("sum foo:", sum(foo))
 
 	// copy
@@ -36,6 +38,28 @@ func main() {
 		print(" ", n)
 	}
 	println()
+
+	// full slice expressions
+	arr := [6]int{1, 2, 3, 4, 5, 6}
+	low, high, max := uint8(1), int64(3), 4
+	full := arr[low:high:max]
+	printslice("arr[1:3:4]", full)
+	full = append(full, 10)
+	full = append(full, 11)
+	full[0] = 20
+	println("full:", len(full), full[0], full[1], full[2], full[3])
+	printslice("arr", arr[:])
+	printslice("foo[2:len:cap]", foo[2:len(foo):cap(foo)])
+	sliceOutOfRange(foo, 2, 3, 1)
+	sliceOutOfRange(foo, 0, 2, 5)
+	sliceOutOfRange(foo, 1, 2, 3)
+}
+
+func sliceOutOfRange(s []int, low, high, max int) {
+	defer func() {
+		println("recovered:", recover() != nil)
+	}()
+	printslice("slice", s[low:high:max])
 }
 
 func printslice(name string, s []int) {
@@ -0,0 +1,60 @@
+package main
+
+import "runtime"
+
+func main() {
+	printCaller(0)
+	indirect()
+	var t T
+	t.value()
+	t.pointer()
+	func() {
+		printCaller(0)
+		func() {
+			printCaller(0)
+		}()
+	}()
+	printCallers()
+	println(runtime.FuncForPC(0) == nil)
+}
+
+func indirect() {
+	printCaller(1)
+}
+
+type T struct{}
+
+func (t T) value() {
+	printCaller(1)
+}
+
+func (t *T) pointer() {
+	printCaller(1)
+}
+
+func printCaller(skip int) {
+	pc, file, line, ok := runtime.Caller(skip + 1)
+	if !ok {
+		println("no caller")
+		return
+	}
+	println(runtime.FuncForPC(pc).Name(), baseName(file), line)
+}
+
+func printCallers() {
+	var pcs [1]uintptr
+	n := runtime.Callers(2, pcs[:])
+	println("callers:", n)
+	for _, pc := range pcs[:n] {
+		println(runtime.FuncForPC(pc).Name())
+	}
+}
+
+func baseName(path string) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i] == '/' {
+			return path[i+1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,51 @@
+package main
+
+func main() {
+	// range over strings
+	rangeString("hello")
+	rangeString("héllo, 世界 🌍")
+	rangeString("")
+
+	// invalid UTF-8
+	rangeString("a\xffb")                // invalid start byte
+	rangeString("\xc3")                  // truncated sequence
+	rangeString("\xe4\xb8")              // truncated sequence
+	rangeString("\xc3\x28")              // invalid continuation byte
+	rangeString("\xc0\xaf")              // overlong encoding
+	rangeString("\xe0\x80\xaf")          // overlong encoding
+	rangeString("\xed\xa0\x80")          // surrogate half
+	rangeString("\xf4\x90\x80\x80")      // above U+10FFFF
+	rangeString("\x80\xbf")              // unexpected continuation bytes
+	rangeString("\xf0\x9f\x8c\x8d\xf0x") // valid rune followed by truncated sequence
+
+	// string <-> []rune
+	runes := []rune("héllo, 世界")
+	println("runes:", len(runes), runes[0], runes[1], runes[7], runes[8])
+	runes[0] = 'H'
+	runes = append(runes, '!', 0x1f30d)
+	s := string(runes)
+	println("string:", s, len(s))
+	println("invalid:", len([]rune("a\xffb\xc3")), []rune("\xe0\x80\xaf")[0])
+	println("empty:", len([]rune("")), string([]rune{}) == "", string([]rune(nil)) == "")
+	invalid := string([]rune{-1, 0xd800, 0x110000, 'x'})
+	println("invalid runes:", invalid == "���x", len(invalid))
+
+	// string from a code point
+	var r rune = 0x4e16
+	var big int64 = 0x100000041
+	var neg = -5
+	println("code points:", string(r), string(rune(0xdfff)) == "�", string(big) == "�", string(neg) == "�", string(uint32(0xffffffff)) == "�")
+
+	// named types
+	type char rune
+	chars := []char{'o', 'k'}
+	println("named:", string(chars))
+}
+
+func rangeString(s string) {
+	print("range ", len(s), ":")
+	for i, r := range s {
+		print(" ", i, "=", r)
+	}
+	println()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var wg sync.WaitGroup
+
+// Mutex: goroutines that yield while holding the lock.
+
+var (
+	counter     int
+	counterLock sync.Mutex
+)
+
+func add(n int) {
+	for i := 0; i < 3; i++ {
+		counterLock.Lock()
+		v := counter
+		time.Sleep(time.Millisecond)
+		counter = v + n
+		counterLock.Unlock()
+	}
+	wg.Done()
+}
+
+// Cond: a sampling goroutine that shares a buffer with a transmit goroutine.
+
+var (
+	samples     []int
+	samplesDone bool
+	samplesLock sync.Mutex
+	samplesCond *sync.Cond
+	transmitted [][]int
+)
+
+func sampler() {
+	for i := 1; i <= 8; i++ {
+		samplesLock.Lock()
+		samples = append(samples, i*i)
+		samplesCond.Signal()
+		samplesLock.Unlock()
+		time.Sleep(time.Millisecond)
+	}
+	samplesLock.Lock()
+	samplesDone = true
+	samplesCond.Signal()
+	samplesLock.Unlock()
+	wg.Done()
+}
+
+func transmitter() {
+	samplesLock.Lock()
+	for {
+		for len(samples) < 4 && !samplesDone {
+			samplesCond.Wait()
+		}
+		if len(samples) == 0 {
+			break
+		}
+		batch := samples
+		samples = nil
+		samplesLock.Unlock()
+		transmitted = append(transmitted, batch)
+		samplesLock.Lock()
+	}
+	samplesLock.Unlock()
+	wg.Done()
+}
+
+// RWMutex: readers share the lock, a waiting writer blocks new readers.
+
+var (
+	value  int
+	seen   [3]int
+	rwLock sync.RWMutex
+)
+
+func reader(index int) {
+	rwLock.RLock()
+	seen[index] = value
+	time.Sleep(3 * time.Millisecond)
+	rwLock.RUnlock()
+	wg.Done()
+}
+
+func writer() {
+	rwLock.Lock()
+	value = 42
+	rwLock.Unlock()
+	wg.Done()
+}
+
+// Once: only the first call runs the function.
+
+var (
+	once  sync.Once
+	calls int
+)
+
+func setup() {
+	calls++
+}
+
+func doOnce() {
+	once.Do(setup)
+	wg.Done()
+}
+
+// Once with a function that blocks: other callers return only after it has
+// finished.
+
+var (
+	slowOnce    sync.Once
+	slowStarted = make(chan bool)
+	slowReady   bool
+)
+
+func slowSetup() {
+	slowStarted <- true
+	time.Sleep(time.Millisecond)
+	slowReady = true
+}
+
+func doSlowOnce() {
+	slowOnce.Do(slowSetup)
+	wg.Done()
+}
+
+func main() {
+	wg.Add(3)
+	go add(1)
+	go add(10)
+	go add(100)
+	wg.Wait()
+	println("counter:", counter)
+
+	samplesCond = sync.NewCond(&samplesLock)
+	wg.Add(2)
+	go transmitter()
+	go sampler()
+	wg.Wait()
+	for _, batch := range transmitted {
+		print("transmit:")
+		for _, sample := range batch {
+			print(" ", sample)
+		}
+		println()
+	}
+
+	wg.Add(4)
+	go reader(0)
+	go reader(1)
+	time.Sleep(time.Millisecond)
+	go writer()
+	time.Sleep(time.Millisecond)
+	go reader(2)
+	wg.Wait()
+	println("seen:", seen[0], seen[1], seen[2])
+
+	wg.Add(3)
+	go doOnce()
+	go doOnce()
+	go doOnce()
+	wg.Wait()
+	once.Do(setup)
+	println("calls:", calls)
+
+	wg.Add(1)
+	go doSlowOnce()
+	<-slowStarted
+	slowOnce.Do(slowSetup)
+	println("ready:", slowReady)
+	wg.Wait()
+
+	// Wait returns immediately when the counter is zero.
+	wg.Wait()
+	println("done")
+}
@@ -0,0 +1,2 @@
+// Package fail is tested by TestTest in main_test.go. One of its tests fails.
+package fail
@@ -0,0 +1,10 @@
+package fail
+
+import "testing"
+
+func TestPass(t *testing.T) {
+}
+
+func TestFail(t *testing.T) {
+	t.Fatal("failed")
+}
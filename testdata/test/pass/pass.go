@@ -0,0 +1,11 @@
+// Package pass is tested by TestTest in main_test.go. All its tests pass.
+package pass
+
+func add(a, b int) int {
+	return a + b
+}
+
+// Double returns twice the given value.
+func Double(n int) int {
+	return add(n, n)
+}
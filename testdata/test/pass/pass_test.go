@@ -0,0 +1,18 @@
+package pass
+
+import "testing"
+
+func TestAdd(t *testing.T) {
+	if add(1, 2) != 3 {
+		t.Error("add(1, 2) != 3")
+	}
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipped")
+}
+
+// Not a test function: the name continues with a lower case letter.
+func Testing(t *testing.T) {
+	t.Error("not a test")
+}
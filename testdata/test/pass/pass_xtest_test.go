@@ -0,0 +1,13 @@
+package pass_test
+
+import (
+	"testing"
+
+	"github.com/aykevl/tinygo/testdata/test/pass"
+)
+
+func TestDouble(t *testing.T) {
+	if pass.Double(3) != 6 {
+		t.Error("Double(3) != 6")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+// This test runs the testing package like the main package generated by
+// `tinygo test` does.
+
+import "testing"
+
+func main() {
+	tests := []testing.InternalTest{
+		{"TestPass", TestPass},
+		{"TestFail", TestFail},
+		{"TestFatal", TestFatal},
+		{"TestSkip", TestSkip},
+		{"TestSubtests", TestSubtests},
+	}
+	println("exit code:", testing.MainStart(tests).Run())
+	println("no tests:", testing.MainStart(nil).Run())
+}
+
+func TestPass(t *testing.T) {
+	t.Log("not shown")
+}
+
+func TestFail(t *testing.T) {
+	t.Errorf("got %d, want %d", 1, 2)
+	t.Log("multiple\nlines")
+	println("failed:", t.Failed())
+}
+
+func TestFatal(t *testing.T) {
+	defer func() {
+		t.Log("deferred")
+	}()
+	t.Fatal("fatal error")
+	println("unreachable")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("skipped")
+	println("unreachable")
+}
+
+func TestSubtests(t *testing.T) {
+	println("name:", t.Name())
+	println("ok:", t.Run("ok", func(t *testing.T) {}))
+	println("fail:", t.Run("with space", func(t *testing.T) {
+		t.Run("nested", func(t *testing.T) {
+			t.Error("nested error")
+		})
+	}))
+}
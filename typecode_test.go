@@ -0,0 +1,60 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// Test that typecodes are 16 bits on very small targets and 32 bits elsewhere,
+// by looking at the runtime._interface type in the generated IR.
+func TestTypecodeWidth(t *testing.T) {
+	tmpdir, err := ioutil.TempDir("", "tinygo-test")
+	if err != nil {
+		t.Fatal("could not create temporary directory:", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	// Like qemu, but with the build tags and LLVM target of a Cortex-M0 (such
+	// as the micro:bit, whose device files are generated).
+	cortexM0 := filepath.Join(tmpdir, "qemu-cortex-m0.json")
+	err = ioutil.WriteFile(cortexM0, []byte(`{"inherits": ["qemu", "cortex-m0"]}`), 0644)
+	if err != nil {
+		t.Fatal("could not write target specification:", err)
+	}
+
+	interfaceType := regexp.MustCompile(`(?m)^%runtime\._interface = type \{ (i\d+),`)
+	for _, tc := range []struct {
+		target string
+		width  string
+	}{
+		{"", "i32"},       // host
+		{"qemu", "i32"},   // cortex-m3
+		{cortexM0, "i16"}, // cortex-m0
+	} {
+		spec, err := LoadTarget(tc.target)
+		if err != nil {
+			t.Errorf("%s: could not load target: %s", tc.target, err)
+			continue
+		}
+		config := &BuildConfig{opt: "0"}
+		outpath := filepath.Join(tmpdir, "interface.ll")
+		err = Compile("testdata/interface.go", outpath, spec, config, nil)
+		if err != nil {
+			t.Errorf("%s: could not compile: %s", tc.target, err)
+			continue
+		}
+		ir, err := ioutil.ReadFile(outpath)
+		if err != nil {
+			t.Fatal("could not read IR:", err)
+		}
+		match := interfaceType.FindSubmatch(ir)
+		if match == nil {
+			t.Errorf("%s: runtime._interface type not found in the IR", tc.target)
+		} else if string(match[1]) != tc.width {
+			t.Errorf("%s: expected typecode type %s, got %s", tc.target, tc.width, match[1])
+		}
+	}
+}